@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/announcer"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/announces"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// runClientCommand handles `joal client <subcommand>`, it returns the process exit code
+func runClientCommand(args []string, out io.Writer, errOut io.Writer) int {
+	if len(args) == 0 || args[0] != "render" {
+		_, _ = fmt.Fprintln(errOut, "usage: joal client render [-tracker url] [-event started|completed|stopped|empty] [-ip ip] [-ipv6 ip] [-port port] <client-file>")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("client render", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	trackerUrl := flags.String("tracker", "http://tracker.example.org/announce", "tracker announce url")
+	eventName := flags.String("event", "started", "announce event: started, completed, stopped or empty")
+	ip := flags.String("ip", "203.0.113.1", "public ip of the listener, may be an ipv4 or an ipv6")
+	ipv6 := flags.String("ipv6", "", "additional public ipv6 of the listener, for dual-stack hosts")
+	port := flags.Uint("port", 49152, "listening port")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintln(errOut, "exactly one client file is expected")
+		return 2
+	}
+
+	event, err := parseAnnounceEvent(*eventName)
+	if err != nil {
+		_, _ = fmt.Fprintln(errOut, err)
+		return 2
+	}
+	parsedIp := net.ParseIP(*ip)
+	if parsedIp == nil {
+		_, _ = fmt.Fprintf(errOut, "'%s' is not a valid ip\n", *ip)
+		return 2
+	}
+	var publicIpv4, publicIpv6 net.IP
+	if parsedIp.To4() != nil {
+		publicIpv4 = parsedIp
+	} else {
+		publicIpv6 = parsedIp
+	}
+	if *ipv6 != "" {
+		publicIpv6 = net.ParseIP(*ipv6)
+		if publicIpv6 == nil || publicIpv6.To4() != nil {
+			_, _ = fmt.Fprintf(errOut, "'%s' is not a valid ipv6\n", *ipv6)
+			return 2
+		}
+	}
+	if *port > 65535 {
+		_, _ = fmt.Fprintf(errOut, "'%d' is not a valid port\n", *port)
+		return 2
+	}
+	u, err := url.Parse(*trackerUrl)
+	if err != nil {
+		_, _ = fmt.Fprintf(errOut, "'%s' is not a valid url: %v\n", *trackerUrl, err)
+		return 2
+	}
+
+	client, err := emulatedclient.FromClientFile(flags.Arg(0), nil)
+	if err != nil {
+		_, _ = fmt.Fprintf(errOut, "failed to load client file: %v\n", err)
+		return 1
+	}
+	renderer, ok := client.(interface {
+		RenderHttpAnnounceUrls(request *announces.AnnounceRequest, ipv4 net.IP, ipv6 net.IP, port uint16) ([]*url.URL, error)
+	})
+	if !ok {
+		_, _ = fmt.Fprintln(errOut, "client can not render announce urls")
+		return 1
+	}
+
+	synthetic := announcer.SyntheticAnnounceRequest(event, publicIpv4, publicIpv6)
+	rendered, err := renderer.RenderHttpAnnounceUrls(&announces.AnnounceRequest{
+		Url:        *u,
+		InfoHash:   synthetic.InfoHash,
+		Event:      synthetic.Event,
+		Uploaded:   synthetic.Uploaded,
+		Downloaded: synthetic.Downloaded,
+		Left:       synthetic.Left,
+		Corrupt:    synthetic.Corrupt,
+		Private:    synthetic.Private,
+	}, publicIpv4, publicIpv6, uint16(*port))
+	if err != nil {
+		_, _ = fmt.Fprintf(errOut, "failed to render announce url: %v\n", err)
+		return 1
+	}
+	for _, u := range rendered {
+		_, _ = fmt.Fprintln(out, u.String())
+	}
+	return 0
+}
+
+func parseAnnounceEvent(name string) (tracker.AnnounceEvent, error) {
+	for _, event := range []tracker.AnnounceEvent{tracker.None, tracker.Started, tracker.Completed, tracker.Stopped} {
+		if strings.EqualFold(event.String(), name) {
+			return event, nil
+		}
+	}
+	return tracker.None, fmt.Errorf("'%s' is not a valid announce event", name)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunClientCommand_ShouldRenderTheAnnounceUrl(t *testing.T) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+
+	code := runClientCommand([]string{"render", "-tracker", "http://tracker.example.org/announce?passkey=abc", "-event", "completed", "-port", "51413", filepath.Join("testdata", "client.yml")}, out, errOut)
+
+	require.Equal(t, 0, code, errOut.String())
+	rendered := strings.TrimSpace(out.String())
+	assert.True(t, strings.HasPrefix(rendered, "http://tracker.example.org/announce?passkey=abc&info_hash=%124Vx%9a%bc%de%f0joal-info-ha&"), rendered)
+	u, err := url.Parse(rendered)
+	require.NoError(t, err)
+	query := u.Query()
+	assert.Regexp(t, `^-qB3310-.{12}$`, query.Get("peer_id"))
+	assert.Equal(t, "51413", query.Get("port"))
+	assert.Equal(t, "123456789", query.Get("uploaded"))
+	assert.Equal(t, "completed", query.Get("event"))
+	assert.Equal(t, "200", query.Get("numwant"))
+	assert.Len(t, query.Get("key"), 8)
+}
+
+func TestRunClientCommand_ShouldOmitTheEventWhenEmpty(t *testing.T) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+
+	code := runClientCommand([]string{"render", "-event", "empty", filepath.Join("testdata", "client.yml")}, out, errOut)
+
+	require.Equal(t, 0, code, errOut.String())
+	u, err := url.Parse(strings.TrimSpace(out.String()))
+	require.NoError(t, err)
+	assert.False(t, u.Query().Has("event"))
+}
+
+func TestRunClientCommand_ShouldRejectInvalidArguments(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{name: "no-subcommand", args: []string{}, wantCode: 2},
+		{name: "unknown-subcommand", args: []string{"print"}, wantCode: 2},
+		{name: "no-client-file", args: []string{"render"}, wantCode: 2},
+		{name: "invalid-event", args: []string{"render", "-event", "paused", filepath.Join("testdata", "client.yml")}, wantCode: 2},
+		{name: "invalid-ip", args: []string{"render", "-ip", "nope", filepath.Join("testdata", "client.yml")}, wantCode: 2},
+		{name: "missing-client-file", args: []string{"render", filepath.Join("testdata", "missing.yml")}, wantCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+			assert.Equal(t, tt.wantCode, runClientCommand(tt.args, out, errOut))
+			assert.Empty(t, out.String())
+			assert.NotEmpty(t, errOut.String())
+		})
+	}
+}
@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"github.com/anthonyraymond/joal-cli/internal/old/common/configloader"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
-	"net/http"
-	"net/url"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 var (
@@ -45,15 +44,28 @@ func readConfig(configDir string) (*AppConfig, error) {
 type AppConfig struct {
 	Log   *logs.LogConfig `yaml:"log"`
 	Proxy ProxyConf       `yaml:"proxy"`
+	// Plugins enables and configures the plugins by name, for instance:
+	//  plugins:
+	//    metrics:
+	//      enabled: true
+	//      config:
+	//        port: 7042
+	// A plugin without a config key is configured by its legacy plugins/<name>/<name>.yml file if there is one, and the
+	// legacy --no-webui, --no-webhooks and --metrics flags override the enabled keys.
+	Plugins types.PluginsConfig `yaml:"plugins"`
 }
 
 func (ac AppConfig) Default() *AppConfig {
 	return &AppConfig{
-		Log:   logs.LogConfig{}.Default(),
-		Proxy: ProxyConf{}.Default(),
+		Log:     logs.LogConfig{}.Default(),
+		Proxy:   ProxyConf{}.Default(),
+		Plugins: types.PluginsConfig{},
 	}
 }
 
+// ProxyConf is the proxy every outbound connection goes through: announces, public ip discovery and plugins.
+// Url is empty (direct connection), or one of http://host:port, https://host:port, socks5://[user:password@]host:port
+// and socks5h://[user:password@]host:port (host names are resolved by the proxy). Only SOCKS5 proxies relay UDP announces.
 type ProxyConf struct {
 	Url string `yaml:"url"`
 }
@@ -64,13 +76,6 @@ func (pc ProxyConf) Default() ProxyConf {
 	}
 }
 
-func (pc *ProxyConf) Proxy() func(*http.Request) (*url.URL, error) {
-	if strings.TrimSpace(pc.Url) == "" {
-		return nil
-	}
-
-	u, err := url.Parse(pc.Url)
-	return func(request *http.Request) (*url.URL, error) {
-		return u, err
-	}
+func (pc *ProxyConf) Dialer() (*proxy.Dialer, error) {
+	return proxy.FromUrl(pc.Url)
 }
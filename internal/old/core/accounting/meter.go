@@ -0,0 +1,88 @@
+package accounting
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaxCatchUp is used when no cap is configured
+const DefaultMaxCatchUp = 1 * time.Minute
+
+// Elapsed returns the time elapsed between from and to, capped to maxCatchUp (no cap if maxCatchUp <= 0). The
+// monotonic clock readings are used when both times carry one, a wall clock jump does not count as elapsed time.
+func Elapsed(from time.Time, to time.Time, maxCatchUp time.Duration) time.Duration {
+	elapsed := to.Sub(from)
+	if elapsed < 0 {
+		return 0
+	}
+	if maxCatchUp > 0 && elapsed > maxCatchUp {
+		// the process has been asleep or starved, do not pretend it was uploading all this time
+		return maxCatchUp
+	}
+	return elapsed
+}
+
+// Meter integrates a speed over the time elapsed between two accountings. A speed change accounts the time spent at
+// the previous speed first, the bytes are exact whatever the interval between two collects.
+type Meter struct {
+	bps        int64
+	last       time.Time
+	bytes      float64
+	maxCatchUp time.Duration
+	now        func() time.Time
+	lock       *sync.Mutex
+}
+
+// NewMeter returns a stopped meter, no time elapsed between two accountings counts for more than maxCatchUp
+func NewMeter(maxCatchUp time.Duration) *Meter {
+	return &Meter{
+		maxCatchUp: maxCatchUp,
+		now:        time.Now,
+		lock:       &sync.Mutex{},
+	}
+}
+
+// SetSpeed accounts the bytes transferred at the previous speed and switches to the new one
+func (m *Meter) SetSpeed(bps int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.account()
+	if bps < 0 {
+		bps = 0
+	}
+	m.bps = bps
+}
+
+func (m *Meter) Speed() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.bps
+}
+
+// Collect returns the whole bytes transferred since the previous collect, the fraction of byte left is kept for the next one
+func (m *Meter) Collect() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.account()
+	collected := int64(m.bytes)
+	m.bytes -= float64(collected)
+	return collected
+}
+
+// Reset sets the speed to zero and drops the bytes not collected yet
+func (m *Meter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.bps = 0
+	m.bytes = 0
+	m.last = time.Time{}
+}
+
+// account integrates the current speed up to now. The lock has to be held by the caller
+func (m *Meter) account() {
+	now := m.now()
+	if !m.last.IsZero() && m.bps > 0 {
+		m.bytes += float64(m.bps) * Elapsed(m.last, now, m.maxCatchUp).Seconds()
+	}
+	m.last = now
+}
@@ -0,0 +1,140 @@
+package accounting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestMeter(maxCatchUp time.Duration) (*Meter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewMeter(maxCatchUp)
+	m.now = clock.Now
+	return m, clock
+}
+
+func TestElapsed(t *testing.T) {
+	from := time.Now()
+	assert.Equal(t, 10*time.Second, Elapsed(from, from.Add(10*time.Second), 0))
+	assert.Equal(t, 10*time.Second, Elapsed(from, from.Add(10*time.Second), time.Minute))
+	assert.Equal(t, time.Minute, Elapsed(from, from.Add(2*time.Hour), time.Minute))
+	assert.Equal(t, time.Duration(0), Elapsed(from, from.Add(-10*time.Second), time.Minute))
+}
+
+func TestMeter_ShouldIntegrateSpeedChangesMidInterval(t *testing.T) {
+	m, clock := newTestMeter(0)
+	m.SetSpeed(100)
+	clock.Advance(5 * time.Second)
+	m.SetSpeed(1000)
+	clock.Advance(2500 * time.Millisecond)
+	m.SetSpeed(0)
+	clock.Advance(1 * time.Hour)
+
+	assert.Equal(t, int64(100*5+1000*2.5), m.Collect())
+	assert.Equal(t, int64(0), m.Collect())
+}
+
+func TestMeter_ShouldKeepFractionOfByteForNextCollect(t *testing.T) {
+	m, clock := newTestMeter(0)
+	m.SetSpeed(3)
+	total := int64(0)
+	for i := 0; i < 10; i++ {
+		clock.Advance(100 * time.Millisecond)
+		total += m.Collect()
+	}
+	assert.Equal(t, int64(3), total)
+}
+
+func TestMeter_ShouldCapCatchUpAfterSleep(t *testing.T) {
+	m, clock := newTestMeter(1 * time.Minute)
+	m.SetSpeed(1000)
+	clock.Advance(15 * time.Second)
+	assert.Equal(t, int64(15000), m.Collect())
+
+	// the machine has been suspended for a night
+	clock.Advance(8 * time.Hour)
+	assert.Equal(t, int64(60000), m.Collect())
+
+	clock.Advance(15 * time.Second)
+	assert.Equal(t, int64(15000), m.Collect())
+}
+
+func TestMeter_ResetShouldDropPendingBytes(t *testing.T) {
+	m, clock := newTestMeter(0)
+	m.SetSpeed(1000)
+	clock.Advance(10 * time.Second)
+	m.Reset()
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, int64(0), m.Speed())
+	assert.Equal(t, int64(0), m.Collect())
+}
+
+// TestMeter_TotalShouldEqualIntegralOfSpeed checks on random speed functions and random collect times that the bytes
+// collected are the integral of the speed, whatever the accounting is interleaved with.
+func TestMeter_TotalShouldEqualIntegralOfSpeed(t *testing.T) {
+	type step struct {
+		Speed    uint16
+		Duration uint16 // in milliseconds
+		Collect  bool
+	}
+	property := func(steps []step) bool {
+		m, clock := newTestMeter(0)
+		integral := float64(0)
+		collected := int64(0)
+		for _, s := range steps {
+			m.SetSpeed(int64(s.Speed))
+			clock.Advance(time.Duration(s.Duration) * time.Millisecond)
+			integral += float64(s.Speed) * float64(s.Duration) / 1000
+			if s.Collect {
+				collected += m.Collect()
+				// never ahead of the integral, never behind by a whole byte
+				if float64(collected) > integral+1e-6 || integral-float64(collected) >= 1+1e-6 {
+					return false
+				}
+			}
+		}
+		collected += m.Collect()
+		return math.Abs(float64(collected)-math.Floor(integral+1e-6)) <= 1
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMeter_CappedTotalShouldEqualIntegralOfCappedGaps checks that the cap only applies to the gaps longer than the cap
+func TestMeter_CappedTotalShouldEqualIntegralOfCappedGaps(t *testing.T) {
+	const maxCatchUp = 30 * time.Second
+	property := func(speed uint16, gaps []uint32) bool {
+		m, clock := newTestMeter(maxCatchUp)
+		m.SetSpeed(int64(speed))
+		integral := float64(0)
+		collected := int64(0)
+		for _, g := range gaps {
+			gap := time.Duration(g%120000) * time.Millisecond
+			clock.Advance(gap)
+			if gap > maxCatchUp {
+				gap = maxCatchUp
+			}
+			integral += float64(speed) * gap.Seconds()
+			collected += m.Collect()
+		}
+		return math.Abs(float64(collected)-math.Floor(integral+1e-6)) <= 1
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
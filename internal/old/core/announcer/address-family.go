@@ -0,0 +1,70 @@
+package announcer
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// AddressFamily restricts the socket an announce is sent through
+type AddressFamily int
+
+const (
+	// AnyFamily lets the system pick the socket, usually IPv4 first
+	AnyFamily AddressFamily = iota
+	IPv4Family
+	IPv6Family
+)
+
+func (f AddressFamily) String() string {
+	switch f {
+	case IPv4Family:
+		return "ipv4"
+	case IPv6Family:
+		return "ipv6"
+	default:
+		return "any"
+	}
+}
+
+// Network returns the family specific variant of a network name as used by net.Dial ("tcp" => "tcp4", "udp" => "udp6", ...)
+func (f AddressFamily) Network(network string) string {
+	switch f {
+	case IPv4Family:
+		return network + "4"
+	case IPv6Family:
+		return network + "6"
+	default:
+		return network
+	}
+}
+
+// AddressFamilyOfUrl returns the family a tracker url can only be reached through, AnyFamily if it is reachable through both.
+// The family is forced either by the scheme (udp4://, udp6://) or by an ip literal host (http://[2001:db8::1]/announce).
+func AddressFamilyOfUrl(u url.URL) AddressFamily {
+	scheme := strings.ToLower(u.Scheme)
+	if strings.HasSuffix(scheme, "6") {
+		return IPv6Family
+	}
+	if strings.HasSuffix(scheme, "4") {
+		return IPv4Family
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if ip.To4() != nil {
+			return IPv4Family
+		}
+		return IPv6Family
+	}
+	return AnyFamily
+}
+
+// AddressFamilyOfIp returns IPv4Family or IPv6Family, AnyFamily if ip is nil
+func AddressFamilyOfIp(ip net.IP) AddressFamily {
+	if ip == nil {
+		return AnyFamily
+	}
+	if ip.To4() != nil {
+		return IPv4Family
+	}
+	return IPv6Family
+}
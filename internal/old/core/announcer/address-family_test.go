@@ -0,0 +1,45 @@
+package announcer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestAddressFamilyOfUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		want AddressFamily
+	}{
+		{url: "http://tracker.example.org/announce", want: AnyFamily},
+		{url: "https://tracker.example.org:443/announce", want: AnyFamily},
+		{url: "udp://tracker.example.org:6969", want: AnyFamily},
+		{url: "udp4://tracker.example.org:6969", want: IPv4Family},
+		{url: "udp6://tracker.example.org:6969", want: IPv6Family},
+		{url: "http://203.0.113.1/announce", want: IPv4Family},
+		{url: "http://[2001:db8::1]:8080/announce", want: IPv6Family},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, AddressFamilyOfUrl(*u))
+		})
+	}
+}
+
+func TestAddressFamily_Network(t *testing.T) {
+	assert.Equal(t, "tcp", AnyFamily.Network("tcp"))
+	assert.Equal(t, "tcp4", IPv4Family.Network("tcp"))
+	assert.Equal(t, "udp6", IPv6Family.Network("udp"))
+}
+
+func TestAddressFamilyOfIp(t *testing.T) {
+	assert.Equal(t, AnyFamily, AddressFamilyOfIp(nil))
+	assert.Equal(t, IPv4Family, AddressFamilyOfIp(net.ParseIP("203.0.113.1")))
+	assert.Equal(t, IPv4Family, AddressFamilyOfIp(net.IPv4(203, 0, 113, 1)))
+	assert.Equal(t, IPv6Family, AddressFamilyOfIp(net.ParseIP("2001:db8::1")))
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/routing"
 	"gopkg.in/yaml.v3"
 	"net"
-	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -23,18 +23,27 @@ type AnnounceRequest struct {
 	// Apparently this is optional. None can be used for announces done at
 	// regular intervals.
 	Event     tracker.AnnounceEvent
-	IPAddress net.IP
+	IPAddress net.IP // public ip matching the family the announce is sent through
+	IPv4      net.IP // public ipv4 of the listener, nil if unknown
+	IPv6      net.IP // public ipv6 of the listener, nil if unknown
 	Key       uint32
 	NumWant   int32 // How many peer addresses are desired. -1 for default.
 	Private   bool
 	Port      uint16
+	TrackerId string // "tracker id" sent by the tracker in a previous response, empty if none
+	// Family of the socket used to send the announce, AnyFamily lets the system decide
+	AddressFamily AddressFamily
+	// Route to reach the tracker through, nil connects directly
+	Route *routing.Route
 } // 82 bytes
 
 type AnnounceResponse struct {
-	Interval time.Duration // Minimum seconds the local peer should wait before next announce.
-	Leechers int32
-	Seeders  int32
-	Peers    []tracker.Peer
+	Interval   time.Duration // Minimum seconds the local peer should wait before next announce.
+	Leechers   int32
+	Seeders    int32
+	Peers      []tracker.Peer
+	TrackerId  string
+	ExternalIp net.IP // ip the tracker has seen the announce coming from (BEP 24), nil if not sent
 }
 
 type iAnnouncer interface {
@@ -49,29 +58,33 @@ type Announcer struct {
 func (a *Announcer) UnmarshalYAML(value *yaml.Node) error {
 	announcer := &struct {
 		Http *HttpAnnouncer `yaml:"http"`
-		// TODO: Udp *UdpAnnouncer `yaml:"udp"`
+		Udp  *UdpAnnouncer  `yaml:"udp"`
 	}{}
 	if a.Http != nil {
 		announcer.Http = a.Http.(*HttpAnnouncer)
 	}
-	// TODO: add UDP
-	/*if a.Udp != nil {
+	if a.Udp != nil {
 		announcer.Udp = a.Udp.(*UdpAnnouncer)
-	}*/
+	}
 	err := value.Decode(announcer)
 	if err != nil {
 		return err
 	}
 
-	(*a).Http = announcer.Http
-	//TODO: (*a).Udp = announcer.udp
+	// a nil pointer assigned to the interface would make a non nil interface
+	if announcer.Http != nil {
+		(*a).Http = announcer.Http
+	}
+	if announcer.Udp != nil {
+		(*a).Udp = announcer.Udp
+	}
 
 	return nil
 }
 
-func (a *Announcer) AfterPropertiesSet(proxyFunc func(*http.Request) (*url.URL, error)) error {
+func (a *Announcer) AfterPropertiesSet() error {
 	if a.Http != nil {
-		if err := a.Http.AfterPropertiesSet(proxyFunc); err != nil {
+		if err := a.Http.AfterPropertiesSet(); err != nil {
 			return err
 		}
 	}
@@ -7,7 +7,6 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
-	"net/http"
 	"net/url"
 	"testing"
 )
@@ -16,8 +15,8 @@ func TestAnnouncer_ShouldUnmarshal(t *testing.T) {
 	yamlString := `---
 http:
   query: dd
+udp: {}
 `
-	// TODO: add UDP
 	announcer := &Announcer{}
 	err := yaml.Unmarshal([]byte(yamlString), announcer)
 	if err != nil {
@@ -25,10 +24,26 @@ http:
 	}
 	assert.NotNil(t, announcer.Http)
 	assert.IsType(t, &HttpAnnouncer{}, announcer.Http)
-	/* TODO: Add UDP
 	assert.NotNil(t, announcer.Udp)
 	assert.IsType(t, &UdpAnnouncer{}, announcer.Udp)
-	*/
+}
+
+func TestAnnouncer_ShouldUnmarshalWithoutHttpOrUdp(t *testing.T) {
+	announcer := &Announcer{}
+	err := yaml.Unmarshal([]byte("udp: {}"), announcer)
+	if err != nil {
+		t.Fatalf("Failed to unmarshall: %+v", err)
+	}
+	assert.Nil(t, announcer.Http)
+	assert.NotNil(t, announcer.Udp)
+
+	announcer = &Announcer{}
+	err = yaml.Unmarshal([]byte("http:\n  query: dd"), announcer)
+	if err != nil {
+		t.Fatalf("Failed to unmarshall: %+v", err)
+	}
+	assert.NotNil(t, announcer.Http)
+	assert.Nil(t, announcer.Udp)
 }
 
 func TestAnnouncer_ShouldUnmarshalAndBeOverrideable(t *testing.T) {
@@ -72,7 +87,7 @@ type validAbleHttpAnnouncer struct {
 	Field string `validate:"required"`
 }
 
-func (a *validAbleHttpAnnouncer) AfterPropertiesSet(proxyFunc func(*http.Request) (*url.URL, error)) error {
+func (a *validAbleHttpAnnouncer) AfterPropertiesSet() error {
 	return nil
 }
 func (a *validAbleHttpAnnouncer) Announce(url.URL, AnnounceRequest, context.Context) (AnnounceResponse, error) {
@@ -133,7 +148,7 @@ func (f *fakeSubHttpAnnouncer) Announce(u url.URL, announceRequest AnnounceReque
 	return AnnounceResponse{}, nil
 }
 
-func (f *fakeSubHttpAnnouncer) AfterPropertiesSet(proxyFunc func(*http.Request) (*url.URL, error)) error {
+func (f *fakeSubHttpAnnouncer) AfterPropertiesSet() error {
 	return nil
 }
 
@@ -8,63 +8,119 @@ import (
 	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/urlencoder"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/routing"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
 
 type IHttpAnnouncer interface {
 	iAnnouncer
-	AfterPropertiesSet(proxyFunc func(*http.Request) (*url.URL, error)) error
+	AfterPropertiesSet() error
 }
 
+// Rendered query strings longer than this are most likely the result of a broken template
+const maxQueryStringLength = 8 * 1024
+
+// HttpAnnouncer announces over HTTP. FunctionsVersion is the version of the template functions set the Query is written against (see TemplateFunctionsVersion), it defaults to 1
 type HttpAnnouncer struct {
-	UrlEncoder     urlencoder.UrlEncoder `yaml:"urlEncoder"`
-	Query          string                `yaml:"query" validate:"required"`
-	RequestHeaders []HttpRequestHeader   `yaml:"requestHeaders" validate:"dive"`
-	queryTemplate  *template.Template    `yaml:"-"`
-	httpClient     *http.Client          `yaml:"-"`
+	UrlEncoder       urlencoder.UrlEncoder          `yaml:"urlEncoder"`
+	Query            string                         `yaml:"query" validate:"required"`
+	FunctionsVersion int                            `yaml:"functionsVersion" validate:"omitempty,min=1"`
+	RequestHeaders   []HttpRequestHeader            `yaml:"requestHeaders" validate:"dive"`
+	queryTemplate    *template.Template             `yaml:"-"`
+	httpClients      map[httpClientKey]*http.Client `yaml:"-"`
+	lock             *sync.Mutex                    `yaml:"-"`
+}
+
+// each route gets its own pool of connections, for each address family
+type httpClientKey struct {
+	route  *routing.Route
+	family AddressFamily
 }
 
-func (a *HttpAnnouncer) AfterPropertiesSet(proxyFunc func(*http.Request) (*url.URL, error)) error {
-	var err error
+func (a *HttpAnnouncer) AfterPropertiesSet() error {
+	version := a.FunctionsVersion
+	if version == 0 {
+		version = 1
+	}
+	funcs, err := TemplateFunctionsForVersion(version, &a.UrlEncoder)
+	if err != nil {
+		return err
+	}
 
-	a.queryTemplate, err = template.New("httpQueryTemplate").Funcs(TemplateFunctions(&a.UrlEncoder)).Parse(a.Query)
+	// an unknown key must fail the render rather than send "<no value>" to the tracker: the struct data already fails on unknown fields, missingkey=error extends it to map values
+	a.queryTemplate, err = template.New("httpQueryTemplate").Option("missingkey=error").Funcs(funcs).Parse(a.Query)
 	if err != nil {
+		return fmt.Errorf("failed to parse query template: %w", err)
+	}
+	// Render errors (calling a function with the wrong types, accessing an unknown field, ...) are only detected at execution.
+	// Render once for each event now, rather than failing on the first announce.
+	if err = checkQueryTemplate(a.queryTemplate); err != nil {
 		return err
 	}
 
-	a.httpClient = &http.Client{
+	a.httpClients = map[httpClientKey]*http.Client{}
+	a.lock = &sync.Mutex{}
+	return nil
+}
+
+func (a *HttpAnnouncer) httpClient(route *routing.Route, family AddressFamily) *http.Client {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	key := httpClientKey{route: route, family: family}
+	client, ok := a.httpClients[key]
+	if !ok {
+		client = newHttpClient(route.Dialer(), family)
+		a.httpClients[key] = client
+	}
+	return client
+}
+
+// newHttpClient returns a client that only dials sockets of the given family, through the dialer proxy
+func newHttpClient(dialer *proxy.Dialer, family AddressFamily) *http.Client {
+	return &http.Client{
 		Timeout: time.Second * 15,
 		Transport: &http.Transport{
-			Proxy:              proxyFunc,
+			Proxy:              dialer.HttpProxy(),
 			DisableCompression: true, // Disable auto send of Accept-Encoding gzip header. Since the lib dont add the header on it's own we'll have to handle the gzip decompression on our own
-			DialContext: (&net.Dialer{
-				Timeout: 15 * time.Second,
-			}).DialContext,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				// With an HTTP proxy, this only restricts the family of the connection to the proxy
+				return dialer.DialContext(ctx, family.Network(network), addr)
+			},
 			TLSHandshakeTimeout: 15 * time.Second,
 			//DisableKeepAlives:   true, // see https://github.com/anacrolix/torrent/commit/04ff050ecd5f5beab9b20a0f4170fda1e71062a4
 		},
 	}
-	return nil
 }
 
-func (a *HttpAnnouncer) Announce(url url.URL, announceRequest AnnounceRequest, ctx context.Context) (AnnounceResponse, error) {
-	log := logs.GetLogger()
+// RenderUrl returns the tracker url with the query string built from the announceRequest, exactly as Announce would send it
+func (a *HttpAnnouncer) RenderUrl(url url.URL, announceRequest AnnounceRequest) (*url.URL, error) {
 	_url := copyURL(&url)
 	queryString, err := buildQueryString(a.queryTemplate, announceRequest)
 	if err != nil {
-		return AnnounceResponse{}, fmt.Errorf("fail to format query string: %w", err)
+		return nil, fmt.Errorf("fail to format query string: %w", err)
 	}
 	if len(_url.Query()) > 0 {
 		queryString = fmt.Sprintf("%s&%s", url.RawQuery, queryString)
 	}
 	_url.RawQuery = queryString
+	return _url, nil
+}
+
+func (a *HttpAnnouncer) Announce(url url.URL, announceRequest AnnounceRequest, ctx context.Context) (AnnounceResponse, error) {
+	log := logs.GetLogger()
+	_url, err := a.RenderUrl(url, announceRequest)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", _url.String(), nil)
 	if err != nil {
@@ -78,11 +134,12 @@ func (a *HttpAnnouncer) Announce(url url.URL, announceRequest AnnounceRequest, c
 		zap.String("protocol", req.Proto),
 		zap.String("method", req.Method),
 		zap.String("url", req.URL.String()),
+		zap.Stringer("route", announceRequest.Route),
 		zap.Reflect("headers", req.Header),
 		zap.ByteString("infohash", announceRequest.InfoHash[:]),
 	)
 
-	resp, err := a.httpClient.Do(req)
+	resp, err := a.httpClient(announceRequest.Route, announceRequest.AddressFamily).Do(req)
 	if err != nil {
 		return AnnounceResponse{}, err
 	}
@@ -103,11 +160,13 @@ func (a *HttpAnnouncer) Announce(url url.URL, announceRequest AnnounceRequest, c
 		return AnnounceResponse{}, fmt.Errorf("tracker gave failure reason: %q", trackerResponse.FailureReason)
 	}
 	ret := AnnounceResponse{
-		Interval: time.Duration(trackerResponse.Interval) * time.Second,
-		Leechers: trackerResponse.Incomplete,
-		Seeders:  trackerResponse.Complete,
-		Peers:    trackerResponse.Peers,
+		Interval:  time.Duration(trackerResponse.Interval) * time.Second,
+		Leechers:  trackerResponse.Incomplete,
+		Seeders:   trackerResponse.Complete,
+		Peers:     trackerResponse.Peers,
+		TrackerId: trackerResponse.TrackerId,
 	}
+	ret.ExternalIp = readExternalIp(bodyBytes)
 	for _, na := range trackerResponse.Peers6 {
 		ret.Peers = append(ret.Peers, tracker.Peer{
 			IP:   na.IP,
@@ -117,12 +176,123 @@ func (a *HttpAnnouncer) Announce(url url.URL, announceRequest AnnounceRequest, c
 	return ret, nil
 }
 
+// QueryTemplateData is the only data the query template has access to.
+// It is a copy of the announce request, the template can neither reach nor alter anything else.
+type QueryTemplateData struct {
+	InfoHash   [20]byte
+	PeerId     [20]byte
+	Downloaded int64
+	Left       int64
+	Uploaded   int64
+	Corrupt    int64
+	Event      tracker.AnnounceEvent
+	IPAddress  net.IP // public ip matching the family the announce is sent through
+	IPv4       net.IP // nil if unknown
+	IPv6       net.IP // nil if unknown
+	Key        uint32
+	NumWant    int32 // already resolved for the event (numwant or numwantOnStop)
+	Private    bool
+	Port       uint16
+	TrackerId  string
+}
+
+func newQueryTemplateData(ar AnnounceRequest) QueryTemplateData {
+	return QueryTemplateData{
+		InfoHash:   ar.InfoHash,
+		PeerId:     ar.PeerId,
+		Downloaded: ar.Downloaded,
+		Left:       ar.Left,
+		Uploaded:   ar.Uploaded,
+		Corrupt:    ar.Corrupt,
+		Event:      ar.Event,
+		IPAddress:  copyIP(ar.IPAddress),
+		IPv4:       copyIP(ar.IPv4),
+		IPv6:       copyIP(ar.IPv6),
+		Key:        ar.Key,
+		NumWant:    ar.NumWant,
+		Private:    ar.Private,
+		Port:       ar.Port,
+		TrackerId:  ar.TrackerId,
+	}
+}
+
+func copyIP(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	ret := make(net.IP, len(ip))
+	copy(ret, ip)
+	return ret
+}
+
 func buildQueryString(queryTemplate *template.Template, ar AnnounceRequest) (string, error) {
 	sb := strings.Builder{}
-	err := queryTemplate.Execute(&sb, ar)
+	err := queryTemplate.Execute(&sb, newQueryTemplateData(ar))
+	if err == nil && sb.Len() > maxQueryStringLength {
+		return "", fmt.Errorf("query string is %d bytes long, maximum allowed is %d", sb.Len(), maxQueryStringLength)
+	}
 	return sb.String(), err
 }
 
+// SyntheticAnnounceRequest returns a plausible request, suitable to check or preview a query template. Either ipv4 or ipv6 may be nil.
+func SyntheticAnnounceRequest(event tracker.AnnounceEvent, ipv4 net.IP, ipv6 net.IP) AnnounceRequest {
+	ip := ipv4
+	if ip == nil {
+		ip = ipv6
+	}
+	var infoHash [20]byte
+	copy(infoHash[:], "\x12\x34\x56\x78\x9a\xbc\xde\xf0joal-info-hash")
+	var peerId [20]byte
+	copy(peerId[:], "-JO0001-abcdefghijkl")
+	return AnnounceRequest{
+		InfoHash:   infoHash,
+		PeerId:     peerId,
+		Downloaded: 0,
+		Left:       0,
+		Uploaded:   123456789,
+		Corrupt:    0,
+		Event:      event,
+		IPAddress:  ip,
+		IPv4:       ipv4,
+		IPv6:       ipv6,
+		Key:        0x0badf00d,
+		NumWant:    200,
+		Private:    false,
+		Port:       49152,
+		TrackerId:  "",
+	}
+}
+
+func checkQueryTemplate(queryTemplate *template.Template) error {
+	events := []tracker.AnnounceEvent{tracker.None, tracker.Started, tracker.Completed, tracker.Stopped}
+	ipv4, ipv6 := net.IPv4(203, 0, 113, 1), net.ParseIP("2001:db8::1")
+	ips := [][2]net.IP{{ipv4, nil}, {nil, ipv6}, {ipv4, ipv6}}
+	for _, event := range events {
+		for _, ip := range ips {
+			if _, err := buildQueryString(queryTemplate, SyntheticAnnounceRequest(event, ip[0], ip[1])); err != nil {
+				return fmt.Errorf("query template fails to render for event '%s' with ipv4 '%s' and ipv6 '%s': %w", event.String(), ip[0], ip[1], err)
+			}
+		}
+	}
+	return nil
+}
+
+// readExternalIp returns the "external ip" (BEP 24) of the response, tracker.HttpResponse does not decode it
+func readExternalIp(body []byte) net.IP {
+	var r struct {
+		ExternalIp string `bencode:"external ip"`
+	}
+	if err := bencode.Unmarshal(body, &r); err != nil {
+		if _, ok := err.(bencode.ErrUnusedTrailingBytes); !ok {
+			return nil
+		}
+	}
+	if len(r.ExternalIp) != net.IPv4len && len(r.ExternalIp) != net.IPv6len {
+		return nil
+	}
+	return net.IP(r.ExternalIp)
+}
+
 func readResponseBody(response *http.Response) ([]byte, error) {
 	var reader = response.Body
 
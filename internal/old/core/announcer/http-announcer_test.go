@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"fmt"
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/casing"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/urlencoder"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/routing"
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/testutils"
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
 	"net"
 	"net/http"
@@ -150,7 +154,7 @@ func TestHttpAnnouncer_AnnounceShouldAnnounce(t *testing.T) {
 		Query:          "info_hash={{urlEncode (byteArray20ToString .InfoHash)}}",
 		RequestHeaders: []HttpRequestHeader{},
 	}
-	err := announcer.AfterPropertiesSet(nil)
+	err := announcer.AfterPropertiesSet()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -207,7 +211,7 @@ func TestHttpAnnouncer_AnnounceShouldUnderstandAndDecodeGzip(t *testing.T) {
 		Query:          "info_hash={{urlEncode (byteArray20ToString .InfoHash)}}",
 		RequestHeaders: []HttpRequestHeader{{"Accept-Encoding", "gzip"}},
 	}
-	err := announcer.AfterPropertiesSet(nil)
+	err := announcer.AfterPropertiesSet()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -247,7 +251,7 @@ func TestHttpAnnouncer_Announce_ShouldNotPrefixWithAmpersandIfQueryHasNoValues(t
 		Query:          "info_hash=a",
 		RequestHeaders: []HttpRequestHeader{},
 	}
-	err := announcer.AfterPropertiesSet(nil)
+	err := announcer.AfterPropertiesSet()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -271,7 +275,7 @@ func TestHttpAnnouncer_Announce_ShouldPrefixWithAmpersandQueryHasValues(t *testi
 		Query:          "info_hash=a",
 		RequestHeaders: []HttpRequestHeader{},
 	}
-	err := announcer.AfterPropertiesSet(nil)
+	err := announcer.AfterPropertiesSet()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -298,7 +302,7 @@ func TestHttpAnnouncer_Announce_ShouldSendHttpHeaders(t *testing.T) {
 		Query:          "info_hash=a",
 		RequestHeaders: headers,
 	}
-	err := announcer.AfterPropertiesSet(nil)
+	err := announcer.AfterPropertiesSet()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -307,3 +311,142 @@ func TestHttpAnnouncer_Announce_ShouldSendHttpHeaders(t *testing.T) {
 
 	_, _ = announcer.Announce(*url, AnnounceRequest{}, context.Background())
 }
+
+func TestHttpAnnouncer_AfterPropertiesSet_ShouldFailIfQueryDoesNotRender(t *testing.T) {
+	announcer := &HttpAnnouncer{Query: `port={{.Port}}&nope={{.DoesNotExists}}`}
+	assert.Error(t, announcer.AfterPropertiesSet())
+
+	announcer = &HttpAnnouncer{Query: `key={{uint32ToHexString .InfoHash}}`}
+	assert.Error(t, announcer.AfterPropertiesSet())
+}
+
+func TestBuildQueryString_ShouldFailOnUnknownKeyInsteadOfRenderingNoValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "unknown-field", query: `port={{.Port}}&nope={{.DoesNotExists}}`},
+		{name: "unknown-nested-key", query: `port={{.Port}}&nope={{.Port.DoesNotExists}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queryTemplate, err := template.New(tt.name).Option("missingkey=error").Funcs(TemplateFunctions(&urlencoder.UrlEncoder{})).Parse(tt.query)
+			require.NoError(t, err)
+
+			query, err := buildQueryString(queryTemplate, AnnounceRequest{Port: 6881})
+
+			assert.Error(t, err)
+			assert.NotContains(t, query, "<no value>")
+		})
+	}
+}
+
+func TestHttpAnnouncer_AfterPropertiesSet_ShouldFailIfFunctionsVersionIsNotSupported(t *testing.T) {
+	announcer := &HttpAnnouncer{Query: `port={{.Port}}`, FunctionsVersion: TemplateFunctionsVersion + 1}
+	assert.Error(t, announcer.AfterPropertiesSet())
+}
+
+func TestHttpAnnouncer_AfterPropertiesSet_ShouldRestrictFunctionsToDeclaredVersion(t *testing.T) {
+	announcer := &HttpAnnouncer{Query: `port={{.Port}}{{paramIf true "a" "b"}}`}
+	assert.Error(t, announcer.AfterPropertiesSet())
+
+	announcer = &HttpAnnouncer{Query: `port={{.Port}}{{paramIf true "a" "b"}}`, FunctionsVersion: 2}
+	assert.NoError(t, announcer.AfterPropertiesSet())
+}
+
+func TestHttpAnnouncer_RenderUrl(t *testing.T) {
+	announcer := &HttpAnnouncer{Query: `port={{.Port}}{{paramIf (ne .TrackerId "") "trackerid" .TrackerId}}`, FunctionsVersion: 2}
+	if err := announcer.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url2.Parse("http://localhost:1234/announce?passkey=abc")
+
+	req := SyntheticAnnounceRequest(tracker.Started, net.ParseIP("203.0.113.1"), nil)
+	req.TrackerId = "xyz"
+	got, err := announcer.RenderUrl(*u, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "http://localhost:1234/announce?passkey=abc&port=49152&trackerid=xyz", got.String())
+}
+
+func TestHttpAnnouncer_RenderUrl_ShouldExposeBothAddresses(t *testing.T) {
+	announcer := &HttpAnnouncer{Query: `ip={{.IPAddress}}{{paramIf (isIPv4 .IPv4) "ipv4" .IPv4}}{{paramIf (isIPv6 .IPv6) "ipv6" .IPv6}}`, FunctionsVersion: 2}
+	if err := announcer.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url2.Parse("http://localhost:1234/announce")
+
+	got, err := announcer.RenderUrl(*u, SyntheticAnnounceRequest(tracker.Started, net.ParseIP("203.0.113.1"), net.ParseIP("2001:db8::1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "http://localhost:1234/announce?ip=203.0.113.1&ipv4=203.0.113.1&ipv6=2001:db8::1", got.String())
+
+	got, err = announcer.RenderUrl(*u, SyntheticAnnounceRequest(tracker.Started, nil, net.ParseIP("2001:db8::1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "http://localhost:1234/announce?ip=2001:db8::1&ipv6=2001:db8::1", got.String())
+}
+
+func TestHttpAnnouncer_AnnounceShouldReadExternalIp(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := bencode.Marshal(map[string]interface{}{
+			"interval":    150,
+			"complete":    20,
+			"incomplete":  10,
+			"peers":       "",
+			"external ip": string(net.IPv4(203, 0, 113, 7).To4()),
+		})
+		if err != nil {
+			t.Errorf("failed to encode http announce response")
+		}
+		_, _ = w.Write(b)
+	}))
+	defer s.Close()
+
+	announcer := HttpAnnouncer{Query: "port={{.Port}}"}
+	if err := announcer.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url2.Parse(s.URL)
+	response, err := announcer.Announce(*u, AnnounceRequest{}, context.Background())
+	if err != nil {
+		t.Fatalf("httpAnnouncer.Announce() has failed: %v", err)
+	}
+	assert.Equal(t, "203.0.113.7", response.ExternalIp.String())
+	assert.Equal(t, int32(20), response.Seeders)
+}
+
+func Test_readExternalIp(t *testing.T) {
+	assert.Nil(t, readExternalIp([]byte("d8:intervali150ee")))
+	assert.Nil(t, readExternalIp([]byte("d11:external ip3:abce")))
+	assert.Equal(t, "2001:db8::1", readExternalIp([]byte("d11:external ip16:"+string(net.ParseIP("2001:db8::1"))+"e")).String())
+}
+
+func TestHttpAnnouncer_AnnounceShouldGoThroughTheRoute(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("d8:intervali150e5:peers0:e"))
+	}))
+	defer s.Close()
+	port := s.Listener.Addr().(*net.TCPAddr).Port
+
+	announcer := HttpAnnouncer{Query: "port={{.Port}}"}
+	if err := announcer.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+	// the tracker host only resolves through the route dns overrides
+	dialer, err := proxy.New("", proxy.Options{Hosts: map[string]net.IP{"tracker.joal.test": net.IPv4(127, 0, 0, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, _ := routing.NewRoute("private", []string{"*.joal.test"}, nil, dialer)
+	u, _ := url2.Parse(fmt.Sprintf("http://tracker.joal.test:%d/announce", port))
+
+	_, err = announcer.Announce(*u, AnnounceRequest{Route: route}, context.Background())
+	assert.NoError(t, err)
+
+	assert.NotSame(t, announcer.httpClient(route, AnyFamily), announcer.httpClient(nil, AnyFamily), "each route should have its own pool")
+	assert.Same(t, announcer.httpClient(route, AnyFamily), announcer.httpClient(route, AnyFamily))
+}
@@ -2,32 +2,122 @@ package announcer
 
 import (
 	"fmt"
+	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/casing"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/urlencoder"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+	"net"
 	"strconv"
+	"strings"
 	"text/template"
 )
 
-func TemplateFunctions(encoder *urlencoder.UrlEncoder) template.FuncMap {
-	return template.FuncMap{
-		"byteArray20ToString": func(s [20]byte) string {
+// TemplateFunctionsVersion is the latest version of the function set available in the query templates.
+// A client file declares the version it was written against, functions introduced after this version are not exposed to its template.
+// Every function along with the version that introduced it is documented in TEMPLATE.md
+const TemplateFunctionsVersion = 2
+
+// versionedFunction is a function of the set, since is the version that introduced it and is left unset for the functions of the first version
+type versionedFunction struct {
+	since int
+	fn    interface{}
+}
+
+func templateFunctions(encoder *urlencoder.UrlEncoder) map[string]versionedFunction {
+	return map[string]versionedFunction{
+		// version 1
+		"byteArray20ToString": {fn: func(s [20]byte) string {
 			return string(s[:])
-		},
-		"uint32ToHexString": func(k uint32) string {
+		}},
+		"uint32ToHexString": {fn: func(k uint32) string {
 			hex := strconv.FormatInt(int64(k), 16)
 			return hex
-		},
-		"withLeadingZeroes": func(str string, upToLength int) string {
+		}},
+		"uint32ToDecimalString": {fn: func(k uint32) string {
+			return strconv.FormatUint(uint64(k), 10)
+		}},
+		"uint32ToFixedLengthHexString": {fn: func(k uint32, length int) string {
+			// keep the least significant digits if the number does not fit in length
+			hex := fmt.Sprintf("%08x", k)
+			if length < len(hex) {
+				return hex[len(hex)-length:]
+			}
+			return fmt.Sprintf("%0"+strconv.Itoa(length)+"s", hex)
+		}},
+		"withLeadingZeroes": {fn: func(str string, upToLength int) string {
 			return fmt.Sprintf("%0"+strconv.Itoa(upToLength)+"s", str)
-		},
-		"toLower": func(str string) string {
+		}},
+		"toLower": {fn: func(str string) string {
 			return casing.Lower.ApplyCase(str)
-		},
-		"toUpper": func(str string) string {
+		}},
+		"toUpper": {fn: func(str string) string {
 			return casing.Upper.ApplyCase(str)
-		},
-		"urlEncode": func(str string) string {
+		}},
+		"urlEncode": {fn: func(str string) string {
 			return encoder.Encode(str)
-		},
+		}},
+
+		// version 2
+		"isIPv4": {since: 2, fn: func(ip net.IP) bool {
+			return ip != nil && ip.To4() != nil
+		}},
+		"isIPv6": {since: 2, fn: func(ip net.IP) bool {
+			return ip != nil && ip.To4() == nil && ip.To16() != nil
+		}},
+		"ipv4String": {since: 2, fn: func(ip net.IP) string {
+			if ip == nil || ip.To4() == nil {
+				return ""
+			}
+			return ip.To4().String()
+		}},
+		"ipv6String": {since: 2, fn: func(ip net.IP) string {
+			if ip == nil || ip.To4() != nil || ip.To16() == nil {
+				return ""
+			}
+			return ip.To16().String()
+		}},
+		"isEvent": {since: 2, fn: func(event tracker.AnnounceEvent, names ...string) bool {
+			for _, name := range names {
+				if strings.EqualFold(event.String(), name) {
+					return true
+				}
+			}
+			return false
+		}},
+		"paramIf": {since: 2, fn: func(condition bool, name string, value interface{}) string {
+			if !condition {
+				return ""
+			}
+			return fmt.Sprintf("&%s=%v", name, value)
+		}},
+		"urlEncodeKeeping": {since: 2, fn: func(str string, safeChars string) string {
+			return encoder.EncodeKeeping(str, safeChars)
+		}},
+		"randomString": {since: 2, fn: func(chars string, length int) string {
+			if chars == "" || length <= 0 {
+				return ""
+			}
+			return randutils.String(chars, length)
+		}},
+	}
+}
+
+// TemplateFunctions returns the latest version of the function set
+func TemplateFunctions(encoder *urlencoder.UrlEncoder) template.FuncMap {
+	funcs, _ := TemplateFunctionsForVersion(TemplateFunctionsVersion, encoder)
+	return funcs
+}
+
+// TemplateFunctionsForVersion returns the functions available to a template written against the given version of the function set
+func TemplateFunctionsForVersion(version int, encoder *urlencoder.UrlEncoder) (template.FuncMap, error) {
+	if version < 1 || version > TemplateFunctionsVersion {
+		return nil, fmt.Errorf("template functions version %d is not supported, supported versions are 1 to %d", version, TemplateFunctionsVersion)
+	}
+	funcs := template.FuncMap{}
+	for name, f := range templateFunctions(encoder) {
+		if f.since <= version {
+			funcs[name] = f.fn
+		}
 	}
+	return funcs, nil
 }
@@ -2,9 +2,12 @@ package announcer
 
 import (
 	"bytes"
+	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/casing"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/urlencoder"
+	"github.com/stretchr/testify/assert"
 	"math"
+	"net"
 	"testing"
 	"text/template"
 )
@@ -26,6 +29,11 @@ func Test_TemplateFunctions(t *testing.T) {
 		{name: "byteArrayToString", args: args{templateString: "jo={{byteArray20ToString .Attr}}", templateData: map[string]interface{}{"Attr": [20]byte{0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61}}}, want: "jo=aaaaaaaaaaaaaaaaaaaa"},
 		{name: "byteArrayToString", args: args{templateString: "jo={{byteArray20ToString .Attr}}", templateData: map[string]interface{}{"Attr": [20]byte{0x61, 0x00, 0x61, 0xff, 0x10, 0x61, 0x00, 0x61, 0xff, 0x10, 0x61, 0x00, 0x61, 0xff, 0x10, 0x61, 0x00, 0x61, 0xff, 0x10}}}, want: "jo=a\x00a\xff\x10a\x00a\xff\x10a\x00a\xff\x10a\x00a\xff\x10"},
 		{name: "byteArrayToString", args: args{templateString: "jo={{byteArray20ToString .Attr}}", templateData: map[string]interface{}{"Attr": [20]byte{}}}, want: "jo=\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{name: "uint32ToDecimalString", args: args{templateString: "jo={{uint32ToDecimalString .Attr}}", templateData: map[string]interface{}{"Attr": uint32(0)}}, want: "jo=0"},
+		{name: "uint32ToDecimalString", args: args{templateString: "jo={{uint32ToDecimalString .Attr}}", templateData: map[string]interface{}{"Attr": uint32(math.MaxUint32)}}, want: "jo=4294967295"},
+		{name: "uint32ToFixedLengthHexString", args: args{templateString: "jo={{uint32ToFixedLengthHexString .Attr 8}}", templateData: map[string]interface{}{"Attr": uint32(254)}}, want: "jo=000000fe"},
+		{name: "uint32ToFixedLengthHexString", args: args{templateString: "jo={{uint32ToFixedLengthHexString .Attr 4}}", templateData: map[string]interface{}{"Attr": uint32(0x12345678)}}, want: "jo=5678"},
+		{name: "uint32ToFixedLengthHexString", args: args{templateString: "jo={{uint32ToFixedLengthHexString .Attr 10}}", templateData: map[string]interface{}{"Attr": uint32(math.MaxUint32)}}, want: "jo=00ffffffff"},
 		{name: "withLeadingZeroes", args: args{templateString: "jo={{withLeadingZeroes .Attr 8}}", templateData: map[string]interface{}{"Attr": ""}}, want: "jo=00000000"},
 		{name: "withLeadingZeroes", args: args{templateString: "jo={{withLeadingZeroes .Attr 8}}", templateData: map[string]interface{}{"Attr": "123"}}, want: "jo=00000123"},
 		{name: "withLeadingZeroes", args: args{templateString: "jo={{withLeadingZeroes .Attr 8}}", templateData: map[string]interface{}{"Attr": "123456789"}}, want: "jo=123456789"},
@@ -34,6 +42,21 @@ func Test_TemplateFunctions(t *testing.T) {
 		{name: "toUpper", args: args{templateString: "jo={{toUpper .Attr}}", templateData: map[string]interface{}{"Attr": "AbcD"}}, want: "jo=ABCD"},
 		{name: "toUpper", args: args{templateString: "jo={{toUpper .Attr}}", templateData: map[string]interface{}{"Attr": "ABCD"}}, want: "jo=ABCD"},
 		{name: "urlEncode", args: args{templateString: "jo={{urlEncode .Attr}}", templateData: map[string]interface{}{"Attr": "AB&%CD"}}, want: "jo=AB%26%25CD"},
+		{name: "isIPv4", args: args{templateString: "jo={{isIPv4 .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("203.0.113.1")}}, want: "jo=true"},
+		{name: "isIPv4", args: args{templateString: "jo={{isIPv4 .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("2001:db8::1")}}, want: "jo=false"},
+		{name: "isIPv6", args: args{templateString: "jo={{isIPv6 .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("2001:db8::1")}}, want: "jo=true"},
+		{name: "isIPv6", args: args{templateString: "jo={{isIPv6 .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("203.0.113.1")}}, want: "jo=false"},
+		{name: "ipv4String", args: args{templateString: "jo={{ipv4String .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("203.0.113.1")}}, want: "jo=203.0.113.1"},
+		{name: "ipv4String", args: args{templateString: "jo={{ipv4String .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("2001:db8::1")}}, want: "jo="},
+		{name: "ipv6String", args: args{templateString: "jo={{ipv6String .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("2001:db8::1")}}, want: "jo=2001:db8::1"},
+		{name: "ipv6String", args: args{templateString: "jo={{ipv6String .Attr}}", templateData: map[string]interface{}{"Attr": net.ParseIP("203.0.113.1")}}, want: "jo="},
+		{name: "isEvent", args: args{templateString: "jo={{isEvent .Attr \"started\" \"stopped\"}}", templateData: map[string]interface{}{"Attr": tracker.Stopped}}, want: "jo=true"},
+		{name: "isEvent", args: args{templateString: "jo={{isEvent .Attr \"started\" \"stopped\"}}", templateData: map[string]interface{}{"Attr": tracker.None}}, want: "jo=false"},
+		{name: "paramIf", args: args{templateString: "jo=1{{paramIf .Attr \"a\" 12}}", templateData: map[string]interface{}{"Attr": true}}, want: "jo=1&a=12"},
+		{name: "paramIf", args: args{templateString: "jo=1{{paramIf .Attr \"a\" 12}}", templateData: map[string]interface{}{"Attr": false}}, want: "jo=1"},
+		{name: "urlEncodeKeeping", args: args{templateString: "jo={{urlEncodeKeeping .Attr \"-.\"}}", templateData: map[string]interface{}{"Attr": "a-b.c_d~e"}}, want: "jo=a-b.c%5Fd%7Ee"},
+		{name: "randomString", args: args{templateString: "jo={{randomString \"a\" 4}}", templateData: map[string]interface{}{}}, want: "jo=aaaa"},
+		{name: "randomString", args: args{templateString: "jo={{randomString \"abc\" 0}}", templateData: map[string]interface{}{}}, want: "jo="},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -54,3 +77,26 @@ func Test_TemplateFunctions(t *testing.T) {
 		})
 	}
 }
+
+func TestTemplateFunctionsForVersion_ShouldNotExposeFunctionsFromLaterVersions(t *testing.T) {
+	urlEncoder := urlencoder.UrlEncoder{EncodedHexCase: casing.Upper}
+	funcs, err := TemplateFunctionsForVersion(1, &urlEncoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, funcs, "urlEncode")
+	assert.NotContains(t, funcs, "paramIf")
+
+	_, err = template.New("v1").Funcs(funcs).Parse(`{{paramIf true "a" "b"}}`)
+	assert.Error(t, err)
+}
+
+func TestTemplateFunctionsForVersion_ShouldFailForUnsupportedVersions(t *testing.T) {
+	urlEncoder := urlencoder.UrlEncoder{EncodedHexCase: casing.Upper}
+	_, err := TemplateFunctionsForVersion(0, &urlEncoder)
+	assert.Error(t, err)
+	_, err = TemplateFunctionsForVersion(TemplateFunctionsVersion+1, &urlEncoder)
+	assert.Error(t, err)
+	_, err = TemplateFunctionsForVersion(TemplateFunctionsVersion, &urlEncoder)
+	assert.NoError(t, err)
+}
@@ -1,6 +1,190 @@
 package announcer
 
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/anacrolix/torrent/tracker"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+)
+
 type IUdpAnnouncer interface {
 	iAnnouncer
 	AfterPropertiesSet() error
 }
+
+const (
+	udpProtocolId = 0x41727101980
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionError    = 3
+
+	// BEP 41
+	udpOptionUrlData = 0x2
+
+	udpMaxAttempts       = 3
+	udpDefaultRetransmit = 15 * time.Second
+	udpDefaultPort       = "80"
+)
+
+// UdpAnnouncer announces over UDP (BEP 15). The path and query of the tracker url are sent as BEP 41 url data.
+// The client file has to declare it with an empty mapping (`udp: {}`).
+type UdpAnnouncer struct {
+	// wait time before the first retransmission, doubled for each retry
+	retransmitTimeout time.Duration `yaml:"-"`
+}
+
+func (a *UdpAnnouncer) AfterPropertiesSet() error {
+	if a.retransmitTimeout == 0 {
+		a.retransmitTimeout = udpDefaultRetransmit
+	}
+	return nil
+}
+
+func (a *UdpAnnouncer) Announce(u url.URL, announceRequest AnnounceRequest, ctx context.Context) (AnnounceResponse, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), udpDefaultPort)
+	}
+	conn, err := announceRequest.Route.Dialer().DialContext(ctx, announceRequest.AddressFamily.Network("udp"), host)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	// unblock the pending read as soon as the context is done
+	stopAfterFunc := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stopAfterFunc()
+
+	body, err := a.request(ctx, conn, udpProtocolId, udpActionConnect, nil)
+	if err != nil {
+		return AnnounceResponse{}, fmt.Errorf("udp connect failed: %w", err)
+	}
+	if len(body) < 8 {
+		return AnnounceResponse{}, fmt.Errorf("udp connect response is too short (%d bytes)", len(body))
+	}
+	connectionId := binary.BigEndian.Uint64(body[:8])
+
+	body, err = a.request(ctx, conn, connectionId, udpActionAnnounce, announcePayload(u, announceRequest))
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	if len(body) < 12 {
+		return AnnounceResponse{}, fmt.Errorf("udp announce response is too short (%d bytes)", len(body))
+	}
+	ret := AnnounceResponse{
+		Interval: time.Duration(binary.BigEndian.Uint32(body[0:4])) * time.Second,
+		Leechers: int32(binary.BigEndian.Uint32(body[4:8])),
+		Seeders:  int32(binary.BigEndian.Uint32(body[8:12])),
+	}
+	// BEP 15: the peers are of the family of the socket the announce has been sent through
+	ipLen := net.IPv4len
+	if announceRequest.AddressFamily == IPv6Family || isIPv6Addr(conn.RemoteAddr()) {
+		ipLen = net.IPv6len
+	}
+	peers := body[12:]
+	for ; len(peers) >= ipLen+2; peers = peers[ipLen+2:] {
+		ip := make(net.IP, ipLen)
+		copy(ip, peers[:ipLen])
+		ret.Peers = append(ret.Peers, tracker.Peer{
+			IP:   ip,
+			Port: int(binary.BigEndian.Uint16(peers[ipLen : ipLen+2])),
+		})
+	}
+	return ret, nil
+}
+
+// announcePayload returns the announce request body, following the connection id, action and transaction id
+func announcePayload(u url.URL, ar AnnounceRequest) []byte {
+	b := make([]byte, 0, 82)
+	b = append(b, ar.InfoHash[:]...)
+	b = append(b, ar.PeerId[:]...)
+	b = binary.BigEndian.AppendUint64(b, uint64(ar.Downloaded))
+	b = binary.BigEndian.AppendUint64(b, uint64(ar.Left))
+	b = binary.BigEndian.AppendUint64(b, uint64(ar.Uploaded))
+	b = binary.BigEndian.AppendUint32(b, uint32(ar.Event)) // tracker.AnnounceEvent values match BEP 15 ones
+	// like real clients, let the tracker use the address the packet comes from
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, ar.Key)
+	b = binary.BigEndian.AppendUint32(b, uint32(ar.NumWant))
+	b = binary.BigEndian.AppendUint16(b, ar.Port)
+
+	requestUri := u.RequestURI()
+	if requestUri == "/" {
+		return b
+	}
+	for len(requestUri) > 0 {
+		chunk := requestUri
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		b = append(b, udpOptionUrlData, byte(len(chunk)))
+		b = append(b, chunk...)
+		requestUri = requestUri[len(chunk):]
+	}
+	return b
+}
+
+// request sends the packet, retransmitting it until a response with the same transaction id is received, and returns
+// the response body (following the action and transaction id)
+func (a *UdpAnnouncer) request(ctx context.Context, conn net.Conn, connectionId uint64, action uint32, payload []byte) ([]byte, error) {
+	transactionId := rand.Uint32()
+	packet := make([]byte, 0, 16+len(payload))
+	packet = binary.BigEndian.AppendUint64(packet, connectionId)
+	packet = binary.BigEndian.AppendUint32(packet, action)
+	packet = binary.BigEndian.AppendUint32(packet, transactionId)
+	packet = append(packet, payload...)
+
+	buf := make([]byte, 64*1024)
+	for attempt := 0; attempt < udpMaxAttempts; attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(a.retransmitTimeout << attempt)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		_ = conn.SetReadDeadline(deadline)
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			n, err := conn.Read(buf)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break // retransmit
+			}
+			if err != nil {
+				return nil, err
+			}
+			if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != transactionId {
+				continue // stale or garbage packet
+			}
+			switch gotAction := binary.BigEndian.Uint32(buf[0:4]); gotAction {
+			case action:
+				return append([]byte{}, buf[8:n]...), nil
+			case udpActionError:
+				return nil, fmt.Errorf("tracker gave failure reason: %q", buf[8:n])
+			default:
+				return nil, fmt.Errorf("tracker answered action %d to action %d", gotAction, action)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no response from tracker after %d attempts", udpMaxAttempts)
+}
+
+func isIPv6Addr(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
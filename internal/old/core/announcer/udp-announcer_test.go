@@ -0,0 +1,188 @@
+package announcer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/routing"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/testutils"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUdpTracker is an in-process BEP 15 tracker
+type fakeUdpTracker struct {
+	conn         net.PacketConn
+	lock         *sync.Mutex
+	dropPackets  int
+	failure      string
+	lastAnnounce []byte
+}
+
+const fakeUdpTrackerConnectionId = 0xdeadbeefcafe
+
+func newFakeUdpTracker(t *testing.T) *fakeUdpTracker {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	f := &fakeUdpTracker{conn: conn, lock: &sync.Mutex{}}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := f.handle(buf[:n]); resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return f
+}
+
+func (f *fakeUdpTracker) handle(packet []byte) []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.dropPackets > 0 {
+		f.dropPackets--
+		return nil
+	}
+	connectionId := binary.BigEndian.Uint64(packet[0:8])
+	action := binary.BigEndian.Uint32(packet[8:12])
+	resp := binary.BigEndian.AppendUint32(nil, action)
+	resp = append(resp, packet[12:16]...)
+	switch {
+	case action == udpActionConnect && connectionId == udpProtocolId:
+		return binary.BigEndian.AppendUint64(resp, fakeUdpTrackerConnectionId)
+	case action == udpActionAnnounce && connectionId == fakeUdpTrackerConnectionId:
+		if f.failure != "" {
+			binary.BigEndian.PutUint32(resp[0:4], udpActionError)
+			return append(resp, f.failure...)
+		}
+		f.lastAnnounce = append([]byte{}, packet[16:]...)
+		resp = binary.BigEndian.AppendUint32(resp, 1800)
+		resp = binary.BigEndian.AppendUint32(resp, 3)
+		resp = binary.BigEndian.AppendUint32(resp, 7)
+		resp = append(resp, 10, 0, 0, 1, 0x1a, 0xe1)
+		return append(resp, 10, 0, 0, 2, 0x1a, 0xe2)
+	}
+	binary.BigEndian.PutUint32(resp[0:4], udpActionError)
+	return append(resp, "unknown connection id"...)
+}
+
+func (f *fakeUdpTracker) announce() []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.lastAnnounce
+}
+
+func newTestUdpAnnouncer(t *testing.T) *UdpAnnouncer {
+	a := &UdpAnnouncer{retransmitTimeout: 50 * time.Millisecond}
+	if err := a.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestUdpAnnouncer_ShouldAnnounce(t *testing.T) {
+	fakeTracker := newFakeUdpTracker(t)
+	request := SyntheticAnnounceRequest(tracker.Started, net.IPv4(203, 0, 113, 1), nil)
+	request.Left = 42
+
+	resp, err := newTestUdpAnnouncer(t).Announce(*testutils.MustParseUrl("udp://" + fakeTracker.conn.LocalAddr().String() + "/announce?passkey=abc"), request, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1800*time.Second, resp.Interval)
+	assert.Equal(t, int32(3), resp.Leechers)
+	assert.Equal(t, int32(7), resp.Seeders)
+	assert.Equal(t, []tracker.Peer{{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}, {IP: net.IPv4(10, 0, 0, 2).To4(), Port: 6882}}, resp.Peers)
+
+	sent := fakeTracker.announce()
+	if !assert.Len(t, sent, 82+2+len("/announce?passkey=abc")) {
+		return
+	}
+	assert.Equal(t, request.InfoHash[:], sent[0:20])
+	assert.Equal(t, request.PeerId[:], sent[20:40])
+	assert.Equal(t, uint64(42), binary.BigEndian.Uint64(sent[48:56]))
+	assert.Equal(t, uint64(request.Uploaded), binary.BigEndian.Uint64(sent[56:64]))
+	assert.Equal(t, uint32(tracker.Started), binary.BigEndian.Uint32(sent[64:68]))
+	assert.Equal(t, request.Key, binary.BigEndian.Uint32(sent[72:76]))
+	assert.Equal(t, uint32(request.NumWant), binary.BigEndian.Uint32(sent[76:80]))
+	assert.Equal(t, request.Port, binary.BigEndian.Uint16(sent[80:82]))
+	assert.Equal(t, append([]byte{udpOptionUrlData, byte(len("/announce?passkey=abc"))}, "/announce?passkey=abc"...), sent[82:])
+}
+
+func TestUdpAnnouncer_ShouldRetransmit(t *testing.T) {
+	fakeTracker := newFakeUdpTracker(t)
+	fakeTracker.dropPackets = 2
+
+	_, err := newTestUdpAnnouncer(t).Announce(*testutils.MustParseUrl("udp://" + fakeTracker.conn.LocalAddr().String()), SyntheticAnnounceRequest(tracker.None, nil, nil), context.Background())
+	assert.NoError(t, err)
+}
+
+func TestUdpAnnouncer_ShouldReturnTrackerFailure(t *testing.T) {
+	fakeTracker := newFakeUdpTracker(t)
+	fakeTracker.failure = "torrent not registered"
+
+	_, err := newTestUdpAnnouncer(t).Announce(*testutils.MustParseUrl("udp://" + fakeTracker.conn.LocalAddr().String()), SyntheticAnnounceRequest(tracker.None, nil, nil), context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "torrent not registered")
+	}
+}
+
+func TestUdpAnnouncer_ShouldGiveUpWhenContextIsDone(t *testing.T) {
+	fakeTracker := newFakeUdpTracker(t)
+	fakeTracker.dropPackets = 1000
+	announcer := newTestUdpAnnouncer(t)
+	announcer.retransmitTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err := announcer.Announce(*testutils.MustParseUrl("udp://" + fakeTracker.conn.LocalAddr().String()), SyntheticAnnounceRequest(tracker.None, nil, nil), ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func Test_announcePayloadShouldSplitLongUrlData(t *testing.T) {
+	path := "/announce/" + strings.Repeat("a", 300)
+	u := testutils.MustParseUrl("udp://tracker.lan:6969")
+	u.Path = path
+	payload := announcePayload(*u, AnnounceRequest{})
+	options := payload[82:]
+	uri := u.RequestURI()
+	assert.Equal(t, []byte{udpOptionUrlData, 255}, options[0:2])
+	assert.Equal(t, uri[:255], string(options[2:257]))
+	assert.Equal(t, []byte{udpOptionUrlData, byte(len(uri) - 255)}, options[257:259])
+	assert.Equal(t, uri[255:], string(options[259:]))
+
+	assert.Len(t, announcePayload(*testutils.MustParseUrl("udp://tracker.lan:6969"), AnnounceRequest{}), 82)
+}
+
+func TestUdpAnnouncer_ShouldGoThroughTheRoute(t *testing.T) {
+	fakeTracker := newFakeUdpTracker(t)
+	port := fakeTracker.conn.LocalAddr().(*net.UDPAddr).Port
+	dialer, err := proxy.New("", proxy.Options{Hosts: map[string]net.IP{"tracker.joal.test": net.IPv4(127, 0, 0, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, _ := routing.NewRoute("private", nil, []string{"udp"}, dialer)
+	request := SyntheticAnnounceRequest(tracker.None, nil, nil)
+	request.Route = route
+
+	_, err = newTestUdpAnnouncer(t).Announce(*testutils.MustParseUrl(fmt.Sprintf("udp://tracker.joal.test:%d", port)), request, context.Background())
+	assert.NoError(t, err)
+}
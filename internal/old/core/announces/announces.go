@@ -18,21 +18,25 @@ type AnnounceRequest struct {
 	Corrupt           int64
 	Event             tracker.AnnounceEvent
 	Private           bool
+	TrackerId         string
 	AnnounceCallbacks *AnnounceCallbacks
 }
 
 type AnnounceResponse struct {
-	Request  *AnnounceRequest
-	Interval time.Duration // Minimum seconds the local peer should wait before next announce.
-	Leechers int32
-	Seeders  int32
-	Peers    []tracker.Peer
+	Request   *AnnounceRequest
+	Interval  time.Duration // Minimum seconds the local peer should wait before next announce.
+	Leechers  int32
+	Seeders   int32
+	Peers     []tracker.Peer
+	TrackerId string
+	Route     string // name of the routing rule the announce went through
 }
 
 type AnnounceResponseError struct {
 	Error    error
 	Request  *AnnounceRequest
 	Interval time.Duration // Minimum seconds the local peer should wait before next announce. May be 0 if the error is not related to the tracker response
+	Route    string        // name of the routing rule the announce went through
 }
 
 type AnnounceCallbacks struct {
@@ -0,0 +1,181 @@
+package bandwidth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/fileutils"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	DailyBudget   = "day"
+	WeeklyBudget  = "week"
+	MonthlyBudget = "month"
+)
+
+// budgetThresholds are the fractions of the budget notified once per period
+var budgetThresholds = []float64{0.8, 1}
+
+type budgetFile struct {
+	PeriodStart time.Time `yaml:"periodStart"`
+	Consumed    int64     `yaml:"consumed"`
+}
+
+// uploadBudget counts the bytes accounted as uploaded by the torrents over the current period. It is only used by the
+// dispatcher routine.
+type uploadBudget struct {
+	limit       int64
+	period      string
+	location    *time.Location
+	path        string
+	periodStart time.Time
+	consumed    float64
+	// notified is the number of budgetThresholds already notified during the period
+	notified int
+	dirty    bool
+}
+
+// newUploadBudget returns nil when the budget is disabled, the consumption of the current period is restored from path
+func newUploadBudget(conf *core.BudgetConfig, path string, now time.Time) (*uploadBudget, error) {
+	if conf == nil || conf.Limit == 0 {
+		return nil, nil
+	}
+	if conf.Limit < 0 {
+		return nil, fmt.Errorf("budget limit must be positive")
+	}
+	period := conf.Period
+	switch period {
+	case "":
+		period = DailyBudget
+	case DailyBudget, WeeklyBudget, MonthlyBudget:
+	default:
+		return nil, fmt.Errorf("unknown budget period '%s'", conf.Period)
+	}
+	location := time.Local
+	if conf.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(conf.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid budget timezone: %w", err)
+		}
+	}
+	b := &uploadBudget{
+		limit:    conf.Limit,
+		period:   period,
+		location: location,
+		path:     path,
+	}
+	b.periodStart = b.startOfPeriod(now)
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read budget file '%s': %w", path, err)
+	}
+	if err == nil {
+		file := budgetFile{}
+		if err := yaml.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse budget file '%s': %w", path, err)
+		}
+		if file.PeriodStart.Equal(b.periodStart) {
+			b.consumed = float64(file.Consumed)
+		}
+	}
+	// thresholds crossed before a restart have already been notified
+	for b.notified < len(budgetThresholds) && b.consumed >= budgetThresholds[b.notified]*float64(b.limit) {
+		b.notified++
+	}
+	return b, nil
+}
+
+func (b *uploadBudget) startOfPeriod(t time.Time) time.Time {
+	t = t.In(b.location)
+	switch b.period {
+	case WeeklyBudget:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, b.location)
+	case MonthlyBudget:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, b.location)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, b.location)
+	}
+}
+
+func (b *uploadBudget) endOfPeriod() time.Time {
+	switch b.period {
+	case WeeklyBudget:
+		return b.periodStart.AddDate(0, 0, 7)
+	case MonthlyBudget:
+		return b.periodStart.AddDate(0, 1, 0)
+	default:
+		return b.periodStart.AddDate(0, 0, 1)
+	}
+}
+
+// rollIfNeeded starts a new period when now is past the current one, returns true if it did
+func (b *uploadBudget) rollIfNeeded(now time.Time) bool {
+	if now.Before(b.endOfPeriod()) {
+		return false
+	}
+	b.periodStart = b.startOfPeriod(now)
+	b.consumed = 0
+	b.notified = 0
+	b.dirty = true
+	return true
+}
+
+// consume counts the bytes uploaded and returns the thresholds crossed by this consumption
+func (b *uploadBudget) consume(bytes float64) []float64 {
+	if bytes <= 0 {
+		return nil
+	}
+	b.consumed += bytes
+	b.dirty = true
+	var crossed []float64
+	for b.notified < len(budgetThresholds) && b.consumed >= budgetThresholds[b.notified]*float64(b.limit) {
+		crossed = append(crossed, budgetThresholds[b.notified])
+		b.notified++
+	}
+	return crossed
+}
+
+func (b *uploadBudget) exhausted() bool {
+	return b.consumed >= float64(b.limit)
+}
+
+// allowance caps the bandwidth to spread what is left of the budget evenly over what is left of the period
+func (b *uploadBudget) allowance(bandwidth int64, now time.Time) int64 {
+	remaining := float64(b.limit) - b.consumed
+	if remaining <= 0 {
+		return 0
+	}
+	secondsLeft := b.endOfPeriod().Sub(now).Seconds()
+	if secondsLeft < 1 {
+		secondsLeft = 1
+	}
+	pace := int64(math.Ceil(remaining / secondsLeft))
+	if pace < bandwidth {
+		return pace
+	}
+	return bandwidth
+}
+
+func (b *uploadBudget) save() error {
+	if !b.dirty {
+		return nil
+	}
+	content, err := yaml.Marshal(&budgetFile{PeriodStart: b.periodStart, Consumed: int64(b.consumed)})
+	if err != nil {
+		return fmt.Errorf("failed to serialize budget: %w", err)
+	}
+	err = fileutils.WriteFileAtomic(b.path, content)
+	if err != nil {
+		return fmt.Errorf("failed to save budget: %w", err)
+	}
+	b.dirty = false
+	return nil
+}
@@ -0,0 +1,125 @@
+package bandwidth
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mustNewUploadBudget(t *testing.T, conf *core.BudgetConfig, now time.Time) *uploadBudget {
+	b, err := newUploadBudget(conf, filepath.Join(t.TempDir(), "budget.yml"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNewUploadBudget(t *testing.T) {
+	now := time.Now()
+	b, err := newUploadBudget(nil, "", now)
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+	b, err = newUploadBudget(&core.BudgetConfig{Limit: 0, Period: WeeklyBudget}, "", now)
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+
+	_, err = newUploadBudget(&core.BudgetConfig{Limit: -1}, "", now)
+	assert.Error(t, err)
+	_, err = newUploadBudget(&core.BudgetConfig{Limit: 1, Period: "year"}, "", now)
+	assert.Error(t, err)
+	_, err = newUploadBudget(&core.BudgetConfig{Limit: 1, Timezone: "Mars/Olympus"}, "", now)
+	assert.Error(t, err)
+
+	b = mustNewUploadBudget(t, &core.BudgetConfig{Limit: 1}, now)
+	assert.Equal(t, DailyBudget, b.period)
+}
+
+func TestUploadBudget_PeriodBoundaries(t *testing.T) {
+	// wednesday
+	now := time.Date(2021, time.March, 3, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		period    string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{period: DailyBudget, wantStart: time.Date(2021, time.March, 3, 0, 0, 0, 0, time.UTC), wantEnd: time.Date(2021, time.March, 4, 0, 0, 0, 0, time.UTC)},
+		{period: WeeklyBudget, wantStart: time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC), wantEnd: time.Date(2021, time.March, 8, 0, 0, 0, 0, time.UTC)},
+		{period: MonthlyBudget, wantStart: time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC), wantEnd: time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			b := mustNewUploadBudget(t, &core.BudgetConfig{Limit: 1, Period: tt.period, Timezone: "UTC"}, now)
+			assert.True(t, tt.wantStart.Equal(b.periodStart), "got %s", b.periodStart)
+			assert.True(t, tt.wantEnd.Equal(b.endOfPeriod()), "got %s", b.endOfPeriod())
+		})
+	}
+
+	// a sunday belongs to the week started on the previous monday
+	b := mustNewUploadBudget(t, &core.BudgetConfig{Limit: 1, Period: WeeklyBudget, Timezone: "UTC"}, time.Date(2021, time.March, 7, 23, 0, 0, 0, time.UTC))
+	assert.True(t, time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC).Equal(b.periodStart))
+}
+
+func TestUploadBudget_ShouldNotifyEachThresholdOncePerPeriod(t *testing.T) {
+	now := time.Date(2021, time.March, 3, 12, 0, 0, 0, time.UTC)
+	b := mustNewUploadBudget(t, &core.BudgetConfig{Limit: 1000, Timezone: "UTC"}, now)
+
+	assert.Empty(t, b.consume(799))
+	assert.Equal(t, []float64{0.8}, b.consume(1))
+	assert.Empty(t, b.consume(100))
+	assert.False(t, b.exhausted())
+	assert.Equal(t, []float64{1}, b.consume(500))
+	assert.True(t, b.exhausted())
+	assert.Empty(t, b.consume(500))
+
+	assert.False(t, b.rollIfNeeded(now.Add(11*time.Hour)))
+	assert.True(t, b.rollIfNeeded(now.Add(12*time.Hour)))
+	assert.False(t, b.exhausted())
+	assert.Equal(t, []float64{0.8, 1}, b.consume(2000))
+}
+
+func TestUploadBudget_AllowanceShouldPaceRemainingBudget(t *testing.T) {
+	now := time.Date(2021, time.March, 3, 0, 0, 0, 0, time.UTC)
+	b := mustNewUploadBudget(t, &core.BudgetConfig{Limit: 86400 * 100, Timezone: "UTC"}, now)
+
+	// 100B/s spreads the whole budget over the day
+	assert.Equal(t, int64(100), b.allowance(1000, now))
+	assert.Equal(t, int64(50), b.allowance(50, now))
+
+	// half the budget is left for a quarter of the day
+	b.consume(86400 * 50)
+	assert.Equal(t, int64(200), b.allowance(1000, now.Add(18*time.Hour)))
+
+	b.consume(86400 * 50)
+	assert.Equal(t, int64(0), b.allowance(1000, now.Add(18*time.Hour)))
+}
+
+func TestUploadBudget_ShouldRestoreConsumptionOfCurrentPeriodOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.yml")
+	conf := &core.BudgetConfig{Limit: 1000, Timezone: "UTC"}
+	now := time.Date(2021, time.March, 3, 12, 0, 0, 0, time.UTC)
+
+	b, err := newUploadBudget(conf, path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.consume(850)
+	if err := b.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := newUploadBudget(conf, path, now.Add(1*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, float64(850), restored.consumed)
+	// 80% has been notified before the restart
+	assert.Equal(t, []float64{1}, restored.consume(150))
+
+	nextDay, err := newUploadBudget(conf, path, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, float64(0), nextDay.consumed)
+}
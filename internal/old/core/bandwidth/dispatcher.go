@@ -10,6 +10,8 @@ import (
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/dataunit"
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/stop"
 	"go.uber.org/zap"
+	"maps"
+	"math"
 	"sync"
 	"time"
 )
@@ -21,61 +23,239 @@ type Peers struct {
 
 type RegisteredTorrent struct {
 	InfoHash torrent.InfoHash
+	// Size of the torrent content in bytes
+	Size     int64
 	GetPeers func() *Peers
-	SetSpeed func(bps int64)
+	// GetContribution returns the bytes uploaded and the highest number of leechers seen by the torrent over all its
+	// seeding sessions, nil when unknown. The registrations come and go, they must not restart the count.
+	GetContribution func() (uploaded int64, leechersSeen int32)
+	SetSpeed        func(bps int64)
 }
 
 type SpeedDispatcher interface {
-	Start(config *core.DispatcherConfig)
+	Start(config *core.DispatcherConfig) error
 	Stop()
-	ReplaceSpeedConfig(config *core.SpeedProviderConfig)
+	ReplaceSpeedConfig(config *core.SpeedProviderConfig) error
 	Register(rt *RegisteredTorrent) (unregisterTorrent func())
+	// AccountUpload counts bytes accounted as uploaded by the torrents against the upload budget
+	AccountUpload(bytes int64)
 }
 
+// budgetSaveInterval debounces the persistence of the budget consumption, the budget is also saved when a period
+// starts and when the dispatcher stops
+const budgetSaveInterval = 5 * time.Minute
+
 type speedDispatcherImpl struct {
 	updateTorrentSpeedInterval time.Duration
 	randomSpeedProvider        iRandomSpeedProvider
 	isRunning                  bool
 	stopping                   stop.Chan
+	speedConfigReplaced        chan struct{}
 	lock                       *sync.Mutex
 	torrents                   *registeredTorrentList
+	budgetFile                 string
+	uploaded                   *uploadCounter
+	now                        func() time.Time
+}
+
+// uploadCounter sums the bytes accounted as uploaded until the dispatcher routine collects them
+type uploadCounter struct {
+	bytes int64
+	lock  *sync.Mutex
+}
+
+func (c *uploadCounter) add(bytes int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.bytes += bytes
+}
+
+// collect returns the bytes added since the previous collect
+func (c *uploadCounter) collect() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bytes := c.bytes
+	c.bytes = 0
+	return bytes
 }
 
-func NewSpeedDispatcher(conf *core.SpeedProviderConfig) SpeedDispatcher {
+// NewSpeedDispatcher creates a dispatcher, the consumption of the upload budget is persisted in budgetFile
+func NewSpeedDispatcher(conf *core.SpeedProviderConfig, budgetFile string) (SpeedDispatcher, error) {
+	speedProvider, err := newRandomSpeedProvider(conf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid speed config: %w", err)
+	}
 	s := &speedDispatcherImpl{
 		updateTorrentSpeedInterval: 20 * time.Second,
-		randomSpeedProvider:        newRandomSpeedProvider(conf),
+		randomSpeedProvider:        speedProvider,
 		isRunning:                  false,
 		stopping:                   stop.NewChan(),
+		speedConfigReplaced:        make(chan struct{}, 1),
 		lock:                       &sync.Mutex{},
 		torrents:                   newRegisteredTorrentList(),
+		budgetFile:                 budgetFile,
+		uploaded:                   &uploadCounter{lock: &sync.Mutex{}},
 	}
 
-	return s
+	return s, nil
 }
 
-func (s *speedDispatcherImpl) Start(config *core.DispatcherConfig) {
+func (s *speedDispatcherImpl) Start(config *core.DispatcherConfig) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	if s.isRunning {
-		return
+		return nil
+	}
+	if config.TorrentMaxBytesPerSeconds < 0 {
+		return fmt.Errorf("torrentMax must be positive")
+	}
+	if config.TorrentMinShare < 0 || config.TorrentMinShare > 1 {
+		return fmt.Errorf("torrentMinShare must be between 0 and 1")
+	}
+	strategy, err := NewWeightingStrategy(config.Weighting)
+	if err != nil {
+		return err
+	}
+	if config.PerLeecherMaxBytesPerSeconds < 0 {
+		return fmt.Errorf("perLeecherMax must be positive")
+	}
+	if config.UploadRatioPerLeecher < 0 {
+		return fmt.Errorf("uploadRatioPerLeecher must be positive")
+	}
+	rules := &distributionRules{
+		strategy:              strategy,
+		torrentMax:            config.TorrentMaxBytesPerSeconds,
+		minShare:              config.TorrentMinShare,
+		perLeecherMax:         config.PerLeecherMaxBytesPerSeconds,
+		uploadRatioPerLeecher: config.UploadRatioPerLeecher,
+		updateInterval:        s.updateTorrentSpeedInterval,
+	}
+	budget, err := newUploadBudget(config.Budget, s.budgetFile, s.clock())
+	if err != nil {
+		return fmt.Errorf("invalid budget: %w", err)
 	}
+	// the bytes accounted while the dispatcher was stopped do not belong to the budget
+	s.uploaded.collect()
 	s.isRunning = true
 
 	go func(s *speedDispatcherImpl) {
 		logger := logs.GetLogger()
 		refreshBandwidthTicker := time.NewTicker(config.GlobalBandwidthRefreshInterval)
 		updateTorrentSpeedTicker := time.NewTicker(s.updateTorrentSpeedInterval)
+		// fires when a schedule window starts or ends, nil channel when there is no schedule
+		var scheduleTimer *time.Timer
+		var scheduleTimerC <-chan time.Time
+		resetScheduleTimer := func() {
+			if scheduleTimer != nil {
+				scheduleTimer.Stop()
+			}
+			scheduleTimerC = nil
+			if delay, ok := s.randomSpeedProvider.DelayBeforeNextSwitch(); ok {
+				scheduleTimer = time.NewTimer(delay)
+				scheduleTimerC = scheduleTimer.C
+			}
+		}
+		emitBandwidthChanged := func(msg string) {
+			bps := s.randomSpeedProvider.GetBytesPerSeconds()
+			profile := s.randomSpeedProvider.GetProfile()
+			broadcast.EmitGlobalBandwidthChanged(broadcast.GlobalBandwidthChangedEvent{AvailableBandwidth: bps, SpeedProfile: profile})
+			logger.Info(msg,
+				zap.String("available-bandwidth", fmt.Sprintf("%s/s", dataunit.ByteCountSI(bps))),
+				zap.String("speed-profile", profile),
+			)
+		}
+
+		emitBudgetChanged := func() {
+			broadcast.EmitBandwidthBudgetChanged(broadcast.BandwidthBudgetChangedEvent{
+				Limit:       budget.limit,
+				Consumed:    int64(budget.consumed),
+				Period:      budget.period,
+				PeriodStart: budget.periodStart,
+				PeriodEnd:   budget.endOfPeriod(),
+			})
+		}
+		// the budget has just been loaded, there is nothing to save yet
+		lastBudgetSave := s.clock()
+		saveBudget := func(now time.Time) {
+			if err := budget.save(); err != nil {
+				logger.Error("bandwidth dispatcher: failed to save upload budget", zap.Error(err))
+				return
+			}
+			lastBudgetSave = now
+		}
+		// consumeUploaded counts the bytes accounted as uploaded since the previous call against the budget
+		consumeUploaded := func() {
+			wasExhausted := budget.exhausted()
+			for _, threshold := range budget.consume(float64(s.uploaded.collect())) {
+				broadcast.EmitBandwidthBudgetThresholdReached(broadcast.BandwidthBudgetThresholdReachedEvent{
+					Threshold: threshold,
+					Limit:     budget.limit,
+					Consumed:  int64(budget.consumed),
+					PeriodEnd: budget.endOfPeriod(),
+				})
+			}
+			if budget.exhausted() && !wasExhausted {
+				logger.Info("bandwidth dispatcher: upload budget exhausted, torrents will not upload until the next period",
+					zap.Time("period-end", budget.endOfPeriod()),
+				)
+			}
+		}
+
+		// speeds given to the torrents by the last update, only a change of distribution is broadcast
+		var lastSpeeds map[torrent.InfoHash]float64
+		if budget != nil {
+			budget.rollIfNeeded(s.clock())
+			emitBudgetChanged()
+		}
+
+		// the active window may have changed since the provider was built
+		if s.randomSpeedProvider.SwitchProfileIfNeeded() {
+			emitBandwidthChanged("bandwidth dispatcher: switched speed profile")
+		}
+		resetScheduleTimer()
 		for {
 			select {
 			case <-refreshBandwidthTicker.C:
 				s.randomSpeedProvider.Refresh()
-				broadcast.EmitGlobalBandwidthChanged(broadcast.GlobalBandwidthChangedEvent{AvailableBandwidth: s.randomSpeedProvider.GetBytesPerSeconds()})
-				logger.Info("bandwidth dispatcher: refreshed global bandwidth",
-					zap.String("available-bandwidth", fmt.Sprintf("%s/s", dataunit.ByteCountSI(s.randomSpeedProvider.GetBytesPerSeconds()))),
-				)
+				emitBandwidthChanged("bandwidth dispatcher: refreshed global bandwidth")
+			case <-scheduleTimerC:
+				if s.randomSpeedProvider.SwitchProfileIfNeeded() {
+					emitBandwidthChanged("bandwidth dispatcher: switched speed profile")
+				}
+				resetScheduleTimer()
+			case <-s.speedConfigReplaced:
+				emitBandwidthChanged("bandwidth dispatcher: speed config replaced")
+				resetScheduleTimer()
 			case <-updateTorrentSpeedTicker.C:
-				updateSpeed(s.torrents.List(), s.randomSpeedProvider.GetBytesPerSeconds())
+				now := s.clock()
+				bandwidth := s.randomSpeedProvider.GetBytesPerSeconds()
+				if budget == nil {
+					s.uploaded.collect()
+				} else {
+					// account for what has been uploaded since the previous update before sharing the bandwidth again. The
+					// period is rolled first, what has been uploaded across the boundary belongs to the new period.
+					consumedBefore := budget.consumed
+					rolled := budget.rollIfNeeded(now)
+					if rolled {
+						logger.Info("bandwidth dispatcher: new upload budget period started")
+					}
+					consumeUploaded()
+					bandwidth = budget.allowance(bandwidth, now)
+					if rolled || budget.consumed != consumedBefore {
+						emitBudgetChanged()
+					}
+					if rolled || now.Sub(lastBudgetSave) >= budgetSaveInterval {
+						saveBudget(now)
+					}
+				}
+				speeds := updateSpeed(s.torrents.List(), bandwidth, rules)
+				if !maps.Equal(speeds, lastSpeeds) {
+					lastSpeeds = speeds
+					broadcast.EmitBandwidthWeightHasChanged(broadcast.BandwidthWeightHasChangedEvent{
+						TotalWeight:    float64(bandwidth),
+						TorrentWeights: speeds,
+					})
+				}
 			case stopRequest := <-s.stopping:
 				//goland:noinspection GoDeferInLoop
 				defer func() {
@@ -94,6 +274,14 @@ func (s *speedDispatcherImpl) Start(config *core.DispatcherConfig) {
 				case <-updateTorrentSpeedTicker.C:
 				default:
 				}
+				if scheduleTimer != nil {
+					scheduleTimer.Stop()
+				}
+				if budget != nil {
+					// the torrents have been stopped first, their last accounting is part of the period
+					consumeUploaded()
+					saveBudget(s.clock())
+				}
 
 				s.torrents.Reset()
 
@@ -101,48 +289,72 @@ func (s *speedDispatcherImpl) Start(config *core.DispatcherConfig) {
 			}
 		}
 	}(s)
+	return nil
 }
 
-func updateSpeed(torrents []*RegisteredTorrent, currentBandwidth int64) {
-	if len(torrents) == 0 {
-		return
+func (s *speedDispatcherImpl) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
 	}
+	return s.now()
+}
 
-	sumOfLeechers := float64(0)
-	for _, registeredTorrent := range torrents {
-		p := registeredTorrent.GetPeers()
-		leech := float64(p.Leechers)
-
-		sumOfLeechers += leech
-	}
+// distributionRules holds the dispatcher policy, it is only used by the dispatcher routine
+type distributionRules struct {
+	strategy              WeightingStrategy
+	torrentMax            int64
+	minShare              float64
+	perLeecherMax         int64
+	uploadRatioPerLeecher float64
+	updateInterval        time.Duration
+}
 
-	totalWeight := float64(0)
-	weights := make([]float64, len(torrents))
+// ceilings returns the highest speed each torrent may get until the next update, +Inf when it is not capped
+func (r *distributionRules) ceilings(torrents []*RegisteredTorrent, swarms []TorrentSwarm) []float64 {
+	ceilings := make([]float64, len(torrents))
 	for i, registeredTorrent := range torrents {
-		p := registeredTorrent.GetPeers()
-		if p.Leechers == 0 || p.Seeders == 0 {
-			weights[i] = 0
-			continue
+		ceiling := math.Inf(1)
+		if r.torrentMax > 0 {
+			ceiling = math.Min(ceiling, float64(r.torrentMax))
 		}
-		leech := float64(p.Leechers)
-		seed := float64(p.Seeders)
-
-		seederRatio := leech / (leech + seed)       // more seeders compared to leecher the better
-		leechersPercentage := leech / sumOfLeechers // more seeder compared to total number of seeders the better
+		// a few leechers can not download at any speed
+		if r.perLeecherMax > 0 {
+			ceiling = math.Min(ceiling, float64(r.perLeecherMax)*float64(swarms[i].Leechers))
+		}
+		// the leechers would have completed the torrent long ago
+		if r.uploadRatioPerLeecher > 0 && registeredTorrent.GetContribution != nil && swarms[i].Size > 0 {
+			uploaded, seen := registeredTorrent.GetContribution()
+			if swarms[i].Leechers > seen {
+				seen = swarms[i].Leechers
+			}
+			budget := r.uploadRatioPerLeecher * float64(swarms[i].Size) * float64(seen)
+			left := budget - float64(uploaded)
+			ceiling = math.Min(ceiling, math.Max(0, left/r.updateInterval.Seconds()))
+		}
+		ceilings[i] = ceiling
+	}
+	return ceilings
+}
 
-		weight := (seederRatio + leechersPercentage) / 2 // sum the two ratio and divide by two to get a number between 0 and 1
-		totalWeight += weight
-		weights[i] = weight
+// updateSpeed shares the bandwidth between the torrents and returns the speed given to each of them
+func updateSpeed(torrents []*RegisteredTorrent, currentBandwidth int64, rules *distributionRules) map[torrent.InfoHash]float64 {
+	speeds := make(map[torrent.InfoHash]float64, len(torrents))
+	if len(torrents) == 0 {
+		return speeds
 	}
+
+	swarms := make([]TorrentSwarm, len(torrents))
 	for i, registeredTorrent := range torrents {
 		p := registeredTorrent.GetPeers()
-		if p.Leechers == 0 || p.Seeders == 0 {
-			registeredTorrent.SetSpeed(0)
-			continue
-		}
-		percentageOfWeight := weights[i] / totalWeight
-		registeredTorrent.SetSpeed(int64(percentageOfWeight * float64(currentBandwidth)))
+		swarms[i] = TorrentSwarm{Leechers: p.Leechers, Seeders: p.Seeders, Size: registeredTorrent.Size}
 	}
+	weights := rules.strategy.Weights(swarms)
+	bps := distribute(weights, swarms, currentBandwidth, rules.ceilings(torrents, swarms), rules.minShare)
+	for i, registeredTorrent := range torrents {
+		registeredTorrent.SetSpeed(bps[i])
+		speeds[registeredTorrent.InfoHash] = float64(bps[i])
+	}
+	return speeds
 }
 
 func (s *speedDispatcherImpl) Stop() {
@@ -163,8 +375,24 @@ func (s *speedDispatcherImpl) Stop() {
 	logger.Info("bandwidth dispatcher: stopped")
 }
 
-func (s *speedDispatcherImpl) ReplaceSpeedConfig(config *core.SpeedProviderConfig) {
-	s.randomSpeedProvider.ReplaceSpeedConfig(config)
+func (s *speedDispatcherImpl) ReplaceSpeedConfig(config *core.SpeedProviderConfig) error {
+	err := s.randomSpeedProvider.ReplaceSpeedConfig(config)
+	if err != nil {
+		return fmt.Errorf("invalid speed config: %w", err)
+	}
+	// let the running dispatcher re-arm its schedule timer
+	select {
+	case s.speedConfigReplaced <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *speedDispatcherImpl) AccountUpload(bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	s.uploaded.add(bytes)
 }
 
 func (s *speedDispatcherImpl) Register(rt *RegisteredTorrent) (unregisterTorrent func()) {
@@ -2,7 +2,14 @@ package bandwidth
 
 import (
 	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 /* TODO: refactor to new implementation; see dispatcher.go
@@ -436,3 +443,377 @@ func TestName(t *testing.T) {
 		fmt.Println(fmt.Sprintf("%v", p))
 	}
 }
+
+func TestSpeedDispatcher_ShouldSwitchSpeedProfileAtScheduleBoundary(t *testing.T) {
+	d, err := NewSpeedDispatcher(&core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 10,
+		MaximumBytesPerSeconds: 100,
+		Schedule: []*core.SpeedWindowConfig{
+			{Name: "night", From: "23:00", To: "07:00", Timezone: "UTC", MinimumBytesPerSeconds: 5000, MaximumBytesPerSeconds: 6000},
+		},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a clock running from 200ms before the window starts
+	fakeStart := time.Date(2021, time.March, 1, 22, 59, 59, int(800*time.Millisecond), time.UTC)
+	realStart := time.Now()
+	d.(*speedDispatcherImpl).randomSpeedProvider.(*randomSpeedProvider).now = func() time.Time {
+		return fakeStart.Add(time.Since(realStart))
+	}
+
+	events := make(chan broadcast.GlobalBandwidthChangedEvent, 10)
+	sub := broadcast.Subscribe(func(event broadcast.GlobalBandwidthChangedEvent) { events <- event })
+	defer sub.Unsubscribe()
+
+	d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: 1 * time.Hour})
+	defer d.Stop()
+
+	// the provider has been built with the real clock, the dispatcher may first switch to the default profile
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.SpeedProfile == DefaultSpeedProfile {
+				continue
+			}
+			assert.Equal(t, "night", event.SpeedProfile)
+			assert.GreaterOrEqual(t, event.AvailableBandwidth, int64(5000))
+			assert.LessOrEqual(t, event.AvailableBandwidth, int64(6000))
+			return
+		case <-timeout:
+			t.Fatal("speed profile has not been switched")
+		}
+	}
+}
+
+func TestSpeedDispatcher_StartShouldRejectInvalidConfig(t *testing.T) {
+	d, err := NewSpeedDispatcher(&core.SpeedProviderConfig{MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 100}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := 1 * time.Hour
+	assert.Error(t, d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: refresh, Weighting: "random"}))
+	assert.Error(t, d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: refresh, TorrentMaxBytesPerSeconds: -1}))
+	assert.Error(t, d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: refresh, TorrentMinShare: 1.5}))
+	assert.False(t, d.(*speedDispatcherImpl).isRunning)
+}
+
+func TestSpeedDispatcher_ShouldDispatchSpeedAndBroadcastDistribution(t *testing.T) {
+	d, err := NewSpeedDispatcher(&core.SpeedProviderConfig{MinimumBytesPerSeconds: 1000, MaximumBytesPerSeconds: 1000}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*speedDispatcherImpl).randomSpeedProvider.Refresh()
+	d.(*speedDispatcherImpl).updateTorrentSpeedInterval = 10 * time.Millisecond
+
+	events := make(chan broadcast.BandwidthWeightHasChangedEvent, 10)
+	sub := broadcast.Subscribe(func(event broadcast.BandwidthWeightHasChangedEvent) { events <- event })
+	defer sub.Unsubscribe()
+
+	speeds := make(chan int64, 100)
+	small := &RegisteredTorrent{
+		InfoHash: torrent.InfoHash{1},
+		Size:     100,
+		GetPeers: func() *Peers { return &Peers{Leechers: 10, Seeders: 10} },
+		SetSpeed: func(bps int64) {},
+	}
+	large := &RegisteredTorrent{
+		InfoHash: torrent.InfoHash{2},
+		Size:     900,
+		GetPeers: func() *Peers { return &Peers{Leechers: 10, Seeders: 10} },
+		SetSpeed: func(bps int64) { speeds <- bps },
+	}
+	d.Register(small)
+	d.Register(large)
+
+	err = d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: 1 * time.Hour, Weighting: SizeWeighting, TorrentMaxBytesPerSeconds: 800})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	select {
+	case event := <-events:
+		assert.Equal(t, float64(1000), event.TotalWeight)
+		assert.Equal(t, map[torrent.InfoHash]float64{small.InfoHash: 200, large.InfoHash: 800}, event.TorrentWeights)
+	case <-time.After(5 * time.Second):
+		t.Fatal("distribution has not been broadcast")
+	}
+	assert.Equal(t, int64(800), <-speeds)
+
+	// an unchanged distribution is not broadcast again
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, events, 0)
+}
+
+// syntheticTorrent is seeded for the first time, the leechers seen so far are the current ones
+func syntheticTorrent(id byte, size int64, leechers int32, seeders int32, uploaded int64) *RegisteredTorrent {
+	return &RegisteredTorrent{
+		InfoHash:        torrent.InfoHash{id},
+		Size:            size,
+		GetPeers:        func() *Peers { return &Peers{Leechers: leechers, Seeders: seeders} },
+		GetContribution: func() (int64, int32) { return uploaded, leechers },
+		SetSpeed:        func(bps int64) {},
+	}
+}
+
+func newTestDistributionRules(perLeecherMax int64, uploadRatioPerLeecher float64) *distributionRules {
+	return &distributionRules{
+		strategy:              &equalWeighting{},
+		perLeecherMax:         perLeecherMax,
+		uploadRatioPerLeecher: uploadRatioPerLeecher,
+		updateInterval:        10 * time.Second,
+	}
+}
+
+func TestUpdateSpeed_ShouldCapSpeedPerLeecher(t *testing.T) {
+	tiny := syntheticTorrent(1, 1000, 1, 1, 0)
+	crowded := syntheticTorrent(2, 1000, 100, 20, 0)
+	busy := syntheticTorrent(3, 1000, 3, 20, 0)
+
+	speeds := updateSpeed([]*RegisteredTorrent{tiny, crowded, busy}, 30000, newTestDistributionRules(1000, 0))
+
+	// tiny and busy are capped, what they can not take goes to crowded
+	assert.Equal(t, map[torrent.InfoHash]float64{
+		tiny.InfoHash:    1000,
+		crowded.InfoHash: 26000,
+		busy.InfoHash:    3000,
+	}, speeds)
+}
+
+func TestUpdateSpeed_ShouldBoundCumulativeUploadBySizeTimesLeechersSeen(t *testing.T) {
+	rules := newTestDistributionRules(0, 2)
+	// budget of 2 * 1000 * 5 = 10000 bytes, 9000 already uploaded: 1000 bytes left over 10 seconds
+	nearlyDone := syntheticTorrent(1, 1000, 5, 10, 9000)
+	done := syntheticTorrent(2, 1000, 5, 10, 10000)
+	fresh := syntheticTorrent(3, 100000, 5, 10, 0)
+
+	speeds := updateSpeed([]*RegisteredTorrent{nearlyDone, done, fresh}, 3000, rules)
+	assert.Equal(t, map[torrent.InfoHash]float64{
+		nearlyDone.InfoHash: 100,
+		done.InfoHash:       0,
+		fresh.InfoHash:      2900,
+	}, speeds)
+
+	// the budget follows the highest number of leechers seen, it does not shrink when leechers leave
+	done = syntheticTorrent(2, 1000, 1, 10, 10000)
+	done.GetContribution = func() (int64, int32) { return 10000, 5 }
+	speeds = updateSpeed([]*RegisteredTorrent{done, fresh}, 3000, rules)
+	assert.Equal(t, float64(0), speeds[done.InfoHash])
+	// and grows with the current leechers once they outnumber it
+	done = syntheticTorrent(2, 1000, 10, 10, 10000)
+	done.GetContribution = func() (int64, int32) { return 10000, 5 }
+	speeds = updateSpeed([]*RegisteredTorrent{done, fresh}, 3000, rules)
+	assert.Equal(t, float64(1000), speeds[done.InfoHash])
+}
+
+func TestUpdateSpeed_ShouldBoundCumulativeUploadAcrossRegistrations(t *testing.T) {
+	rules := newTestDistributionRules(0, 2)
+	// the contribution outlives the registrations, as the one persisted by the manager
+	uploaded, leechersSeen := int64(0), int32(0)
+	register := func(leechers int32) *RegisteredTorrent {
+		rt := syntheticTorrent(1, 1000, leechers, 10, 0)
+		rt.GetContribution = func() (int64, int32) { return uploaded, leechersSeen }
+		return rt
+	}
+
+	// budget of 2 * 1000 * 5 = 10000 bytes over 10 seconds
+	first := register(5)
+	assert.Equal(t, float64(1000), updateSpeed([]*RegisteredTorrent{first}, 3000, rules)[first.InfoHash])
+
+	// the first session has uploaded the whole budget, the torrent is registered again with a single leecher
+	uploaded, leechersSeen = 10000, 5
+	second := register(1)
+	assert.Equal(t, float64(0), updateSpeed([]*RegisteredTorrent{second}, 3000, rules)[second.InfoHash])
+}
+
+func TestSpeedDispatcher_ShouldNotUploadOnceBudgetIsExhausted(t *testing.T) {
+	budgetPath := filepath.Join(t.TempDir(), "budget.yml")
+	budgetConf := &core.BudgetConfig{Limit: 1000, Period: MonthlyBudget}
+	// the budget of the current period has been consumed before a restart
+	previous, err := newUploadBudget(budgetConf, budgetPath, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.consume(1000)
+	if err := previous.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewSpeedDispatcher(&core.SpeedProviderConfig{MinimumBytesPerSeconds: 100000, MaximumBytesPerSeconds: 100000}, budgetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*speedDispatcherImpl).randomSpeedProvider.Refresh()
+	d.(*speedDispatcherImpl).updateTorrentSpeedInterval = 10 * time.Millisecond
+
+	budgets := make(chan broadcast.BandwidthBudgetChangedEvent, 10)
+	thresholds := make(chan broadcast.BandwidthBudgetThresholdReachedEvent, 10)
+	budgetSub := broadcast.Subscribe(func(event broadcast.BandwidthBudgetChangedEvent) { budgets <- event })
+	defer budgetSub.Unsubscribe()
+	thresholdSub := broadcast.Subscribe(func(event broadcast.BandwidthBudgetThresholdReachedEvent) { thresholds <- event })
+	defer thresholdSub.Unsubscribe()
+
+	speeds := make(chan int64, 1000)
+	d.Register(&RegisteredTorrent{
+		InfoHash: torrent.InfoHash{1},
+		Size:     100,
+		GetPeers: func() *Peers { return &Peers{Leechers: 10, Seeders: 10} },
+		SetSpeed: func(bps int64) { speeds <- bps },
+	})
+
+	err = d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: 1 * time.Hour, Budget: budgetConf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	select {
+	case event := <-budgets:
+		assert.Equal(t, int64(1000), event.Limit)
+		assert.Equal(t, int64(1000), event.Consumed)
+		assert.Equal(t, MonthlyBudget, event.Period)
+	case <-time.After(5 * time.Second):
+		t.Fatal("budget has not been broadcast")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case bps := <-speeds:
+			assert.Equal(t, int64(0), bps)
+		case <-time.After(5 * time.Second):
+			t.Fatal("speed has not been dispatched")
+		}
+	}
+	// thresholds notified before the restart are not notified again
+	assert.Len(t, thresholds, 0)
+}
+
+func TestSpeedDispatcher_ShouldConsumeBudgetFromAccountedUploadAndSaveOnStop(t *testing.T) {
+	budgetPath := filepath.Join(t.TempDir(), "budget.yml")
+	budgetConf := &core.BudgetConfig{Limit: 1000, Period: MonthlyBudget}
+	d, err := NewSpeedDispatcher(&core.SpeedProviderConfig{MinimumBytesPerSeconds: 100000, MaximumBytesPerSeconds: 100000}, budgetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*speedDispatcherImpl).randomSpeedProvider.Refresh()
+	d.(*speedDispatcherImpl).updateTorrentSpeedInterval = 10 * time.Millisecond
+
+	budgets := make(chan broadcast.BandwidthBudgetChangedEvent, 100)
+	thresholds := make(chan broadcast.BandwidthBudgetThresholdReachedEvent, 10)
+	budgetSub := broadcast.Subscribe(func(event broadcast.BandwidthBudgetChangedEvent) { budgets <- event })
+	defer budgetSub.Unsubscribe()
+	thresholdSub := broadcast.Subscribe(func(event broadcast.BandwidthBudgetThresholdReachedEvent) { thresholds <- event })
+	defer thresholdSub.Unsubscribe()
+
+	speeds := make(chan int64, 1000)
+	d.Register(&RegisteredTorrent{
+		InfoHash: torrent.InfoHash{1},
+		Size:     100,
+		GetPeers: func() *Peers { return &Peers{Leechers: 10, Seeders: 10} },
+		SetSpeed: func(bps int64) { speeds <- bps },
+	})
+	err = d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: 1 * time.Hour, Budget: budgetConf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitConsumed := func(consumed int64) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event := <-budgets:
+				if event.Consumed == consumed {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("budget consumption of %d has not been broadcast", consumed)
+			}
+		}
+	}
+	awaitConsumed(0)
+
+	// the bandwidth handed out is not consumed, only what the torrents account as uploaded
+	for i := 0; i < 3; i++ {
+		assert.Greater(t, <-speeds, int64(0))
+	}
+	d.AccountUpload(850)
+	awaitConsumed(850)
+	select {
+	case event := <-thresholds:
+		assert.Equal(t, 0.8, event.Threshold)
+	case <-time.After(5 * time.Second):
+		t.Fatal("threshold has not been broadcast")
+	}
+	assert.NoFileExists(t, budgetPath, "the save is debounced")
+
+	d.AccountUpload(100)
+	d.Stop()
+
+	restored, err := newUploadBudget(budgetConf, budgetPath, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, float64(950), restored.consumed)
+}
+
+func TestSpeedDispatcher_ShouldConsumeTheUploadAcrossAPeriodBoundaryInTheNewPeriod(t *testing.T) {
+	budgetPath := filepath.Join(t.TempDir(), "budget.yml")
+	budgetConf := &core.BudgetConfig{Limit: 1000, Period: MonthlyBudget, Timezone: "UTC"}
+	d, err := NewSpeedDispatcher(&core.SpeedProviderConfig{MinimumBytesPerSeconds: 100000, MaximumBytesPerSeconds: 100000}, budgetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*speedDispatcherImpl).randomSpeedProvider.Refresh()
+	d.(*speedDispatcherImpl).updateTorrentSpeedInterval = 10 * time.Millisecond
+
+	march := time.Date(2021, time.March, 31, 23, 59, 0, 0, time.UTC)
+	april := time.Date(2021, time.April, 1, 0, 0, 1, 0, time.UTC)
+	lock := &sync.Mutex{}
+	now := march
+	// crossBoundary runs when the clock is read next, the update reading it sees both the new time and the upload
+	var crossBoundary func()
+	d.(*speedDispatcherImpl).now = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		if crossBoundary != nil {
+			crossBoundary()
+			crossBoundary = nil
+		}
+		return now
+	}
+
+	budgets := make(chan broadcast.BandwidthBudgetChangedEvent, 100)
+	budgetSub := broadcast.Subscribe(func(event broadcast.BandwidthBudgetChangedEvent) { budgets <- event })
+	defer budgetSub.Unsubscribe()
+
+	err = d.Start(&core.DispatcherConfig{GlobalBandwidthRefreshInterval: 1 * time.Hour, Budget: budgetConf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	awaitBudget := func(accept func(event broadcast.BandwidthBudgetChangedEvent) bool) broadcast.BandwidthBudgetChangedEvent {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event := <-budgets:
+				if accept(event) {
+					return event
+				}
+			case <-timeout:
+				t.Fatal("budget has not been broadcast")
+			}
+		}
+	}
+
+	d.AccountUpload(900)
+	awaitBudget(func(event broadcast.BandwidthBudgetChangedEvent) bool { return event.Consumed == 900 })
+
+	lock.Lock()
+	now = april
+	crossBoundary = func() { d.AccountUpload(300) }
+	lock.Unlock()
+	event := awaitBudget(func(event broadcast.BandwidthBudgetChangedEvent) bool {
+		return event.PeriodStart.Equal(time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC))
+	})
+	assert.Equal(t, int64(300), event.Consumed)
+}
@@ -3,37 +3,77 @@ package bandwidth
 import (
 	"github.com/anthonyraymond/joal-cli/internal/old/core"
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+	"reflect"
 	"sync"
+	"time"
 )
 
 type iRandomSpeedProvider interface {
-	ReplaceSpeedConfig(conf *core.SpeedProviderConfig)
+	ReplaceSpeedConfig(conf *core.SpeedProviderConfig) error
 	GetBytesPerSeconds() int64
+	// GetProfile returns the name of the schedule window in use, DefaultSpeedProfile if none
+	GetProfile() string
 	Refresh()
+	// SwitchProfileIfNeeded picks a new value if the active schedule window has changed since the last pick
+	SwitchProfileIfNeeded() (switched bool)
+	// DelayBeforeNextSwitch returns the time left before a schedule window starts or ends, false if there is no schedule
+	DelayBeforeNextSwitch() (time.Duration, bool)
 }
 
 type randomSpeedProvider struct {
+	// range of the active speed profile
 	MinimumBytesPerSeconds int64
 	MaximumBytesPerSeconds int64
+	profile                string
+	schedule               *speedSchedule
+	model                  speedModel
+	modelConf              *core.SpeedModelConfig
 	value                  int64
+	now                    func() time.Time
 	lock                   *sync.RWMutex
 }
 
-func newRandomSpeedProvider(conf *core.SpeedProviderConfig) iRandomSpeedProvider {
-	return &randomSpeedProvider{
-		MinimumBytesPerSeconds: conf.MinimumBytesPerSeconds,
-		MaximumBytesPerSeconds: conf.MaximumBytesPerSeconds,
-		value:                  0,
-		lock:                   &sync.RWMutex{},
+func newRandomSpeedProvider(conf *core.SpeedProviderConfig) (iRandomSpeedProvider, error) {
+	r := &randomSpeedProvider{
+		value: 0,
+		now:   time.Now,
+		lock:  &sync.RWMutex{},
 	}
+	schedule, err := newSpeedSchedule(conf)
+	if err != nil {
+		return nil, err
+	}
+	model, err := newSpeedModel(conf.Model)
+	if err != nil {
+		return nil, err
+	}
+	r.schedule = schedule
+	r.model = model
+	r.modelConf = conf.Model
+	r.selectProfile()
+	return r, nil
 }
 
-func (r *randomSpeedProvider) ReplaceSpeedConfig(conf *core.SpeedProviderConfig) {
+func (r *randomSpeedProvider) ReplaceSpeedConfig(conf *core.SpeedProviderConfig) error {
+	schedule, err := newSpeedSchedule(conf)
+	if err != nil {
+		return err
+	}
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	r.MinimumBytesPerSeconds = conf.MinimumBytesPerSeconds
-	r.MaximumBytesPerSeconds = conf.MaximumBytesPerSeconds
-	r.value = randutils.Range(r.MinimumBytesPerSeconds, r.MaximumBytesPerSeconds)
+	// keep the model state (and so a smooth speed) unless its config has changed
+	if r.model == nil || !reflect.DeepEqual(r.modelConf, conf.Model) {
+		model, err := newSpeedModel(conf.Model)
+		if err != nil {
+			return err
+		}
+		r.model = model
+		r.modelConf = conf.Model
+	}
+	r.schedule = schedule
+	r.selectProfile()
+	r.value = r.nextValue()
+	return nil
 }
 
 func (r *randomSpeedProvider) GetBytesPerSeconds() int64 {
@@ -42,8 +82,70 @@ func (r *randomSpeedProvider) GetBytesPerSeconds() int64 {
 	return r.value
 }
 
+func (r *randomSpeedProvider) GetProfile() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.profile == "" {
+		return DefaultSpeedProfile
+	}
+	return r.profile
+}
+
 func (r *randomSpeedProvider) Refresh() {
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	r.value = randutils.Range(r.MinimumBytesPerSeconds, r.MaximumBytesPerSeconds)
+	r.selectProfile()
+	r.value = r.nextValue()
+}
+
+func (r *randomSpeedProvider) SwitchProfileIfNeeded() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.selectProfile() {
+		return false
+	}
+	r.value = r.nextValue()
+	return true
+}
+
+func (r *randomSpeedProvider) DelayBeforeNextSwitch() (time.Duration, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.schedule == nil {
+		return 0, false
+	}
+	now := r.clock()
+	next, ok := r.schedule.nextBoundary(now)
+	if !ok {
+		return 0, false
+	}
+	return next.Sub(now), true
+}
+
+// selectProfile applies the range of the schedule window active right now, the lock has to be held by the caller
+func (r *randomSpeedProvider) selectProfile() (changed bool) {
+	if r.schedule == nil {
+		return false
+	}
+	profile, min, max := r.schedule.rangeAt(r.clock())
+	changed = profile != r.profile || min != r.MinimumBytesPerSeconds || max != r.MaximumBytesPerSeconds
+	r.profile = profile
+	r.MinimumBytesPerSeconds = min
+	r.MaximumBytesPerSeconds = max
+	return changed
+}
+
+// nextValue asks the speed model for a value within the active range, the lock has to be held by the caller
+func (r *randomSpeedProvider) nextValue() int64 {
+	if r.model == nil {
+		return randutils.Range(r.MinimumBytesPerSeconds, r.MaximumBytesPerSeconds)
+	}
+	return r.model.next(r.MinimumBytesPerSeconds, r.MaximumBytesPerSeconds)
+}
+
+func (r *randomSpeedProvider) clock() time.Time {
+	if r.now == nil {
+		return time.Now()
+	}
+	return r.now()
 }
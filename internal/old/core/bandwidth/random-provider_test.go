@@ -3,14 +3,19 @@ package bandwidth
 import (
 	"github.com/anthonyraymond/joal-cli/internal/old/core"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRandomSpeedProvider_ShouldBuildFromConfig(t *testing.T) {
-	rsp := newRandomSpeedProvider(&core.SpeedProviderConfig{
+	rsp, err := newRandomSpeedProvider(&core.SpeedProviderConfig{
 		MinimumBytesPerSeconds: 10,
 		MaximumBytesPerSeconds: 100,
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	assert.Equal(t, int64(10), rsp.(*randomSpeedProvider).MinimumBytesPerSeconds)
 	assert.Equal(t, int64(100), rsp.(*randomSpeedProvider).MaximumBytesPerSeconds)
@@ -37,6 +42,7 @@ func TestRandomSpeedProvider_RefreshShouldGenerateValueWithinRange(t *testing.T)
 				MinimumBytesPerSeconds: tt.fields.minimumBytesPerSeconds,
 				MaximumBytesPerSeconds: tt.fields.maximumBytesPerSeconds,
 				value:                  tt.fields.value,
+				lock:                   &sync.RWMutex{},
 			}
 			for i := 0; i < 100; i++ {
 				r.Refresh()
@@ -52,6 +58,7 @@ func TestRandomSpeedProviderRefreshShouldGenerateRandomValues(t *testing.T) {
 	r := randomSpeedProvider{
 		MinimumBytesPerSeconds: 20,
 		MaximumBytesPerSeconds: 100000,
+		lock:                   &sync.RWMutex{},
 	}
 
 	// One key for each unique value
@@ -65,3 +72,106 @@ func TestRandomSpeedProviderRefreshShouldGenerateRandomValues(t *testing.T) {
 	// Must ave more than 1 key if generated values were different
 	assert.Greater(t, len(valueSet), 1)
 }
+
+func TestRandomSpeedProvider_ShouldFailToBuildWithInvalidSchedule(t *testing.T) {
+	_, err := newRandomSpeedProvider(&core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 10,
+		MaximumBytesPerSeconds: 100,
+		Schedule:               []*core.SpeedWindowConfig{{Name: "night", From: "25:00", To: "07:00"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestRandomSpeedProvider_ShouldUseActiveWindowRange(t *testing.T) {
+	now := time.Date(2021, time.March, 3, 23, 30, 0, 0, time.UTC) // wednesday
+	r, err := newRandomSpeedProvider(&core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 10,
+		MaximumBytesPerSeconds: 100,
+		Schedule: []*core.SpeedWindowConfig{
+			{Name: "night", From: "23:00", To: "07:00", Timezone: "UTC", MinimumBytesPerSeconds: 5000, MaximumBytesPerSeconds: 6000},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.(*randomSpeedProvider).now = func() time.Time { return now }
+
+	r.Refresh()
+	assert.Equal(t, "night", r.GetProfile())
+	assert.GreaterOrEqual(t, r.GetBytesPerSeconds(), int64(5000))
+	assert.LessOrEqual(t, r.GetBytesPerSeconds(), int64(6000))
+
+	delay, ok := r.DelayBeforeNextSwitch()
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Hour+30*time.Minute, delay)
+}
+
+func TestRandomSpeedProvider_SwitchProfileIfNeededShouldSwitchAtBoundaries(t *testing.T) {
+	now := time.Date(2021, time.March, 3, 22, 59, 0, 0, time.UTC)
+	r, err := newRandomSpeedProvider(&core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 10,
+		MaximumBytesPerSeconds: 100,
+		Schedule: []*core.SpeedWindowConfig{
+			{Name: "night", From: "23:00", To: "07:00", Timezone: "UTC", MinimumBytesPerSeconds: 5000, MaximumBytesPerSeconds: 6000},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.(*randomSpeedProvider).now = func() time.Time { return now }
+	r.Refresh()
+	assert.Equal(t, DefaultSpeedProfile, r.GetProfile())
+	assert.False(t, r.SwitchProfileIfNeeded())
+
+	now = now.Add(1 * time.Minute)
+	assert.True(t, r.SwitchProfileIfNeeded())
+	assert.Equal(t, "night", r.GetProfile())
+	assert.GreaterOrEqual(t, r.GetBytesPerSeconds(), int64(5000))
+	assert.False(t, r.SwitchProfileIfNeeded())
+
+	now = now.Add(8 * time.Hour)
+	assert.True(t, r.SwitchProfileIfNeeded())
+	assert.Equal(t, DefaultSpeedProfile, r.GetProfile())
+	assert.LessOrEqual(t, r.GetBytesPerSeconds(), int64(100))
+}
+
+func TestRandomSpeedProvider_ReplaceSpeedConfigShouldKeepPreviousConfigOnError(t *testing.T) {
+	r, err := newRandomSpeedProvider(&core.SpeedProviderConfig{MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.ReplaceSpeedConfig(&core.SpeedProviderConfig{MinimumBytesPerSeconds: 100, MaximumBytesPerSeconds: 10})
+	assert.Error(t, err)
+	assert.Equal(t, int64(10), r.(*randomSpeedProvider).MinimumBytesPerSeconds)
+	assert.Equal(t, int64(100), r.(*randomSpeedProvider).MaximumBytesPerSeconds)
+}
+
+func TestRandomSpeedProvider_ReplaceSpeedConfigShouldKeepModelStateWhenModelIsUnchanged(t *testing.T) {
+	conf := &core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 10,
+		MaximumBytesPerSeconds: 100,
+		Model:                  &core.SpeedModelConfig{Type: RandomWalkSpeedModel, Seed: 1},
+	}
+	r, err := newRandomSpeedProvider(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := r.(*randomSpeedProvider).model
+
+	err = r.ReplaceSpeedConfig(&core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 20,
+		MaximumBytesPerSeconds: 200,
+		Model:                  &core.SpeedModelConfig{Type: RandomWalkSpeedModel, Seed: 1},
+	})
+	assert.NoError(t, err)
+	assert.Same(t, model, r.(*randomSpeedProvider).model)
+
+	err = r.ReplaceSpeedConfig(&core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 20,
+		MaximumBytesPerSeconds: 200,
+		Model:                  &core.SpeedModelConfig{Type: OrnsteinUhlenbeckSpeedModel},
+	})
+	assert.NoError(t, err)
+	assert.IsType(t, &ornsteinUhlenbeckModel{}, r.(*randomSpeedProvider).model)
+}
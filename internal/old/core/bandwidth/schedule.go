@@ -0,0 +1,219 @@
+package bandwidth
+
+import (
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // embeds the zone database, only used as a fallback when the host has none
+)
+
+// DefaultSpeedProfile is the speed profile in use when no schedule window is active
+const DefaultSpeedProfile = "default"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+type speedWindow struct {
+	name     string
+	days     [7]bool // indexed by time.Weekday
+	from     int     // minutes since midnight
+	to       int     // minutes since midnight
+	location *time.Location
+	min      int64
+	max      int64
+}
+
+func newSpeedWindow(conf *core.SpeedWindowConfig) (*speedWindow, error) {
+	if conf.Name == "" {
+		return nil, fmt.Errorf("schedule window name is required")
+	}
+	if conf.Name == DefaultSpeedProfile {
+		return nil, fmt.Errorf("schedule window name '%s' is reserved", DefaultSpeedProfile)
+	}
+	if conf.MinimumBytesPerSeconds < 0 || conf.MaximumBytesPerSeconds < conf.MinimumBytesPerSeconds {
+		return nil, fmt.Errorf("schedule window '%s': min must be positive and lower or equal to max", conf.Name)
+	}
+	w := &speedWindow{
+		name:     conf.Name,
+		location: time.Local,
+		min:      conf.MinimumBytesPerSeconds,
+		max:      conf.MaximumBytesPerSeconds,
+	}
+	var err error
+	if w.from, err = parseClock(conf.From); err != nil {
+		return nil, fmt.Errorf("schedule window '%s': invalid from: %w", conf.Name, err)
+	}
+	if w.from == 24*60 {
+		return nil, fmt.Errorf("schedule window '%s': from can not be 24:00", conf.Name)
+	}
+	if w.to, err = parseClock(conf.To); err != nil {
+		return nil, fmt.Errorf("schedule window '%s': invalid to: %w", conf.Name, err)
+	}
+	if conf.Timezone != "" {
+		if w.location, err = time.LoadLocation(conf.Timezone); err != nil {
+			return nil, fmt.Errorf("schedule window '%s': invalid timezone: %w", conf.Name, err)
+		}
+	}
+	if len(conf.Days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, d := range conf.Days {
+		if err = w.addDays(d); err != nil {
+			return nil, fmt.Errorf("schedule window '%s': %w", conf.Name, err)
+		}
+	}
+	return w, nil
+}
+
+// addDays enables a single day (mon) or a range of days (mon-fri, fri-mon)
+func (w *speedWindow) addDays(days string) error {
+	first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(days)), "-")
+	if !isRange {
+		last = first
+	}
+	start, ok := weekdays[first]
+	if !ok {
+		return fmt.Errorf("unknown day '%s'", first)
+	}
+	end, ok := weekdays[last]
+	if !ok {
+		return fmt.Errorf("unknown day '%s'", last)
+	}
+	for d := start; ; d = (d + 1) % 7 {
+		w.days[d] = true
+		if d == end {
+			return nil
+		}
+	}
+}
+
+// parseClock parses HH:MM into minutes since midnight, 24:00 is accepted as the end of the day
+func parseClock(clock string) (int, error) {
+	h, m, ok := strings.Cut(clock, ":")
+	if !ok || len(m) != 2 {
+		return 0, fmt.Errorf("'%s' is not formatted as HH:MM", clock)
+	}
+	hours, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not formatted as HH:MM", clock)
+	}
+	minutes, err := strconv.Atoi(m)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not formatted as HH:MM", clock)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("'%s' is out of range", clock)
+	}
+	return hours*60 + minutes, nil
+}
+
+// length returns the duration of the window in minutes
+func (w *speedWindow) length() int {
+	if w.to > w.from {
+		return w.to - w.from
+	}
+	// wraps past midnight, or lasts the whole day when from equals to
+	return w.to + 24*60 - w.from
+}
+
+// at returns the instant of the given minute of the day on the day of t, in the window timezone.
+// Going through time.Date keeps the clock time right across DST changes.
+func (w *speedWindow) at(t time.Time, dayOffset int, minutes int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+dayOffset, 0, minutes, 0, 0, w.location)
+}
+
+func (w *speedWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	// the window may have started on the previous day and span over midnight
+	for dayOffset := -1; dayOffset <= 0; dayOffset++ {
+		start := w.at(t, dayOffset, w.from)
+		if !w.days[start.Weekday()] {
+			continue
+		}
+		end := w.at(t, dayOffset, w.from+w.length())
+		if !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextBoundary returns the first instant strictly after t at which the window starts or ends
+func (w *speedWindow) nextBoundary(t time.Time) (time.Time, bool) {
+	t = t.In(w.location)
+	var next time.Time
+	for dayOffset := -1; dayOffset <= 7; dayOffset++ {
+		start := w.at(t, dayOffset, w.from)
+		if !w.days[start.Weekday()] {
+			continue
+		}
+		for _, b := range []time.Time{start, w.at(t, dayOffset, w.from+w.length())} {
+			if b.After(t) && (next.IsZero() || b.Before(next)) {
+				next = b
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// speedSchedule picks the bandwidth range in use at a given time
+type speedSchedule struct {
+	min     int64
+	max     int64
+	windows []*speedWindow
+}
+
+func newSpeedSchedule(conf *core.SpeedProviderConfig) (*speedSchedule, error) {
+	if conf.MinimumBytesPerSeconds < 0 || conf.MaximumBytesPerSeconds < conf.MinimumBytesPerSeconds {
+		return nil, fmt.Errorf("speed min must be positive and lower or equal to max")
+	}
+	s := &speedSchedule{
+		min: conf.MinimumBytesPerSeconds,
+		max: conf.MaximumBytesPerSeconds,
+	}
+	names := make(map[string]bool)
+	for _, windowConf := range conf.Schedule {
+		w, err := newSpeedWindow(windowConf)
+		if err != nil {
+			return nil, err
+		}
+		if names[w.name] {
+			return nil, fmt.Errorf("schedule window name '%s' is used more than once", w.name)
+		}
+		names[w.name] = true
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// rangeAt returns the speed profile active at t along with its range
+func (s *speedSchedule) rangeAt(t time.Time) (profile string, min int64, max int64) {
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return w.name, w.min, w.max
+		}
+	}
+	return DefaultSpeedProfile, s.min, s.max
+}
+
+// nextBoundary returns the first instant after t at which any window starts or ends, false if there is no window
+func (s *speedSchedule) nextBoundary(t time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, w := range s.windows {
+		b, ok := w.nextBoundary(t)
+		if ok && (next.IsZero() || b.Before(next)) {
+			next = b
+		}
+	}
+	return next, !next.IsZero()
+}
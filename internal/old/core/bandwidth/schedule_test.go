@@ -0,0 +1,210 @@
+package bandwidth
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		clock   string
+		want    int
+		wantErr bool
+	}{
+		{clock: "00:00", want: 0},
+		{clock: "09:30", want: 9*60 + 30},
+		{clock: "9:05", want: 9*60 + 5},
+		{clock: "24:00", want: 24 * 60},
+		{clock: "24:01", wantErr: true},
+		{clock: "12:60", wantErr: true},
+		{clock: "12", wantErr: true},
+		{clock: "12:5", wantErr: true},
+		{clock: "ab:cd", wantErr: true},
+		{clock: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clock, func(t *testing.T) {
+			got, err := parseClock(tt.clock)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewSpeedWindow_ShouldParseDays(t *testing.T) {
+	w, err := newSpeedWindow(&core.SpeedWindowConfig{Name: "w", Days: []string{"mon-wed", "Sat"}, From: "00:00", To: "01:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [7]bool{false, true, true, true, false, false, true}, w.days)
+
+	w, err = newSpeedWindow(&core.SpeedWindowConfig{Name: "w", Days: []string{"fri-mon"}, From: "00:00", To: "01:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [7]bool{true, true, false, false, false, true, true}, w.days)
+
+	w, err = newSpeedWindow(&core.SpeedWindowConfig{Name: "w", From: "00:00", To: "01:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [7]bool{true, true, true, true, true, true, true}, w.days)
+}
+
+func TestNewSpeedWindow_ShouldRejectInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf core.SpeedWindowConfig
+	}{
+		{name: "noName", conf: core.SpeedWindowConfig{From: "00:00", To: "01:00"}},
+		{name: "reservedName", conf: core.SpeedWindowConfig{Name: DefaultSpeedProfile, From: "00:00", To: "01:00"}},
+		{name: "unknownDay", conf: core.SpeedWindowConfig{Name: "w", Days: []string{"mon-fry"}, From: "00:00", To: "01:00"}},
+		{name: "invalidFrom", conf: core.SpeedWindowConfig{Name: "w", From: "0000", To: "01:00"}},
+		{name: "fromEndOfDay", conf: core.SpeedWindowConfig{Name: "w", From: "24:00", To: "01:00"}},
+		{name: "invalidTo", conf: core.SpeedWindowConfig{Name: "w", From: "00:00"}},
+		{name: "unknownTimezone", conf: core.SpeedWindowConfig{Name: "w", From: "00:00", To: "01:00", Timezone: "Mars/Olympus_Mons"}},
+		{name: "minGreaterThanMax", conf: core.SpeedWindowConfig{Name: "w", From: "00:00", To: "01:00", MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSpeedWindow(&tt.conf)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSpeedWindow_Contains(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		name string
+		conf core.SpeedWindowConfig
+		at   time.Time
+		want bool
+	}{
+		// 2021-03-01 is a monday
+		{name: "insideSameDayWindow", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 9, 0, 0, 0, utc), want: true},
+		{name: "endIsExcluded", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 18, 0, 0, 0, utc), want: false},
+		{name: "beforeSameDayWindow", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 8, 59, 0, 0, utc), want: false},
+		{name: "beforeMidnightOfWrappingWindow", conf: core.SpeedWindowConfig{From: "23:00", To: "07:00"}, at: time.Date(2021, 3, 1, 23, 30, 0, 0, utc), want: true},
+		{name: "afterMidnightOfWrappingWindow", conf: core.SpeedWindowConfig{From: "23:00", To: "07:00"}, at: time.Date(2021, 3, 2, 6, 59, 0, 0, utc), want: true},
+		{name: "outsideWrappingWindow", conf: core.SpeedWindowConfig{From: "23:00", To: "07:00"}, at: time.Date(2021, 3, 2, 7, 0, 0, 0, utc), want: false},
+		{name: "wholeDay", conf: core.SpeedWindowConfig{Days: []string{"mon"}, From: "00:00", To: "00:00"}, at: time.Date(2021, 3, 1, 23, 59, 0, 0, utc), want: true},
+		{name: "wholeDayEndsAtMidnight", conf: core.SpeedWindowConfig{Days: []string{"mon"}, From: "00:00", To: "00:00"}, at: time.Date(2021, 3, 2, 0, 0, 0, 0, utc), want: false},
+		{name: "endOfDay", conf: core.SpeedWindowConfig{Days: []string{"mon"}, From: "20:00", To: "24:00"}, at: time.Date(2021, 3, 1, 23, 59, 0, 0, utc), want: true},
+		{name: "otherDay", conf: core.SpeedWindowConfig{Days: []string{"tue"}, From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 10, 0, 0, 0, utc), want: false},
+		{name: "wrapBelongsToStartDay", conf: core.SpeedWindowConfig{Days: []string{"fri"}, From: "22:00", To: "02:00"}, at: time.Date(2021, 3, 6, 1, 0, 0, 0, utc), want: true},
+		{name: "wrapDoesNotStartOnNextDay", conf: core.SpeedWindowConfig{Days: []string{"sat"}, From: "22:00", To: "02:00"}, at: time.Date(2021, 3, 6, 1, 0, 0, 0, utc), want: false},
+		{name: "inWindowTimezone", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00", Timezone: "Asia/Tokyo"}, at: time.Date(2021, 3, 1, 0, 30, 0, 0, utc), want: true},
+		{name: "outOfWindowTimezone", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00", Timezone: "Asia/Tokyo"}, at: time.Date(2021, 3, 1, 9, 30, 0, 0, utc), want: false},
+		{name: "dayInWindowTimezone", conf: core.SpeedWindowConfig{Days: []string{"tue"}, From: "08:00", To: "10:00", Timezone: "Asia/Tokyo"}, at: time.Date(2021, 3, 1, 23, 30, 0, 0, utc), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Name = "w"
+			w, err := newSpeedWindow(&tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, w.contains(tt.at))
+		})
+	}
+}
+
+func TestSpeedWindow_NextBoundary(t *testing.T) {
+	utc := time.UTC
+	paris := mustLoadLocation(t, "Europe/Paris")
+	tests := []struct {
+		name string
+		conf core.SpeedWindowConfig
+		at   time.Time
+		want time.Time
+	}{
+		{name: "nextStart", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 8, 0, 0, 0, utc), want: time.Date(2021, 3, 1, 9, 0, 0, 0, utc)},
+		{name: "boundaryIsStrictlyAfter", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 9, 0, 0, 0, utc), want: time.Date(2021, 3, 1, 18, 0, 0, 0, utc)},
+		{name: "nextDayStart", conf: core.SpeedWindowConfig{From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 19, 0, 0, 0, utc), want: time.Date(2021, 3, 2, 9, 0, 0, 0, utc)},
+		{name: "endOfWrappingWindow", conf: core.SpeedWindowConfig{From: "23:00", To: "07:00"}, at: time.Date(2021, 3, 2, 1, 0, 0, 0, utc), want: time.Date(2021, 3, 2, 7, 0, 0, 0, utc)},
+		{name: "skipsDisabledDays", conf: core.SpeedWindowConfig{Days: []string{"sat-sun"}, From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 10, 0, 0, 0, utc), want: time.Date(2021, 3, 6, 9, 0, 0, 0, utc)},
+		{name: "nextWeek", conf: core.SpeedWindowConfig{Days: []string{"mon"}, From: "09:00", To: "18:00"}, at: time.Date(2021, 3, 1, 18, 0, 0, 0, utc), want: time.Date(2021, 3, 8, 9, 0, 0, 0, utc)},
+		// DST starts on 2021-03-28 at 02:00 in Paris, the window lasts 3 hours that day
+		{name: "acrossDst", conf: core.SpeedWindowConfig{From: "01:00", To: "05:00", Timezone: "Europe/Paris"}, at: time.Date(2021, 3, 28, 1, 0, 0, 0, paris), want: time.Date(2021, 3, 28, 1, 0, 0, 0, paris).Add(3 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Name = "w"
+			w, err := newSpeedWindow(&tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := w.nextBoundary(tt.at)
+			assert.True(t, ok)
+			assert.True(t, tt.want.Equal(got), "want %s, got %s", tt.want, got)
+		})
+	}
+}
+
+func TestSpeedSchedule_FirstMatchingWindowShouldWin(t *testing.T) {
+	s, err := newSpeedSchedule(&core.SpeedProviderConfig{
+		MinimumBytesPerSeconds: 1,
+		MaximumBytesPerSeconds: 2,
+		Schedule: []*core.SpeedWindowConfig{
+			{Name: "lunch", From: "12:00", To: "14:00", Timezone: "UTC", MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 20},
+			{Name: "day", From: "08:00", To: "20:00", Timezone: "UTC", MinimumBytesPerSeconds: 100, MaximumBytesPerSeconds: 200},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, min, max := s.rangeAt(time.Date(2021, 3, 1, 13, 0, 0, 0, time.UTC))
+	assert.Equal(t, "lunch", profile)
+	assert.Equal(t, int64(10), min)
+	assert.Equal(t, int64(20), max)
+
+	profile, min, max = s.rangeAt(time.Date(2021, 3, 1, 15, 0, 0, 0, time.UTC))
+	assert.Equal(t, "day", profile)
+	assert.Equal(t, int64(100), min)
+	assert.Equal(t, int64(200), max)
+
+	profile, min, max = s.rangeAt(time.Date(2021, 3, 1, 21, 0, 0, 0, time.UTC))
+	assert.Equal(t, DefaultSpeedProfile, profile)
+	assert.Equal(t, int64(1), min)
+	assert.Equal(t, int64(2), max)
+
+	next, ok := s.nextBoundary(time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), next.UTC())
+}
+
+func TestSpeedSchedule_ShouldRejectDuplicatedNames(t *testing.T) {
+	_, err := newSpeedSchedule(&core.SpeedProviderConfig{
+		MaximumBytesPerSeconds: 2,
+		Schedule: []*core.SpeedWindowConfig{
+			{Name: "w", From: "12:00", To: "14:00"},
+			{Name: "w", From: "15:00", To: "16:00"},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestSpeedSchedule_NextBoundaryShouldBeFalseWithoutWindows(t *testing.T) {
+	s, err := newSpeedSchedule(&core.SpeedProviderConfig{MaximumBytesPerSeconds: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := s.nextBoundary(time.Now())
+	assert.False(t, ok)
+}
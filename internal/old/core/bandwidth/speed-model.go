@@ -0,0 +1,229 @@
+package bandwidth
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	UniformSpeedModel           = "uniform"
+	RandomWalkSpeedModel        = "randomWalk"
+	OrnsteinUhlenbeckSpeedModel = "ornsteinUhlenbeck"
+	TraceSpeedModel             = "trace"
+)
+
+// speedModel generates the successive speeds of the randomSpeedProvider. Models are not safe for concurrent use.
+type speedModel interface {
+	// next returns the speed to use until the next refresh, within [min, max]
+	next(min int64, max int64) int64
+}
+
+func newSpeedModel(conf *core.SpeedModelConfig) (speedModel, error) {
+	if conf == nil {
+		return &uniformModel{rng: rand.New(randutils.NewCryptoSeededSource())}, nil
+	}
+	var source rand.Source
+	if conf.Seed == 0 {
+		source = randutils.NewCryptoSeededSource()
+	} else {
+		source = rand.NewSource(conf.Seed)
+	}
+	rng := rand.New(source)
+
+	switch conf.Type {
+	case "", UniformSpeedModel:
+		return &uniformModel{rng: rng}, nil
+	case RandomWalkSpeedModel:
+		walkConf := conf.RandomWalk
+		if walkConf == nil {
+			walkConf = &core.RandomWalkModelConfig{}
+		}
+		return newRandomWalkModel(walkConf, rng)
+	case OrnsteinUhlenbeckSpeedModel:
+		ouConf := conf.OrnsteinUhlenbeck
+		if ouConf == nil {
+			ouConf = &core.OrnsteinUhlenbeckModelConfig{}
+		}
+		return newOrnsteinUhlenbeckModel(ouConf, rng)
+	case TraceSpeedModel:
+		if conf.Trace == nil || conf.Trace.File == "" {
+			return nil, fmt.Errorf("trace speed model requires a trace file")
+		}
+		return newTraceModelFromFile(conf.Trace)
+	default:
+		return nil, fmt.Errorf("unknown speed model '%s'", conf.Type)
+	}
+}
+
+// uniformModel picks a new speed uniformly at each refresh
+type uniformModel struct {
+	rng *rand.Rand
+}
+
+func (m *uniformModel) next(min int64, max int64) int64 {
+	if min == max {
+		return min
+	}
+	return m.rng.Int63n(max-min+1) + min
+}
+
+// randomWalkModel moves the speed by a uniform step in [-maxStep, maxStep] and reflects it on the range bounds
+type randomWalkModel struct {
+	rng     *rand.Rand
+	maxStep float64
+	started bool
+	current float64
+}
+
+func newRandomWalkModel(conf *core.RandomWalkModelConfig, rng *rand.Rand) (*randomWalkModel, error) {
+	m := &randomWalkModel{rng: rng, maxStep: conf.MaxStep}
+	if m.maxStep == 0 {
+		m.maxStep = 0.1
+	}
+	if m.maxStep < 0 || m.maxStep > 1 {
+		return nil, fmt.Errorf("random walk maxStep must be between 0 and 1")
+	}
+	return m, nil
+}
+
+func (m *randomWalkModel) next(min int64, max int64) int64 {
+	low, high := float64(min), float64(max)
+	if !m.started {
+		m.started = true
+		m.current = low + m.rng.Float64()*(high-low)
+		return int64(math.Round(m.current))
+	}
+	// the range may have changed since the last refresh (schedule switch or config reload)
+	m.current = clamp(m.current, low, high)
+	m.current += (m.rng.Float64()*2 - 1) * m.maxStep * (high - low)
+	if m.current > high {
+		m.current = 2*high - m.current
+	}
+	if m.current < low {
+		m.current = 2*low - m.current
+	}
+	m.current = clamp(m.current, low, high)
+	return int64(math.Round(m.current))
+}
+
+// ornsteinUhlenbeckModel is a discrete mean-reverting process: x += theta * (mean - x) + sigma * N(0, 1)
+type ornsteinUhlenbeckModel struct {
+	rng     *rand.Rand
+	mean    float64
+	theta   float64
+	sigma   float64
+	started bool
+	current float64
+}
+
+func newOrnsteinUhlenbeckModel(conf *core.OrnsteinUhlenbeckModelConfig, rng *rand.Rand) (*ornsteinUhlenbeckModel, error) {
+	// 0 is a legit value of each parameter, only the unset ones take the default
+	m := &ornsteinUhlenbeckModel{rng: rng, mean: 0.5, theta: 0.15, sigma: 0.1}
+	if conf.Mean != nil {
+		m.mean = *conf.Mean
+	}
+	if conf.Theta != nil {
+		m.theta = *conf.Theta
+	}
+	if conf.Sigma != nil {
+		m.sigma = *conf.Sigma
+	}
+	if m.mean < 0 || m.mean > 1 {
+		return nil, fmt.Errorf("ornstein-uhlenbeck mean must be between 0 and 1")
+	}
+	if m.theta < 0 || m.theta > 1 {
+		return nil, fmt.Errorf("ornstein-uhlenbeck theta must be between 0 and 1")
+	}
+	if m.sigma < 0 {
+		return nil, fmt.Errorf("ornstein-uhlenbeck sigma must be positive")
+	}
+	return m, nil
+}
+
+func (m *ornsteinUhlenbeckModel) next(min int64, max int64) int64 {
+	low, high := float64(min), float64(max)
+	mean := low + m.mean*(high-low)
+	if !m.started {
+		m.started = true
+		m.current = mean
+	}
+	m.current = clamp(m.current, low, high)
+	m.current += m.theta*(mean-m.current) + m.sigma*(high-low)*m.rng.NormFloat64()
+	m.current = clamp(m.current, low, high)
+	return int64(math.Round(m.current))
+}
+
+// traceModel replays recorded speeds in a loop
+type traceModel struct {
+	values   []int64
+	rescale  bool
+	lowest   int64
+	highest  int64
+	position int
+}
+
+func newTraceModelFromFile(conf *core.TraceModelConfig) (*traceModel, error) {
+	file, err := os.Open(conf.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open speed trace: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var values []int64
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, err := strconv.ParseInt(line, 10, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("speed trace '%s' line %d: '%s' is not a positive integer", conf.File, lineNumber, line)
+		}
+		values = append(values, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read speed trace: %w", err)
+	}
+	return newTraceModel(values, conf.Rescale)
+}
+
+func newTraceModel(values []int64, rescale bool) (*traceModel, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("speed trace is empty")
+	}
+	m := &traceModel{values: values, rescale: rescale, lowest: values[0], highest: values[0]}
+	for _, v := range values {
+		if v < m.lowest {
+			m.lowest = v
+		}
+		if v > m.highest {
+			m.highest = v
+		}
+	}
+	return m, nil
+}
+
+func (m *traceModel) next(min int64, max int64) int64 {
+	v := m.values[m.position]
+	m.position = (m.position + 1) % len(m.values)
+	if !m.rescale {
+		return int64(clamp(float64(v), float64(min), float64(max)))
+	}
+	if m.highest == m.lowest {
+		return min + (max-min)/2
+	}
+	ratio := float64(v-m.lowest) / float64(m.highest-m.lowest)
+	return min + int64(math.Round(ratio*float64(max-min)))
+}
+
+func clamp(v float64, low float64, high float64) float64 {
+	return math.Max(low, math.Min(high, v))
+}
@@ -0,0 +1,187 @@
+package bandwidth
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustNewSpeedModel(t *testing.T, conf *core.SpeedModelConfig) speedModel {
+	m, err := newSpeedModel(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestNewSpeedModel_ShouldBuildSelectedModel(t *testing.T) {
+	assert.IsType(t, &uniformModel{}, mustNewSpeedModel(t, nil))
+	assert.IsType(t, &uniformModel{}, mustNewSpeedModel(t, &core.SpeedModelConfig{}))
+	assert.IsType(t, &randomWalkModel{}, mustNewSpeedModel(t, &core.SpeedModelConfig{Type: RandomWalkSpeedModel}))
+	assert.IsType(t, &ornsteinUhlenbeckModel{}, mustNewSpeedModel(t, &core.SpeedModelConfig{Type: OrnsteinUhlenbeckSpeedModel}))
+
+	_, err := newSpeedModel(&core.SpeedModelConfig{Type: "sine"})
+	assert.Error(t, err)
+	_, err = newSpeedModel(&core.SpeedModelConfig{Type: TraceSpeedModel})
+	assert.Error(t, err)
+	_, err = newSpeedModel(&core.SpeedModelConfig{Type: RandomWalkSpeedModel, RandomWalk: &core.RandomWalkModelConfig{MaxStep: 2}})
+	assert.Error(t, err)
+	_, err = newSpeedModel(&core.SpeedModelConfig{Type: OrnsteinUhlenbeckSpeedModel, OrnsteinUhlenbeck: &core.OrnsteinUhlenbeckModelConfig{Theta: float64Ptr(-1)}})
+	assert.Error(t, err)
+}
+
+func TestSpeedModels_ShouldBeDeterministicWithSeed(t *testing.T) {
+	for _, modelType := range []string{UniformSpeedModel, RandomWalkSpeedModel, OrnsteinUhlenbeckSpeedModel} {
+		t.Run(modelType, func(t *testing.T) {
+			a := mustNewSpeedModel(t, &core.SpeedModelConfig{Type: modelType, Seed: 42})
+			b := mustNewSpeedModel(t, &core.SpeedModelConfig{Type: modelType, Seed: 42})
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, a.next(1000, 50000), b.next(1000, 50000))
+			}
+		})
+	}
+}
+
+func TestSpeedModels_ShouldStayWithinRange(t *testing.T) {
+	const steps = 20000
+	for _, modelType := range []string{UniformSpeedModel, RandomWalkSpeedModel, OrnsteinUhlenbeckSpeedModel} {
+		t.Run(modelType, func(t *testing.T) {
+			// a high volatility keeps hitting the bounds
+			m := mustNewSpeedModel(t, &core.SpeedModelConfig{
+				Type:              modelType,
+				Seed:              7,
+				RandomWalk:        &core.RandomWalkModelConfig{MaxStep: 0.9},
+				OrnsteinUhlenbeck: &core.OrnsteinUhlenbeckModelConfig{Sigma: float64Ptr(2)},
+			})
+			var lowest, highest int64 = math.MaxInt64, math.MinInt64
+			for i := 0; i < steps; i++ {
+				// change the range halfway, like a schedule switch would
+				min, max := int64(1000), int64(5000)
+				if i >= steps/2 {
+					min, max = 20000, 21000
+				}
+				v := m.next(min, max)
+				assert.GreaterOrEqual(t, v, min)
+				assert.LessOrEqual(t, v, max)
+				if i < steps/2 {
+					lowest = int64(math.Min(float64(lowest), float64(v)))
+					highest = int64(math.Max(float64(highest), float64(v)))
+				}
+			}
+			// the whole range is explored
+			assert.Less(t, lowest, int64(1400))
+			assert.Greater(t, highest, int64(4600))
+		})
+	}
+}
+
+func TestRandomWalkModel_ShouldNotJumpMoreThanMaxStep(t *testing.T) {
+	m := mustNewSpeedModel(t, &core.SpeedModelConfig{Type: RandomWalkSpeedModel, Seed: 3, RandomWalk: &core.RandomWalkModelConfig{MaxStep: 0.05}})
+	previous := m.next(0, 100000)
+	for i := 0; i < 10000; i++ {
+		v := m.next(0, 100000)
+		// the reflection on a bound can not make the step larger
+		assert.LessOrEqual(t, math.Abs(float64(v-previous)), 0.05*100000+1)
+		previous = v
+	}
+}
+
+func TestOrnsteinUhlenbeckModel_ShouldRevertToMean(t *testing.T) {
+	m := mustNewSpeedModel(t, &core.SpeedModelConfig{
+		Type:              OrnsteinUhlenbeckSpeedModel,
+		Seed:              11,
+		OrnsteinUhlenbeck: &core.OrnsteinUhlenbeckModelConfig{Mean: float64Ptr(0.25), Theta: float64Ptr(0.2), Sigma: float64Ptr(0.05)},
+	})
+	const steps = 20000
+	sum := float64(0)
+	for i := 0; i < steps; i++ {
+		sum += float64(m.next(0, 100000))
+	}
+	assert.InDelta(t, 25000, sum/steps, 1500)
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func TestOrnsteinUhlenbeckModel_ShouldAcceptZeroParameters(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *core.OrnsteinUhlenbeckModelConfig
+		want []int64
+	}{
+		// without noise the speed starts on the mean and stays on it, a zero mean is the minimum speed
+		{name: "zero-mean-and-sigma", conf: &core.OrnsteinUhlenbeckModelConfig{Mean: float64Ptr(0), Theta: float64Ptr(0.5), Sigma: float64Ptr(0)}, want: []int64{0, 0, 0}},
+		{name: "zero-theta-and-sigma", conf: &core.OrnsteinUhlenbeckModelConfig{Mean: float64Ptr(0.25), Theta: float64Ptr(0), Sigma: float64Ptr(0)}, want: []int64{250, 250, 250}},
+		{name: "zero-sigma", conf: &core.OrnsteinUhlenbeckModelConfig{Mean: float64Ptr(1), Sigma: float64Ptr(0)}, want: []int64{1000, 1000, 1000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mustNewSpeedModel(t, &core.SpeedModelConfig{Type: OrnsteinUhlenbeckSpeedModel, Seed: 5, OrnsteinUhlenbeck: tt.conf})
+			var got []int64
+			for range tt.want {
+				got = append(got, m.next(0, 1000))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTraceModel_ShouldReplayTraceInLoop(t *testing.T) {
+	m, err := newTraceModel([]int64{10, 20, 30}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for i := 0; i < 7; i++ {
+		got = append(got, m.next(0, 100))
+	}
+	assert.Equal(t, []int64{10, 20, 30, 10, 20, 30, 10}, got)
+}
+
+func TestTraceModel_ShouldClampOrRescaleValues(t *testing.T) {
+	clamped, err := newTraceModel([]int64{10, 20, 30}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(15), clamped.next(15, 25))
+	assert.Equal(t, int64(20), clamped.next(15, 25))
+	assert.Equal(t, int64(25), clamped.next(15, 25))
+
+	rescaled, err := newTraceModel([]int64{10, 20, 30}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1000), rescaled.next(1000, 2000))
+	assert.Equal(t, int64(1500), rescaled.next(1000, 2000))
+	assert.Equal(t, int64(2000), rescaled.next(1000, 2000))
+}
+
+func TestTraceModel_ShouldLoadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.txt")
+	err := os.WriteFile(path, []byte("# recorded on 2021-03-01\n100\n\n 200 \n300\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mustNewSpeedModel(t, &core.SpeedModelConfig{Type: TraceSpeedModel, Trace: &core.TraceModelConfig{File: path}})
+	assert.Equal(t, int64(100), m.next(0, 1000))
+	assert.Equal(t, int64(200), m.next(0, 1000))
+	assert.Equal(t, int64(300), m.next(0, 1000))
+
+	err = os.WriteFile(path, []byte("100\nfast\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newSpeedModel(&core.SpeedModelConfig{Type: TraceSpeedModel, Trace: &core.TraceModelConfig{File: path}})
+	assert.Error(t, err)
+
+	err = os.WriteFile(path, []byte("# nothing\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newSpeedModel(&core.SpeedModelConfig{Type: TraceSpeedModel, Trace: &core.TraceModelConfig{File: path}})
+	assert.Error(t, err)
+}
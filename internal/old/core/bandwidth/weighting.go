@@ -0,0 +1,171 @@
+package bandwidth
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	BalancedWeighting = "balanced"
+	LeechersWeighting = "leechers"
+	EqualWeighting    = "equal"
+	SizeWeighting     = "size"
+)
+
+// TorrentSwarm is what a WeightingStrategy knows about a torrent
+type TorrentSwarm struct {
+	Leechers int32
+	Seeders  int32
+	// Size of the torrent content in bytes
+	Size int64
+}
+
+// WeightingStrategy gives a weight to each torrent, the bandwidth is shared in proportion of the weights.
+// A torrent with a weight of 0 gets no bandwidth beyond the min share granted to the torrents having leechers.
+type WeightingStrategy interface {
+	// Weights returns the weight of each torrent, in the order of the given swarms
+	Weights(swarms []TorrentSwarm) []float64
+}
+
+func NewWeightingStrategy(name string) (WeightingStrategy, error) {
+	switch name {
+	case "", BalancedWeighting:
+		return &balancedWeighting{}, nil
+	case LeechersWeighting:
+		return &leechersWeighting{}, nil
+	case EqualWeighting:
+		return &equalWeighting{}, nil
+	case SizeWeighting:
+		return &sizeWeighting{}, nil
+	default:
+		return nil, fmt.Errorf("unknown weighting strategy '%s'", name)
+	}
+}
+
+// balancedWeighting averages the share of leechers in the swarm and the share of all the leechers the swarm holds.
+// Torrents without seeders are left aside, being the only seeder of a swarm looks suspicious.
+type balancedWeighting struct{}
+
+func (w *balancedWeighting) Weights(swarms []TorrentSwarm) []float64 {
+	sumOfLeechers := float64(0)
+	for _, s := range swarms {
+		sumOfLeechers += float64(s.Leechers)
+	}
+	weights := make([]float64, len(swarms))
+	for i, s := range swarms {
+		if s.Leechers == 0 || s.Seeders == 0 {
+			continue
+		}
+		leech := float64(s.Leechers)
+		seed := float64(s.Seeders)
+
+		seederRatio := leech / (leech + seed)       // more seeders compared to leecher the better
+		leechersPercentage := leech / sumOfLeechers // more seeder compared to total number of seeders the better
+
+		weights[i] = (seederRatio + leechersPercentage) / 2 // sum the two ratio and divide by two to get a number between 0 and 1
+	}
+	return weights
+}
+
+// leechersWeighting shares the bandwidth in proportion of the number of leechers
+type leechersWeighting struct{}
+
+func (w *leechersWeighting) Weights(swarms []TorrentSwarm) []float64 {
+	weights := make([]float64, len(swarms))
+	for i, s := range swarms {
+		weights[i] = float64(s.Leechers)
+	}
+	return weights
+}
+
+// equalWeighting shares the bandwidth evenly between the torrents having leechers
+type equalWeighting struct{}
+
+func (w *equalWeighting) Weights(swarms []TorrentSwarm) []float64 {
+	weights := make([]float64, len(swarms))
+	for i, s := range swarms {
+		if s.Leechers > 0 {
+			weights[i] = 1
+		}
+	}
+	return weights
+}
+
+// sizeWeighting shares the bandwidth in proportion of the size of the torrents having leechers
+type sizeWeighting struct{}
+
+func (w *sizeWeighting) Weights(swarms []TorrentSwarm) []float64 {
+	weights := make([]float64, len(swarms))
+	for i, s := range swarms {
+		if s.Leechers > 0 {
+			weights[i] = float64(s.Size)
+		}
+	}
+	return weights
+}
+
+// distribute shares the bandwidth according to the weights. Each torrent having leechers (or a weight) first gets minShare
+// of the bandwidth whatever its weight, the rest is given in proportion of the weights. A torrent can not go over its
+// ceiling (+Inf for no cap), what it can not take is given to the others. The bandwidth left when every torrent is capped
+// is not used.
+func distribute(weights []float64, swarms []TorrentSwarm, bandwidth int64, ceilings []float64, minShare float64) []int64 {
+	speeds := make([]int64, len(weights))
+	var floored []int
+	var eligible []int
+	for i, weight := range weights {
+		if weight > 0 {
+			eligible = append(eligible, i)
+		}
+		if weight > 0 || swarms[i].Leechers > 0 {
+			floored = append(floored, i)
+		}
+	}
+	if minShare <= 0 {
+		floored = eligible
+	}
+	if len(floored) == 0 || bandwidth <= 0 {
+		return speeds
+	}
+	total := float64(bandwidth)
+
+	allocated := make([]float64, len(weights))
+	floor := minShare * total
+	if floor*float64(len(floored)) > total {
+		floor = total / float64(len(floored))
+	}
+	remaining := total
+	for _, i := range floored {
+		allocated[i] = math.Min(floor, ceilings[i])
+		remaining -= allocated[i]
+	}
+
+	uncapped := eligible
+	for remaining >= 1 && len(uncapped) > 0 {
+		totalWeight := float64(0)
+		for _, i := range uncapped {
+			totalWeight += weights[i]
+		}
+		var stillUncapped []int
+		given := float64(0)
+		for _, i := range uncapped {
+			share := remaining * weights[i] / totalWeight
+			if allocated[i]+share >= ceilings[i] {
+				share = ceilings[i] - allocated[i]
+			} else {
+				stillUncapped = append(stillUncapped, i)
+			}
+			allocated[i] += share
+			given += share
+		}
+		remaining -= given
+		if len(stillUncapped) == len(uncapped) {
+			break // nobody has been capped, everything is given
+		}
+		uncapped = stillUncapped
+	}
+
+	for i, a := range allocated {
+		speeds[i] = int64(a)
+	}
+	return speeds
+}
@@ -0,0 +1,121 @@
+package bandwidth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestNewWeightingStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    WeightingStrategy
+		wantErr bool
+	}{
+		{name: "", want: &balancedWeighting{}},
+		{name: BalancedWeighting, want: &balancedWeighting{}},
+		{name: LeechersWeighting, want: &leechersWeighting{}},
+		{name: EqualWeighting, want: &equalWeighting{}},
+		{name: SizeWeighting, want: &sizeWeighting{}},
+		{name: "random", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewWeightingStrategy(tt.name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, tt.want, got)
+		})
+	}
+}
+
+func TestWeightingStrategies_Weights(t *testing.T) {
+	swarms := []TorrentSwarm{
+		{Leechers: 1000, Seeders: 50, Size: 100},
+		{Leechers: 1000, Seeders: 1000, Size: 300},
+		{Leechers: 50, Seeders: 1000, Size: 600},
+		{Leechers: 0, Seeders: 20, Size: 1000},
+		{Leechers: 10, Seeders: 0, Size: 400},
+	}
+	tests := []struct {
+		name     string
+		strategy WeightingStrategy
+		want     []float64
+	}{
+		{name: "balanced", strategy: &balancedWeighting{}, want: []float64{
+			(1000.0/1050 + 1000.0/2060) / 2,
+			(1000.0/2000 + 1000.0/2060) / 2,
+			(50.0/1050 + 50.0/2060) / 2,
+			0,
+			0,
+		}},
+		{name: "leechers", strategy: &leechersWeighting{}, want: []float64{1000, 1000, 50, 0, 10}},
+		{name: "equal", strategy: &equalWeighting{}, want: []float64{1, 1, 1, 0, 1}},
+		{name: "size", strategy: &sizeWeighting{}, want: []float64{100, 300, 600, 0, 400}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.strategy.Weights(swarms)
+			assert.InDeltaSlice(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func noCeilings(n int) []float64 {
+	ceilings := make([]float64, n)
+	for i := range ceilings {
+		ceilings[i] = math.Inf(1)
+	}
+	return ceilings
+}
+
+func TestDistribute(t *testing.T) {
+	tests := []struct {
+		name      string
+		weights   []float64
+		bandwidth int64
+		ceilings  []float64
+		minShare  float64
+		// leechers of each torrent, nil for one leecher each
+		leechers []int32
+		want     []int64
+	}{
+		{name: "proportional", weights: []float64{1, 3}, bandwidth: 1000, ceilings: noCeilings(2), want: []int64{250, 750}},
+		{name: "zeroWeightGetsNothing", weights: []float64{1, 0, 1}, bandwidth: 1000, ceilings: noCeilings(3), want: []int64{500, 0, 500}},
+		{name: "noWeight", weights: []float64{0, 0}, bandwidth: 1000, ceilings: noCeilings(2), want: []int64{0, 0}},
+		{name: "noBandwidth", weights: []float64{1, 1}, bandwidth: 0, ceilings: noCeilings(2), want: []int64{0, 0}},
+		{name: "capRedistributesExcess", weights: []float64{8, 1, 1}, bandwidth: 1000, ceilings: []float64{500, 500, 500}, want: []int64{500, 250, 250}},
+		{name: "capCascades", weights: []float64{6, 3, 1}, bandwidth: 1000, ceilings: []float64{400, 400, 400}, want: []int64{400, 400, 200}},
+		{name: "allCappedLeavesBandwidthUnused", weights: []float64{1, 1}, bandwidth: 1000, ceilings: []float64{300, 300}, want: []int64{300, 300}},
+		{name: "zeroCeiling", weights: []float64{1, 1}, bandwidth: 1000, ceilings: []float64{0, math.Inf(1)}, want: []int64{0, 1000}},
+		{name: "minShare", weights: []float64{9, 1}, bandwidth: 1000, ceilings: noCeilings(2), minShare: 0.2, want: []int64{200 + 540, 200 + 60}},
+		{name: "minShareTooLargeIsSplitEvenly", weights: []float64{9, 1, 0}, bandwidth: 1000, ceilings: noCeilings(3), minShare: 0.8, leechers: []int32{1, 1, 0}, want: []int64{500, 500, 0}},
+		{name: "minShareIsGrantedToZeroWeightWithLeechers", weights: []float64{9, 1, 0}, bandwidth: 1000, ceilings: noCeilings(3), minShare: 0.1, want: []int64{100 + 630, 100 + 70, 100}},
+		{name: "minShareIsNotGrantedWithoutLeechers", weights: []float64{1, 0}, bandwidth: 1000, ceilings: noCeilings(2), minShare: 0.1, leechers: []int32{1, 0}, want: []int64{1000, 0}},
+		{name: "minShareOnlyWithoutWeights", weights: []float64{0, 0}, bandwidth: 1000, ceilings: noCeilings(2), minShare: 0.1, want: []int64{100, 100}},
+		{name: "minShareIsCapped", weights: []float64{1, 1}, bandwidth: 1000, ceilings: []float64{300, 300}, minShare: 0.4, want: []int64{300, 300}},
+		{name: "minShareOfCappedTorrentGoesToOthers", weights: []float64{1, 1}, bandwidth: 1000, ceilings: []float64{100, math.Inf(1)}, minShare: 0.4, want: []int64{100, 900}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarms := make([]TorrentSwarm, len(tt.weights))
+			for i := range swarms {
+				swarms[i].Leechers = 1
+				if tt.leechers != nil {
+					swarms[i].Leechers = tt.leechers[i]
+				}
+			}
+			assert.Equal(t, tt.want, distribute(tt.weights, swarms, tt.bandwidth, tt.ceilings, tt.minShare))
+		})
+	}
+}
+
+func TestDistribute_ShouldGrantMinShareToSeederlessSwarmOfBalancedWeighting(t *testing.T) {
+	swarms := []TorrentSwarm{{Leechers: 10, Seeders: 10}, {Leechers: 5, Seeders: 0}}
+	weights := (&balancedWeighting{}).Weights(swarms)
+
+	assert.Equal(t, []int64{900, 100}, distribute(weights, swarms, 1000, noCeilings(2), 0.1))
+}
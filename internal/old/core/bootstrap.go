@@ -22,6 +22,12 @@ var (
 	clientsDirFromRoot = func(rootConfigDir string) string {
 		return filepath.Join(rootConfigDir, "clients")
 	}
+	contributionsFileFromRoot = func(rootConfigDir string) string {
+		return filepath.Join(rootConfigDir, "contributions.yml")
+	}
+	budgetFileFromRoot = func(rootConfigDir string) string {
+		return filepath.Join(rootConfigDir, "budget.yml")
+	}
 )
 
 func Bootstrap(coreRootDir string, client *http.Client) (*CoreConfigLoader, error) {
@@ -41,7 +47,7 @@ func Bootstrap(coreRootDir string, client *http.Client) (*CoreConfigLoader, erro
 		return nil, fmt.Errorf("failed to bootstrap core: %w", err)
 	}
 
-	configLoader := newCoreConfigLoader(coreRootDir)
+	configLoader := NewCoreConfigLoader(coreRootDir)
 
 	err = bootstrapClients(coreRootDir, client, log)
 	if err != nil {
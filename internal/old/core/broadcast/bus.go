@@ -0,0 +1,323 @@
+package broadcast
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy tells what to do with an event published to a subscription whose queue is full
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest queued event to make room for the new one
+	DropOldest OverflowPolicy = iota
+	// Block makes the publisher wait for the subscriber to make room. The publications are serialized, a handler of a
+	// subscription with this policy must never publish.
+	Block
+	// CoalesceByInfohash replaces the oldest queued event of the same type and torrent with the new one, at its position
+	// in the queue. Events not related to a torrent fall back to DropOldest
+	CoalesceByInfohash
+)
+
+const defaultQueueSize = 256
+
+type subscribeOptions struct {
+	name       string
+	queueSize  int
+	overflow   OverflowPolicy
+	eventTypes map[reflect.Type]bool
+	replay     *HistoryQuery
+}
+
+type SubscribeOption func(o *subscribeOptions)
+
+// WithName names the subscription in its Stats
+func WithName(name string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.name = name
+	}
+}
+
+// WithQueueSize sets the number of events a subscription holds before applying its OverflowPolicy
+func WithQueueSize(size int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if size > 0 {
+			o.queueSize = size
+		}
+	}
+}
+
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.overflow = policy
+	}
+}
+
+// WithEventTypes restricts the subscription to the events of the same types as the given samples, for a subscription
+// to an interface such as Subscribe[any]
+func WithEventTypes(samples ...any) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if o.eventTypes == nil {
+			o.eventTypes = make(map[reflect.Type]bool)
+		}
+		for _, sample := range samples {
+			o.eventTypes[reflect.TypeOf(sample)] = true
+		}
+	}
+}
+
+// WithReplayAfter delivers the events of the journal published after the sequence number seq before the live events.
+// The web plugin does not use it, its stomp clients only receive the live events.
+func WithReplayAfter(seq uint64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.replay = &HistoryQuery{AfterSeq: seq}
+	}
+}
+
+// WithReplaySince delivers the events of the journal published since t before the live events
+func WithReplaySince(t time.Time) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.replay = &HistoryQuery{Since: t}
+	}
+}
+
+// SubscriptionStats are the delivery counters of a subscription
+type SubscriptionStats struct {
+	Name      string
+	Queued    int
+	Delivered uint64
+	Dropped   uint64
+	Coalesced uint64
+}
+
+// Subscription holds the events published for a subscriber until its consumer goroutine hands them to the handler, one
+// at a time and in the order they have been published
+type Subscription struct {
+	name      string
+	accepts   func(entry Entry) bool
+	handle    func(entry Entry)
+	queue     []Entry
+	queueSize int
+	overflow  OverflowPolicy
+	closed    bool
+	delivered uint64
+	dropped   uint64
+	coalesced uint64
+	lock      *sync.Mutex
+	notEmpty  *sync.Cond
+	notFull   *sync.Cond
+}
+
+// Subscribe delivers the published events assignable to T to the handler, or their journal Entry if T is Entry. The
+// handler runs on a goroutine dedicated to the subscription, a slow handler only delays its own events.
+func Subscribe[T any](handler func(event T), options ...SubscribeOption) *Subscription {
+	o := &subscribeOptions{queueSize: defaultQueueSize, overflow: DropOldest}
+	for _, option := range options {
+		option(o)
+	}
+	s := &Subscription{
+		name: o.name,
+		accepts: func(entry Entry) bool {
+			if _, ok := entry.Event.(T); !ok {
+				if _, ok := any(entry).(T); !ok {
+					return false
+				}
+			}
+			return o.eventTypes == nil || o.eventTypes[reflect.TypeOf(entry.Event)]
+		},
+		handle: func(entry Entry) {
+			if event, ok := entry.Event.(T); ok {
+				handler(event)
+				return
+			}
+			handler(any(entry).(T))
+		},
+		queueSize: o.queueSize,
+		overflow:  o.overflow,
+		lock:      &sync.Mutex{},
+	}
+	s.notEmpty = sync.NewCond(s.lock)
+	s.notFull = sync.NewCond(s.lock)
+
+	defaultBus.add(s, o.replay)
+	go s.consume()
+	return s
+}
+
+// Unsubscribe stops the delivery, the queued events are discarded. It does not wait for a running handler to return.
+func (s *Subscription) Unsubscribe() {
+	defaultBus.remove(s)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	s.queue = nil
+	s.notEmpty.Broadcast()
+	s.notFull.Broadcast()
+}
+
+func (s *Subscription) Stats() SubscriptionStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return SubscriptionStats{
+		Name:      s.name,
+		Queued:    len(s.queue),
+		Delivered: s.delivered,
+		Dropped:   s.dropped,
+		Coalesced: s.coalesced,
+	}
+}
+
+func (s *Subscription) enqueue(entry Entry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if len(s.queue) >= s.queueSize {
+		switch s.overflow {
+		case Block:
+			for len(s.queue) >= s.queueSize && !s.closed {
+				s.notFull.Wait()
+			}
+			if s.closed {
+				return
+			}
+		case CoalesceByInfohash:
+			if i := s.indexOfSameTorrentEvent(entry.Event); i >= 0 {
+				// the event keeps the position of the one it replaces, it must not overtake the events queued after it
+				s.queue[i] = entry
+				s.coalesced++
+				s.notEmpty.Signal()
+				return
+			}
+			s.queue = s.queue[1:]
+			s.dropped++
+		default:
+			s.queue = s.queue[1:]
+			s.dropped++
+		}
+	}
+	s.queue = append(s.queue, entry)
+	s.notEmpty.Signal()
+}
+
+// indexOfSameTorrentEvent returns the index of the oldest queued event of the same type for the same torrent, -1 if
+// there is none. The lock has to be held by the caller
+func (s *Subscription) indexOfSameTorrentEvent(event any) int {
+	scoped, ok := event.(torrentScopedEvent)
+	if !ok {
+		return -1
+	}
+	eventType := reflect.TypeOf(event)
+	for i, queued := range s.queue {
+		if reflect.TypeOf(queued.Event) != eventType {
+			continue
+		}
+		if queued.Event.(torrentScopedEvent).infohash() == scoped.infohash() {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Subscription) consume() {
+	for {
+		s.lock.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.notEmpty.Wait()
+		}
+		if s.closed {
+			s.lock.Unlock()
+			return
+		}
+		entry := s.queue[0]
+		s.queue[0] = Entry{}
+		s.queue = s.queue[1:]
+		s.delivered++
+		s.notFull.Signal()
+		s.lock.Unlock()
+
+		s.handle(entry)
+	}
+}
+
+// torrentScopedEvent is implemented by the events related to a single torrent
+type torrentScopedEvent interface {
+	infohash() torrent.InfoHash
+}
+
+type bus struct {
+	subscriptions []*Subscription
+	journal       *journal
+	lock          *sync.RWMutex
+	// publishLock serializes the publications, the subscriptions receive the entries in the order of their Seq
+	publishLock *sync.Mutex
+}
+
+var defaultBus = &bus{
+	subscriptions: []*Subscription{},
+	journal:       newJournal(core.JournalConfig{}.Default()),
+	lock:          &sync.RWMutex{},
+	publishLock:   &sync.Mutex{},
+}
+
+// add registers the subscription, the entries of the journal matching replay are queued first whatever the size of the
+// queue. Both happen under the lock so that no event is missed or delivered twice.
+func (b *bus) add(s *Subscription, replay *HistoryQuery) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if replay != nil {
+		entries, _ := b.journal.query(*replay, time.Now())
+		for _, entry := range entries {
+			if s.accepts(entry) {
+				s.queue = append(s.queue, entry)
+			}
+		}
+	}
+	b.subscriptions = append(b.subscriptions, s)
+}
+
+func (b *bus) remove(s *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, subscription := range b.subscriptions {
+		if subscription == s {
+			// copy on write, publish may be iterating over the previous slice
+			subscriptions := make([]*Subscription, 0, len(b.subscriptions)-1)
+			subscriptions = append(subscriptions, b.subscriptions[:i]...)
+			b.subscriptions = append(subscriptions, b.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// publish appends the event to the journal and queues it for the subscriptions. The fan-out is not done under the lock
+// of the bus, a Block subscription waiting for room must not prevent its own unsubscription.
+func (b *bus) publish(event any) {
+	b.publishLock.Lock()
+	defer b.publishLock.Unlock()
+	b.lock.Lock()
+	entry := b.journal.append(event, time.Now())
+	subscriptions := b.subscriptions
+	b.lock.Unlock()
+	for _, s := range subscriptions {
+		if s.accepts(entry) {
+			s.enqueue(entry)
+		}
+	}
+}
+
+// Stats returns the delivery counters of all the active subscriptions
+func Stats() []SubscriptionStats {
+	defaultBus.lock.RLock()
+	subscriptions := defaultBus.subscriptions
+	defaultBus.lock.RUnlock()
+	stats := make([]SubscriptionStats, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		stats = append(stats, s.Stats())
+	}
+	return stats
+}
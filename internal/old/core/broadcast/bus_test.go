@@ -0,0 +1,222 @@
+package broadcast
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler signals each event it receives on started, then waits for release before returning
+func blockingHandler[T any](started chan<- T, release <-chan struct{}) func(T) {
+	return func(event T) {
+		started <- event
+		<-release
+	}
+}
+
+func receive[T any](t *testing.T, c <-chan T) T {
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	panic("unreachable")
+}
+
+func TestSubscribe_ShouldDeliverInPublicationOrder(t *testing.T) {
+	received := make(chan SeedStartedEvent, 1000)
+	sub := Subscribe(func(event SeedStartedEvent) { received <- event }, WithQueueSize(1000))
+	defer sub.Unsubscribe()
+
+	for i := 0; i < 1000; i++ {
+		EmitSeedStart(SeedStartedEvent{Client: strconv.Itoa(i)})
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, strconv.Itoa(i), receive(t, received).Client)
+	}
+}
+
+func TestSubscribe_ShouldDeliverConcurrentPublicationsInSeqOrder(t *testing.T) {
+	received := make(chan Entry, 4000)
+	sub := Subscribe(func(entry Entry) { received <- entry }, WithEventTypes(SeedStartedEvent{}), WithQueueSize(4000))
+	defer sub.Unsubscribe()
+
+	wg := &sync.WaitGroup{}
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				EmitSeedStart(SeedStartedEvent{Client: "concurrent"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	lastSeq := uint64(0)
+	for i := 0; i < 4000; i++ {
+		entry := receive(t, received)
+		if entry.Seq <= lastSeq {
+			t.Fatalf("entry %d delivered after entry %d", entry.Seq, lastSeq)
+		}
+		lastSeq = entry.Seq
+	}
+}
+
+func TestSubscribe_ShouldOnlyDeliverAssignableEvents(t *testing.T) {
+	received := make(chan SeedStoppedEvent, 10)
+	sub := Subscribe(func(event SeedStoppedEvent) { received <- event })
+	defer sub.Unsubscribe()
+
+	EmitSeedStart(SeedStartedEvent{})
+	EmitSeedStop(SeedStoppedEvent{})
+
+	receive(t, received)
+	assert.Eventually(t, func() bool { return sub.Stats().Delivered == 1 }, time.Second, time.Millisecond)
+}
+
+func TestSubscribe_ShouldFilterByEventTypes(t *testing.T) {
+	received := make(chan any, 10)
+	sub := Subscribe(func(event any) { received <- event }, WithEventTypes(SeedStoppedEvent{}, TorrentRemovedEvent{}))
+	defer sub.Unsubscribe()
+
+	EmitSeedStart(SeedStartedEvent{})
+	EmitSeedStop(SeedStoppedEvent{})
+	EmitTorrentAdded(TorrentAddedEvent{})
+	EmitTorrentRemoved(TorrentRemovedEvent{})
+
+	assert.IsType(t, SeedStoppedEvent{}, receive(t, received))
+	assert.IsType(t, TorrentRemovedEvent{}, receive(t, received))
+	select {
+	case event := <-received:
+		t.Fatalf("unexpected event %T", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribe_DropOldestShouldDiscardOldestQueuedEvent(t *testing.T) {
+	started := make(chan SeedStartedEvent, 10)
+	release := make(chan struct{})
+	sub := Subscribe(blockingHandler(started, release), WithQueueSize(2), WithName("drop"))
+	defer sub.Unsubscribe()
+
+	EmitSeedStart(SeedStartedEvent{Client: "1"})
+	assert.Equal(t, "1", receive(t, started).Client)
+	EmitSeedStart(SeedStartedEvent{Client: "2"})
+	EmitSeedStart(SeedStartedEvent{Client: "3"})
+	EmitSeedStart(SeedStartedEvent{Client: "4"})
+
+	assert.Equal(t, SubscriptionStats{Name: "drop", Queued: 2, Delivered: 1, Dropped: 1}, sub.Stats())
+	close(release)
+	assert.Equal(t, "3", receive(t, started).Client)
+	assert.Equal(t, "4", receive(t, started).Client)
+}
+
+func TestSubscribe_BlockShouldWaitForRoomInQueue(t *testing.T) {
+	started := make(chan SeedStartedEvent, 10)
+	release := make(chan struct{})
+	sub := Subscribe(blockingHandler(started, release), WithQueueSize(1), WithOverflowPolicy(Block))
+	defer sub.Unsubscribe()
+
+	EmitSeedStart(SeedStartedEvent{Client: "1"})
+	receive(t, started)
+	EmitSeedStart(SeedStartedEvent{Client: "2"})
+
+	published := make(chan struct{})
+	go func() {
+		EmitSeedStart(SeedStartedEvent{Client: "3"})
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("publish should have been blocked by the full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	receive(t, published)
+	assert.Equal(t, "2", receive(t, started).Client)
+	assert.Equal(t, "3", receive(t, started).Client)
+	assert.Equal(t, uint64(0), sub.Stats().Dropped)
+}
+
+func TestSubscribe_CoalesceByInfohashShouldReplaceQueuedEventOfSameTorrent(t *testing.T) {
+	started := make(chan any, 10)
+	release := make(chan struct{})
+	sub := Subscribe(blockingHandler(started, release), WithQueueSize(3), WithOverflowPolicy(CoalesceByInfohash))
+	defer sub.Unsubscribe()
+
+	EmitSeedStart(SeedStartedEvent{})
+	receive(t, started)
+	EmitTorrentSwarmChanged(TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{1}, Seeder: 1})
+	EmitTorrentSwarmChanged(TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{2}, Seeder: 1})
+	EmitTorrentRemoved(TorrentRemovedEvent{Infohash: torrent.InfoHash{1}})
+	// the queue is full, replaces the swarm of the torrent 1
+	EmitTorrentSwarmChanged(TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{1}, Seeder: 2})
+	// no queued event of the same torrent, the oldest is dropped
+	EmitSeedStop(SeedStoppedEvent{})
+
+	stats := sub.Stats()
+	assert.Equal(t, uint64(1), stats.Coalesced)
+	assert.Equal(t, uint64(1), stats.Dropped)
+	close(release)
+	// the swarm of the torrent 1 has been replaced at the head of the queue, it is the oldest one dropped
+	assert.Equal(t, TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{2}, Seeder: 1}, receive(t, started))
+	assert.Equal(t, TorrentRemovedEvent{Infohash: torrent.InfoHash{1}}, receive(t, started))
+	assert.Equal(t, SeedStoppedEvent{}, receive(t, started))
+}
+
+func TestSubscribe_CoalesceByInfohashShouldKeepTheQueueOrder(t *testing.T) {
+	started := make(chan any, 10)
+	release := make(chan struct{})
+	sub := Subscribe(blockingHandler(started, release), WithQueueSize(3), WithOverflowPolicy(CoalesceByInfohash))
+	defer sub.Unsubscribe()
+
+	EmitSeedStart(SeedStartedEvent{})
+	receive(t, started)
+	EmitTorrentSwarmChanged(TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{1}, Seeder: 1})
+	EmitTorrentSwarmChanged(TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{2}, Seeder: 1})
+	EmitTorrentRemoved(TorrentRemovedEvent{Infohash: torrent.InfoHash{1}})
+	// the newer swarm must not be delivered after the removal of its torrent
+	EmitTorrentSwarmChanged(TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{1}, Seeder: 2})
+
+	assert.Equal(t, uint64(1), sub.Stats().Coalesced)
+	close(release)
+	assert.Equal(t, TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{1}, Seeder: 2}, receive(t, started))
+	assert.Equal(t, TorrentSwarmChangedEvent{Infohash: torrent.InfoHash{2}, Seeder: 1}, receive(t, started))
+	assert.Equal(t, TorrentRemovedEvent{Infohash: torrent.InfoHash{1}}, receive(t, started))
+}
+
+func TestSubscription_UnsubscribeFromHandlerShouldNotDeadlock(t *testing.T) {
+	done := make(chan struct{})
+	var sub *Subscription
+	sub = Subscribe(func(event SeedStoppedEvent) {
+		sub.Unsubscribe()
+		close(done)
+	}, WithName("self"))
+	EmitSeedStop(SeedStoppedEvent{})
+	receive(t, done)
+
+	EmitSeedStop(SeedStoppedEvent{})
+	for _, s := range Stats() {
+		assert.NotEqual(t, "self", s.Name)
+	}
+	assert.Equal(t, uint64(1), sub.Stats().Delivered)
+}
+
+func TestStats_ShouldListActiveSubscriptions(t *testing.T) {
+	a := Subscribe(func(event any) {}, WithName("a"))
+	b := Subscribe(func(event any) {}, WithName("b"))
+	defer b.Unsubscribe()
+	a.Unsubscribe()
+
+	var names []string
+	for _, s := range Stats() {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"b"}, names)
+}
@@ -1,49 +1,77 @@
 package broadcast
 
 func EmitSeedStart(event SeedStartedEvent) {
-	listeners.OnSeedStart(event)
+	defaultBus.publish(event)
 }
 
 func EmitSeedStop(event SeedStoppedEvent) {
-	listeners.OnSeedStop(event)
+	defaultBus.publish(event)
 }
 
 func EmitConfigChanged(event ConfigChangedEvent) {
-	listeners.OnConfigChanged(event)
+	defaultBus.publish(event)
 }
 
 func EmitTorrentAdded(event TorrentAddedEvent) {
-	listeners.OnTorrentAdded(event)
+	defaultBus.publish(event)
 }
 
 func EmitTorrentAnnouncing(event TorrentAnnouncingEvent) {
-	listeners.OnTorrentAnnouncing(event)
+	defaultBus.publish(event)
 }
 
 func EmitTorrentAnnounceSuccess(event TorrentAnnounceSuccessEvent) {
-	listeners.OnTorrentAnnounceSuccess(event)
+	defaultBus.publish(event)
 }
 
 func EmitTorrentAnnounceFailed(event TorrentAnnounceFailedEvent) {
-	listeners.OnTorrentAnnounceFailed(event)
+	defaultBus.publish(event)
+}
+
+func EmitTorrentTrackerDisabled(event TorrentTrackerDisabledEvent) {
+	defaultBus.publish(event)
 }
 
 func EmitTorrentSwarmChanged(event TorrentSwarmChangedEvent) {
-	listeners.OnTorrentSwarmChanged(event)
+	defaultBus.publish(event)
 }
 
 func EmitTorrentRemoved(event TorrentRemovedEvent) {
-	listeners.OnTorrentRemoved(event)
+	defaultBus.publish(event)
+}
+
+func EmitTorrentGoalReached(event TorrentGoalReachedEvent) {
+	defaultBus.publish(event)
+}
+
+func EmitTorrentPaused(event TorrentPausedEvent) {
+	defaultBus.publish(event)
+}
+
+func EmitTorrentResumed(event TorrentResumedEvent) {
+	defaultBus.publish(event)
 }
 
 func EmitNoticeableError(event NoticeableErrorEvent) {
-	listeners.OnNoticeableError(event)
+	defaultBus.publish(event)
 }
 
 func EmitGlobalBandwidthChanged(event GlobalBandwidthChangedEvent) {
-	listeners.OnGlobalBandwidthChanged(event)
+	defaultBus.publish(event)
 }
 
 func EmitBandwidthWeightHasChanged(event BandwidthWeightHasChangedEvent) {
-	listeners.OnBandwidthWeightHasChanged(event)
+	defaultBus.publish(event)
+}
+
+func EmitBandwidthBudgetChanged(event BandwidthBudgetChangedEvent) {
+	defaultBus.publish(event)
+}
+
+func EmitBandwidthBudgetThresholdReached(event BandwidthBudgetThresholdReachedEvent) {
+	defaultBus.publish(event)
+}
+
+func EmitPublicIpChanged(event PublicIpChangedEvent) {
+	defaultBus.publish(event)
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"net"
 	"net/url"
 	"time"
 )
@@ -21,6 +22,8 @@ type ConfigChangedEvent struct {
 	RuntimeConfig           *core.RuntimeConfig
 }
 
+// TorrentAddedEvent is emitted when the watcher finds a torrent file, and again for each known torrent when the seed
+// starts since the listeners forget the torrents on SeedStoppedEvent
 type TorrentAddedEvent struct {
 	Infohash            torrent.InfoHash
 	Name                string
@@ -44,6 +47,7 @@ type TorrentAnnounceSuccessEvent struct {
 	Seeder        int32
 	Leechers      int32
 	Interval      time.Duration
+	Route         string // name of the routing rule the announce went through
 }
 
 type TorrentAnnounceFailedEvent struct {
@@ -52,6 +56,15 @@ type TorrentAnnounceFailedEvent struct {
 	AnnounceEvent tracker.AnnounceEvent
 	Datetime      time.Time
 	Error         string
+	Route         string // name of the routing rule the announce went through
+}
+
+// TorrentTrackerDisabledEvent is emitted when a tracker of a torrent will not be announced to, because the client does
+// not support its protocol or because it kept failing. It is emitted once per tracker of a torrent.
+type TorrentTrackerDisabledEvent struct {
+	Infohash   torrent.InfoHash
+	TrackerUrl url.URL
+	Reason     string
 }
 
 type TorrentSwarmChangedEvent struct {
@@ -64,6 +77,27 @@ type TorrentRemovedEvent struct {
 	Infohash torrent.InfoHash
 }
 
+// TorrentGoalReachedEvent is emitted when a torrent reaches its goal and the manager stops seeding it
+type TorrentGoalReachedEvent struct {
+	Infohash torrent.InfoHash
+	Goal     string // ratio, seedingTime or dailyUpload
+	Action   string // pause or archive
+	// Uploaded and SeedingTime are the contribution of the torrent over all its seeding sessions
+	Uploaded    int64
+	SeedingTime time.Duration
+}
+
+// TorrentPausedEvent is emitted when the manager stops seeding a torrent until it is resumed
+type TorrentPausedEvent struct {
+	Infohash torrent.InfoHash
+	Reason   string // user, or the goal reached: ratio, seedingTime or dailyUpload
+}
+
+// TorrentResumedEvent is emitted when a paused torrent is seeded again
+type TorrentResumedEvent struct {
+	Infohash torrent.InfoHash
+}
+
 type NoticeableErrorEvent struct {
 	Error    error
 	Datetime time.Time
@@ -71,9 +105,94 @@ type NoticeableErrorEvent struct {
 
 type GlobalBandwidthChangedEvent struct {
 	AvailableBandwidth int64
+	// SpeedProfile is the name of the active schedule window ("default" when none is active)
+	SpeedProfile string
 }
 
+// BandwidthWeightHasChangedEvent is emitted when the bandwidth distribution changes. The share of the bandwidth given
+// to a torrent is its weight divided by the total weight, capped torrents may leave part of the bandwidth unused.
 type BandwidthWeightHasChangedEvent struct {
 	TotalWeight    float64
 	TorrentWeights map[torrent.InfoHash]float64
 }
+
+// BandwidthBudgetChangedEvent reports the consumption of the upload budget for the current period
+type BandwidthBudgetChangedEvent struct {
+	Limit       int64
+	Consumed    int64
+	Period      string // day, week or month
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+// BandwidthBudgetThresholdReachedEvent is emitted once per period when the consumption goes over a fraction (0.8 then
+// 1) of the upload budget
+type BandwidthBudgetThresholdReachedEvent struct {
+	Threshold float64
+	Limit     int64
+	Consumed  int64
+	PeriodEnd time.Time
+}
+
+type PublicIpChangedEvent struct {
+	PreviousIPv4 net.IP
+	PreviousIPv6 net.IP
+	IPv4         net.IP
+	IPv6         net.IP
+}
+
+func (e TorrentAddedEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentAnnouncingEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentAnnouncingEvent) trackerUrl() url.URL {
+	return e.TrackerUrl
+}
+
+func (e TorrentAnnounceSuccessEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentAnnounceSuccessEvent) trackerUrl() url.URL {
+	return e.TrackerUrl
+}
+
+func (e TorrentAnnounceFailedEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentAnnounceFailedEvent) trackerUrl() url.URL {
+	return e.TrackerUrl
+}
+
+func (e TorrentTrackerDisabledEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentTrackerDisabledEvent) trackerUrl() url.URL {
+	return e.TrackerUrl
+}
+
+func (e TorrentSwarmChangedEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentRemovedEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentGoalReachedEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentPausedEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
+
+func (e TorrentResumedEvent) infohash() torrent.InfoHash {
+	return e.Infohash
+}
@@ -0,0 +1,186 @@
+package broadcast
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Entry is a published event with its position in the journal. Subscribe[Entry] receives the entries rather than the
+// events, to know where to resume from.
+type Entry struct {
+	// Seq is incremented by one for each published event, starting at 1
+	Seq   uint64
+	Time  time.Time
+	Event any
+}
+
+// Type returns the name of the type of the event, see EventType
+func (e Entry) Type() string {
+	return EventType(e.Event)
+}
+
+// EventType returns the name of the type of the event without its Event suffix, TorrentAdded for a TorrentAddedEvent
+func EventType(event any) string {
+	t := reflect.TypeOf(event)
+	if t == nil {
+		return ""
+	}
+	return strings.TrimSuffix(t.Name(), "Event")
+}
+
+// trackerScopedEvent is implemented by the events related to a single tracker of a torrent
+type trackerScopedEvent interface {
+	trackerUrl() url.URL
+}
+
+// HistoryQuery selects entries of the journal, the zero value of a field does not filter
+type HistoryQuery struct {
+	AfterSeq uint64
+	Since    time.Time
+	Infohash *torrent.InfoHash
+	// Tracker is the announce url of the tracker or its host
+	Tracker string
+	// Types are the EventType of the entries
+	Types []string
+	Limit int
+}
+
+func (q HistoryQuery) matches(e Entry) bool {
+	if e.Seq <= q.AfterSeq || e.Time.Before(q.Since) {
+		return false
+	}
+	if q.Infohash != nil {
+		scoped, ok := e.Event.(torrentScopedEvent)
+		if !ok || scoped.infohash() != *q.Infohash {
+			return false
+		}
+	}
+	if q.Tracker != "" {
+		scoped, ok := e.Event.(trackerScopedEvent)
+		if !ok {
+			return false
+		}
+		u := scoped.trackerUrl()
+		if !strings.EqualFold(u.String(), q.Tracker) && !strings.EqualFold(u.Host, q.Tracker) {
+			return false
+		}
+	}
+	if len(q.Types) > 0 {
+		eventType := e.Type()
+		for _, t := range q.Types {
+			if strings.EqualFold(t, eventType) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// journal is a ring buffer of the latest entries, bounded in size and age. It is only used under the lock of the bus.
+type journal struct {
+	entries []Entry
+	start   int
+	count   int
+	maxAge  time.Duration
+	lastSeq uint64
+}
+
+func newJournal(conf *core.JournalConfig) *journal {
+	j := &journal{}
+	j.configure(conf)
+	return j
+}
+
+// configure applies new bounds, keeping the newest entries
+func (j *journal) configure(conf *core.JournalConfig) {
+	maxEntries := conf.MaxEntries
+	if maxEntries < 0 {
+		maxEntries = 0
+	}
+	kept := j.count
+	if kept > maxEntries {
+		kept = maxEntries
+	}
+	entries := make([]Entry, maxEntries)
+	for i := 0; i < kept; i++ {
+		entries[i] = j.at(j.count - kept + i)
+	}
+	j.entries = entries
+	j.start = 0
+	j.count = kept
+	j.maxAge = conf.MaxAge
+}
+
+func (j *journal) at(i int) Entry {
+	return j.entries[(j.start+i)%len(j.entries)]
+}
+
+// append records the event and returns its entry, the sequence number is incremented even if the journal is disabled
+func (j *journal) append(event any, now time.Time) Entry {
+	j.lastSeq++
+	e := Entry{Seq: j.lastSeq, Time: now, Event: event}
+	j.expire(now)
+	if len(j.entries) == 0 {
+		return e
+	}
+	if j.count < len(j.entries) {
+		j.entries[(j.start+j.count)%len(j.entries)] = e
+		j.count++
+	} else {
+		j.entries[j.start] = e
+		j.start = (j.start + 1) % len(j.entries)
+	}
+	return e
+}
+
+// expire forgets the entries older than maxAge
+func (j *journal) expire(now time.Time) {
+	if j.maxAge <= 0 {
+		return
+	}
+	for j.count > 0 && now.Sub(j.entries[j.start].Time) > j.maxAge {
+		j.entries[j.start] = Entry{}
+		j.start = (j.start + 1) % len(j.entries)
+		j.count--
+	}
+}
+
+// query returns the entries matching the query from the oldest, and true if more entries match after the last one
+// returned
+func (j *journal) query(q HistoryQuery, now time.Time) ([]Entry, bool) {
+	var res []Entry
+	for i := 0; i < j.count; i++ {
+		e := j.at(i)
+		if j.maxAge > 0 && now.Sub(e.Time) > j.maxAge {
+			continue
+		}
+		if !q.matches(e) {
+			continue
+		}
+		if q.Limit > 0 && len(res) == q.Limit {
+			return res, true
+		}
+		res = append(res, e)
+	}
+	return res, false
+}
+
+// ConfigureJournal changes the bounds of the journal, the newest entries are kept
+func ConfigureJournal(conf *core.JournalConfig) {
+	defaultBus.lock.Lock()
+	defer defaultBus.lock.Unlock()
+	defaultBus.journal.configure(conf)
+}
+
+// History returns the entries of the journal matching the query from the oldest, and true if more entries match after
+// the last one returned. The next page starts after the Seq of the last entry.
+func History(query HistoryQuery) ([]Entry, bool) {
+	defaultBus.lock.RLock()
+	defer defaultBus.lock.RUnlock()
+	return defaultBus.journal.query(query, time.Now())
+}
@@ -0,0 +1,159 @@
+package broadcast
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func seqs(entries []Entry) []uint64 {
+	var res []uint64
+	for _, e := range entries {
+		res = append(res, e.Seq)
+	}
+	return res
+}
+
+func TestEventType(t *testing.T) {
+	assert.Equal(t, "TorrentAdded", EventType(TorrentAddedEvent{}))
+	assert.Equal(t, "SeedStopped", Entry{Event: SeedStoppedEvent{}}.Type())
+	assert.Equal(t, "", EventType(nil))
+}
+
+func TestJournal_ShouldKeepNewestEntries(t *testing.T) {
+	j := newJournal(&core.JournalConfig{MaxEntries: 3})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		j.append(SeedStoppedEvent{}, now)
+	}
+
+	entries, more := j.query(HistoryQuery{}, now)
+	assert.Equal(t, []uint64{3, 4, 5}, seqs(entries))
+	assert.False(t, more)
+}
+
+func TestJournal_ShouldForgetExpiredEntries(t *testing.T) {
+	j := newJournal(&core.JournalConfig{MaxEntries: 10, MaxAge: time.Hour})
+	now := time.Now()
+	j.append(SeedStoppedEvent{}, now.Add(-2*time.Hour))
+	j.append(SeedStoppedEvent{}, now.Add(-30*time.Minute))
+	j.append(SeedStoppedEvent{}, now)
+
+	entries, _ := j.query(HistoryQuery{}, now)
+	assert.Equal(t, []uint64{2, 3}, seqs(entries))
+	entries, _ = j.query(HistoryQuery{}, now.Add(45*time.Minute))
+	assert.Equal(t, []uint64{3}, seqs(entries))
+}
+
+func TestJournal_ShouldCountSequenceWhenDisabled(t *testing.T) {
+	j := newJournal(&core.JournalConfig{MaxEntries: 0})
+	now := time.Now()
+	j.append(SeedStoppedEvent{}, now)
+	assert.Equal(t, uint64(2), j.append(SeedStoppedEvent{}, now).Seq)
+
+	entries, _ := j.query(HistoryQuery{}, now)
+	assert.Empty(t, entries)
+}
+
+func TestJournal_ConfigureShouldKeepNewestEntries(t *testing.T) {
+	j := newJournal(&core.JournalConfig{MaxEntries: 4})
+	now := time.Now()
+	for i := 0; i < 6; i++ {
+		j.append(SeedStoppedEvent{}, now)
+	}
+
+	j.configure(&core.JournalConfig{MaxEntries: 2})
+	entries, _ := j.query(HistoryQuery{}, now)
+	assert.Equal(t, []uint64{5, 6}, seqs(entries))
+
+	j.configure(&core.JournalConfig{MaxEntries: 5})
+	j.append(SeedStoppedEvent{}, now)
+	entries, _ = j.query(HistoryQuery{}, now)
+	assert.Equal(t, []uint64{5, 6, 7}, seqs(entries))
+}
+
+func TestJournal_QueryShouldFilterAndPage(t *testing.T) {
+	j := newJournal(&core.JournalConfig{MaxEntries: 100})
+	now := time.Now()
+	first, _ := url.Parse("http://tracker.example.org/announce")
+	second, _ := url.Parse("udp://other.example.org:6969")
+	ih1 := torrent.InfoHash{1}
+	ih2 := torrent.InfoHash{2}
+	j.append(SeedStartedEvent{}, now.Add(-time.Minute))                                                           // 1
+	j.append(TorrentAddedEvent{Infohash: ih1}, now.Add(-time.Minute))                                             // 2
+	j.append(TorrentAnnouncingEvent{Infohash: ih1, TrackerUrl: *first, AnnounceEvent: tracker.Started}, now)      // 3
+	j.append(TorrentAnnounceSuccessEvent{Infohash: ih1, TrackerUrl: *first, AnnounceEvent: tracker.Started}, now) // 4
+	j.append(TorrentAnnouncingEvent{Infohash: ih2, TrackerUrl: *second, AnnounceEvent: tracker.Started}, now)     // 5
+	j.append(TorrentAnnounceFailedEvent{Infohash: ih2, TrackerUrl: *second, AnnounceEvent: tracker.Started}, now) // 6
+	j.append(TorrentRemovedEvent{Infohash: ih1}, now)                                                             // 7
+
+	tests := []struct {
+		name  string
+		query HistoryQuery
+		want  []uint64
+		more  bool
+	}{
+		{name: "all", query: HistoryQuery{}, want: []uint64{1, 2, 3, 4, 5, 6, 7}},
+		{name: "after-seq", query: HistoryQuery{AfterSeq: 5}, want: []uint64{6, 7}},
+		{name: "since", query: HistoryQuery{Since: now}, want: []uint64{3, 4, 5, 6, 7}},
+		{name: "infohash", query: HistoryQuery{Infohash: &ih1}, want: []uint64{2, 3, 4, 7}},
+		{name: "tracker-url", query: HistoryQuery{Tracker: "http://tracker.example.org/announce"}, want: []uint64{3, 4}},
+		{name: "tracker-host", query: HistoryQuery{Tracker: "other.example.org:6969"}, want: []uint64{5, 6}},
+		{name: "types", query: HistoryQuery{Types: []string{"TorrentAnnouncing", "torrentremoved"}}, want: []uint64{3, 5, 7}},
+		{name: "combined", query: HistoryQuery{Infohash: &ih2, Types: []string{"TorrentAnnounceFailed"}}, want: []uint64{6}},
+		{name: "first-page", query: HistoryQuery{Limit: 3}, want: []uint64{1, 2, 3}, more: true},
+		{name: "next-page", query: HistoryQuery{AfterSeq: 3, Limit: 3}, want: []uint64{4, 5, 6}, more: true},
+		{name: "last-page", query: HistoryQuery{AfterSeq: 6, Limit: 3}, want: []uint64{7}, more: false},
+		{name: "exact-last-page", query: HistoryQuery{AfterSeq: 4, Limit: 3}, want: []uint64{5, 6, 7}, more: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, more := j.query(tt.query, now)
+			assert.Equal(t, tt.want, seqs(entries))
+			assert.Equal(t, tt.more, more)
+		})
+	}
+}
+
+func TestSubscribe_ShouldReplayJournalBeforeLiveEvents(t *testing.T) {
+	EmitSeedStart(SeedStartedEvent{Client: "replay-1"})
+	entries, _ := History(HistoryQuery{Types: []string{"SeedStarted"}})
+	afterSeq := entries[len(entries)-1].Seq
+	EmitSeedStart(SeedStartedEvent{Client: "replay-2"})
+	EmitSeedStop(SeedStoppedEvent{})
+	EmitSeedStart(SeedStartedEvent{Client: "replay-3"})
+
+	received := make(chan Entry, 10)
+	sub := Subscribe(func(entry Entry) {
+		if _, ok := entry.Event.(SeedStartedEvent); ok {
+			received <- entry
+		}
+	}, WithReplayAfter(afterSeq))
+	defer sub.Unsubscribe()
+	EmitSeedStart(SeedStartedEvent{Client: "replay-4"})
+
+	var clients []string
+	lastSeq := afterSeq
+	for i := 0; i < 3; i++ {
+		entry := receive(t, received)
+		assert.Greater(t, entry.Seq, lastSeq)
+		lastSeq = entry.Seq
+		clients = append(clients, entry.Event.(SeedStartedEvent).Client)
+	}
+	assert.Equal(t, []string{"replay-2", "replay-3", "replay-4"}, clients)
+}
+
+func TestSubscribe_ShouldReplaySinceTimestamp(t *testing.T) {
+	since := time.Now()
+	EmitSeedStart(SeedStartedEvent{Client: "since-1"})
+
+	received := make(chan SeedStartedEvent, 10)
+	sub := Subscribe(func(event SeedStartedEvent) { received <- event }, WithReplaySince(since))
+	defer sub.Unsubscribe()
+
+	assert.Equal(t, "since-1", receive(t, received).Client)
+}
@@ -10,14 +10,19 @@ type CoreConfigLoader struct {
 	torrentDir          string
 	archivedTorrentsDir string
 	clientsDir          string
+	contributionsFile   string
+	budgetFile          string
 }
 
-func newCoreConfigLoader(coreRootDir string) *CoreConfigLoader {
+// NewCoreConfigLoader reads the configuration of an already bootstrapped core root directory
+func NewCoreConfigLoader(coreRootDir string) *CoreConfigLoader {
 	return &CoreConfigLoader{
 		configFilePath:      configFileFromRoot(coreRootDir),
 		torrentDir:          torrentDirFromRoot(coreRootDir),
 		archivedTorrentsDir: archivedTorrentDirFromRoot(coreRootDir),
 		clientsDir:          clientsDirFromRoot(coreRootDir),
+		contributionsFile:   contributionsFileFromRoot(coreRootDir),
+		budgetFile:          budgetFileFromRoot(coreRootDir),
 	}
 }
 
@@ -32,6 +37,8 @@ func (l *CoreConfigLoader) ReadConfig() (*JoalConfig, error) {
 		TorrentsDir:         l.torrentDir,
 		ArchivedTorrentsDir: l.archivedTorrentsDir,
 		ClientsDir:          l.clientsDir,
+		ContributionsFile:   l.contributionsFile,
+		BudgetFile:          l.budgetFile,
 		RuntimeConfig:       conf,
 	}, nil
 }
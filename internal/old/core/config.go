@@ -11,7 +11,11 @@ type JoalConfig struct {
 	TorrentsDir         string
 	ArchivedTorrentsDir string
 	ClientsDir          string
-	RuntimeConfig       *RuntimeConfig
+	// ContributionsFile persists the contribution of each torrent across restarts
+	ContributionsFile string
+	// BudgetFile persists the consumption of the upload budget across restarts
+	BudgetFile    string
+	RuntimeConfig *RuntimeConfig
 }
 
 func (c *JoalConfig) ListClientFiles() ([]string, error) {
@@ -38,8 +42,13 @@ func (c *JoalConfig) ListClientFiles() ([]string, error) {
 }
 
 type RuntimeConfig struct {
-	BandwidthConfig *BandwidthConfig `yaml:"bandwidth"`
-	Client          string           `yaml:"client"`
+	BandwidthConfig *BandwidthConfig   `yaml:"bandwidth"`
+	Client          string             `yaml:"client"`
+	PublicIp        *PublicIpConfig    `yaml:"publicIp"`
+	PortMapping     *PortMappingConfig `yaml:"portMapping"`
+	Routing         *RoutingConfig     `yaml:"routing"`
+	Goals           *GoalsConfig       `yaml:"goals"`
+	Journal         *JournalConfig     `yaml:"journal"`
 }
 
 // Return a new RuntimeConfig with the default values filled in
@@ -47,23 +56,70 @@ func (c RuntimeConfig) Default() *RuntimeConfig {
 	return &RuntimeConfig{
 		BandwidthConfig: BandwidthConfig{}.Default(),
 		Client:          "qbittorrent-3.3.1.yml",
+		PublicIp:        PublicIpConfig{}.Default(),
+		PortMapping:     PortMappingConfig{}.Default(),
+		Routing:         RoutingConfig{}.Default(),
+		Goals:           GoalsConfig{}.Default(),
+		Journal:         JournalConfig{}.Default(),
 	}
 }
 
 type BandwidthConfig struct {
 	Speed      *SpeedProviderConfig `yaml:"speed"`
 	Dispatcher *DispatcherConfig    `yaml:"dispatcher"`
+	Accounting *AccountingConfig    `yaml:"accounting"`
 }
 
 func (c BandwidthConfig) Default() *BandwidthConfig {
 	return &BandwidthConfig{
 		Speed:      SpeedProviderConfig{}.Default(),
 		Dispatcher: DispatcherConfig{}.Default(),
+		Accounting: AccountingConfig{}.Default(),
+	}
+}
+
+// AccountingConfig defines how the uploaded bytes are counted from the speeds, over the real time elapsed between two
+// accountings
+type AccountingConfig struct {
+	// MaxCatchUp is the longest time accounted at once, a process resuming from sleep does not upload for the whole
+	// time it was asleep. 0 disables the cap
+	MaxCatchUp time.Duration `yaml:"maxCatchUp"`
+}
+
+func (c AccountingConfig) Default() *AccountingConfig {
+	return &AccountingConfig{
+		MaxCatchUp: 1 * time.Minute,
 	}
 }
 
 type DispatcherConfig struct {
 	GlobalBandwidthRefreshInterval time.Duration `yaml:"globalBandwidthRefreshInterval"`
+	// Weighting is the strategy sharing the bandwidth between the torrents: balanced (default), leechers, equal or size
+	Weighting string `yaml:"weighting"`
+	// TorrentMaxBytesPerSeconds caps the speed of each torrent, the excess goes to the other torrents. 0 disables the cap
+	TorrentMaxBytesPerSeconds int64 `yaml:"torrentMax"`
+	// TorrentMinShare is the fraction of the bandwidth (between 0 and 1) granted to each torrent having leechers,
+	// whatever its weight. When the shares do not fit in the bandwidth, it is split evenly
+	TorrentMinShare float64 `yaml:"torrentMinShare"`
+	// PerLeecherMaxBytesPerSeconds caps the speed of each torrent to this value times its number of leechers, the excess
+	// goes to the other torrents. 0 disables the cap
+	PerLeecherMaxBytesPerSeconds int64 `yaml:"perLeecherMax"`
+	// UploadRatioPerLeecher stops a torrent from uploading more than this multiple of its size times the highest number
+	// of leechers seen, both counted over all its seeding sessions. 0 disables the limit
+	UploadRatioPerLeecher float64 `yaml:"uploadRatioPerLeecher"`
+	// Budget limits the bytes uploaded by all the torrents over a period of time, nil disables it
+	Budget *BudgetConfig `yaml:"budget"`
+}
+
+// BudgetConfig is a global upload quota. The bandwidth is paced to spread what is left of the budget over what is left
+// of the period, once the budget is exhausted the torrents keep announcing without uploading until the next period.
+type BudgetConfig struct {
+	// Limit is the number of bytes the torrents may upload per period, 0 disables the budget
+	Limit int64 `yaml:"limit"`
+	// Period is day (default), week (starting on monday) or month
+	Period string `yaml:"period"`
+	// Timezone the period boundaries are computed in, empty for the local time
+	Timezone string `yaml:"timezone"`
 }
 
 func (c DispatcherConfig) Default() *DispatcherConfig {
@@ -75,6 +131,61 @@ func (c DispatcherConfig) Default() *DispatcherConfig {
 type SpeedProviderConfig struct {
 	MinimumBytesPerSeconds int64 `yaml:"min"`
 	MaximumBytesPerSeconds int64 `yaml:"max"`
+	// Schedule overrides min and max during time windows, the first window containing the current time wins
+	Schedule []*SpeedWindowConfig `yaml:"schedule"`
+	// Model defines how the speed evolves between two refreshes, nil picks a new uniform random speed each time
+	Model *SpeedModelConfig `yaml:"model"`
+}
+
+// SpeedModelConfig selects the speed model, available types are: uniform, randomWalk, ornsteinUhlenbeck and trace.
+// Only the section of the selected type is used.
+type SpeedModelConfig struct {
+	Type string `yaml:"type"`
+	// Seed makes the generated speeds reproducible, 0 seeds from a random source
+	Seed              int64                         `yaml:"seed"`
+	RandomWalk        *RandomWalkModelConfig        `yaml:"randomWalk"`
+	OrnsteinUhlenbeck *OrnsteinUhlenbeckModelConfig `yaml:"ornsteinUhlenbeck"`
+	Trace             *TraceModelConfig             `yaml:"trace"`
+}
+
+// RandomWalkModelConfig moves the speed by a random step at each refresh, bouncing on min and max
+type RandomWalkModelConfig struct {
+	// MaxStep is the largest change between two refreshes as a fraction of max - min, 0 for 0.1
+	MaxStep float64 `yaml:"maxStep"`
+}
+
+// OrnsteinUhlenbeckModelConfig moves the speed randomly while pulling it back toward a mean
+type OrnsteinUhlenbeckModelConfig struct {
+	// Mean is the speed the model reverts to as a fraction of max - min (0.5 is the middle of the range), unset for 0.5
+	Mean *float64 `yaml:"mean"`
+	// Theta is the share of the gap to the mean closed at each refresh, between 0 and 1, unset for 0.15
+	Theta *float64 `yaml:"theta"`
+	// Sigma is the standard deviation of the noise added at each refresh as a fraction of max - min, unset for 0.1
+	Sigma *float64 `yaml:"sigma"`
+}
+
+// TraceModelConfig replays a recorded speed trace in a loop, one value per refresh
+type TraceModelConfig struct {
+	// File holds one speed in bytes per second per line, empty lines and lines starting with # are ignored
+	File string `yaml:"file"`
+	// Rescale maps the lowest and highest values of the trace onto min and max, otherwise values are clamped
+	Rescale bool `yaml:"rescale"`
+}
+
+type SpeedWindowConfig struct {
+	// Name is reported as the active speed profile, it has to be unique
+	Name string `yaml:"name"`
+	// Days the window starts on (mon, tue, ... or ranges like mon-fri), empty means every day
+	Days []string `yaml:"days"`
+	// From is the start of the window (HH:MM, included)
+	From string `yaml:"from"`
+	// To is the end of the window (HH:MM, excluded), a value before From ends the window on the next day and a value
+	// equal to From makes the window last the whole day
+	To string `yaml:"to"`
+	// Timezone is an IANA timezone name (Europe/Paris, UTC, ...), empty for the system one
+	Timezone               string `yaml:"timezone"`
+	MinimumBytesPerSeconds int64  `yaml:"min"`
+	MaximumBytesPerSeconds int64  `yaml:"max"`
 }
 
 func (c SpeedProviderConfig) Default() *SpeedProviderConfig {
@@ -83,3 +194,159 @@ func (c SpeedProviderConfig) Default() *SpeedProviderConfig {
 		MaximumBytesPerSeconds: 15000,
 	}
 }
+
+// PublicIpConfig defines how the public ips announced to the trackers are found.
+// Strategies are tried in order, for each address family the first strategy that finds an ip wins.
+// Available strategies are: static, providers, tracker and interface.
+type PublicIpConfig struct {
+	Strategies      []string                 `yaml:"strategies"`
+	RefreshInterval time.Duration            `yaml:"refreshInterval"`
+	Static          *StaticPublicIpConfig    `yaml:"static"`
+	Providers       *PublicIpProvidersConfig `yaml:"providers"`
+	Interface       *InterfacePublicIpConfig `yaml:"interface"`
+}
+
+func (c PublicIpConfig) Default() *PublicIpConfig {
+	return &PublicIpConfig{
+		Strategies:      []string{"providers"},
+		RefreshInterval: 30 * time.Minute,
+		Static:          &StaticPublicIpConfig{},
+		Providers:       PublicIpProvidersConfig{}.Default(),
+		Interface:       &InterfacePublicIpConfig{},
+	}
+}
+
+type StaticPublicIpConfig struct {
+	IPv4 string `yaml:"ipv4"`
+	IPv6 string `yaml:"ipv6"`
+}
+
+// PublicIpProvidersConfig lists web services answering with the ip of the caller as plain text.
+// They are all queried at once, an ip is accepted when at least Quorum of them agree.
+type PublicIpProvidersConfig struct {
+	Urls   []string `yaml:"urls"`
+	Quorum int      `yaml:"quorum"`
+}
+
+func (c PublicIpProvidersConfig) Default() *PublicIpProvidersConfig {
+	return &PublicIpProvidersConfig{
+		Urls: []string{
+			"https://api.ipify.org",
+			"https://api64.ipify.org",
+			"http://myexternalip.com/raw",
+			"http://ipinfo.io/ip",
+			"http://ipecho.net/plain",
+			"http://icanhazip.com",
+			"http://ifconfig.me/ip",
+			"http://ident.me",
+			"http://checkip.amazonaws.com",
+			"http://whatismyip.akamai.com",
+			"http://wgetip.com",
+			"http://ip.tyk.nu",
+		},
+		Quorum: 2,
+	}
+}
+
+// InterfacePublicIpConfig uses the address of a local network interface, for hosts directly connected to internet.
+// An empty Name means any interface.
+type InterfacePublicIpConfig struct {
+	Name string `yaml:"name"`
+}
+
+// PortMappingConfig asks the router to forward the listener port. Methods are tried in order until one succeeds,
+// available methods are: upnp (UPnP IGD) and natpmp (NAT-PMP). No method means the port is not mapped.
+type PortMappingConfig struct {
+	Methods  []string      `yaml:"methods"`
+	Lifetime time.Duration `yaml:"lifetime"`
+	// Gateway is the NAT-PMP gateway address, the default route gateway is used if empty. The default route can only be detected on linux, it is required elsewhere
+	Gateway string `yaml:"gateway"`
+}
+
+func (c PortMappingConfig) Default() *PortMappingConfig {
+	return &PortMappingConfig{
+		Methods:  []string{},
+		Lifetime: 1 * time.Hour,
+	}
+}
+
+// RoutingConfig decides how each tracker is reached. Rules are evaluated in order, the first matching one wins.
+// Trackers matching no rule go through the application proxy.
+type RoutingConfig struct {
+	Rules []*RouteRuleConfig `yaml:"rules"`
+}
+
+func (c RoutingConfig) Default() *RoutingConfig {
+	return &RoutingConfig{
+		Rules: []*RouteRuleConfig{},
+	}
+}
+
+// RouteRuleConfig matches the trackers whose host matches one of the Hosts globs (*.example.org) and whose scheme is one
+// of Schemes (udp also matches udp4 and udp6). An empty list matches anything.
+type RouteRuleConfig struct {
+	Name    string   `yaml:"name"`
+	Hosts   []string `yaml:"hosts"`
+	Schemes []string `yaml:"schemes"`
+	// Proxy is empty to use the application proxy, "none" to connect directly, or a http://, https://, socks5:// or socks5h:// url
+	Proxy string `yaml:"proxy"`
+	// BindAddress is the local ip the sockets are bound to, empty lets the system pick one
+	BindAddress string          `yaml:"bindAddress"`
+	Dns         *RouteDnsConfig `yaml:"dns"`
+}
+
+// RouteDnsConfig resolves Hosts to a fixed ip, and the other host names with Server ("ip" or "ip:port"), empty uses the
+// system resolver. An HTTP proxy resolves the host names itself, it can not be combined with a RouteDnsConfig.
+type RouteDnsConfig struct {
+	Hosts  map[string]string `yaml:"hosts"`
+	Server string            `yaml:"server"`
+}
+
+// GoalsConfig defines when a torrent is done seeding. The goal set on a torrent through the API wins over the goal of
+// the directory holding the torrent file, which wins over the global goal.
+type GoalsConfig struct {
+	Global *GoalConfig `yaml:"global"`
+	// Directories are watched along with the torrents directory, a change requires a restart
+	Directories []*DirectoryGoalConfig `yaml:"directories"`
+}
+
+func (c GoalsConfig) Default() *GoalsConfig {
+	return &GoalsConfig{
+		Global:      &GoalConfig{},
+		Directories: []*DirectoryGoalConfig{},
+	}
+}
+
+// GoalConfig counts the contribution of a torrent over all its seeding sessions, zero values disable a goal
+type GoalConfig struct {
+	// Ratio is reached once the torrent has uploaded Ratio times its size
+	Ratio float64 `yaml:"ratio"`
+	// SeedingTime is reached once the torrent has been seeded that long
+	SeedingTime time.Duration `yaml:"seedingTime"`
+	// DailyUpload pauses the torrent until the next day once it has uploaded that many bytes during the day
+	DailyUpload int64 `yaml:"dailyUpload"`
+	// Action applied once the ratio or the seeding time is reached: pause (default) or archive
+	Action string `yaml:"action"`
+}
+
+type DirectoryGoalConfig struct {
+	// Path of the directory, relative to the torrents directory. It can not be the archived directory or inside it
+	Path string     `yaml:"path"`
+	Goal GoalConfig `yaml:",inline"`
+}
+
+// JournalConfig bounds the history of the events kept in memory for the late subscribers, the oldest events are
+// forgotten first
+type JournalConfig struct {
+	// MaxEntries is the number of events kept, 0 disables the journal
+	MaxEntries int `yaml:"maxEntries"`
+	// MaxAge is the age after which an event is forgotten, 0 keeps the events whatever their age
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+func (c JournalConfig) Default() *JournalConfig {
+	return &JournalConfig{
+		MaxEntries: 10000,
+		MaxAge:     24 * time.Hour,
+	}
+}
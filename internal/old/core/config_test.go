@@ -151,6 +151,31 @@ globalBandwidthRefreshInterval: 300h
 	}, c)
 }
 
+func TestDispatcherConfig_ShouldUnmarshalWeighting(t *testing.T) {
+	yamlStr := `
+weighting: size
+torrentMax: 50000
+torrentMinShare: 0.05
+perLeecherMax: 2000
+uploadRatioPerLeecher: 0.5
+`
+
+	c := DispatcherConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &DispatcherConfig{
+		GlobalBandwidthRefreshInterval: DispatcherConfig{}.Default().GlobalBandwidthRefreshInterval,
+		Weighting:                      "size",
+		TorrentMaxBytesPerSeconds:      50000,
+		TorrentMinShare:                0.05,
+		PerLeecherMaxBytesPerSeconds:   2000,
+		UploadRatioPerLeecher:          0.5,
+	}, c)
+}
+
 func TestSpeedProviderConfig_ShouldUnmarshal(t *testing.T) {
 	yamlStr := `
 min: 25
@@ -185,3 +210,249 @@ max: 10000
 		MaximumBytesPerSeconds: 10000,
 	}, c)
 }
+
+func TestSpeedProviderConfig_ShouldUnmarshalSchedule(t *testing.T) {
+	yamlStr := `
+min: 25
+max: 10000
+schedule:
+  - name: office-hours
+    days: [mon-fri]
+    from: "09:00"
+    to: "18:00"
+    timezone: Europe/Paris
+    min: 0
+    max: 100
+  - name: night
+    from: "23:00"
+    to: "07:00"
+    min: 50000
+    max: 90000
+`
+
+	c := SpeedProviderConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &SpeedProviderConfig{
+		MinimumBytesPerSeconds: 25,
+		MaximumBytesPerSeconds: 10000,
+		Schedule: []*SpeedWindowConfig{
+			{Name: "office-hours", Days: []string{"mon-fri"}, From: "09:00", To: "18:00", Timezone: "Europe/Paris", MinimumBytesPerSeconds: 0, MaximumBytesPerSeconds: 100},
+			{Name: "night", From: "23:00", To: "07:00", MinimumBytesPerSeconds: 50000, MaximumBytesPerSeconds: 90000},
+		},
+	}, c)
+}
+
+func TestSpeedProviderConfig_ShouldUnmarshalModel(t *testing.T) {
+	yamlStr := `
+min: 25
+max: 10000
+model:
+  type: ornsteinUhlenbeck
+  seed: 42
+  randomWalk:
+    maxStep: 0.2
+  ornsteinUhlenbeck:
+    mean: 0.7
+    theta: 0.3
+    sigma: 0.05
+  trace:
+    file: /tmp/trace.txt
+    rescale: true
+`
+
+	c := SpeedProviderConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &SpeedModelConfig{
+		Type:              "ornsteinUhlenbeck",
+		Seed:              42,
+		RandomWalk:        &RandomWalkModelConfig{MaxStep: 0.2},
+		OrnsteinUhlenbeck: &OrnsteinUhlenbeckModelConfig{Mean: float64Ptr(0.7), Theta: float64Ptr(0.3), Sigma: float64Ptr(0.05)},
+		Trace:             &TraceModelConfig{File: "/tmp/trace.txt", Rescale: true},
+	}, c.Model)
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func TestOrnsteinUhlenbeckModelConfig_ShouldTellZeroFromUnset(t *testing.T) {
+	c := &OrnsteinUhlenbeckModelConfig{}
+	err := yaml.Unmarshal([]byte("mean: 0\nsigma: 0\n"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &OrnsteinUhlenbeckModelConfig{Mean: float64Ptr(0), Sigma: float64Ptr(0)}, c)
+}
+
+func TestPublicIpConfig_ShouldUnmarshal(t *testing.T) {
+	yamlStr := `
+strategies: [static, providers]
+refreshInterval: 10m
+static:
+  ipv4: 203.0.113.1
+  ipv6: 2001:db8::1
+providers:
+  urls: [http://localhost/ip]
+  quorum: 1
+interface:
+  name: eth0
+`
+
+	c := &PublicIpConfig{}
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &PublicIpConfig{
+		Strategies:      []string{"static", "providers"},
+		RefreshInterval: 10 * time.Minute,
+		Static:          &StaticPublicIpConfig{IPv4: "203.0.113.1", IPv6: "2001:db8::1"},
+		Providers:       &PublicIpProvidersConfig{Urls: []string{"http://localhost/ip"}, Quorum: 1},
+		Interface:       &InterfacePublicIpConfig{Name: "eth0"},
+	}, c)
+}
+
+func TestPublicIpConfig_ShouldUnmarshalAndReplaceDefault(t *testing.T) {
+	yamlStr := `
+strategies: [tracker, providers]
+`
+
+	c := PublicIpConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"tracker", "providers"}, c.Strategies)
+	assert.Equal(t, PublicIpConfig{}.Default().RefreshInterval, c.RefreshInterval)
+	assert.Equal(t, PublicIpProvidersConfig{}.Default(), c.Providers)
+}
+
+func TestPortMappingConfig_ShouldUnmarshalAndReplaceDefault(t *testing.T) {
+	yamlStr := `
+methods: [natpmp, upnp]
+gateway: 192.168.1.1
+`
+
+	c := PortMappingConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &PortMappingConfig{
+		Methods:  []string{"natpmp", "upnp"},
+		Lifetime: PortMappingConfig{}.Default().Lifetime,
+		Gateway:  "192.168.1.1",
+	}, c)
+}
+
+func TestRoutingConfig_ShouldUnmarshalAndReplaceDefault(t *testing.T) {
+	yamlStr := `
+rules:
+  - name: private
+    hosts: ["*.private.org", tracker.private.net]
+    schemes: [https]
+    proxy: socks5://127.0.0.1:9050
+    bindAddress: 192.168.1.10
+    dns:
+      hosts:
+        tracker.private.net: 203.0.113.7
+      server: 1.1.1.1
+  - name: lan
+    schemes: [udp]
+    proxy: none
+`
+
+	c := RoutingConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &RoutingConfig{
+		Rules: []*RouteRuleConfig{
+			{
+				Name:        "private",
+				Hosts:       []string{"*.private.org", "tracker.private.net"},
+				Schemes:     []string{"https"},
+				Proxy:       "socks5://127.0.0.1:9050",
+				BindAddress: "192.168.1.10",
+				Dns: &RouteDnsConfig{
+					Hosts:  map[string]string{"tracker.private.net": "203.0.113.7"},
+					Server: "1.1.1.1",
+				},
+			},
+			{Name: "lan", Schemes: []string{"udp"}, Proxy: "none"},
+		},
+	}, c)
+}
+
+func TestGoalsConfig_ShouldUnmarshalAndReplaceDefault(t *testing.T) {
+	yamlStr := `
+global:
+  ratio: 2.5
+  seedingTime: 72h
+directories:
+  - path: private
+    ratio: 5
+    dailyUpload: 1000000000
+    action: archive
+`
+
+	c := GoalsConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &GoalsConfig{
+		Global: &GoalConfig{Ratio: 2.5, SeedingTime: 72 * time.Hour},
+		Directories: []*DirectoryGoalConfig{
+			{Path: "private", Goal: GoalConfig{Ratio: 5, DailyUpload: 1000000000, Action: "archive"}},
+		},
+	}, c)
+}
+
+func TestAccountingConfig_ShouldUnmarshalAndReplaceDefault(t *testing.T) {
+	yamlStr := `
+accounting:
+  maxCatchUp: 5m
+`
+
+	c := BandwidthConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &AccountingConfig{MaxCatchUp: 5 * time.Minute}, c.Accounting)
+	assert.Equal(t, AccountingConfig{}.Default(), BandwidthConfig{}.Default().Accounting)
+}
+
+func TestJournalConfig_ShouldUnmarshalAndReplaceDefault(t *testing.T) {
+	yamlStr := `
+journal:
+  maxEntries: 500
+  maxAge: 2h
+`
+
+	c := RuntimeConfig{}.Default()
+	err := yaml.Unmarshal([]byte(yamlStr), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &JournalConfig{MaxEntries: 500, MaxAge: 2 * time.Hour}, c.Journal)
+	assert.Equal(t, JournalConfig{}.Default(), RuntimeConfig{}.Default().Journal)
+}
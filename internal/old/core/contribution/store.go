@@ -0,0 +1,166 @@
+package contribution
+
+import (
+	"errors"
+	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/fileutils"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+// Counters is the contribution of a torrent over all its seeding sessions
+type Counters struct {
+	Uploaded    int64         `yaml:"uploaded"`
+	SeedingTime time.Duration `yaml:"seedingTime"`
+	// Day is the local date (YYYY-MM-DD) DayUploaded has been counted on
+	Day         string `yaml:"day"`
+	DayUploaded int64  `yaml:"dayUploaded"`
+	// LeechersSeen is the highest number of leechers the trackers have reported for the torrent
+	LeechersSeen int32 `yaml:"leechersSeen"`
+	// Goal has been set on the torrent through the API, it wins over the goals of the config
+	Goal *core.GoalConfig `yaml:"goal,omitempty"`
+}
+
+// UploadedOn returns the bytes uploaded on the local day of t
+func (c Counters) UploadedOn(t time.Time) int64 {
+	if c.Day != t.Format(dayLayout) {
+		return 0
+	}
+	return c.DayUploaded
+}
+
+type storeFile struct {
+	Torrents map[string]*Counters `yaml:"torrents"`
+}
+
+// Store keeps the Counters of each torrent in a file, Save has to be called to persist the changes
+type Store struct {
+	path     string
+	torrents map[string]*Counters
+	dirty    bool
+	lock     *sync.Mutex
+}
+
+// Load reads the store file, a missing file gives an empty store
+func Load(path string) (*Store, error) {
+	s := &Store{
+		path:     path,
+		torrents: make(map[string]*Counters),
+		lock:     &sync.Mutex{},
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contributions file '%s': %w", path, err)
+	}
+	file := storeFile{}
+	err = yaml.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse contributions file '%s': %w", path, err)
+	}
+	for infoHash, counters := range file.Torrents {
+		if counters != nil {
+			s.torrents[infoHash] = counters
+		}
+	}
+	return s, nil
+}
+
+// Get returns the counters of the torrent, zero counters if the torrent is unknown
+func (s *Store) Get(infoHash torrent.InfoHash) Counters {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.copyOf(infoHash)
+}
+
+// Add counts the bytes uploaded and the time spent seeding by the torrent at the given time
+func (s *Store) Add(infoHash torrent.InfoHash, uploaded int64, seedingTime time.Duration, now time.Time) Counters {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.counters(infoHash)
+	if day := now.Format(dayLayout); c.Day != day {
+		c.Day = day
+		c.DayUploaded = 0
+	}
+	c.Uploaded += uploaded
+	c.DayUploaded += uploaded
+	c.SeedingTime += seedingTime
+	s.dirty = true
+	return s.copyOf(infoHash)
+}
+
+// SeeLeechers records the number of leechers reported for the torrent, only the highest one is kept
+func (s *Store) SeeLeechers(infoHash torrent.InfoHash, leechers int32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.counters(infoHash)
+	if leechers > c.LeechersSeen {
+		c.LeechersSeen = leechers
+		s.dirty = true
+	}
+}
+
+// SetGoal sets the goal of the torrent, nil removes it
+func (s *Store) SetGoal(infoHash torrent.InfoHash, goal *core.GoalConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.counters(infoHash)
+	if goal != nil {
+		g := *goal
+		goal = &g
+	}
+	c.Goal = goal
+	s.dirty = true
+}
+
+// Save writes the store to its file if it has changed since the last save
+func (s *Store) Save() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	content, err := yaml.Marshal(&storeFile{Torrents: s.torrents})
+	if err != nil {
+		return fmt.Errorf("failed to serialize contributions: %w", err)
+	}
+	err = fileutils.WriteFileAtomic(s.path, content)
+	if err != nil {
+		return fmt.Errorf("failed to save contributions: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// counters returns the stored counters of the torrent, creating them if needed. The lock has to be held by the caller
+func (s *Store) counters(infoHash torrent.InfoHash) *Counters {
+	c, ok := s.torrents[infoHash.HexString()]
+	if !ok {
+		c = &Counters{}
+		s.torrents[infoHash.HexString()] = c
+	}
+	return c
+}
+
+// copyOf returns a copy of the counters of the torrent. The lock has to be held by the caller
+func (s *Store) copyOf(infoHash torrent.InfoHash) Counters {
+	c, ok := s.torrents[infoHash.HexString()]
+	if !ok {
+		return Counters{}
+	}
+	res := *c
+	if c.Goal != nil {
+		g := *c.Goal
+		res.Goal = &g
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package contribution
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad_ShouldReturnEmptyStoreIfFileIsMissing(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "contributions.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Counters{}, s.Get(torrent.InfoHash{1}))
+}
+
+func TestLoad_ShouldFailOnInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contributions.yml")
+	err := os.WriteFile(path, []byte("torrents: [not, a, map]"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(path)
+	assert.Error(t, err)
+}
+
+func TestStore_AddShouldAccumulateAndResetDailyCounterOnNewDay(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "contributions.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoHash := torrent.InfoHash{1}
+	day1 := time.Date(2021, 3, 1, 23, 0, 0, 0, time.Local)
+
+	s.Add(infoHash, 100, 1*time.Minute, day1)
+	c := s.Add(infoHash, 50, 1*time.Minute, day1.Add(30*time.Minute))
+	assert.Equal(t, int64(150), c.Uploaded)
+	assert.Equal(t, 2*time.Minute, c.SeedingTime)
+	assert.Equal(t, int64(150), c.UploadedOn(day1))
+
+	c = s.Add(infoHash, 10, 1*time.Minute, day1.Add(2*time.Hour))
+	assert.Equal(t, int64(160), c.Uploaded)
+	assert.Equal(t, int64(10), c.UploadedOn(day1.Add(2*time.Hour)))
+	assert.Equal(t, int64(0), c.UploadedOn(day1.Add(26*time.Hour)))
+}
+
+func TestStore_ShouldPersistAcrossLoads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contributions.yml")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+	s.Add(torrent.InfoHash{1}, 1000, 1*time.Hour, now)
+	s.SetGoal(torrent.InfoHash{2}, &core.GoalConfig{Ratio: 3, Action: "archive"})
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Counters{Uploaded: 1000, SeedingTime: 1 * time.Hour, Day: "2021-03-01", DayUploaded: 1000}, reloaded.Get(torrent.InfoHash{1}))
+	assert.Equal(t, &core.GoalConfig{Ratio: 3, Action: "archive"}, reloaded.Get(torrent.InfoHash{2}).Goal)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 1, "the temporary file must be removed")
+}
+
+func TestStore_GetShouldReturnACopy(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "contributions.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetGoal(torrent.InfoHash{1}, &core.GoalConfig{Ratio: 3})
+	c := s.Get(torrent.InfoHash{1})
+	c.Goal.Ratio = 10
+	c.Uploaded = 10
+
+	assert.Equal(t, Counters{Goal: &core.GoalConfig{Ratio: 3}}, s.Get(torrent.InfoHash{1}))
+}
+
+func TestStore_SeeLeechersShouldKeepTheHighestNumber(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "contributions.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoHash := torrent.InfoHash{1}
+
+	s.SeeLeechers(infoHash, 5)
+	s.SeeLeechers(infoHash, 2)
+	assert.Equal(t, int32(5), s.Get(infoHash).LeechersSeen)
+	s.SeeLeechers(infoHash, 8)
+	assert.Equal(t, int32(8), s.Get(infoHash).LeechersSeen)
+}
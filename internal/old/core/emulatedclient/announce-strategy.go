@@ -4,4 +4,6 @@ type AnnounceCapabilities struct {
 	SupportAnnounceList         bool `yaml:"supportAnnounceList"`
 	AnnounceToAllTiers          bool `yaml:"announceToAllTiers"`
 	AnnounceToAllTrackersInTier bool `yaml:"announceToAllTrackersInTier"`
+	// When both public ips are known, announce once over IPv4 and once over IPv6 (as libtorrent does) rather than once over the system preferred stack
+	AnnouncePerAddressFamily bool `yaml:"announcePerAddressFamily"`
 }
@@ -8,19 +8,23 @@ import (
 	"github.com/anthonyraymond/joal-cli/internal/old/core/announces"
 	keygenerator "github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key/generator"
 	peeridgenerator "github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/peerid/generator"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/portmapping"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/publicip"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/routing"
 	"github.com/go-playground/validator/v10"
 	"gopkg.in/yaml.v3"
 	"io"
-	"net/http"
+	"net"
 	"net/url"
 	"os"
+	"strings"
 )
 
 type IEmulatedClient interface {
 	GetName() string
 	GetVersion() string
 	Announce(request *announces.AnnounceRequest)
-	StartListener(proxyFunc func(*http.Request) (*url.URL, error)) error
+	StartListener(publicIps *publicip.Monitor, portMapper *portmapping.PortMapper) error
 	StopListener(ctx context.Context)
 	GetAnnounceCapabilities() AnnounceCapabilities
 	SupportsHttpAnnounce() bool
@@ -37,17 +41,19 @@ type EmulatedClient struct {
 	AnnounceCapabilities AnnounceCapabilities             `yaml:"announceCapabilities" validate:"required"`
 	Announcer            *announcer.Announcer             `yaml:"announcer" validate:"required"`
 	Listener             *Listener                        `yaml:"listener" validate:"required"`
+	router               *routing.Router                  `yaml:"-"`
 }
 
-func FromClientFile(path string, proxyFunc func(*http.Request) (*url.URL, error)) (IEmulatedClient, error) {
+// FromClientFile loads the client file, each tracker is reached through the route the router picks for it (a nil router connects directly)
+func FromClientFile(path string, router *routing.Router) (IEmulatedClient, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	return FromReader(file, proxyFunc)
+	return FromReader(file, router)
 }
 
-func FromReader(reader io.Reader, proxyFunc func(*http.Request) (*url.URL, error)) (IEmulatedClient, error) {
+func FromReader(reader io.Reader, router *routing.Router) (IEmulatedClient, error) {
 	client := EmulatedClient{}
 	err := yaml.NewDecoder(reader).Decode(&client)
 	if err != nil {
@@ -61,7 +67,7 @@ func FromReader(reader io.Reader, proxyFunc func(*http.Request) (*url.URL, error
 		return nil, err
 	}
 
-	err = client.AfterPropertiesSet(proxyFunc)
+	err = client.AfterPropertiesSet(router)
 	if err != nil {
 		return nil, err
 	}
@@ -69,7 +75,8 @@ func FromReader(reader io.Reader, proxyFunc func(*http.Request) (*url.URL, error
 	return &client, nil
 }
 
-func (c *EmulatedClient) AfterPropertiesSet(proxyFunc func(*http.Request) (*url.URL, error)) error {
+func (c *EmulatedClient) AfterPropertiesSet(router *routing.Router) error {
+	c.router = router
 	err := c.KeyGenerator.AfterPropertiesSet()
 	if err != nil {
 		return err
@@ -80,7 +87,7 @@ func (c *EmulatedClient) AfterPropertiesSet(proxyFunc func(*http.Request) (*url.
 		return err
 	}
 
-	err = c.Announcer.AfterPropertiesSet(proxyFunc)
+	err = c.Announcer.AfterPropertiesSet()
 	if err != nil {
 		return err
 	}
@@ -101,48 +108,164 @@ func (c *EmulatedClient) GetVersion() string {
 }
 
 func (c *EmulatedClient) Announce(request *announces.AnnounceRequest) {
-	if c.Listener.ip == nil || c.Listener.listeningPort == nil {
+	ipv4, ipv6 := c.Listener.IPs()
+	if (ipv4 == nil && ipv6 == nil) || c.Listener.listeningPort == nil {
 		panic(fmt.Errorf("EmulatedClient listener is not started"))
 	}
 
-	announceRequest := announcer.AnnounceRequest{
-		InfoHash:   request.InfoHash,
-		PeerId:     c.PeerIdGenerator.Get(request.InfoHash, request.Event),
-		Downloaded: request.Downloaded,
-		Left:       request.Left,
-		Uploaded:   request.Uploaded,
-		Corrupt:    request.Corrupt,
-		Event:      request.Event,
-		IPAddress:  *c.Listener.ip,
-		Key:        uint32(c.KeyGenerator.Get(request.InfoHash, request.Event)),
-		NumWant:    c.NumWant,
-		Private:    request.Private,
-		Port:       *c.Listener.listeningPort,
-	}
-	if request.Event == tracker.Stopped {
-		announceRequest.NumWant = c.NumWantOnStop
-	}
-
-	response, err := c.Announcer.Announce(request.Url, announceRequest, request.Ctx)
+	route := c.router.Route(request.Url)
+	families, err := c.announceFamilies(request.Url, ipv4, ipv6)
 	if err != nil {
 		request.AnnounceCallbacks.Failed(announces.AnnounceResponseError{
 			Request:  request,
 			Error:    fmt.Errorf("announce failed: %w", err),
 			Interval: 0,
+			Route:    route.Name(),
+		})
+		return
+	}
+
+	var responses []announcer.AnnounceResponse
+	var errs []string
+	for _, family := range families {
+		announceRequest := c.toAnnouncerRequest(request, ipv4, ipv6, family, c.Listener.announcedPort())
+		announceRequest.Route = route
+		response, err := c.Announcer.Announce(request.Url, announceRequest, request.Ctx)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", family, err))
+			continue
+		}
+		c.Listener.reportExternalIp(response.ExternalIp)
+		responses = append(responses, response)
+	}
+	if len(responses) == 0 {
+		request.AnnounceCallbacks.Failed(announces.AnnounceResponseError{
+			Request:  request,
+			Error:    fmt.Errorf("announce failed: %s", strings.Join(errs, ", ")),
+			Interval: 0,
+			Route:    route.Name(),
 		})
 		return
 	}
+
+	response := mergeResponses(responses)
 	request.AnnounceCallbacks.Success(announces.AnnounceResponse{
-		Request:  request,
-		Interval: response.Interval,
-		Leechers: response.Leechers,
-		Seeders:  response.Seeders,
-		Peers:    response.Peers,
+		Request:   request,
+		Interval:  response.Interval,
+		Leechers:  response.Leechers,
+		Seeders:   response.Seeders,
+		Peers:     response.Peers,
+		TrackerId: response.TrackerId,
+		Route:     route.Name(),
 	})
 }
 
-func (c *EmulatedClient) StartListener(proxyFunc func(*http.Request) (*url.URL, error)) error {
-	return c.Listener.Start(proxyFunc)
+// announceFamilies returns the address families the announce has to be sent through
+func (c *EmulatedClient) announceFamilies(u url.URL, ipv4 net.IP, ipv6 net.IP) ([]announcer.AddressFamily, error) {
+	switch announcer.AddressFamilyOfUrl(u) {
+	case announcer.IPv4Family:
+		if ipv4 == nil {
+			return nil, fmt.Errorf("tracker '%s' is only reachable over ipv4 and no public ipv4 is known", u.Host)
+		}
+		return []announcer.AddressFamily{announcer.IPv4Family}, nil
+	case announcer.IPv6Family:
+		if ipv6 == nil {
+			return nil, fmt.Errorf("tracker '%s' is only reachable over ipv6 and no public ipv6 is known", u.Host)
+		}
+		return []announcer.AddressFamily{announcer.IPv6Family}, nil
+	}
+	if ipv4 != nil && ipv6 != nil && c.AnnounceCapabilities.AnnouncePerAddressFamily {
+		return []announcer.AddressFamily{announcer.IPv4Family, announcer.IPv6Family}, nil
+	}
+	if ipv4 == nil {
+		return []announcer.AddressFamily{announcer.IPv6Family}, nil
+	}
+	if ipv6 == nil {
+		return []announcer.AddressFamily{announcer.IPv4Family}, nil
+	}
+	return []announcer.AddressFamily{announcer.AnyFamily}, nil
+}
+
+// mergeResponses combines the responses of the announces sent through each address family
+func mergeResponses(responses []announcer.AnnounceResponse) announcer.AnnounceResponse {
+	merged := responses[0]
+	merged.Peers = append([]tracker.Peer{}, merged.Peers...)
+	for _, r := range responses[1:] {
+		if r.Interval < merged.Interval {
+			merged.Interval = r.Interval
+		}
+		if r.Leechers > merged.Leechers {
+			merged.Leechers = r.Leechers
+		}
+		if r.Seeders > merged.Seeders {
+			merged.Seeders = r.Seeders
+		}
+		if merged.TrackerId == "" {
+			merged.TrackerId = r.TrackerId
+		}
+		merged.Peers = append(merged.Peers, r.Peers...)
+	}
+	return merged
+}
+
+// RenderHttpAnnounceUrls returns the urls the client would query for this request, nothing is sent over the network.
+// ipv4, ipv6 (either one may be nil) and port stand for the listener ones, so the listener does not need to be started.
+// One url is returned per address family the client announces through, in the order the announces are sent.
+func (c *EmulatedClient) RenderHttpAnnounceUrls(request *announces.AnnounceRequest, ipv4 net.IP, ipv6 net.IP, port uint16) ([]*url.URL, error) {
+	if c.Announcer.Http == nil {
+		return nil, fmt.Errorf("client does not support http announce")
+	}
+	httpAnnouncer, ok := c.Announcer.Http.(*announcer.HttpAnnouncer)
+	if !ok {
+		return nil, fmt.Errorf("http announcer can not render urls")
+	}
+	families, err := c.announceFamilies(request.Url, ipv4, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]*url.URL, 0, len(families))
+	for _, family := range families {
+		rendered, err := httpAnnouncer.RenderUrl(request.Url, c.toAnnouncerRequest(request, ipv4, ipv6, family, port))
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s announce url: %w", family, err)
+		}
+		urls = append(urls, rendered)
+	}
+	return urls, nil
+}
+
+func (c *EmulatedClient) toAnnouncerRequest(request *announces.AnnounceRequest, ipv4 net.IP, ipv6 net.IP, family announcer.AddressFamily, port uint16) announcer.AnnounceRequest {
+	ip := ipv4
+	if family == announcer.IPv6Family || ip == nil {
+		ip = ipv6
+	}
+	peerId := c.PeerIdGenerator.Get(request.InfoHash, request.Event)
+	announceRequest := announcer.AnnounceRequest{
+		InfoHash:      request.InfoHash,
+		PeerId:        peerId,
+		Downloaded:    request.Downloaded,
+		Left:          request.Left,
+		Uploaded:      request.Uploaded,
+		Corrupt:       request.Corrupt,
+		Event:         request.Event,
+		IPAddress:     ip,
+		IPv4:          ipv4,
+		IPv6:          ipv6,
+		Key:           uint32(c.KeyGenerator.Get(request.InfoHash, peerId, request.Event)),
+		NumWant:       c.NumWant,
+		Private:       request.Private,
+		Port:          port,
+		TrackerId:     request.TrackerId,
+		AddressFamily: family,
+	}
+	if request.Event == tracker.Stopped {
+		announceRequest.NumWant = c.NumWantOnStop
+	}
+	return announceRequest
+}
+
+func (c *EmulatedClient) StartListener(publicIps *publicip.Monitor, portMapper *portmapping.PortMapper) error {
+	return c.Listener.Start(publicIps, portMapper)
 }
 
 func (c *EmulatedClient) StopListener(ctx context.Context) {
@@ -1,7 +1,9 @@
 package emulatedclient
 
 import (
+	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/announcer"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/announces"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/casing"
 	keyAlgorithm "github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key/algorithm"
 	keyGenerator "github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key/generator"
@@ -12,6 +14,8 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
@@ -108,3 +112,85 @@ func TestEmulatedClient_ShouldValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestEmulatedClient_announceFamilies(t *testing.T) {
+	ipv4, ipv6 := net.ParseIP("203.0.113.1"), net.ParseIP("2001:db8::1")
+	tests := []struct {
+		name      string
+		url       string
+		perFamily bool
+		ipv4      net.IP
+		ipv6      net.IP
+		want      []announcer.AddressFamily
+		wantErr   bool
+	}{
+		{name: "shouldLetSystemChooseWithBothIps", url: "http://t.org/a", ipv4: ipv4, ipv6: ipv6, want: []announcer.AddressFamily{announcer.AnyFamily}},
+		{name: "shouldAnnounceOverIpv4IfOnlyIpv4", url: "http://t.org/a", ipv4: ipv4, want: []announcer.AddressFamily{announcer.IPv4Family}},
+		{name: "shouldAnnounceOverIpv6IfOnlyIpv6", url: "http://t.org/a", ipv6: ipv6, want: []announcer.AddressFamily{announcer.IPv6Family}},
+		{name: "shouldAnnouncePerFamily", url: "http://t.org/a", perFamily: true, ipv4: ipv4, ipv6: ipv6, want: []announcer.AddressFamily{announcer.IPv4Family, announcer.IPv6Family}},
+		{name: "shouldNotAnnouncePerFamilyWithOneIp", url: "http://t.org/a", perFamily: true, ipv4: ipv4, want: []announcer.AddressFamily{announcer.IPv4Family}},
+		{name: "shouldForceIpv6ForUdp6", url: "udp6://t.org:6969", perFamily: true, ipv4: ipv4, ipv6: ipv6, want: []announcer.AddressFamily{announcer.IPv6Family}},
+		{name: "shouldForceIpv6ForIpv6Host", url: "http://[2001:db8::2]/a", ipv4: ipv4, ipv6: ipv6, want: []announcer.AddressFamily{announcer.IPv6Family}},
+		{name: "shouldFailForIpv6TrackerWithoutIpv6", url: "udp6://t.org:6969", ipv4: ipv4, wantErr: true},
+		{name: "shouldFailForIpv4TrackerWithoutIpv4", url: "http://203.0.113.2/a", ipv6: ipv6, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &EmulatedClient{AnnounceCapabilities: AnnounceCapabilities{AnnouncePerAddressFamily: tt.perFamily}}
+			u, _ := url.Parse(tt.url)
+			got, err := client.announceFamilies(*u, tt.ipv4, tt.ipv6)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEmulatedClient_RenderHttpAnnounceUrls_ShouldRenderEveryAddressFamily(t *testing.T) {
+	ipv4, ipv6 := net.ParseIP("203.0.113.1"), net.ParseIP("2001:db8::1")
+	tests := []struct {
+		name      string
+		perFamily bool
+		ipv4      net.IP
+		ipv6      net.IP
+		want      []string
+	}{
+		{name: "dual-stack-per-family", perFamily: true, ipv4: ipv4, ipv6: ipv6, want: []string{"ip=203.0.113.1&ipv6=2001:db8::1", "ip=2001:db8::1&ipv6=2001:db8::1"}},
+		{name: "dual-stack", ipv4: ipv4, ipv6: ipv6, want: []string{"ip=203.0.113.1&ipv6=2001:db8::1"}},
+		{name: "ipv6-only", perFamily: true, ipv6: ipv6, want: []string{"ip=2001:db8::1&ipv6=2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, _ := os.Open(filepath.Join("testdata", "client.yml"))
+			defer func() { _ = file.Close() }()
+			var client EmulatedClient
+			assert.NoError(t, yaml.NewDecoder(file).Decode(&client))
+			client.Announcer.Http.(*announcer.HttpAnnouncer).Query = `ip={{.IPAddress}}{{if .IPv6}}&ipv6={{.IPv6}}{{end}}`
+			assert.NoError(t, client.AfterPropertiesSet(nil))
+			client.AnnounceCapabilities.AnnouncePerAddressFamily = tt.perFamily
+			u, _ := url.Parse("http://t.org/announce")
+
+			urls, err := client.RenderHttpAnnounceUrls(&announces.AnnounceRequest{Url: *u, Event: tracker.Started}, tt.ipv4, tt.ipv6, 6881)
+
+			assert.NoError(t, err)
+			var queries []string
+			for _, rendered := range urls {
+				queries = append(queries, rendered.RawQuery)
+			}
+			assert.Equal(t, tt.want, queries)
+		})
+	}
+}
+
+func Test_mergeResponses(t *testing.T) {
+	v4Peer := tracker.Peer{IP: net.ParseIP("198.51.100.1"), Port: 1}
+	v6Peer := tracker.Peer{IP: net.ParseIP("2001:db8::3"), Port: 2}
+	merged := mergeResponses([]announcer.AnnounceResponse{
+		{Interval: 1800, Leechers: 3, Seeders: 10, Peers: []tracker.Peer{v4Peer}},
+		{Interval: 900, Leechers: 5, Seeders: 8, Peers: []tracker.Peer{v6Peer}, TrackerId: "abc"},
+	})
+	assert.Equal(t, announcer.AnnounceResponse{Interval: 900, Leechers: 5, Seeders: 10, Peers: []tracker.Peer{v4Peer, v6Peer}, TrackerId: "abc"}, merged)
+}
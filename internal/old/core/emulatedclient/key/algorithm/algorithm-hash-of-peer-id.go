@@ -0,0 +1,29 @@
+package algorithm
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/peerid"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+	"math"
+)
+
+// HashOfPeerIdAlgorithm derives the key from the peer id: the key is the first four bytes of the SHA-1 of the peer id.
+// Since the key follows the peer id, it changes exactly when the peer id does, whatever the key generator says.
+type HashOfPeerIdAlgorithm struct {
+}
+
+// Generate is only used when no peer id is known, it falls back to a random key
+func (a *HashOfPeerIdAlgorithm) Generate() key.Key {
+	return key.Key(randutils.RangeUint32Inclusive(0, math.MaxUint32))
+}
+
+func (a *HashOfPeerIdAlgorithm) GenerateFromPeerId(peerId peerid.PeerId) key.Key {
+	hash := sha1.Sum(peerId[:])
+	return key.Key(binary.BigEndian.Uint32(hash[:4]))
+}
+
+func (a *HashOfPeerIdAlgorithm) AfterPropertiesSet() error {
+	return nil
+}
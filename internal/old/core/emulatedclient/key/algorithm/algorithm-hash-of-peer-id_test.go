@@ -0,0 +1,65 @@
+package algorithm
+
+import (
+	"crypto/rand"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/peerid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestHashOfPeerIdAlgorithm_ShouldUnmarshal(t *testing.T) {
+	yamlString := `---
+type: HASH_OF_PEER_ID
+`
+	algorithm := &KeyAlgorithm{}
+	err := yaml.Unmarshal([]byte(yamlString), algorithm)
+	if err != nil {
+		t.Fatalf("Failed to unmarshall: %+v", err)
+	}
+	_ = algorithm.AfterPropertiesSet()
+	assert.IsType(t, &HashOfPeerIdAlgorithm{}, algorithm.IKeyAlgorithm)
+
+	_, isDerived := AsPeerIdDerived(algorithm)
+	assert.True(t, isDerived)
+}
+
+func TestAsPeerIdDerived_ShouldNotMatchRandomAlgorithms(t *testing.T) {
+	_, isDerived := AsPeerIdDerived(&KeyAlgorithm{IKeyAlgorithm: &NumRangeAsHexAlgorithm{}})
+	assert.False(t, isDerived)
+}
+
+func TestHashOfPeerIdAlgorithm_GenerateFromPeerIdShouldBeStable(t *testing.T) {
+	alg := HashOfPeerIdAlgorithm{}
+	var pid peerid.PeerId
+	copy(pid[:], "-qB4250-abcdefghijkl")
+
+	k := alg.GenerateFromPeerId(pid)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, k, alg.GenerateFromPeerId(pid))
+	}
+
+	var otherPid peerid.PeerId
+	copy(otherPid[:], "-qB4250-abcdefghijkm")
+	assert.NotEqual(t, k, alg.GenerateFromPeerId(otherPid))
+}
+
+func TestHashOfPeerIdAlgorithm_GenerateFromPeerIdShouldBeUniformlyDistributed(t *testing.T) {
+	alg := HashOfPeerIdAlgorithm{}
+
+	const samples = 32000
+	// bucket by most significant hex digit
+	buckets := make(map[uint32]int)
+	for i := 0; i < samples; i++ {
+		var pid peerid.PeerId
+		copy(pid[:], "-qB4250-")
+		_, _ = rand.Read(pid[8:])
+		buckets[uint32(alg.GenerateFromPeerId(pid))>>28]++
+	}
+
+	assert.Len(t, buckets, 16)
+	for v, count := range buckets {
+		// expected is 2000 per bucket
+		assert.InDelta(t, samples/16, count, 400, "leading digit %x is not evenly distributed", v)
+	}
+}
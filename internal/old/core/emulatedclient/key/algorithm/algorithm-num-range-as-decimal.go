@@ -0,0 +1,20 @@
+package algorithm
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+)
+
+// NumRangeAsDecimalAlgorithm generates a number within [Min, Max], meant to be sent as a base 10 number (see the uint32ToDecimalString template function)
+type NumRangeAsDecimalAlgorithm struct {
+	Min uint32 `yaml:"min"`
+	Max uint32 `yaml:"max" validate:"min=1,gtefield=Min"`
+}
+
+func (a *NumRangeAsDecimalAlgorithm) Generate() key.Key {
+	return key.Key(randutils.RangeUint32Inclusive(a.Min, a.Max))
+}
+
+func (a *NumRangeAsDecimalAlgorithm) AfterPropertiesSet() error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package algorithm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestNumRangeAsDecimalAlgorithm_ShouldUnmarshal(t *testing.T) {
+	yamlString := `---
+type: NUM_RANGE_ENCODED_AS_DECIMAL
+min: 1
+max: 350
+`
+	algorithm := &KeyAlgorithm{}
+	err := yaml.Unmarshal([]byte(yamlString), algorithm)
+	if err != nil {
+		t.Fatalf("Failed to unmarshall: %+v", err)
+	}
+	_ = algorithm.AfterPropertiesSet()
+	assert.IsType(t, &NumRangeAsDecimalAlgorithm{}, algorithm.IKeyAlgorithm)
+	assert.Equal(t, uint32(1), algorithm.IKeyAlgorithm.(*NumRangeAsDecimalAlgorithm).Min)
+	assert.Equal(t, uint32(350), algorithm.IKeyAlgorithm.(*NumRangeAsDecimalAlgorithm).Max)
+}
+
+func TestNumRangeAsDecimalAlgorithm_GenerateShouldBeUniformlyDistributed(t *testing.T) {
+	alg := NumRangeAsDecimalAlgorithm{
+		Min: 10,
+		Max: 19,
+	}
+	if err := alg.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+
+	const samples = 20000
+	buckets := make(map[uint32]int)
+	for i := 0; i < samples; i++ {
+		buckets[uint32(alg.Generate())]++
+	}
+
+	assert.Len(t, buckets, 10)
+	for v, count := range buckets {
+		assert.GreaterOrEqual(t, v, uint32(10))
+		assert.LessOrEqual(t, v, uint32(19))
+		// expected is 2000 per bucket
+		assert.InDelta(t, samples/10, count, 400, "value %d is not evenly distributed", v)
+	}
+}
@@ -0,0 +1,28 @@
+package algorithm
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+)
+
+// RandomHexOfFixedLengthAlgorithm generates a number that fits in exactly Length hexadecimal digits (see the uint32ToFixedLengthHexString template function).
+// When NoLeadingZero is true the most significant digit is never 0, this is what uTorrent and BitTorrent are doing.
+type RandomHexOfFixedLengthAlgorithm struct {
+	Length        uint8 `yaml:"length" validate:"min=1,max=8"`
+	NoLeadingZero bool  `yaml:"noLeadingZero"`
+	min           uint32
+	max           uint32
+}
+
+func (a *RandomHexOfFixedLengthAlgorithm) Generate() key.Key {
+	return key.Key(randutils.RangeUint32Inclusive(a.min, a.max))
+}
+
+func (a *RandomHexOfFixedLengthAlgorithm) AfterPropertiesSet() error {
+	a.max = uint32((uint64(1) << (4 * uint64(a.Length))) - 1)
+	a.min = 0
+	if a.NoLeadingZero {
+		a.min = uint32(uint64(1) << (4 * uint64(a.Length-1)))
+	}
+	return nil
+}
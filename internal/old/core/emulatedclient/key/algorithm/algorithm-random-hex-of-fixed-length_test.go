@@ -0,0 +1,112 @@
+package algorithm
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/testutils"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"math"
+	"testing"
+)
+
+func TestRandomHexOfFixedLengthAlgorithm_ShouldUnmarshal(t *testing.T) {
+	yamlString := `---
+type: RANDOM_HEX_OF_FIXED_LENGTH
+length: 8
+noLeadingZero: true
+`
+	algorithm := &KeyAlgorithm{}
+	err := yaml.Unmarshal([]byte(yamlString), algorithm)
+	if err != nil {
+		t.Fatalf("Failed to unmarshall: %+v", err)
+	}
+	_ = algorithm.AfterPropertiesSet()
+	assert.IsType(t, &RandomHexOfFixedLengthAlgorithm{}, algorithm.IKeyAlgorithm)
+	assert.Equal(t, uint8(8), algorithm.IKeyAlgorithm.(*RandomHexOfFixedLengthAlgorithm).Length)
+	assert.True(t, algorithm.IKeyAlgorithm.(*RandomHexOfFixedLengthAlgorithm).NoLeadingZero)
+}
+
+func TestRandomHexOfFixedLengthAlgorithm_ShouldValidate(t *testing.T) {
+	type args struct {
+		Alg RandomHexOfFixedLengthAlgorithm
+	}
+	tests := []struct {
+		name             string
+		args             args
+		wantErr          bool
+		errorDescription testutils.ErrorDescription
+	}{
+		{name: "shouldFailWithLength0", args: args{Alg: RandomHexOfFixedLengthAlgorithm{Length: 0}}, wantErr: true, errorDescription: testutils.ErrorDescription{ErrorFieldPath: "RandomHexOfFixedLengthAlgorithm.Length", ErrorTag: "min"}},
+		{name: "shouldFailWithLengthGreaterThan8", args: args{Alg: RandomHexOfFixedLengthAlgorithm{Length: 9}}, wantErr: true, errorDescription: testutils.ErrorDescription{ErrorFieldPath: "RandomHexOfFixedLengthAlgorithm.Length", ErrorTag: "max"}},
+		{name: "shouldNotFailWithLength8", args: args{Alg: RandomHexOfFixedLengthAlgorithm{Length: 8}}, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.New().Struct(tt.args.Alg)
+			if tt.wantErr == true && err == nil {
+				t.Fatal("validation failed, wantErr=true but err is nil")
+			}
+			if tt.wantErr == false && err != nil {
+				t.Fatalf("validation failed, wantErr=false but err is : %v", err)
+			}
+			if tt.wantErr {
+				testutils.AssertValidateError(t, err.(validator.ValidationErrors), tt.errorDescription)
+			}
+		})
+	}
+}
+
+func TestRandomHexOfFixedLengthAlgorithm_GenerateShouldStayWithinLength(t *testing.T) {
+	for length := uint8(1); length <= 8; length++ {
+		alg := RandomHexOfFixedLengthAlgorithm{Length: length}
+		if err := alg.AfterPropertiesSet(); err != nil {
+			t.Fatal(err)
+		}
+		upperBound := uint64(math.Pow(16, float64(length))) - 1
+		for i := 0; i < 500; i++ {
+			assert.LessOrEqual(t, uint64(alg.Generate()), upperBound)
+		}
+	}
+}
+
+func TestRandomHexOfFixedLengthAlgorithm_GenerateShouldBeUniformlyDistributed(t *testing.T) {
+	alg := RandomHexOfFixedLengthAlgorithm{Length: 1}
+	if err := alg.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+
+	const samples = 32000
+	buckets := make(map[uint32]int)
+	for i := 0; i < samples; i++ {
+		buckets[uint32(alg.Generate())]++
+	}
+
+	assert.Len(t, buckets, 16)
+	for v, count := range buckets {
+		// expected is 2000 per bucket
+		assert.InDelta(t, samples/16, count, 400, "value %x is not evenly distributed", v)
+	}
+}
+
+func TestRandomHexOfFixedLengthAlgorithm_GenerateShouldNotHaveLeadingZero(t *testing.T) {
+	alg := RandomHexOfFixedLengthAlgorithm{Length: 2, NoLeadingZero: true}
+	if err := alg.AfterPropertiesSet(); err != nil {
+		t.Fatal(err)
+	}
+
+	const samples = 24000
+	// bucket by most significant digit
+	buckets := make(map[uint32]int)
+	for i := 0; i < samples; i++ {
+		v := uint32(alg.Generate())
+		assert.LessOrEqual(t, v, uint32(0xff))
+		buckets[v>>4]++
+	}
+
+	assert.Len(t, buckets, 15)
+	assert.Zero(t, buckets[0])
+	for v, count := range buckets {
+		// expected is 1600 per bucket
+		assert.InDelta(t, samples/15, count, 350, "leading digit %x is not evenly distributed", v)
+	}
+}
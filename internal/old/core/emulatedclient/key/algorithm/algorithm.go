@@ -3,11 +3,21 @@ package algorithm
 import (
 	"fmt"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/peerid"
 	"gopkg.in/yaml.v3"
 )
 
+// Real clients do not agree on the key format:
+//   - libtorrent based clients (qBittorrent, Deluge) send a random uint32 as 8 hexadecimal digits: NUM_RANGE_ENCODED_AS_HEXADECIMAL or RANDOM_HEX_OF_FIXED_LENGTH
+//   - uTorrent and BitTorrent send 8 hexadecimal digits without leading zero: RANDOM_HEX_OF_FIXED_LENGTH with noLeadingZero
+//   - Transmission sends a positive int32 as hexadecimal without leading zeroes: NUM_RANGE_ENCODED_AS_HEXADECIMAL
+//   - some clients send a base 10 number: NUM_RANGE_ENCODED_AS_DECIMAL
+//   - some clients derive the key from their peer id: HASH_OF_PEER_ID
 var algorithmImplementations = map[string]func() IKeyAlgorithm{
 	"NUM_RANGE_ENCODED_AS_HEXADECIMAL": func() IKeyAlgorithm { return &NumRangeAsHexAlgorithm{} },
+	"NUM_RANGE_ENCODED_AS_DECIMAL":     func() IKeyAlgorithm { return &NumRangeAsDecimalAlgorithm{} },
+	"RANDOM_HEX_OF_FIXED_LENGTH":       func() IKeyAlgorithm { return &RandomHexOfFixedLengthAlgorithm{} },
+	"HASH_OF_PEER_ID":                  func() IKeyAlgorithm { return &HashOfPeerIdAlgorithm{} },
 }
 
 type IKeyAlgorithm interface {
@@ -15,6 +25,12 @@ type IKeyAlgorithm interface {
 	AfterPropertiesSet() error
 }
 
+// IPeerIdDerivedKeyAlgorithm is implemented by the algorithms that compute the key out of the peer id instead of generating a random one
+type IPeerIdDerivedKeyAlgorithm interface {
+	IKeyAlgorithm
+	GenerateFromPeerId(peerId peerid.PeerId) key.Key
+}
+
 type KeyAlgorithm struct {
 	IKeyAlgorithm `yaml:",inline" validate:"required"`
 }
@@ -48,3 +64,12 @@ func (a *KeyAlgorithm) UnmarshalYAML(value *yaml.Node) error {
 	a.IKeyAlgorithm = algorithm
 	return nil
 }
+
+// AsPeerIdDerived returns the underlying algorithm if it derives the key from the peer id
+func AsPeerIdDerived(alg IKeyAlgorithm) (IPeerIdDerivedKeyAlgorithm, bool) {
+	if wrapper, ok := alg.(*KeyAlgorithm); ok {
+		alg = wrapper.IKeyAlgorithm
+	}
+	derived, ok := alg.(IPeerIdDerivedKeyAlgorithm)
+	return derived, ok
+}
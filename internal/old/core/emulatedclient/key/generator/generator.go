@@ -6,6 +6,7 @@ import (
 	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key/algorithm"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/peerid"
 	"gopkg.in/yaml.v3"
 	"time"
 )
@@ -63,7 +64,12 @@ func (a *KeyGenerator) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
-func (a *KeyGenerator) Get(infoHash torrent.InfoHash, event tracker.AnnounceEvent) key.Key {
+// Get returns the key to use for this announce. peerId must be the peer id sent along with the key.
+func (a *KeyGenerator) Get(infoHash torrent.InfoHash, peerId peerid.PeerId, event tracker.AnnounceEvent) key.Key {
+	if derived, ok := algorithm.AsPeerIdDerived(a.Algorithm); ok {
+		// the key follows the peer id refresh policy, the generator one does not apply
+		return derived.GenerateFromPeerId(peerId)
+	}
 	return a.IKeyGenerator.get(a.Algorithm, infoHash, event)
 }
 
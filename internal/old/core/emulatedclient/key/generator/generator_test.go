@@ -5,6 +5,7 @@ import (
 	"github.com/anacrolix/torrent/tracker"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/key/algorithm"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/peerid"
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/testutils"
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
@@ -31,6 +32,30 @@ type: TORRENT_PERSISTENT_REFRESH
 	assert.NotNil(t, generator.Algorithm)
 }
 
+func TestKeyGenerator_ShouldDeriveKeyFromPeerIdWhenAlgorithmIsPeerIdDerived(t *testing.T) {
+	yamlString := `---
+algorithm:
+  type: HASH_OF_PEER_ID
+type: NEVER_REFRESH
+`
+	generator := &KeyGenerator{}
+	err := yaml.Unmarshal([]byte(yamlString), generator)
+	if err != nil {
+		t.Fatalf("Failed to unmarshall: %+v", err)
+	}
+	_ = generator.AfterPropertiesSet()
+
+	var pidA, pidB peerid.PeerId
+	copy(pidA[:], "-qB4250-aaaaaaaaaaaa")
+	copy(pidB[:], "-qB4250-bbbbbbbbbbbb")
+	infoHash := torrent.InfoHash{}
+
+	keyA := generator.Get(infoHash, pidA, tracker.None)
+	assert.Equal(t, keyA, generator.Get(infoHash, pidA, tracker.None))
+	// NEVER_REFRESH would have kept the first key, but the key must follow the peer id
+	assert.NotEqual(t, keyA, generator.Get(infoHash, pidB, tracker.None))
+}
+
 type validAbleKeyGenerator struct {
 	Field string `validate:"required"`
 }
@@ -4,19 +4,28 @@ import (
 	"context"
 	"fmt"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/portmapping"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/publicip"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
-	"io/ioutil"
 	"net"
-	"net/http"
-	"net/url"
-	"strings"
-	"time"
 )
 
+// acceptedConnections counts the peer connections accepted by the listeners since the process started
+var acceptedConnections = atomic.NewUint64(0)
+
+// AcceptedConnections returns the number of peer connections accepted by the listeners since the process started
+func AcceptedConnections() uint64 {
+	return acceptedConnections.Load()
+}
+
 type Listener struct {
-	Port          Port    `yaml:"port" validate:"required"`
-	listeningPort *uint16 `yaml:"-"`
-	ip            *net.IP `yaml:"-"`
+	Port          Port                    `yaml:"port" validate:"required"`
+	listeningPort *uint16                 `yaml:"-"`
+	publicIps     *publicip.Monitor       `yaml:"-"`
+	portMapper    *portmapping.PortMapper `yaml:"-"`
+	socket        net.Listener            `yaml:"-"`
 }
 type Port struct {
 	Min uint16 `yaml:"min" validate:"min=1"`
@@ -27,94 +36,96 @@ func (l *Listener) AfterPropertiesSet() error {
 	return nil
 }
 
-// Blocking call until the listener is ready and public ip is retrieved.
-func (l *Listener) Start(proxyFunc func(*http.Request) (*url.URL, error)) error {
+// Blocking call until the listener is ready. publicIps must be started already, portMapper may be nil.
+func (l *Listener) Start(publicIps *publicip.Monitor, portMapper *portmapping.PortMapper) error {
 	log := logs.GetLogger()
-	ip, err := getPublicIp(proxyFunc)
+	if publicIps == nil || publicIps.Addresses().IsEmpty() {
+		return fmt.Errorf("listener can not start without a public ip")
+	}
+	socket, err := bindInRange(l.Port.Min, l.Port.Max)
 	if err != nil {
 		return err
 	}
-	l.ip = &ip
-	// TODO: Start listening on port for peers requests and answer
-	mockedPort := uint16(9000)
-	l.listeningPort = &mockedPort
+	l.socket = socket
+	go func() {
+		for {
+			conn, err := socket.Accept()
+			if err != nil {
+				return // socket closed
+			}
+			acceptedConnections.Inc()
+			// TODO: answer peers requests
+			_ = conn.Close()
+		}
+	}()
+	port := uint16(socket.Addr().(*net.TCPAddr).Port)
+	l.listeningPort = &port
+	l.publicIps = publicIps
 
-	log.Info("peer listener: started", zap.String("public-ip", l.ip.String()), zap.Uint16("port", *l.listeningPort))
+	if portMapper != nil {
+		portMapper.Start(port)
+		l.portMapper = portMapper
+	}
+
+	ipv4, ipv6 := l.IPs()
+	log.Info("peer listener: started", zap.Stringer("public-ipv4", ipv4), zap.Stringer("public-ipv6", ipv6), zap.Uint16("port", *l.listeningPort), zap.Uint16("announced-port", l.announcedPort()))
 	return nil
 }
 
-func (l *Listener) Stop(context.Context) {
+func (l *Listener) Stop(ctx context.Context) {
 	log := logs.GetLogger()
 
 	log.Info("peer listener: stopping")
-	// TODO: implement
+	if l.portMapper != nil {
+		l.portMapper.Stop(ctx)
+		l.portMapper = nil
+	}
+	if l.socket != nil {
+		_ = l.socket.Close()
+		l.socket = nil
+	}
+	l.listeningPort = nil
 	log.Info("peer listener: stopped")
 }
 
-var publicIpProviders = []string{
-	"https://api.ipify.org",
-	"http://myexternalip.com/raw",
-	"http://ipinfo.io/ip",
-	"http://ipecho.net/plain",
-	"http://icanhazip.com",
-	"http://ifconfig.me/ip",
-	"http://ident.me",
-	"http://checkip.amazonaws.com",
-	"http://whatismyip.akamai.com",
-	"http://wgetip.com",
-	"http://ip.appspot.com",
-	"http://ip.tyk.nu",
-}
-
-// may be an ipv4 or ipv6
-func getPublicIp(proxyFunc func(*http.Request) (*url.URL, error)) (net.IP, error) {
-	for _, providerUri := range publicIpProviders {
-		client := &http.Client{
-			Transport: &http.Transport{
-				Proxy: proxyFunc,
-				DialContext: (&net.Dialer{
-					Timeout: 10 * time.Second,
-				}).DialContext,
-				TLSHandshakeTimeout:   10 * time.Second,
-				DisableKeepAlives:     true, // no need to jeep connection opened
-				IdleConnTimeout:       10 * time.Second,
-				ResponseHeaderTimeout: 10 * time.Second,
-			},
-			Timeout: 10 * time.Second,
-		}
-		req, err := http.NewRequest("GET", providerUri, nil)
-		if err != nil {
-			// TODO: log error
-			fmt.Println(err)
-			continue
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			// TODO: log error
-			fmt.Println(err)
-			continue
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			_ = resp.Body.Close()
-			// TODO: log error
-			continue
+// bindInRange listens on the first available port of the range, starting from a random one
+func bindInRange(min uint16, max uint16) (net.Listener, error) {
+	size := int64(max) - int64(min) + 1
+	offset := randutils.RangeInclusive(0, size-1)
+	var lastErr error
+	for i := int64(0); i < size; i++ {
+		port := int64(min) + (offset+i)%size
+		socket, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			return socket, nil
 		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("no port available between %d and %d: %w", min, max, lastErr)
+}
 
-		if resp.StatusCode != 200 {
-			//TODO: log error
-			continue
+// announcedPort returns the port peers can reach the listener on: the external port if it is mapped on the router, the listening port otherwise
+func (l *Listener) announcedPort() uint16 {
+	if l.portMapper != nil {
+		if port, mapped := l.portMapper.ExternalPort(); mapped {
+			return port
 		}
+	}
+	return *l.listeningPort
+}
 
-		tb := strings.TrimSpace(string(body))
-		ip := net.ParseIP(tb)
-		if ip == nil {
-			// TODO: log error
-			continue
-		}
-		return ip, nil
+// IPs returns the current public ips of the listener, either one may be nil if the host has no public address for this family
+func (l *Listener) IPs() (ipv4 net.IP, ipv6 net.IP) {
+	if l.publicIps == nil {
+		return nil, nil
 	}
+	addresses := l.publicIps.Addresses()
+	return addresses.IPv4, addresses.IPv6
+}
 
-	return nil, fmt.Errorf("failed to get public IP address")
+// reportExternalIp forwards the ip a tracker has seen the announce coming from
+func (l *Listener) reportExternalIp(ip net.IP) {
+	if l.publicIps != nil && ip != nil {
+		l.publicIps.ReportExternalIp(ip)
+	}
 }
@@ -1,14 +1,17 @@
 package emulatedclient
 
 import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/portmapping"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/publicip"
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/testutils"
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 	"net"
-	"net/http"
-	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestListener_Unmarshall(t *testing.T) {
@@ -62,39 +65,71 @@ func TestListener_Start(t *testing.T) {
 		Port: Port{Min: 8000, Max: 8100},
 	}
 
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte("1.1.1.1"))
-	}))
-	defer s.Close()
-
-	publicIpProviders = []string{s.URL}
+	resolver, _ := publicip.NewStaticResolver("1.1.1.1", "2001:db8::1")
+	monitor := publicip.NewMonitor(resolver, 0, nil)
+	if err := monitor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
 
-	err := listener.Start(nil)
+	err := listener.Start(monitor, nil)
 	if err != nil {
-		t.Fatalf("failed to get public ip: %v", err)
+		t.Fatalf("failed to start listener: %v", err)
+	}
+	defer listener.Stop(context.Background())
+	ipv4, ipv6 := listener.IPs()
+	assert.Equal(t, net.ParseIP("1.1.1.1").String(), ipv4.String())
+	assert.Equal(t, net.ParseIP("2001:db8::1").String(), ipv6.String())
+}
+
+func TestListener_StartShouldFailWithoutPublicIp(t *testing.T) {
+	listener := Listener{
+		Port: Port{Min: 8000, Max: 8100},
 	}
-	assert.Equal(t, net.ParseIP("1.1.1.1").String(), listener.ip.String())
+
+	assert.Error(t, listener.Start(nil, nil))
 }
 
-func TestListener_getPublicIpShouldFallbackThroughUrl(t *testing.T) {
+func TestListener_StartShouldBindAPortInRange(t *testing.T) {
 	listener := Listener{
 		Port: Port{Min: 8000, Max: 8100},
 	}
 
-	failingServ := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "server error", 500)
-	}))
-	defer failingServ.Close()
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte("1.1.1.1"))
-	}))
-	defer s.Close()
+	resolver, _ := publicip.NewStaticResolver("1.1.1.1", "")
+	monitor := publicip.NewMonitor(resolver, 0, nil)
+	if err := monitor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := listener.Start(monitor, portmapping.NewPortMapper(nil, time.Hour)); err != nil {
+		t.Fatalf("failed to start listener: %v", err)
+	}
+
+	port := *listener.listeningPort
+	assert.GreaterOrEqual(t, port, uint16(8000))
+	assert.LessOrEqual(t, port, uint16(8100))
+	// without port mapping, the listening port is announced
+	assert.Equal(t, port, listener.announcedPort())
+
+	accepted := AcceptedConnections()
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
+	if err != nil {
+		t.Fatalf("listener is not listening: %v", err)
+	}
+	_ = conn.Close()
+	assert.Eventually(t, func() bool { return AcceptedConnections() == accepted+1 }, 2*time.Second, 10*time.Millisecond)
 
-	publicIpProviders = []string{failingServ.URL, s.URL}
+	listener.Stop(context.Background())
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
+	assert.Error(t, err)
+}
 
-	err := listener.Start(nil)
+func Test_bindInRangeShouldSkipPortsInUse(t *testing.T) {
+	used, err := net.Listen("tcp", ":0")
 	if err != nil {
-		t.Fatalf("failed to get public ip: %v", err)
+		t.Fatal(err)
 	}
-	assert.Equal(t, net.ParseIP("1.1.1.1").String(), listener.ip.String())
+	defer func() { _ = used.Close() }()
+	port := uint16(used.Addr().(*net.TCPAddr).Port)
+
+	_, err = bindInRange(port, port)
+	assert.Error(t, err)
 }
@@ -1,6 +1,7 @@
 package urlencoder
 
 import (
+	"fmt"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient/casing"
 	"net/url"
 	"strings"
@@ -26,3 +27,24 @@ func (u *UrlEncoder) Encode(str string) string {
 	}
 	return sb.String()
 }
+
+// EncodeKeeping percent-encodes str byte by byte. ASCII letters, digits and the bytes listed in safeChars are left untouched, every other byte is encoded.
+// Some clients do not follow RFC 3986 and leave characters such as '*' or '!' unencoded, safeChars allows to mimic them.
+func (u *UrlEncoder) EncodeKeeping(str string, safeChars string) string {
+	sb := &strings.Builder{}
+	sb.Grow(len(str) * 3)
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if isAlphaNumeric(c) || strings.IndexByte(safeChars, c) != -1 {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteString(u.EncodedHexCase.ApplyCase(fmt.Sprintf("%02x", c)))
+	}
+	return sb.String()
+}
+
+func isAlphaNumeric(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
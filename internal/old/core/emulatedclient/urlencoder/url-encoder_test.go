@@ -60,6 +60,35 @@ func TestUrlEncoder_Encode(t *testing.T) {
 	}
 }
 
+func TestUrlEncoder_EncodeKeeping(t *testing.T) {
+	type args struct {
+		str       string
+		safeChars string
+	}
+	tests := []struct {
+		name    string
+		hexCase casing.Case
+		args    args
+		want    string
+	}{
+		{name: "ShouldEncodeEverythingButAlphaNumericWithoutSafeChars", hexCase: casing.Lower, args: args{str: "a-b_c.d~e", safeChars: ""}, want: "a%2db%5fc%2ed%7ee"},
+		{name: "ShouldKeepSafeChars", hexCase: casing.Lower, args: args{str: "a-b_c.d~e*!", safeChars: "-_.~*"}, want: "a-b_c.d~e*%21"},
+		{name: "ShouldEncodeEachByteOfMultiBytesChars", hexCase: casing.Upper, args: args{str: "pü", safeChars: ""}, want: "p%C3%BC"},
+		{name: "ShouldEncodeNullBytes", hexCase: casing.Upper, args: args{str: "a\x00a", safeChars: ""}, want: "a%00a"},
+		{name: "ShouldApplyHexCase", hexCase: casing.Upper, args: args{str: "|", safeChars: ""}, want: "%7C"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &UrlEncoder{
+				EncodedHexCase: tt.hexCase,
+			}
+			if got := u.EncodeKeeping(tt.args.str, tt.args.safeChars); got != tt.want {
+				t.Errorf("EncodeKeeping() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func createStrFrom00toFF() string {
 	sb := strings.Builder{}
 	sb.Grow(255)
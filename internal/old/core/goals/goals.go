@@ -0,0 +1,129 @@
+package goals
+
+import (
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/contribution"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	PauseAction   = "pause"
+	ArchiveAction = "archive"
+)
+
+const (
+	RatioReason       = "ratio"
+	SeedingTimeReason = "seedingTime"
+	DailyUploadReason = "dailyUpload"
+)
+
+// Validate checks the goals config, the directories are expected to be relative to the torrents directory. The archived
+// torrents directory and its sub directories are refused: the torrents moved there are not meant to be seeded again.
+func Validate(conf *core.GoalsConfig, torrentsDir string, archivedTorrentsDir string) error {
+	if conf == nil {
+		return nil
+	}
+	if err := ValidateGoal(conf.Global); err != nil {
+		return fmt.Errorf("invalid global goal: %w", err)
+	}
+	archived, err := filepath.Rel(torrentsDir, archivedTorrentsDir)
+	if err != nil {
+		return fmt.Errorf("failed to locate the archived torrents directory: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, d := range conf.Directories {
+		if d == nil {
+			continue
+		}
+		dir := filepath.Clean(d.Path)
+		if d.Path == "" || dir == "." || filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("goal directory '%s' must be a sub directory of the torrents directory", d.Path)
+		}
+		if isWithin(dir, archived) {
+			return fmt.Errorf("goal directory '%s' can not be the archived torrents directory or one of its sub directories", d.Path)
+		}
+		if seen[dir] {
+			return fmt.Errorf("goal directory '%s' is declared more than once", d.Path)
+		}
+		seen[dir] = true
+		if err := ValidateGoal(&d.Goal); err != nil {
+			return fmt.Errorf("invalid goal for directory '%s': %w", d.Path, err)
+		}
+	}
+	return nil
+}
+
+// ValidateGoal checks the values and the action of a goal
+func ValidateGoal(goal *core.GoalConfig) error {
+	if goal == nil {
+		return nil
+	}
+	if goal.Ratio < 0 || goal.SeedingTime < 0 || goal.DailyUpload < 0 {
+		return fmt.Errorf("goal values can not be negative")
+	}
+	switch goal.Action {
+	case "", PauseAction, ArchiveAction:
+		return nil
+	default:
+		return fmt.Errorf("unknown goal action '%s'", goal.Action)
+	}
+}
+
+// Resolve returns the goal applying to a torrent: the override set through the API, then the goal of the deepest
+// directory holding the torrent file, then the global goal. Returns nil if no goal applies.
+func Resolve(conf *core.GoalsConfig, torrentsDir string, torrentPath string, override *core.GoalConfig) *core.GoalConfig {
+	if override != nil {
+		return override
+	}
+	if conf == nil {
+		return nil
+	}
+	if rel, err := filepath.Rel(torrentsDir, filepath.Dir(torrentPath)); err == nil {
+		var best *core.DirectoryGoalConfig
+		for _, d := range conf.Directories {
+			if d == nil || !isWithin(rel, filepath.Clean(d.Path)) {
+				continue
+			}
+			if best == nil || len(filepath.Clean(d.Path)) > len(filepath.Clean(best.Path)) {
+				best = d
+			}
+		}
+		if best != nil {
+			return &best.Goal
+		}
+	}
+	return conf.Global
+}
+
+func isWithin(dir string, parent string) bool {
+	return dir == parent || strings.HasPrefix(dir, parent+string(filepath.Separator))
+}
+
+// Reached returns the reason why the torrent has reached its goal, an empty string if it has not
+func Reached(goal *core.GoalConfig, size int64, counters contribution.Counters, now time.Time) string {
+	if goal == nil {
+		return ""
+	}
+	if goal.Ratio > 0 && size > 0 && float64(counters.Uploaded) >= goal.Ratio*float64(size) {
+		return RatioReason
+	}
+	if goal.SeedingTime > 0 && counters.SeedingTime >= goal.SeedingTime {
+		return SeedingTimeReason
+	}
+	if goal.DailyUpload > 0 && counters.UploadedOn(now) >= goal.DailyUpload {
+		return DailyUploadReason
+	}
+	return ""
+}
+
+// ActionFor returns the action to apply once the goal has been reached for the given reason. A daily upload goal
+// always pauses the torrent, it will be resumed on the next day.
+func ActionFor(goal *core.GoalConfig, reason string) string {
+	if reason == DailyUploadReason || goal == nil || goal.Action == "" {
+		return PauseAction
+	}
+	return goal.Action
+}
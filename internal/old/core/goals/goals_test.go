@@ -0,0 +1,110 @@
+package goals
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/contribution"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    *core.GoalsConfig
+		wantErr bool
+	}{
+		{name: "nil", conf: nil},
+		{name: "default", conf: core.GoalsConfig{}.Default()},
+		{name: "valid", conf: &core.GoalsConfig{
+			Global:      &core.GoalConfig{Ratio: 2, Action: PauseAction},
+			Directories: []*core.DirectoryGoalConfig{{Path: "movies", Goal: core.GoalConfig{SeedingTime: time.Hour, Action: ArchiveAction}}},
+		}},
+		{name: "negativeValue", conf: &core.GoalsConfig{Global: &core.GoalConfig{Ratio: -1}}, wantErr: true},
+		{name: "unknownAction", conf: &core.GoalsConfig{Global: &core.GoalConfig{Action: "delete"}}, wantErr: true},
+		{name: "emptyPath", conf: &core.GoalsConfig{Directories: []*core.DirectoryGoalConfig{{Path: ""}}}, wantErr: true},
+		{name: "outsideTorrentsDir", conf: &core.GoalsConfig{Directories: []*core.DirectoryGoalConfig{{Path: "../movies"}}}, wantErr: true},
+		{name: "absolutePath", conf: &core.GoalsConfig{Directories: []*core.DirectoryGoalConfig{{Path: "/movies"}}}, wantErr: true},
+		{name: "duplicatedPath", conf: &core.GoalsConfig{Directories: []*core.DirectoryGoalConfig{{Path: "movies"}, {Path: "movies/"}}}, wantErr: true},
+		{name: "archivedDir", conf: &core.GoalsConfig{Directories: []*core.DirectoryGoalConfig{{Path: "archived/"}}}, wantErr: true},
+		{name: "insideArchivedDir", conf: &core.GoalsConfig{Directories: []*core.DirectoryGoalConfig{{Path: "archived/movies"}}}, wantErr: true},
+		{name: "archivedDirPrefix", conf: &core.GoalsConfig{Directories: []*core.DirectoryGoalConfig{{Path: "archived-movies"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrentsDir := filepath.Join("joal", "torrents")
+			err := Validate(tt.conf, torrentsDir, filepath.Join(torrentsDir, "archived"))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	torrentsDir := filepath.Join("joal", "torrents")
+	global := &core.GoalConfig{Ratio: 1}
+	conf := &core.GoalsConfig{
+		Global: global,
+		Directories: []*core.DirectoryGoalConfig{
+			{Path: "movies", Goal: core.GoalConfig{Ratio: 2}},
+			{Path: filepath.Join("movies", "hd"), Goal: core.GoalConfig{Ratio: 3}},
+		},
+	}
+	override := &core.GoalConfig{Ratio: 10}
+
+	tests := []struct {
+		name        string
+		torrentPath string
+		override    *core.GoalConfig
+		want        *core.GoalConfig
+	}{
+		{name: "root", torrentPath: filepath.Join(torrentsDir, "a.torrent"), want: global},
+		{name: "directory", torrentPath: filepath.Join(torrentsDir, "movies", "a.torrent"), want: &core.GoalConfig{Ratio: 2}},
+		{name: "deepestDirectoryWins", torrentPath: filepath.Join(torrentsDir, "movies", "hd", "a.torrent"), want: &core.GoalConfig{Ratio: 3}},
+		{name: "subDirectoryInheritsFromParent", torrentPath: filepath.Join(torrentsDir, "movies", "sd", "a.torrent"), want: &core.GoalConfig{Ratio: 2}},
+		{name: "samePrefixIsNotASubDirectory", torrentPath: filepath.Join(torrentsDir, "moviesOld", "a.torrent"), want: global},
+		{name: "overrideWins", torrentPath: filepath.Join(torrentsDir, "movies", "a.torrent"), override: override, want: override},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Resolve(conf, torrentsDir, tt.torrentPath, tt.override))
+		})
+	}
+
+	assert.Nil(t, Resolve(nil, torrentsDir, filepath.Join(torrentsDir, "a.torrent"), nil))
+}
+
+func TestReached(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+	today := now.Format("2006-01-02")
+	tests := []struct {
+		name     string
+		goal     *core.GoalConfig
+		counters contribution.Counters
+		want     string
+	}{
+		{name: "noGoal", goal: nil, counters: contribution.Counters{Uploaded: 1 << 40}, want: ""},
+		{name: "disabledGoal", goal: &core.GoalConfig{}, counters: contribution.Counters{Uploaded: 1 << 40}, want: ""},
+		{name: "ratioNotReached", goal: &core.GoalConfig{Ratio: 2}, counters: contribution.Counters{Uploaded: 1999}, want: ""},
+		{name: "ratioReached", goal: &core.GoalConfig{Ratio: 2}, counters: contribution.Counters{Uploaded: 2000}, want: RatioReason},
+		{name: "seedingTimeReached", goal: &core.GoalConfig{SeedingTime: time.Hour}, counters: contribution.Counters{SeedingTime: time.Hour}, want: SeedingTimeReason},
+		{name: "dailyUploadReached", goal: &core.GoalConfig{DailyUpload: 500}, counters: contribution.Counters{Day: today, DayUploaded: 500}, want: DailyUploadReason},
+		{name: "dailyUploadOfAnotherDay", goal: &core.GoalConfig{DailyUpload: 500}, counters: contribution.Counters{Day: "2021-02-28", DayUploaded: 500}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Reached(tt.goal, 1000, tt.counters, now))
+		})
+	}
+}
+
+func TestActionFor(t *testing.T) {
+	assert.Equal(t, PauseAction, ActionFor(nil, RatioReason))
+	assert.Equal(t, PauseAction, ActionFor(&core.GoalConfig{}, RatioReason))
+	assert.Equal(t, ArchiveAction, ActionFor(&core.GoalConfig{Action: ArchiveAction}, SeedingTimeReason))
+	assert.Equal(t, PauseAction, ActionFor(&core.GoalConfig{Action: ArchiveAction}, DailyUploadReason))
+}
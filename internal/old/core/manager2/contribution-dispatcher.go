@@ -0,0 +1,28 @@
+package manager2
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core/bandwidth"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/contribution"
+)
+
+// contributionDispatcher registers the torrents along with their contribution persisted over all their seeding
+// sessions, the cumulative upload cap of the dispatcher then survives the restarts of the torrents and of joal. The
+// bytes uploaded are the accounted ones, the last accounting interval is not part of them.
+type contributionDispatcher struct {
+	bandwidth.SpeedDispatcher
+	contributions *contribution.Store
+}
+
+func (d *contributionDispatcher) Register(rt *bandwidth.RegisteredTorrent) (unregisterTorrent func()) {
+	registered := *rt
+	registered.GetPeers = func() *bandwidth.Peers {
+		peers := rt.GetPeers()
+		d.contributions.SeeLeechers(rt.InfoHash, peers.Leechers)
+		return peers
+	}
+	registered.GetContribution = func() (int64, int32) {
+		counters := d.contributions.Get(rt.InfoHash)
+		return counters.Uploaded, counters.LeechersSeen
+	}
+	return d.SpeedDispatcher.Register(&registered)
+}
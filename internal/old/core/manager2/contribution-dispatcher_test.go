@@ -0,0 +1,66 @@
+package manager2
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/bandwidth"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/contribution"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// registeringDispatcher keeps the last torrent registered
+type registeringDispatcher struct {
+	bandwidth.SpeedDispatcher
+	registered *bandwidth.RegisteredTorrent
+}
+
+func (d *registeringDispatcher) Register(rt *bandwidth.RegisteredTorrent) (unregisterTorrent func()) {
+	d.registered = rt
+	return func() {}
+}
+
+func registerWithLeechers(dispatcher *contributionDispatcher, infoHash torrent.InfoHash, leechers int32) *bandwidth.RegisteredTorrent {
+	dispatcher.Register(&bandwidth.RegisteredTorrent{
+		InfoHash: infoHash,
+		GetPeers: func() *bandwidth.Peers { return &bandwidth.Peers{Leechers: leechers, Seeders: 10} },
+		GetContribution: func() (int64, int32) {
+			return 0, leechers // the current session only, must be superseded
+		},
+		SetSpeed: func(bps int64) {},
+	})
+	return dispatcher.SpeedDispatcher.(*registeringDispatcher).registered
+}
+
+func TestContributionDispatcher_ShouldKeepTheContributionAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contributions.yml")
+	infoHash := torrent.InfoHash{1}
+	store, err := contribution.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := &contributionDispatcher{SpeedDispatcher: &registeringDispatcher{}, contributions: store}
+	registered := registerWithLeechers(dispatcher, infoHash, 5)
+	assert.Equal(t, &bandwidth.Peers{Leechers: 5, Seeders: 10}, registered.GetPeers())
+	store.Add(infoHash, 10000, time.Minute, time.Now())
+	uploaded, leechersSeen := registered.GetContribution()
+	assert.Equal(t, int64(10000), uploaded)
+	assert.Equal(t, int32(5), leechersSeen)
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// joal restarts, the torrent registers again and sees less leechers
+	store, err = contribution.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher = &contributionDispatcher{SpeedDispatcher: &registeringDispatcher{}, contributions: store}
+	registered = registerWithLeechers(dispatcher, infoHash, 1)
+	registered.GetPeers()
+	uploaded, leechersSeen = registered.GetContribution()
+	assert.Equal(t, int64(10000), uploaded)
+	assert.Equal(t, int32(5), leechersSeen)
+}
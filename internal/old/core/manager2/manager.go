@@ -3,31 +3,56 @@ package manager2
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/accounting"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/announces"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/bandwidth"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/contribution"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/goals"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/portmapping"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/publicip"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/routing"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/torrent2"
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/stop"
 	"github.com/anthonyraymond/watcher"
 	"go.uber.org/zap"
-	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 )
 
-var NoOpProxy = http.ProxyFromEnvironment
+var (
+	ErrTorrentNotFound = errors.New("torrent not found in seeding list")
+	ErrNotSeeding      = errors.New("manager is not seeding")
+	ErrTorrentPaused   = errors.New("torrent is paused")
+	ErrGoalReached     = errors.New("torrent has reached its goal")
+)
+
+// PausedByUser is the reason of the TorrentPausedEvent emitted when a torrent is paused with PauseTorrent
+const PausedByUser = "user"
 
 type Manager interface {
 	StartSeeding()
 	StopSeeding(ctx context.Context)
 	SaveTorrentFile(filename string, bytes []byte)
-	ArchiveTorrent(hash torrent.InfoHash)
+	// ArchiveTorrent moves the torrent file to the archive directory, the torrent is removed once the watcher notices it
+	ArchiveTorrent(hash torrent.InfoHash) error
+	// SetTorrentGoal sets the goal of a torrent, it wins over the goals of the config. A nil goal removes it.
+	SetTorrentGoal(hash torrent.InfoHash, goal *core.GoalConfig)
+	// PauseTorrent stops seeding a torrent until ResumeTorrent is called, the pause does not survive a restart of JOAL
+	PauseTorrent(hash torrent.InfoHash) error
+	// ResumeTorrent seeds back a torrent paused by PauseTorrent, a torrent which has reached its goal can not be resumed
+	ResumeTorrent(hash torrent.InfoHash) error
+	// AnnounceTorrent makes a seeded torrent announce right away
+	AnnounceTorrent(hash torrent.InfoHash) error
 	// Quit destroy the Manager in a non-recoverable way. To be called before exiting the program.
 	Quit()
 }
@@ -36,21 +61,34 @@ type managerImpl struct {
 	isSeeding       bool
 	commands        chan func()
 	configLoader    *core.CoreConfigLoader
+	dialer          *proxy.Dialer
 	loadedConfig    *core.JoalConfig
 	announceQueue   *torrent2.AnnounceQueue
 	speedDispatcher bandwidth.SpeedDispatcher
 	client          emulatedclient.IEmulatedClient
+	publicIps       *publicip.Monitor
 	torrents        map[torrent.InfoHash]torrent2.Torrent
-	quit            stop.Chan
+	contributions   *contribution.Store
+	// paused holds the torrents stopped after reaching their goal, with the reason
+	paused map[torrent.InfoHash]string
+	// pausedByUser holds the torrents paused with PauseTorrent, the goals are not evaluated until they are resumed
+	pausedByUser map[torrent.InfoHash]bool
+	// lastAccounting is the last time the uploaded bytes and the seeding time have been counted
+	lastAccounting time.Time
+	quit           stop.Chan
 }
 
-func Run(configLoader *core.CoreConfigLoader) (Manager, error) {
+// Run starts the Manager, the announces and the public ip discovery go through the dialer (nil connects directly)
+func Run(configLoader *core.CoreConfigLoader, dialer *proxy.Dialer) (Manager, error) {
 	log := logs.GetLogger()
 	m := &managerImpl{
 		isSeeding:    false,
 		commands:     make(chan func(), 50),
 		configLoader: configLoader,
+		dialer:       dialer,
 		torrents:     make(map[torrent.InfoHash]torrent2.Torrent),
+		paused:       make(map[torrent.InfoHash]string),
+		pausedByUser: make(map[torrent.InfoHash]bool),
 		quit:         stop.NewChan(),
 	}
 
@@ -59,11 +97,29 @@ func Run(configLoader *core.CoreConfigLoader) (Manager, error) {
 		return nil, fmt.Errorf("failed to start Manager: %w", err)
 	}
 
-	m.speedDispatcher = bandwidth.NewSpeedDispatcher(m.loadedConfig.RuntimeConfig.BandwidthConfig.Speed)
+	m.speedDispatcher, err = bandwidth.NewSpeedDispatcher(m.loadedConfig.RuntimeConfig.BandwidthConfig.Speed, m.loadedConfig.BudgetFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Manager: %w", err)
+	}
+
+	m.contributions, err = contribution.Load(m.loadedConfig.ContributionsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Manager: %w", err)
+	}
 
 	torrentFileWatcher := watcher.New()
 	torrentFileWatcher.AddFilterHook(torrentFileFilter)
 	_ = torrentFileWatcher.Add(m.loadedConfig.TorrentsDir)
+	for _, d := range m.loadedConfig.RuntimeConfig.Goals.Directories {
+		if d == nil {
+			continue
+		}
+		dir := filepath.Join(m.loadedConfig.TorrentsDir, d.Path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create goal directory '%s': %w", dir, err)
+		}
+		_ = torrentFileWatcher.Add(dir)
+	}
 
 	const intervalBetweenTorrentStatsUpdate = 15 * time.Second
 	go func(m *managerImpl) {
@@ -76,8 +132,28 @@ func Run(configLoader *core.CoreConfigLoader) (Manager, error) {
 				if !m.isSeeding {
 					continue
 				}
-				for key, _ := range m.torrents {
-					m.torrents[key].AddDataFor(intervalBetweenTorrentStatsUpdate)
+				now := time.Now()
+				// a missed tick or a system sleep must not count for the ticker interval
+				elapsed := accounting.Elapsed(m.lastAccounting, now, m.loadedConfig.RuntimeConfig.BandwidthConfig.Accounting.MaxCatchUp)
+				m.lastAccounting = now
+				uploaded := int64(0)
+				for key, t := range m.torrents {
+					if m.pausedByUser[key] {
+						continue
+					}
+					if _, paused := m.paused[key]; paused {
+						// a new day or a raised goal may have released the torrent
+						m.refreshGoal(t, now)
+						continue
+					}
+					torrentUploaded := t.AccountUpload()
+					uploaded += torrentUploaded
+					m.contributions.Add(key, torrentUploaded, elapsed, now)
+					m.applyGoal(t, now)
+				}
+				m.speedDispatcher.AccountUpload(uploaded)
+				if err := m.contributions.Save(); err != nil {
+					log.Error("failed to save torrents contributions", zap.Error(err))
 				}
 			case err := <-torrentFileWatcher.Error:
 				log.Warn("file watcher has reported an error", zap.Error(err))
@@ -85,21 +161,15 @@ func Run(configLoader *core.CoreConfigLoader) (Manager, error) {
 				switch event.Op {
 				case watcher.Create:
 					log.Info(event.String())
-					t, err := torrent2.FromFile(event.Path)
+					t, err := torrent2.FromFile(event.Path, m.loadedConfig.RuntimeConfig.BandwidthConfig.Accounting)
 					if err != nil {
 						log.Error("failed to parse torrent from file", zap.Error(err))
 						break
 					}
 					m.torrents[t.InfoHash()] = t
-					if m.isSeeding {
-						clientAbilities := m.client.GetAnnounceCapabilities()
-						t.Start(torrent2.AnnounceProps{
-							SupportHttpAnnounce:   m.client.SupportsHttpAnnounce(),
-							SupportUdpAnnounce:    m.client.SupportsUdpAnnounce(),
-							SupportAnnounceList:   clientAbilities.SupportAnnounceList,
-							AnnounceToAllTiers:    clientAbilities.AnnounceToAllTiers,
-							AnnounceToAllTrackers: clientAbilities.AnnounceToAllTrackersInTier,
-						}, m.announceQueue, m.speedDispatcher) // On passe le dispatcher pour que le torrent puisse se register
+					emitTorrentAdded(t)
+					if m.isSeeding && !m.applyGoal(t, time.Now()) {
+						m.startTorrent(t)
 					}
 				case watcher.Rename:
 					log.Info(event.String())
@@ -115,11 +185,14 @@ func Run(configLoader *core.CoreConfigLoader) (Manager, error) {
 						break
 					}
 					delete(m.torrents, t.InfoHash())
+					delete(m.paused, t.InfoHash())
+					delete(m.pausedByUser, t.InfoHash())
 					if m.isSeeding {
 						ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
-						t.Stop(ctx)
+						m.stopTorrent(ctx, t)
 						cancel()
 					}
+					broadcast.EmitTorrentRemoved(broadcast.TorrentRemovedEvent{Infohash: t.InfoHash()})
 				default:
 					// does not handle WRITE since it may occur while the file is being written before CREATE
 					log.Info("Event is ignored", zap.String("file", filepath.Base(event.Path)), zap.String("event", event.Op.String()))
@@ -172,14 +245,43 @@ func (m *managerImpl) doStartSeeding() error {
 	if m.loadedConfig.RuntimeConfig.Client == "" {
 		return fmt.Errorf("core config does not contains a client file, please take a look at the documentation")
 	}
-	client, err := emulatedclient.FromClientFile(filepath.Join(m.loadedConfig.ClientsDir, m.loadedConfig.RuntimeConfig.Client), NoOpProxy)
+	router, err := routing.FromConfig(m.loadedConfig.RuntimeConfig.Routing, m.dialer)
+	if err != nil {
+		return fmt.Errorf("invalid routing configuration: %w", err)
+	}
+	client, err := emulatedclient.FromClientFile(filepath.Join(m.loadedConfig.ClientsDir, m.loadedConfig.RuntimeConfig.Client), router)
 	if err != nil {
 		return fmt.Errorf("failed to load client file %s: %w", m.loadedConfig.RuntimeConfig.Client, err)
 	}
-	err = client.StartListener(NoOpProxy)
+	resolver, err := publicip.FromConfig(m.loadedConfig.RuntimeConfig.PublicIp, m.dialer)
+	if err != nil {
+		return fmt.Errorf("invalid public ip configuration: %w", err)
+	}
+	publicIps := publicip.NewMonitor(resolver, m.loadedConfig.RuntimeConfig.PublicIp.RefreshInterval, func(_ publicip.Addresses, _ publicip.Addresses) {
+		m.reannounceAll()
+	})
+	err = publicIps.Start(context.Background())
+	if err != nil {
+		return err
+	}
+	portMapper, err := portmapping.FromConfig(m.loadedConfig.RuntimeConfig.PortMapping)
 	if err != nil {
+		publicIps.Stop(context.Background())
+		return fmt.Errorf("invalid port mapping configuration: %w", err)
+	}
+	err = client.StartListener(publicIps, portMapper)
+	if err != nil {
+		publicIps.Stop(context.Background())
 		return fmt.Errorf("failed to start listener: %w", err)
 	}
+	m.publicIps = publicIps
+
+	err = m.speedDispatcher.Start(m.loadedConfig.RuntimeConfig.BandwidthConfig.Dispatcher)
+	if err != nil {
+		client.StopListener(context.Background())
+		publicIps.Stop(context.Background())
+		return fmt.Errorf("invalid bandwidth dispatcher configuration: %w", err)
+	}
 
 	m.client = client
 	m.announceQueue = torrent2.NewAnnounceQueue()
@@ -188,20 +290,119 @@ func (m *managerImpl) doStartSeeding() error {
 	})
 
 	m.isSeeding = true
-	m.speedDispatcher.Start(m.loadedConfig.RuntimeConfig.BandwidthConfig.Dispatcher)
+	broadcast.EmitSeedStart(broadcast.SeedStartedEvent{
+		Client:  client.GetName(),
+		Version: client.GetVersion(),
+	})
+	now := time.Now()
+	m.lastAccounting = now
+	for key, t := range m.torrents {
+		// the listeners forget the torrents when the seed stops
+		emitTorrentAdded(t)
+		if m.pausedByUser[key] {
+			broadcast.EmitTorrentPaused(broadcast.TorrentPausedEvent{Infohash: key, Reason: PausedByUser})
+			continue
+		}
+		previous, wasPaused := m.paused[key]
+		if m.applyGoal(t, now) {
+			// the goal reached in a previous seeding session is not reported again by applyGoal
+			if reason, paused := m.paused[key]; wasPaused && paused && reason == previous {
+				broadcast.EmitTorrentPaused(broadcast.TorrentPausedEvent{Infohash: key, Reason: reason})
+			}
+			continue
+		}
+		m.startTorrent(t)
+	}
+
+	return nil
+}
+
+func (m *managerImpl) startTorrent(t torrent2.Torrent) {
 	clientAbilities := m.client.GetAnnounceCapabilities()
-	for _, t := range m.torrents {
+	t.Start(torrent2.AnnounceProps{
+		SupportHttpAnnounce:   m.client.SupportsHttpAnnounce(),
+		SupportUdpAnnounce:    m.client.SupportsUdpAnnounce(),
+		SupportAnnounceList:   clientAbilities.SupportAnnounceList,
+		AnnounceToAllTiers:    clientAbilities.AnnounceToAllTiers,
+		AnnounceToAllTrackers: clientAbilities.AnnounceToAllTrackersInTier,
+	}, m.announceQueue, &contributionDispatcher{SpeedDispatcher: m.speedDispatcher, contributions: m.contributions}) // On passe le dispatcher pour que le torrent puisse se register
+}
 
-		t.Start(torrent2.AnnounceProps{
-			SupportHttpAnnounce:   m.client.SupportsHttpAnnounce(),
-			SupportUdpAnnounce:    m.client.SupportsUdpAnnounce(),
-			SupportAnnounceList:   clientAbilities.SupportAnnounceList,
-			AnnounceToAllTiers:    clientAbilities.AnnounceToAllTiers,
-			AnnounceToAllTrackers: clientAbilities.AnnounceToAllTrackersInTier,
-		}, m.announceQueue, m.speedDispatcher) // On passe le dispatcher pour que le torrent puisse se register
+// stopTorrent stops the torrent and accounts the bytes it has uploaded since the previous accounting, they would be
+// lost otherwise since a stopped torrent is not accounted anymore
+func (m *managerImpl) stopTorrent(ctx context.Context, t torrent2.Torrent) {
+	t.Stop(ctx)
+	uploaded := t.AccountUpload()
+	if uploaded == 0 {
+		return
 	}
+	m.contributions.Add(t.InfoHash(), uploaded, 0, time.Now())
+	m.speedDispatcher.AccountUpload(uploaded)
+}
 
-	return nil
+// applyGoal stops the torrent if it has reached its goal and returns true, a torrent which has not reached its goal is
+// no longer considered as paused. The event is only emitted the first time the goal is reached.
+func (m *managerImpl) applyGoal(t torrent2.Torrent, now time.Time) bool {
+	log := logs.GetLogger()
+	infoHash := t.InfoHash()
+	counters := m.contributions.Get(infoHash)
+	goal := goals.Resolve(m.loadedConfig.RuntimeConfig.Goals, m.loadedConfig.TorrentsDir, t.Path(), counters.Goal)
+	reason := goals.Reached(goal, t.Size(), counters, now)
+	if reason == "" {
+		delete(m.paused, infoHash)
+		return false
+	}
+	if previous, paused := m.paused[infoHash]; paused && previous == reason {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
+	m.stopTorrent(ctx, t)
+	cancel()
+
+	action := goals.ActionFor(goal, reason)
+	log.Info("torrent has reached its goal", zap.String("torrent", filepath.Base(t.Path())), zap.String("goal", reason), zap.String("action", action))
+	if action == goals.ArchiveAction {
+		err := t.MoveTo(m.loadedConfig.ArchivedTorrentsDir)
+		if err != nil {
+			log.Error("failed to archive torrent, it is paused instead", zap.Error(err))
+			action = goals.PauseAction
+		} else {
+			delete(m.torrents, infoHash)
+			delete(m.paused, infoHash)
+			delete(m.pausedByUser, infoHash)
+			// the torrent is no longer known, the watcher will not report the file removal
+			defer broadcast.EmitTorrentRemoved(broadcast.TorrentRemovedEvent{Infohash: infoHash})
+		}
+	}
+	if action == goals.PauseAction {
+		m.paused[infoHash] = reason
+	}
+
+	broadcast.EmitTorrentGoalReached(broadcast.TorrentGoalReachedEvent{
+		Infohash:    infoHash,
+		Goal:        reason,
+		Action:      action,
+		Uploaded:    counters.Uploaded,
+		SeedingTime: counters.SeedingTime,
+	})
+	if action == goals.PauseAction {
+		broadcast.EmitTorrentPaused(broadcast.TorrentPausedEvent{Infohash: infoHash, Reason: reason})
+	}
+	return true
+}
+
+// refreshGoal evaluates the goal of the torrent again, starting it back if it is paused and no longer reaches its goal
+func (m *managerImpl) refreshGoal(t torrent2.Torrent, now time.Time) {
+	if m.pausedByUser[t.InfoHash()] {
+		return
+	}
+	_, wasPaused := m.paused[t.InfoHash()]
+	if m.applyGoal(t, now) || !wasPaused {
+		return
+	}
+	broadcast.EmitTorrentResumed(broadcast.TorrentResumedEvent{Infohash: t.InfoHash()})
+	m.startTorrent(t)
 }
 
 func (m *managerImpl) doSaveTorrentFile(filename string, content []byte) error {
@@ -230,7 +431,9 @@ func (m *managerImpl) doArchiveTorrent(hash torrent.InfoHash) error {
 			torrentToRemove = t
 			break
 		}
-		return fmt.Errorf("torrent not found in seeding list")
+	}
+	if torrentToRemove == nil {
+		return ErrTorrentNotFound
 	}
 
 	err := torrentToRemove.MoveTo(m.loadedConfig.ArchivedTorrentsDir)
@@ -241,19 +444,111 @@ func (m *managerImpl) doArchiveTorrent(hash torrent.InfoHash) error {
 	return nil
 }
 
+func (m *managerImpl) doSetTorrentGoal(hash torrent.InfoHash, goal *core.GoalConfig) error {
+	if err := goals.ValidateGoal(goal); err != nil {
+		return fmt.Errorf("invalid goal: %w", err)
+	}
+	m.contributions.SetGoal(hash, goal)
+	if err := m.contributions.Save(); err != nil {
+		return err
+	}
+	t, found := m.torrents[hash]
+	if !found || !m.isSeeding {
+		return nil
+	}
+	m.refreshGoal(t, time.Now())
+	return nil
+}
+
+func (m *managerImpl) doPauseTorrent(hash torrent.InfoHash) error {
+	t, found := m.torrents[hash]
+	if !found {
+		return ErrTorrentNotFound
+	}
+	if m.pausedByUser[hash] {
+		return nil
+	}
+	m.pausedByUser[hash] = true
+	if _, paused := m.paused[hash]; m.isSeeding && !paused {
+		ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
+		m.stopTorrent(ctx, t)
+		cancel()
+	}
+	broadcast.EmitTorrentPaused(broadcast.TorrentPausedEvent{Infohash: hash, Reason: PausedByUser})
+	return nil
+}
+
+func (m *managerImpl) doResumeTorrent(hash torrent.InfoHash) error {
+	t, found := m.torrents[hash]
+	if !found {
+		return ErrTorrentNotFound
+	}
+	if !m.pausedByUser[hash] {
+		if _, paused := m.paused[hash]; paused {
+			return ErrGoalReached
+		}
+		return nil
+	}
+	delete(m.pausedByUser, hash)
+	broadcast.EmitTorrentResumed(broadcast.TorrentResumedEvent{Infohash: hash})
+	if m.isSeeding && !m.applyGoal(t, time.Now()) {
+		m.startTorrent(t)
+	}
+	return nil
+}
+
+func (m *managerImpl) doAnnounceTorrent(hash torrent.InfoHash) error {
+	t, found := m.torrents[hash]
+	if !found {
+		return ErrTorrentNotFound
+	}
+	if !m.isSeeding {
+		return ErrNotSeeding
+	}
+	if _, paused := m.paused[hash]; paused || m.pausedByUser[hash] {
+		return ErrTorrentPaused
+	}
+	t.Reannounce()
+	return nil
+}
+
 func (m *managerImpl) doStopSeeding(ctx context.Context) {
 	if !m.isSeeding {
 		return
 	}
+	log := logs.GetLogger()
 	for _, t := range m.torrents {
 		ctx, cancel := context.WithTimeout(ctx, 7*time.Second)
-		t.Stop(ctx)
+		m.stopTorrent(ctx, t)
 		cancel()
 	}
+	if err := m.contributions.Save(); err != nil {
+		log.Error("failed to save torrents contributions", zap.Error(err))
+	}
 	m.speedDispatcher.Stop()
 	m.announceQueue.DiscardFutureEnqueueAndDestroy()
 	m.isSeeding = false
 	m.client.StopListener(context.Background())
+	m.publicIps.Stop(ctx)
+	broadcast.EmitSeedStop(broadcast.SeedStoppedEvent{})
+}
+
+// reannounceAll makes all the torrents announce right away, it does not block the caller
+func (m *managerImpl) reannounceAll() {
+	log := logs.GetLogger()
+	command := func() {
+		if !m.isSeeding {
+			return
+		}
+		for _, t := range m.torrents {
+			t.Reannounce()
+		}
+	}
+	select {
+	case m.commands <- command:
+	default:
+		log.Warn("manager is too busy to re-announce the torrents, they will announce the new public ip on their next announce")
+	}
 }
 
 func (m *managerImpl) doReloadConfig() error {
@@ -261,12 +556,28 @@ func (m *managerImpl) doReloadConfig() error {
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if conf.RuntimeConfig.Goals == nil {
+		conf.RuntimeConfig.Goals = core.GoalsConfig{}.Default()
+	}
+	if conf.RuntimeConfig.BandwidthConfig.Accounting == nil {
+		conf.RuntimeConfig.BandwidthConfig.Accounting = core.AccountingConfig{}.Default()
+	}
+	if conf.RuntimeConfig.Journal == nil {
+		conf.RuntimeConfig.Journal = core.JournalConfig{}.Default()
+	}
+	if err = goals.Validate(conf.RuntimeConfig.Goals, conf.TorrentsDir, conf.ArchivedTorrentsDir); err != nil {
+		return fmt.Errorf("invalid goals configuration: %w", err)
+	}
 	m.loadedConfig = conf
+	broadcast.ConfigureJournal(conf.RuntimeConfig.Journal)
 	// TODO: Based on what have changed, maybe we can publish an event "restart required" to warn the user that a
 	//  restart is needed to fully apply the new configuration (for example: a change of the RuntieConfig.Client need a restart)
 
 	if m.speedDispatcher != nil {
-		m.speedDispatcher.ReplaceSpeedConfig(m.loadedConfig.RuntimeConfig.BandwidthConfig.Speed)
+		err = m.speedDispatcher.ReplaceSpeedConfig(m.loadedConfig.RuntimeConfig.BandwidthConfig.Speed)
+		if err != nil {
+			return fmt.Errorf("failed to apply config: %w", err)
+		}
 	}
 	return nil
 }
@@ -293,17 +604,50 @@ func (m *managerImpl) SaveTorrentFile(filename string, bytes []byte) {
 	}
 }
 
-func (m *managerImpl) ArchiveTorrent(hash torrent.InfoHash) {
+func (m *managerImpl) ArchiveTorrent(hash torrent.InfoHash) error {
+	return m.call(func() error {
+		return m.doArchiveTorrent(hash)
+	})
+}
+
+func (m *managerImpl) SetTorrentGoal(hash torrent.InfoHash, goal *core.GoalConfig) {
 	log := logs.GetLogger()
 	m.commands <- func() {
-		err := m.doArchiveTorrent(hash)
+		err := m.doSetTorrentGoal(hash, goal)
 		if err != nil {
-			log.Error("manager failed to archive torrent", zap.Error(err))
+			log.Error("manager failed to set torrent goal", zap.Error(err))
 			//TODO: find a way to return error?
 		}
 	}
 }
 
+func (m *managerImpl) PauseTorrent(hash torrent.InfoHash) error {
+	return m.call(func() error {
+		return m.doPauseTorrent(hash)
+	})
+}
+
+func (m *managerImpl) ResumeTorrent(hash torrent.InfoHash) error {
+	return m.call(func() error {
+		return m.doResumeTorrent(hash)
+	})
+}
+
+func (m *managerImpl) AnnounceTorrent(hash torrent.InfoHash) error {
+	return m.call(func() error {
+		return m.doAnnounceTorrent(hash)
+	})
+}
+
+// call runs the command on the manager goroutine and waits for its result
+func (m *managerImpl) call(command func() error) error {
+	result := make(chan error, 1)
+	m.commands <- func() {
+		result <- command()
+	}
+	return <-result
+}
+
 func (m *managerImpl) StopSeeding(ctx context.Context) {
 	m.commands <- func() {
 		m.doStopSeeding(ctx)
@@ -317,6 +661,16 @@ func (m *managerImpl) Quit() {
 	_ = stopReq.AwaitDone()
 }
 
+func emitTorrentAdded(t torrent2.Torrent) {
+	broadcast.EmitTorrentAdded(broadcast.TorrentAddedEvent{
+		Infohash:            t.InfoHash(),
+		Name:                t.Name(),
+		File:                filepath.Base(t.Path()),
+		TrackerAnnounceUrls: t.AnnounceUrls(),
+		Size:                t.Size(),
+	})
+}
+
 func findTorrent(torrents map[torrent.InfoHash]torrent2.Torrent, path string) (torrent2.Torrent, bool) {
 	for _, t := range torrents {
 		if t.Path() == path {
@@ -0,0 +1,240 @@
+package manager2
+
+import (
+	"context"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testClientFile = `---
+name: qBittorrent
+version: 3.3.1
+keyGenerator:
+  algorithm:
+    type: NUM_RANGE_ENCODED_AS_HEXADECIMAL
+    min: 0
+    max: 4294967295
+  type: TORRENT_PERSISTENT_REFRESH
+peerIdGenerator:
+  algorithm:
+    type: REGEX
+    pattern: ^-qB3310-[A-Za-z0-9_~\(\)\!\.\*-]{12}$
+  type: NEVER_REFRESH
+numwant: 200
+numwantOnStop: 0
+announceCapabilities:
+  supportAnnounceList: true
+  announceToAllTiers: true
+  announceToAllTrackersInTier: true
+announcer:
+  http:
+    urlEncoder:
+      encodedHexCase: lower
+    query: info_hash={{urlEncode (byteArray20ToString .InfoHash)}}&peer_id={{byteArray20ToString .PeerId}}&port={{.Port}}&uploaded={{.Uploaded}}&downloaded={{.Downloaded}}&left={{.Left}}&corrupt=0&key={{withLeadingZeroes (uint32ToHexString .Key) 8}}{{if ne .Event.String "empty"}}&event={{.Event.String}}{{end}}&numwant={{.NumWant}}&compact=1&no_peer_id=1&supportcrypto=1&redundant=0
+    requestHeaders:
+      - name: User-Agent
+        value: qBittorrent v3.3.1
+listener:
+  port:
+    min: 8999
+    max: 9100
+`
+
+const testCoreConfig = `---
+client: qbittorrent-3.3.1.yml
+publicIp:
+  strategies: [static]
+  refreshInterval: 0s
+  static:
+    ipv4: 127.0.0.1
+`
+
+func newFakeTracker(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := bencode.Marshal(map[string]interface{}{
+			"interval":   1800,
+			"complete":   20,
+			"incomplete": 10,
+			"peers":      "",
+		})
+		if err != nil {
+			t.Errorf("failed to encode http announce response")
+		}
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func writeTestTorrent(t *testing.T, path string, announce string) metainfo.Hash {
+	infoBytes, err := bencode.Marshal(metainfo.Info{
+		Name:        "test-torrent",
+		PieceLength: 16384,
+		Length:      16384,
+		Pieces:      make([]byte, 20),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := metainfo.MetaInfo{Announce: announce, InfoBytes: infoBytes}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := meta.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return meta.HashInfoBytes()
+}
+
+func setupCoreRoot(t *testing.T) string {
+	root := t.TempDir()
+	for _, dir := range []string{"torrents/archived", "clients"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "clients", "qbittorrent-3.3.1.yml"), []byte(testClientFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "core.yml"), []byte(testCoreConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func nextEvent(t *testing.T, events <-chan any) any {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return nil
+}
+
+func TestManager_ShouldEmitLifecycleEvents(t *testing.T) {
+	trackerServer := newFakeTracker(t)
+	announceUrl, _ := url.Parse(trackerServer.URL + "/announce")
+	root := setupCoreRoot(t)
+	torrentPath := filepath.Join(root, "torrents", "test.torrent")
+	infoHash := writeTestTorrent(t, torrentPath, announceUrl.String())
+
+	events := make(chan any, 100)
+	sub := broadcast.Subscribe(func(event any) { events <- event }, broadcast.WithOverflowPolicy(broadcast.Block), broadcast.WithEventTypes(
+		broadcast.SeedStartedEvent{},
+		broadcast.SeedStoppedEvent{},
+		broadcast.TorrentAddedEvent{},
+		broadcast.TorrentAnnouncingEvent{},
+		broadcast.TorrentAnnounceSuccessEvent{},
+		broadcast.TorrentAnnounceFailedEvent{},
+		broadcast.TorrentSwarmChangedEvent{},
+		broadcast.TorrentRemovedEvent{},
+	))
+	defer sub.Unsubscribe()
+
+	m, err := Run(core.NewCoreConfigLoader(root), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Quit()
+
+	added := broadcast.TorrentAddedEvent{
+		Infohash:            infoHash,
+		Name:                "test-torrent",
+		File:                "test.torrent",
+		TrackerAnnounceUrls: []url.URL{*announceUrl},
+		Size:                16384,
+	}
+	assert.Equal(t, added, nextEvent(t, events))
+
+	m.StartSeeding()
+	assert.Equal(t, broadcast.SeedStartedEvent{Client: "qBittorrent", Version: "3.3.1"}, nextEvent(t, events))
+	assert.Equal(t, added, nextEvent(t, events))
+	assert.Equal(t, broadcast.TorrentAnnouncingEvent{
+		Infohash:      infoHash,
+		TrackerUrl:    *announceUrl,
+		AnnounceEvent: tracker.Started,
+	}, nextEvent(t, events))
+	success := nextEvent(t, events).(broadcast.TorrentAnnounceSuccessEvent)
+	assert.Equal(t, infoHash, success.Infohash)
+	assert.Equal(t, tracker.Started, success.AnnounceEvent)
+	assert.Equal(t, int32(20), success.Seeder)
+	assert.Equal(t, int32(10), success.Leechers)
+	assert.Equal(t, 1800*time.Second, success.Interval)
+	assert.Equal(t, broadcast.TorrentSwarmChangedEvent{Infohash: infoHash, Seeder: 20, Leechers: 10}, nextEvent(t, events))
+
+	m.StopSeeding(context.Background())
+	announcing := nextEvent(t, events).(broadcast.TorrentAnnouncingEvent)
+	assert.Equal(t, tracker.Stopped, announcing.AnnounceEvent)
+	success = nextEvent(t, events).(broadcast.TorrentAnnounceSuccessEvent)
+	assert.Equal(t, tracker.Stopped, success.AnnounceEvent)
+	assert.Equal(t, broadcast.SeedStoppedEvent{}, nextEvent(t, events))
+
+	if err := os.Remove(torrentPath); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, broadcast.TorrentRemovedEvent{Infohash: infoHash}, nextEvent(t, events))
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %#v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestManager_ShouldPauseAndResumeTorrents(t *testing.T) {
+	trackerServer := newFakeTracker(t)
+	announceUrl, _ := url.Parse(trackerServer.URL + "/announce")
+	root := setupCoreRoot(t)
+	infoHash := writeTestTorrent(t, filepath.Join(root, "torrents", "test.torrent"), announceUrl.String())
+
+	events := make(chan any, 100)
+	sub := broadcast.Subscribe(func(event any) { events <- event }, broadcast.WithOverflowPolicy(broadcast.Block), broadcast.WithEventTypes(
+		broadcast.TorrentAddedEvent{},
+		broadcast.TorrentAnnounceSuccessEvent{},
+		broadcast.TorrentPausedEvent{},
+		broadcast.TorrentResumedEvent{},
+	))
+	defer sub.Unsubscribe()
+
+	m, err := Run(core.NewCoreConfigLoader(root), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Quit()
+	assert.IsType(t, broadcast.TorrentAddedEvent{}, nextEvent(t, events))
+
+	assert.ErrorIs(t, m.PauseTorrent(torrent.InfoHash{1}), ErrTorrentNotFound)
+	assert.ErrorIs(t, m.AnnounceTorrent(infoHash), ErrNotSeeding)
+
+	m.StartSeeding()
+	assert.IsType(t, broadcast.TorrentAddedEvent{}, nextEvent(t, events))
+	assert.Equal(t, tracker.Started, nextEvent(t, events).(broadcast.TorrentAnnounceSuccessEvent).AnnounceEvent)
+
+	assert.NoError(t, m.PauseTorrent(infoHash))
+	assert.Equal(t, tracker.Stopped, nextEvent(t, events).(broadcast.TorrentAnnounceSuccessEvent).AnnounceEvent)
+	assert.Equal(t, broadcast.TorrentPausedEvent{Infohash: infoHash, Reason: PausedByUser}, nextEvent(t, events))
+	assert.ErrorIs(t, m.AnnounceTorrent(infoHash), ErrTorrentPaused)
+
+	assert.NoError(t, m.ResumeTorrent(infoHash))
+	assert.Equal(t, broadcast.TorrentResumedEvent{Infohash: infoHash}, nextEvent(t, events))
+	assert.Equal(t, tracker.Started, nextEvent(t, events).(broadcast.TorrentAnnounceSuccessEvent).AnnounceEvent)
+
+	assert.NoError(t, m.AnnounceTorrent(infoHash))
+	assert.Equal(t, tracker.None, nextEvent(t, events).(broadcast.TorrentAnnounceSuccessEvent).AnnounceEvent)
+	m.StopSeeding(context.Background())
+}
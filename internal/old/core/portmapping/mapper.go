@@ -0,0 +1,21 @@
+package portmapping
+
+import (
+	"context"
+	"time"
+)
+
+// Mapping is a port forwarded by the router to the listener
+type Mapping struct {
+	InternalPort uint16
+	ExternalPort uint16
+	Lifetime     time.Duration
+}
+
+// Mapper creates port mappings on the router with a given protocol (UPnP IGD, NAT-PMP, ...). Only TCP ports are mapped.
+type Mapper interface {
+	Name() string
+	// Map asks the router to forward an external port to internalPort for lifetime, the external port may differ from the internal one
+	Map(ctx context.Context, internalPort uint16, lifetime time.Duration) (Mapping, error)
+	Unmap(ctx context.Context, mapping Mapping) error
+}
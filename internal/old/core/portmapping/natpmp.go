@@ -0,0 +1,173 @@
+package portmapping
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	natPmpPort          = 5351
+	natPmpVersion       = 0
+	natPmpOpMapTcp      = 2
+	natPmpResponseFlag  = 128
+	natPmpMaxAttempts   = 4
+	natPmpFirstTimeout  = 250 * time.Millisecond
+	natPmpResponseBytes = 16
+)
+
+var natPmpResultCodes = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized/refused",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// NatPmpMapper maps ports with NAT-PMP (RFC 6886). PCP gateways (RFC 6887) usually answer NAT-PMP requests as well.
+type NatPmpMapper struct {
+	gateway string
+}
+
+// NewNatPmpMapper returns a mapper talking to gateway, if gateway is empty the default route gateway is used
+func NewNatPmpMapper(gateway string) *NatPmpMapper {
+	return &NatPmpMapper{gateway: gateway}
+}
+
+func (m *NatPmpMapper) Name() string {
+	return "natpmp"
+}
+
+func (m *NatPmpMapper) Map(ctx context.Context, internalPort uint16, lifetime time.Duration) (Mapping, error) {
+	externalPort, grantedLifetime, err := m.request(ctx, internalPort, internalPort, uint32(lifetime.Seconds()))
+	if err != nil {
+		return Mapping{}, fmt.Errorf("natpmp: failed to add port mapping: %w", err)
+	}
+	return Mapping{
+		InternalPort: internalPort,
+		ExternalPort: externalPort,
+		Lifetime:     time.Duration(grantedLifetime) * time.Second,
+	}, nil
+}
+
+func (m *NatPmpMapper) Unmap(ctx context.Context, mapping Mapping) error {
+	// a mapping is deleted by requesting it with a lifetime and an external port of 0
+	if _, _, err := m.request(ctx, mapping.InternalPort, 0, 0); err != nil {
+		return fmt.Errorf("natpmp: failed to delete port mapping: %w", err)
+	}
+	return nil
+}
+
+func (m *NatPmpMapper) gatewayAddr() (string, error) {
+	if m.gateway != "" {
+		if _, _, err := net.SplitHostPort(m.gateway); err == nil {
+			return m.gateway, nil
+		}
+		return net.JoinHostPort(m.gateway, fmt.Sprint(natPmpPort)), nil
+	}
+	gateway, err := defaultGateway()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the default gateway, set it in the configuration: %w", err)
+	}
+	return net.JoinHostPort(gateway.String(), fmt.Sprint(natPmpPort)), nil
+}
+
+func (m *NatPmpMapper) request(ctx context.Context, internalPort uint16, externalPort uint16, lifetime uint32) (uint16, uint32, error) {
+	gateway, err := m.gatewayAddr()
+	if err != nil {
+		return 0, 0, err
+	}
+	conn, err := net.Dial("udp", gateway)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	req := make([]byte, 12)
+	req[0] = natPmpVersion
+	req[1] = natPmpOpMapTcp
+	binary.BigEndian.PutUint16(req[4:6], internalPort)
+	binary.BigEndian.PutUint16(req[6:8], externalPort)
+	binary.BigEndian.PutUint32(req[8:12], lifetime)
+
+	// RFC 6886 3.1: retransmit with a timeout doubling at each attempt
+	timeout := natPmpFirstTimeout
+	resp := make([]byte, natPmpResponseBytes)
+	for attempt := 0; attempt < natPmpMaxAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		if _, err = conn.Write(req); err != nil {
+			return 0, 0, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(resp)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				timeout *= 2
+				continue
+			}
+			return 0, 0, err
+		}
+		if n < natPmpResponseBytes || resp[0] != natPmpVersion || resp[1] != natPmpResponseFlag+natPmpOpMapTcp {
+			continue
+		}
+		if binary.BigEndian.Uint16(resp[8:10]) != internalPort {
+			continue
+		}
+		if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+			reason, ok := natPmpResultCodes[code]
+			if !ok {
+				reason = "unknown error"
+			}
+			return 0, 0, fmt.Errorf("gateway refused the mapping: %s (%d)", reason, code)
+		}
+		return binary.BigEndian.Uint16(resp[10:12]), binary.BigEndian.Uint32(resp[12:16]), nil
+	}
+	return 0, 0, fmt.Errorf("no answer from gateway %s", gateway)
+}
+
+// defaultGatewaySupported tells whether the default route gateway can be read on this platform, only linux exposes /proc/net/route
+var defaultGatewaySupported = runtime.GOOS == "linux"
+
+var errDefaultGatewayUnsupported = fmt.Errorf("default gateway detection is not supported on %s", runtime.GOOS)
+
+// defaultGateway reads the default route gateway, only linux is supported
+func defaultGateway() (net.IP, error) {
+	if !defaultGatewaySupported {
+		return nil, errDefaultGatewayUnsupported
+	}
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return parseRouteTable(f)
+}
+
+func parseRouteTable(f io.Reader) (net.IP, error) {
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Iface Destination Gateway ...
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// stored in host byte order, which is little endian on all the platforms joal runs on
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]), nil
+	}
+	return nil, fmt.Errorf("no default route found")
+}
@@ -0,0 +1,127 @@
+package portmapping
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNatPmp is an in-process NAT-PMP gateway, it maps internal port n to external port n+1000
+type fakeNatPmp struct {
+	conn       net.PacketConn
+	lock       *sync.Mutex
+	mappings   map[uint16]uint32
+	resultCode uint16
+	dropFirst  int
+}
+
+func newFakeNatPmp(t *testing.T) *fakeNatPmp {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	f := &fakeNatPmp{conn: conn, lock: &sync.Mutex{}, mappings: map[uint16]uint32{}}
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n != 12 || buf[0] != 0 || buf[1] != 2 {
+				continue
+			}
+			resp := f.handle(binary.BigEndian.Uint16(buf[4:6]), binary.BigEndian.Uint16(buf[6:8]), binary.BigEndian.Uint32(buf[8:12]))
+			if resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return f
+}
+
+func (f *fakeNatPmp) handle(internalPort uint16, externalPort uint16, lifetime uint32) []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.dropFirst > 0 {
+		f.dropFirst--
+		return nil
+	}
+	resp := make([]byte, 16)
+	resp[1] = 128 + 2
+	binary.BigEndian.PutUint16(resp[2:4], f.resultCode)
+	binary.BigEndian.PutUint16(resp[8:10], internalPort)
+	if f.resultCode != 0 {
+		return resp
+	}
+	if lifetime == 0 && externalPort == 0 {
+		delete(f.mappings, internalPort)
+		binary.BigEndian.PutUint16(resp[8:10], internalPort)
+		return resp
+	}
+	f.mappings[internalPort] = lifetime
+	binary.BigEndian.PutUint16(resp[10:12], internalPort+1000)
+	binary.BigEndian.PutUint32(resp[12:16], lifetime)
+	return resp
+}
+
+func (f *fakeNatPmp) mapped(internalPort uint16) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, ok := f.mappings[internalPort]
+	return ok
+}
+
+func TestNatPmpMapper_ShouldMapAndUnmap(t *testing.T) {
+	gateway := newFakeNatPmp(t)
+	mapper := NewNatPmpMapper(gateway.conn.LocalAddr().String())
+
+	mapping, err := mapper.Map(context.Background(), 6881, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Mapping{InternalPort: 6881, ExternalPort: 7881, Lifetime: time.Hour}, mapping)
+	assert.True(t, gateway.mapped(6881))
+
+	assert.NoError(t, mapper.Unmap(context.Background(), mapping))
+	assert.False(t, gateway.mapped(6881))
+}
+
+func TestNatPmpMapper_ShouldRetransmit(t *testing.T) {
+	gateway := newFakeNatPmp(t)
+	gateway.dropFirst = 2
+	mapper := NewNatPmpMapper(gateway.conn.LocalAddr().String())
+
+	_, err := mapper.Map(context.Background(), 6881, time.Hour)
+	assert.NoError(t, err)
+}
+
+func TestNatPmpMapper_ShouldReportGatewayError(t *testing.T) {
+	gateway := newFakeNatPmp(t)
+	gateway.resultCode = 2
+	mapper := NewNatPmpMapper(gateway.conn.LocalAddr().String())
+
+	_, err := mapper.Map(context.Background(), 6881, time.Hour)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not authorized")
+	}
+}
+
+func Test_parseRouteTable(t *testing.T) {
+	table := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	0000A8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	0	00000000	0	0	0
+`
+	ip, err := parseRouteTable(strings.NewReader(table))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.1", ip.String())
+
+	_, err = parseRouteTable(strings.NewReader("Iface\tDestination\tGateway\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,230 @@
+package portmapping
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/stop"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UpnpMethod   = "upnp"
+	NatPmpMethod = "natpmp"
+)
+
+const mappingRequestTimeout = 10 * time.Second
+
+// PortMapper keeps the listener port forwarded on the router, renewing the mapping before it expires.
+// A PortMapper without mapper does nothing, the listener port is announced as is.
+type PortMapper struct {
+	mappers   []Mapper
+	lifetime  time.Duration
+	mapper    Mapper
+	mapping   *Mapping
+	isRunning bool
+	// cancelStart aborts the mapping attempt of an ongoing Start, it is nil when no Start is ongoing
+	cancelStart context.CancelFunc
+	stopping    stop.Chan
+	lock        *sync.RWMutex
+}
+
+func NewPortMapper(mappers []Mapper, lifetime time.Duration) *PortMapper {
+	return &PortMapper{
+		mappers:  mappers,
+		lifetime: lifetime,
+		stopping: stop.NewChan(),
+		lock:     &sync.RWMutex{},
+	}
+}
+
+func FromConfig(conf *core.PortMappingConfig) (*PortMapper, error) {
+	var mappers []Mapper
+	for _, method := range conf.Methods {
+		switch strings.ToLower(method) {
+		case UpnpMethod:
+			mappers = append(mappers, NewUpnpMapper())
+		case NatPmpMethod:
+			if conf.Gateway == "" && !defaultGatewaySupported {
+				return nil, fmt.Errorf("port mapping method '%s' needs the gateway to be configured, %s", method, errDefaultGatewayUnsupported)
+			}
+			mappers = append(mappers, NewNatPmpMapper(conf.Gateway))
+		default:
+			return nil, fmt.Errorf("port mapping method '%s' is not supported", method)
+		}
+	}
+	if len(mappers) > 0 && conf.Lifetime < 2*time.Minute {
+		return nil, fmt.Errorf("port mapping lifetime must be at least 2m, found %s", conf.Lifetime)
+	}
+	return NewPortMapper(mappers, conf.Lifetime), nil
+}
+
+// Start maps internalPort with the first mapper that succeeds and returns the external port to announce.
+// If no mapper succeeds a NoticeableErrorEvent is broadcast and internalPort is returned, the port is then expected to be forwarded by hand.
+func (p *PortMapper) Start(internalPort uint16) uint16 {
+	log := logs.GetLogger()
+	p.lock.Lock()
+	if p.isRunning || p.cancelStart != nil || len(p.mappers) == 0 {
+		p.lock.Unlock()
+		return internalPort
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancelStart = cancel
+	p.lock.Unlock()
+
+	// the router round-trips are done without the lock, Stop and ExternalPort must not wait for the discovery timeouts
+	mapper, mapping, errs := p.mapWithFirstWorkingMapper(ctx, internalPort)
+
+	p.lock.Lock()
+	p.cancelStart = nil
+	stopped := ctx.Err() != nil
+	cancel()
+	if mapping != nil && !stopped {
+		p.mapper = mapper
+		p.mapping = mapping
+		p.isRunning = true
+		go p.renewRoutine()
+	}
+	p.lock.Unlock()
+
+	if stopped {
+		if mapping != nil {
+			// Stop has been called while mapping, the mapping must not outlive the port mapper
+			unmapCtx, cancelUnmap := context.WithTimeout(context.Background(), mappingRequestTimeout)
+			defer cancelUnmap()
+			if err := mapper.Unmap(unmapCtx, *mapping); err != nil {
+				log.Warn("port mapping: failed to remove mapping", zap.Error(err))
+			}
+		}
+		return internalPort
+	}
+	if mapping == nil {
+		err := fmt.Errorf("failed to map listener port %d, make sure it is forwarded by the router: %s", internalPort, strings.Join(errs, ", "))
+		log.Warn("port mapping: failed", zap.Error(err))
+		broadcast.EmitNoticeableError(broadcast.NoticeableErrorEvent{Error: err, Datetime: time.Now()})
+		return internalPort
+	}
+	log.Info("port mapping: mapped",
+		zap.String("method", mapper.Name()),
+		zap.Uint16("internal-port", mapping.InternalPort),
+		zap.Uint16("external-port", mapping.ExternalPort),
+		zap.Duration("lifetime", mapping.Lifetime),
+	)
+	return mapping.ExternalPort
+}
+
+// mapWithFirstWorkingMapper tries the mappers in order, it returns a nil mapping along with the error of each mapper if none succeeded
+func (p *PortMapper) mapWithFirstWorkingMapper(ctx context.Context, internalPort uint16) (Mapper, *Mapping, []string) {
+	var errs []string
+	for _, mapper := range p.mappers {
+		if ctx.Err() != nil {
+			return nil, nil, append(errs, ctx.Err().Error())
+		}
+		requestCtx, cancel := context.WithTimeout(ctx, mappingRequestTimeout)
+		mapping, err := mapper.Map(requestCtx, internalPort, p.lifetime)
+		cancel()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return mapper, &mapping, nil
+	}
+	return nil, nil, errs
+}
+
+// ExternalPort returns the external port of the current mapping, false if the port is not mapped
+func (p *PortMapper) ExternalPort() (uint16, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.mapping == nil {
+		return 0, false
+	}
+	return p.mapping.ExternalPort, true
+}
+
+func (p *PortMapper) renewRoutine() {
+	log := logs.GetLogger()
+	timer := time.NewTimer(renewDelay(p.currentMapping()))
+	for {
+		select {
+		case <-timer.C:
+			mapping := p.currentMapping()
+			ctx, cancel := context.WithTimeout(context.Background(), mappingRequestTimeout)
+			renewed, err := p.mapper.Map(ctx, mapping.InternalPort, p.lifetime)
+			cancel()
+			if err != nil {
+				// the current mapping is still valid until it expires, retry soon
+				log.Warn("port mapping: failed to renew", zap.Error(err))
+				broadcast.EmitNoticeableError(broadcast.NoticeableErrorEvent{Error: fmt.Errorf("failed to renew the listener port mapping: %w", err), Datetime: time.Now()})
+				timer.Reset(time.Minute)
+				continue
+			}
+			if renewed.ExternalPort != mapping.ExternalPort {
+				log.Warn("port mapping: external port has changed on renewal", zap.Uint16("previous", mapping.ExternalPort), zap.Uint16("current", renewed.ExternalPort))
+			}
+			p.lock.Lock()
+			p.mapping = &renewed
+			p.lock.Unlock()
+			timer.Reset(renewDelay(renewed))
+		case stopRequest := <-p.stopping:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			stopRequest.NotifyDone()
+			return
+		}
+	}
+}
+
+func (p *PortMapper) currentMapping() Mapping {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return *p.mapping
+}
+
+// renewDelay renews at half of the lifetime, as recommended by RFC 6886. Permanent mappings are still refreshed hourly in case the router rebooted.
+func renewDelay(mapping Mapping) time.Duration {
+	if mapping.Lifetime <= 0 {
+		return time.Hour
+	}
+	return mapping.Lifetime / 2
+}
+
+// Stop stops renewing the mapping and removes it from the router, an ongoing Start is aborted
+func (p *PortMapper) Stop(ctx context.Context) {
+	log := logs.GetLogger()
+	p.lock.Lock()
+	if p.cancelStart != nil {
+		p.cancelStart()
+	}
+	if !p.isRunning {
+		p.lock.Unlock()
+		return
+	}
+	p.isRunning = false
+	p.lock.Unlock()
+
+	stopReq := stop.NewRequest(ctx)
+	p.stopping <- stopReq
+	_ = stopReq.AwaitDone()
+
+	p.lock.Lock()
+	mapper, mapping := p.mapper, p.mapping
+	p.mapping = nil
+	p.mapper = nil
+	p.lock.Unlock()
+
+	if err := mapper.Unmap(ctx, *mapping); err != nil {
+		log.Warn("port mapping: failed to remove mapping", zap.Error(err))
+	} else {
+		log.Info("port mapping: removed", zap.Uint16("external-port", mapping.ExternalPort))
+	}
+}
@@ -0,0 +1,180 @@
+package portmapping
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type failingMapper struct{}
+
+func (failingMapper) Name() string {
+	return "failing"
+}
+
+func (failingMapper) Map(context.Context, uint16, time.Duration) (Mapping, error) {
+	return Mapping{}, fmt.Errorf("no router")
+}
+
+func (failingMapper) Unmap(context.Context, Mapping) error {
+	return fmt.Errorf("no router")
+}
+
+type countingMapper struct {
+	lock  *sync.Mutex
+	calls int
+}
+
+func (m *countingMapper) Name() string {
+	return "counting"
+}
+
+func (m *countingMapper) Map(_ context.Context, internalPort uint16, lifetime time.Duration) (Mapping, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls++
+	return Mapping{InternalPort: internalPort, ExternalPort: internalPort, Lifetime: lifetime}, nil
+}
+
+func (m *countingMapper) Unmap(context.Context, Mapping) error {
+	return nil
+}
+
+func (m *countingMapper) count() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.calls
+}
+
+// blockingMapper never answers, like a router that drops the discovery requests
+type blockingMapper struct {
+	started chan struct{}
+}
+
+func (m *blockingMapper) Name() string {
+	return "blocking"
+}
+
+func (m *blockingMapper) Map(ctx context.Context, _ uint16, _ time.Duration) (Mapping, error) {
+	close(m.started)
+	<-ctx.Done()
+	return Mapping{}, ctx.Err()
+}
+
+func (m *blockingMapper) Unmap(context.Context, Mapping) error {
+	return nil
+}
+
+func TestFromConfig(t *testing.T) {
+	p, err := FromConfig(&core.PortMappingConfig{Methods: []string{"upnp", "NATPMP"}, Lifetime: time.Hour})
+	assert.NoError(t, err)
+	assert.Len(t, p.mappers, 2)
+
+	_, err = FromConfig(&core.PortMappingConfig{Methods: []string{"pcp"}, Lifetime: time.Hour})
+	assert.Error(t, err)
+
+	_, err = FromConfig(&core.PortMappingConfig{Methods: []string{"upnp"}, Lifetime: time.Second})
+	assert.Error(t, err)
+
+	p, err = FromConfig(core.PortMappingConfig{}.Default())
+	assert.NoError(t, err)
+	assert.Len(t, p.mappers, 0)
+}
+
+func TestFromConfig_ShouldRequireNatPmpGatewayWhereItCanNotBeDetected(t *testing.T) {
+	supported := defaultGatewaySupported
+	defaultGatewaySupported = false
+	defer func() { defaultGatewaySupported = supported }()
+
+	_, err := FromConfig(&core.PortMappingConfig{Methods: []string{"natpmp"}, Lifetime: time.Hour})
+	assert.Error(t, err)
+	_, err = defaultGateway()
+	assert.Error(t, err)
+
+	_, err = FromConfig(&core.PortMappingConfig{Methods: []string{"natpmp"}, Lifetime: time.Hour, Gateway: "192.168.1.1"})
+	assert.NoError(t, err)
+}
+
+func TestPortMapper_ShouldUseFirstWorkingMapperAndUnmapOnStop(t *testing.T) {
+	gateway := newFakeNatPmp(t)
+	p := NewPortMapper([]Mapper{failingMapper{}, NewNatPmpMapper(gateway.conn.LocalAddr().String())}, time.Hour)
+
+	assert.Equal(t, uint16(7881), p.Start(6881))
+	port, mapped := p.ExternalPort()
+	assert.True(t, mapped)
+	assert.Equal(t, uint16(7881), port)
+	assert.True(t, gateway.mapped(6881))
+
+	p.Stop(context.Background())
+	assert.False(t, gateway.mapped(6881))
+	_, mapped = p.ExternalPort()
+	assert.False(t, mapped)
+}
+
+func TestPortMapper_ShouldFallbackToInternalPortAndNotify(t *testing.T) {
+	errs := make(chan broadcast.NoticeableErrorEvent, 1)
+	sub := broadcast.Subscribe(func(event broadcast.NoticeableErrorEvent) {
+		errs <- event
+	})
+	defer sub.Unsubscribe()
+
+	p := NewPortMapper([]Mapper{failingMapper{}}, time.Hour)
+	assert.Equal(t, uint16(6881), p.Start(6881))
+	_, mapped := p.ExternalPort()
+	assert.False(t, mapped)
+
+	select {
+	case e := <-errs:
+		assert.Contains(t, e.Error.Error(), "6881")
+	case <-time.After(2 * time.Second):
+		t.Fatal("NoticeableErrorEvent has not been broadcast")
+	}
+	p.Stop(context.Background()) // should not block
+}
+
+func TestPortMapper_ShouldNotBlockWhileMapping(t *testing.T) {
+	mapper := &blockingMapper{started: make(chan struct{})}
+	p := NewPortMapper([]Mapper{mapper}, time.Hour)
+	port := make(chan uint16)
+	go func() { port <- p.Start(6881) }()
+	<-mapper.started
+
+	_, mapped := p.ExternalPort()
+	assert.False(t, mapped)
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop(context.Background())
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop has waited for the mapping to complete")
+	}
+	select {
+	case p := <-port:
+		assert.Equal(t, uint16(6881), p)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start has not been aborted by Stop")
+	}
+}
+
+func TestPortMapper_ShouldRenewBeforeExpiration(t *testing.T) {
+	mapper := &countingMapper{lock: &sync.Mutex{}}
+	p := NewPortMapper([]Mapper{mapper}, 100*time.Millisecond)
+	p.Start(6881)
+	defer p.Stop(context.Background())
+
+	assert.Eventually(t, func() bool { return mapper.count() >= 3 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func Test_renewDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Minute, renewDelay(Mapping{Lifetime: time.Hour}))
+	assert.Equal(t, time.Hour, renewDelay(Mapping{Lifetime: 0}))
+}
@@ -0,0 +1,301 @@
+package portmapping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/randutils"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	upnpSsdpAddr         = "239.255.255.250:1900"
+	upnpGatewayType      = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	upnpMappingName      = "joal"
+	upnpErrConflict      = 718
+	upnpErrOnlyPermanent = 725
+)
+
+// UpnpMapper maps ports through the WANIPConnection (or WANPPPConnection) service of an UPnP Internet Gateway Device
+type UpnpMapper struct {
+	ssdpAddr   string
+	httpClient *http.Client
+}
+
+func NewUpnpMapper() *UpnpMapper {
+	return &UpnpMapper{
+		ssdpAddr:   upnpSsdpAddr,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (m *UpnpMapper) Name() string {
+	return "upnp"
+}
+
+func (m *UpnpMapper) Map(ctx context.Context, internalPort uint16, lifetime time.Duration) (Mapping, error) {
+	service, err := m.findService(ctx)
+	if err != nil {
+		return Mapping{}, err
+	}
+	localIp, err := localIpToward(service.controlUrl.Host)
+	if err != nil {
+		return Mapping{}, err
+	}
+
+	externalPort := internalPort
+	for attempt := 0; attempt < 4; attempt++ {
+		err = m.addPortMapping(ctx, service, localIp, internalPort, externalPort, lifetime)
+		if upnpErr, ok := err.(*upnpError); ok && upnpErr.code == upnpErrOnlyPermanent && lifetime != 0 {
+			// some routers only accept permanent leases, they are deleted on Unmap anyway
+			lifetime = 0
+			err = m.addPortMapping(ctx, service, localIp, internalPort, externalPort, lifetime)
+		}
+		if upnpErr, ok := err.(*upnpError); ok && upnpErr.code == upnpErrConflict {
+			// the external port is already forwarded to another host, try another one
+			externalPort = uint16(randutils.RangeInclusive(1024, 65535))
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return Mapping{}, fmt.Errorf("upnp: failed to add port mapping: %w", err)
+	}
+	return Mapping{InternalPort: internalPort, ExternalPort: externalPort, Lifetime: lifetime}, nil
+}
+
+func (m *UpnpMapper) Unmap(ctx context.Context, mapping Mapping) error {
+	service, err := m.findService(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = m.soapCall(ctx, service, "DeletePortMapping", []soapArg{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(mapping.ExternalPort)},
+		{"NewProtocol", "TCP"},
+	})
+	if err != nil {
+		return fmt.Errorf("upnp: failed to delete port mapping: %w", err)
+	}
+	return nil
+}
+
+func (m *UpnpMapper) addPortMapping(ctx context.Context, service *upnpService, localIp net.IP, internalPort uint16, externalPort uint16, lifetime time.Duration) error {
+	_, err := m.soapCall(ctx, service, "AddPortMapping", []soapArg{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(externalPort)},
+		{"NewProtocol", "TCP"},
+		{"NewInternalPort", fmt.Sprint(internalPort)},
+		{"NewInternalClient", localIp.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", upnpMappingName},
+		{"NewLeaseDuration", fmt.Sprint(int64(lifetime.Seconds()))},
+	})
+	return err
+}
+
+type upnpService struct {
+	serviceType string
+	controlUrl  *url.URL
+}
+
+// findService discovers the gateway with SSDP and returns its WAN connection service
+func (m *UpnpMapper) findService(ctx context.Context) (*upnpService, error) {
+	location, err := m.discoverGateway(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("upnp: failed to discover gateway: %w", err)
+	}
+	service, err := m.readDescription(ctx, location)
+	if err != nil {
+		return nil, fmt.Errorf("upnp: failed to read gateway description: %w", err)
+	}
+	return service, nil
+}
+
+func (m *UpnpMapper) discoverGateway(ctx context.Context) (*url.URL, error) {
+	ssdpAddr, err := net.ResolveUDPAddr("udp4", m.ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	search := strings.Join([]string{
+		"M-SEARCH * HTTP/1.1",
+		"HOST: " + upnpSsdpAddr,
+		"ST: " + upnpGatewayType,
+		`MAN: "ssdp:discover"`,
+		"MX: 2",
+		"", "",
+	}, "\r\n")
+	if _, err = conn.WriteTo([]byte(search), ssdpAddr); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no gateway answered: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		_ = resp.Body.Close()
+		if !strings.Contains(resp.Header.Get("St"), "InternetGatewayDevice") {
+			continue
+		}
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || location.Host == "" {
+			continue
+		}
+		return location, nil
+	}
+}
+
+type upnpDeviceDescription struct {
+	URLBase string           `xml:"URLBase"`
+	Device  upnpDeviceXmlTag `xml:"device"`
+}
+
+type upnpDeviceXmlTag struct {
+	Devices  []upnpDeviceXmlTag `xml:"deviceList>device"`
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+}
+
+func (d upnpDeviceXmlTag) findWanService() (serviceType string, controlUrl string, found bool) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return s.ServiceType, s.ControlURL, true
+		}
+	}
+	for _, child := range d.Devices {
+		if serviceType, controlUrl, found = child.findWanService(); found {
+			return
+		}
+	}
+	return "", "", false
+}
+
+func (m *UpnpMapper) readDescription(ctx context.Context, location *url.URL) (*upnpService, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gateway responded with status %s", resp.Status)
+	}
+
+	var description upnpDeviceDescription
+	if err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&description); err != nil {
+		return nil, err
+	}
+	serviceType, controlUrl, found := description.Device.findWanService()
+	if !found {
+		return nil, fmt.Errorf("gateway does not provide a WANIPConnection nor a WANPPPConnection service")
+	}
+
+	base := location
+	if description.URLBase != "" {
+		if base, err = url.Parse(description.URLBase); err != nil {
+			return nil, fmt.Errorf("invalid URLBase: %w", err)
+		}
+	}
+	control, err := base.Parse(controlUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid controlURL: %w", err)
+	}
+	return &upnpService{serviceType: serviceType, controlUrl: control}, nil
+}
+
+type soapArg struct {
+	name  string
+	value string
+}
+
+type upnpError struct {
+	code        int
+	description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.code, e.description)
+}
+
+func (m *UpnpMapper) soapCall(ctx context.Context, service *upnpService, action string, args []soapArg) ([]byte, error) {
+	body := &strings.Builder{}
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	body.WriteString(fmt.Sprintf(`<u:%s xmlns:u="%s">`, action, service.serviceType))
+	for _, arg := range args {
+		body.WriteString("<" + arg.name + ">")
+		_ = xml.EscapeText(body, []byte(arg.value))
+		body.WriteString("</" + arg.name + ">")
+	}
+	body.WriteString(fmt.Sprintf(`</u:%s></s:Body></s:Envelope>`, action))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", service.controlUrl.String(), strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, service.serviceType, action))
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Code        int    `xml:"Body>Fault>detail>UPnPError>errorCode"`
+			Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+		}
+		if xml.Unmarshal(respBody, &fault) == nil && fault.Code != 0 {
+			return nil, &upnpError{code: fault.Code, description: fault.Description}
+		}
+		return nil, fmt.Errorf("gateway responded with status %s", resp.Status)
+	}
+	return respBody, nil
+}
+
+// localIpToward returns the local ip used to reach host, this is the address the router has to forward the port to
+func localIpToward(host string) (net.IP, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	conn, err := net.Dial("udp4", host) // nothing is sent, this only selects the route
+	if err != nil {
+		return nil, fmt.Errorf("failed to find local ip toward '%s': %w", host, err)
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,204 @@
+package portmapping
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIgd is an in-process UPnP Internet Gateway Device: an SSDP responder, a device description and a WANIPConnection control endpoint
+type fakeIgd struct {
+	ssdp          net.PacketConn
+	http          *httptest.Server
+	lock          *sync.Mutex
+	mappings      map[uint16]fakeIgdMapping
+	takenPorts    map[uint16]bool
+	permanentOnly bool
+}
+
+type fakeIgdMapping struct {
+	internalPort   uint16
+	internalClient string
+	lease          int
+}
+
+const fakeIgdDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+var soapArgRegex = regexp.MustCompile(`<(New[A-Za-z]+)>([^<]*)</New[A-Za-z]+>`)
+
+func newFakeIgd(t *testing.T) *fakeIgd {
+	igd := &fakeIgd{
+		lock:       &sync.Mutex{},
+		mappings:   map[uint16]fakeIgdMapping{},
+		takenPorts: map[uint16]bool{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(fakeIgdDescription))
+	})
+	mux.HandleFunc("/ctl/IPConn", igd.control)
+	igd.http = httptest.NewServer(mux)
+	t.Cleanup(igd.http.Close)
+
+	ssdp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	igd.ssdp = ssdp
+	t.Cleanup(func() { _ = ssdp.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			_, addr, err := ssdp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nUSN: uuid:fake::urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nLOCATION: %s/rootDesc.xml\r\n\r\n", igd.http.URL)
+			_, _ = ssdp.WriteTo([]byte(resp), addr)
+		}
+	}()
+	return igd
+}
+
+func (igd *fakeIgd) mapper() *UpnpMapper {
+	m := NewUpnpMapper()
+	m.ssdpAddr = igd.ssdp.LocalAddr().String()
+	return m
+}
+
+func (igd *fakeIgd) control(w http.ResponseWriter, r *http.Request) {
+	igd.lock.Lock()
+	defer igd.lock.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	args := map[string]string{}
+	for _, match := range soapArgRegex.FindAllStringSubmatch(string(body), -1) {
+		args[match[1]] = match[2]
+	}
+	externalPort, _ := strconv.Atoi(args["NewExternalPort"])
+
+	switch r.Header.Get("SOAPAction") {
+	case `"urn:schemas-upnp-org:service:WANIPConnection:1#AddPortMapping"`:
+		if igd.takenPorts[uint16(externalPort)] {
+			igd.fault(w, 718, "ConflictInMappingEntry")
+			return
+		}
+		lease, _ := strconv.Atoi(args["NewLeaseDuration"])
+		if igd.permanentOnly && lease != 0 {
+			igd.fault(w, 725, "OnlyPermanentLeasesSupported")
+			return
+		}
+		internalPort, _ := strconv.Atoi(args["NewInternalPort"])
+		igd.mappings[uint16(externalPort)] = fakeIgdMapping{internalPort: uint16(internalPort), internalClient: args["NewInternalClient"], lease: lease}
+	case `"urn:schemas-upnp-org:service:WANIPConnection:1#DeletePortMapping"`:
+		if _, ok := igd.mappings[uint16(externalPort)]; !ok {
+			igd.fault(w, 714, "NoSuchEntryInArray")
+			return
+		}
+		delete(igd.mappings, uint16(externalPort))
+	default:
+		igd.fault(w, 401, "Invalid Action")
+		return
+	}
+	_, _ = w.Write([]byte(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body></s:Body></s:Envelope>`))
+}
+
+func (igd *fakeIgd) fault(w http.ResponseWriter, code int, description string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`, code, description)
+}
+
+func (igd *fakeIgd) mapping(externalPort uint16) (fakeIgdMapping, bool) {
+	igd.lock.Lock()
+	defer igd.lock.Unlock()
+	m, ok := igd.mappings[externalPort]
+	return m, ok
+}
+
+func TestUpnpMapper_ShouldMapAndUnmap(t *testing.T) {
+	igd := newFakeIgd(t)
+	mapper := igd.mapper()
+
+	mapping, err := mapper.Map(context.Background(), 6881, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Mapping{InternalPort: 6881, ExternalPort: 6881, Lifetime: time.Hour}, mapping)
+	m, ok := igd.mapping(6881)
+	assert.True(t, ok)
+	assert.Equal(t, fakeIgdMapping{internalPort: 6881, internalClient: "127.0.0.1", lease: 3600}, m)
+
+	assert.NoError(t, mapper.Unmap(context.Background(), mapping))
+	_, ok = igd.mapping(6881)
+	assert.False(t, ok)
+}
+
+func TestUpnpMapper_ShouldPickAnotherExternalPortOnConflict(t *testing.T) {
+	igd := newFakeIgd(t)
+	igd.takenPorts[6881] = true
+
+	mapping, err := igd.mapper().Map(context.Background(), 6881, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, uint16(6881), mapping.ExternalPort)
+	m, ok := igd.mapping(mapping.ExternalPort)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(6881), m.internalPort)
+}
+
+func TestUpnpMapper_ShouldFallbackToPermanentLease(t *testing.T) {
+	igd := newFakeIgd(t)
+	igd.permanentOnly = true
+
+	mapping, err := igd.mapper().Map(context.Background(), 6881, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Duration(0), mapping.Lifetime)
+}
+
+func TestUpnpMapper_ShouldFailWithoutGateway(t *testing.T) {
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = silent.Close() }()
+	mapper := NewUpnpMapper()
+	mapper.ssdpAddr = silent.LocalAddr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = mapper.Map(ctx, 6881, time.Hour)
+	assert.Error(t, err)
+}
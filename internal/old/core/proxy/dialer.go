@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SchemeHttp    = "http"
+	SchemeHttps   = "https"
+	SchemeSocks5  = "socks5"  // the target host name is resolved locally
+	SchemeSocks5h = "socks5h" // the target host name is resolved by the proxy
+)
+
+const defaultSocksPort = "1080"
+
+// Dialer opens the outbound connections of the core (announces, public ip discovery), either directly or through the
+// configured proxy. A nil *Dialer connects directly.
+//
+// HTTP proxies are only able to relay HTTP requests, they are applied through http.Transport.Proxy (see HttpProxy).
+// SOCKS5 proxies relay both TCP (CONNECT) and UDP (UDP ASSOCIATE) through DialContext.
+// Without configured proxy, the HTTP requests still honour the proxy environment variables (HTTP_PROXY, HTTPS_PROXY, NO_PROXY).
+type Dialer struct {
+	proxyUrl *url.URL
+	options  Options
+	resolver *net.Resolver
+	// direct ignores the proxy environment variables, see NewDirect
+	direct bool
+}
+
+// Options tune the way a Dialer reaches the network, the zero value uses the system defaults
+type Options struct {
+	// LocalIp is the address the sockets are bound to, including the ones to the proxy. nil lets the system pick one.
+	LocalIp net.IP
+	// Hosts resolves these host names to a fixed ip, without querying any DNS.
+	// HTTP proxies resolve the host names themselves, Hosts and DnsServer are refused along with one. They do not apply
+	// either to the HTTP requests sent through a proxy of the environment variables (HTTP_PROXY, HTTPS_PROXY).
+	Hosts map[string]net.IP
+	// DnsServer is the "ip:port" of the DNS server resolving the other host names, empty uses the system resolver.
+	// It does not apply to the host names resolved by a socks5h proxy.
+	DnsServer string
+}
+
+// FromUrl returns a Dialer for the proxy url, an empty url connects directly.
+// Supported schemes are http, https, socks5 and socks5h, credentials are read from the url user info.
+func FromUrl(rawUrl string) (*Dialer, error) {
+	return New(rawUrl, Options{})
+}
+
+// NewDirect returns a Dialer tuned with options which always connects directly, unlike a Dialer without proxy url it
+// does not send the HTTP requests through the proxy of the environment variables (HTTP_PROXY, HTTPS_PROXY)
+func NewDirect(options Options) (*Dialer, error) {
+	d := &Dialer{direct: true}
+	if err := d.setOptions(options); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// New returns a Dialer for the proxy url (see FromUrl) tuned with options
+func New(rawUrl string, options Options) (*Dialer, error) {
+	d := &Dialer{}
+	if strings.TrimSpace(rawUrl) == "" {
+		if err := d.setOptions(options); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	switch u.Scheme {
+	case SchemeHttp, SchemeHttps:
+	case SchemeSocks5, SchemeSocks5h:
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), defaultSocksPort)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme '%s', supported schemes are %s, %s, %s and %s", u.Scheme, SchemeHttp, SchemeHttps, SchemeSocks5, SchemeSocks5h)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy url '%s' has no host", u.Redacted())
+	}
+	d.proxyUrl = u
+	if err := d.setOptions(options); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// WithOptions returns a Dialer going through the same proxy, tuned with options
+func (d *Dialer) WithOptions(options Options) (*Dialer, error) {
+	derived := &Dialer{}
+	if d != nil {
+		derived.proxyUrl = d.proxyUrl
+		derived.direct = d.direct
+	}
+	if err := derived.setOptions(options); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+func (d *Dialer) setOptions(options Options) error {
+	if d.isHttp() && (len(options.Hosts) > 0 || options.DnsServer != "") {
+		// the target host name is sent as is to the proxy, which resolves it on its own
+		return fmt.Errorf("dns overrides can not be applied through the %s proxy %s, it resolves the host names itself", d.proxyUrl.Scheme, d)
+	}
+	hosts := make(map[string]net.IP, len(options.Hosts))
+	for host, ip := range options.Hosts {
+		if ip == nil {
+			return fmt.Errorf("host '%s' is overridden with an empty ip", host)
+		}
+		hosts[strings.ToLower(host)] = ip
+	}
+	options.Hosts = hosts
+
+	if options.DnsServer != "" {
+		server := options.DnsServer
+		if net.ParseIP(server) != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		host, _, err := net.SplitHostPort(server)
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("dns server '%s' must be an ip, optionally followed by a port", options.DnsServer)
+		}
+		options.DnsServer = server
+		d.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return d.netDialer(network).DialContext(ctx, network, server)
+			},
+		}
+	}
+	d.options = options
+	return nil
+}
+
+// netDialer returns a dialer bound to the local ip, resolving host names with the configured dns server
+func (d *Dialer) netDialer(network string) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if d == nil {
+		return dialer
+	}
+	dialer.Resolver = d.resolver
+	if d.options.LocalIp != nil {
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: d.options.LocalIp}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: d.options.LocalIp}
+		}
+	}
+	return dialer
+}
+
+// overriddenHost returns the ip the host is statically resolved to, nil if it is not overridden
+func (d *Dialer) overriddenHost(host string) net.IP {
+	if d == nil {
+		return nil
+	}
+	return d.options.Hosts[strings.ToLower(host)]
+}
+
+// lookupIP resolves the host, network (tcp4, udp6, ...) restricts the family of the ip
+func (d *Dialer) lookupIP(ctx context.Context, network string, host string) (net.IP, error) {
+	if ip := d.overriddenHost(host); ip != nil {
+		return ip, nil
+	}
+	ipNetwork := "ip"
+	switch network[len(network)-1] {
+	case '4':
+		ipNetwork = "ip4"
+	case '6':
+		ipNetwork = "ip6"
+	}
+	resolver := net.DefaultResolver
+	if d != nil && d.resolver != nil {
+		resolver = d.resolver
+	}
+	ips, err := resolver.LookupIP(ctx, ipNetwork, host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+func (d *Dialer) String() string {
+	if d == nil || d.proxyUrl == nil {
+		return "direct"
+	}
+	return d.proxyUrl.Redacted()
+}
+
+func (d *Dialer) isSocks() bool {
+	return d != nil && d.proxyUrl != nil && (d.proxyUrl.Scheme == SchemeSocks5 || d.proxyUrl.Scheme == SchemeSocks5h)
+}
+
+func (d *Dialer) isHttp() bool {
+	return d != nil && d.proxyUrl != nil && (d.proxyUrl.Scheme == SchemeHttp || d.proxyUrl.Scheme == SchemeHttps)
+}
+
+// HttpProxy returns the function to use as http.Transport.Proxy. Without configured proxy the HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY environment variables apply, a SOCKS5 proxy is not an HTTP one and returns nil (it is applied by DialContext).
+// A Dialer of NewDirect returns nil as well.
+func (d *Dialer) HttpProxy() func(*http.Request) (*url.URL, error) {
+	if d.isSocks() || (d != nil && d.direct) {
+		return nil
+	}
+	if d.isHttp() {
+		return http.ProxyURL(d.proxyUrl)
+	}
+	return http.ProxyFromEnvironment
+}
+
+// DialContext connects to addr. network is one of tcp, tcp4, tcp6, udp, udp4 or udp6, the trailing 4 or 6 restricts the
+// family of the target address (when the proxy resolves the host name, socks5h, the family can not be enforced).
+// The connection is direct for HTTP proxies: they are applied by the http.Transport, and can not relay UDP.
+func (d *Dialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	isUdp := strings.HasPrefix(network, "udp")
+	switch {
+	case d.isSocks() && isUdp:
+		return d.socksAssociate(ctx, network, addr)
+	case d.isSocks():
+		return d.socksConnect(ctx, network, addr)
+	case d.isHttp() && isUdp:
+		// sending it directly would leak the real ip
+		return nil, fmt.Errorf("%s proxy can not relay udp traffic to '%s'", d.proxyUrl.Scheme, addr)
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if ip := d.overriddenHost(host); ip != nil {
+			addr = net.JoinHostPort(ip.String(), port)
+		}
+	}
+	return d.netDialer(network).DialContext(ctx, network, addr)
+}
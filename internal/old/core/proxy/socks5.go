@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 client, RFC 1928 (CONNECT and UDP ASSOCIATE) and RFC 1929 (username/password authentication)
+
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodUserPassword = 0x02
+	socksMethodNoAcceptable = 0xff
+
+	socksCmdConnect      = 0x01
+	socksCmdUdpAssociate = 0x03
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksHandshakeTimeout = 30 * time.Second
+	// large enough for any tracker response
+	socksUdpBufferSize = 64 * 1024
+)
+
+var socksReplies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socksConnect opens a TCP connection to addr through the proxy
+func (d *Dialer) socksConnect(ctx context.Context, network string, addr string) (net.Conn, error) {
+	target, err := d.socksTarget(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := d.socksRequest(ctx, socksCmdConnect, target)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// socksAssociate returns a connected UDP socket to addr, relayed by the proxy
+func (d *Dialer) socksAssociate(ctx context.Context, network string, addr string) (net.Conn, error) {
+	target, err := d.socksTarget(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// The client does not know the address it will send from (it may be behind a NAT), let the server learn it
+	ctrl, bound, err := d.socksRequest(ctx, socksCmdUdpAssociate, socksAddr{ip: net.IPv4zero, port: 0})
+	if err != nil {
+		return nil, err
+	}
+	relayIp := bound.ip
+	if relayIp == nil || relayIp.IsUnspecified() {
+		// the server relays on the address it has been contacted on
+		relayIp = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+	relay, err := d.netDialer("udp").DialContext(ctx, "udp", net.JoinHostPort(relayIp.String(), strconv.Itoa(int(bound.port))))
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, fmt.Errorf("failed to open udp socket toward socks relay: %w", err)
+	}
+	header := append([]byte{0, 0, 0}, target.encode()...)
+	return &socksUdpConn{UDPConn: relay.(*net.UDPConn), ctrl: ctrl, header: header, target: target}, nil
+}
+
+// socksTarget resolves the host locally unless the proxy is a socks5h one and the host is not overridden
+func (d *Dialer) socksTarget(ctx context.Context, network string, addr string) (socksAddr, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return socksAddr{}, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return socksAddr{}, fmt.Errorf("invalid port in '%s': %w", addr, err)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return socksAddr{ip: ip, port: uint16(port)}, nil
+	}
+	if d.proxyUrl.Scheme == SchemeSocks5h && d.overriddenHost(host) == nil {
+		if len(host) > 255 {
+			return socksAddr{}, fmt.Errorf("host name '%s' is too long", host)
+		}
+		return socksAddr{host: host, port: uint16(port)}, nil
+	}
+	ip, err := d.lookupIP(ctx, network, host)
+	if err != nil {
+		return socksAddr{}, err
+	}
+	return socksAddr{ip: ip, port: uint16(port)}, nil
+}
+
+// socksRequest connects to the proxy, authenticates and sends the command. The returned connection is the one the
+// command has been sent on, along with the address bound by the server.
+func (d *Dialer) socksRequest(ctx context.Context, cmd byte, target socksAddr) (net.Conn, socksAddr, error) {
+	conn, err := d.netDialer("tcp").DialContext(ctx, "tcp", d.proxyUrl.Host)
+	if err != nil {
+		return nil, socksAddr{}, fmt.Errorf("failed to connect to socks proxy: %w", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	// the end of ctx expires the deadline, which interrupts the pending read or write. The deadline of ctx is not copied
+	// on the connection, the handshake could then time out before ctx reports its error.
+	stopInterrupting := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+
+	bound, err := d.socksHandshake(conn, cmd, target)
+	if !stopInterrupting() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, socksAddr{}, fmt.Errorf("socks proxy %s: %w", d, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, bound, nil
+}
+
+func (d *Dialer) socksHandshake(conn net.Conn, cmd byte, target socksAddr) (socksAddr, error) {
+	methods := []byte{socksMethodNoAuth}
+	if d.proxyUrl.User != nil {
+		methods = []byte{socksMethodNoAuth, socksMethodUserPassword}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return socksAddr{}, err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return socksAddr{}, err
+	}
+	if buf[0] != socksVersion {
+		return socksAddr{}, fmt.Errorf("unexpected protocol version %d", buf[0])
+	}
+	switch buf[1] {
+	case socksMethodNoAuth:
+	case socksMethodUserPassword:
+		if d.proxyUrl.User == nil {
+			return socksAddr{}, errors.New("server requires authentication")
+		}
+		password, _ := d.proxyUrl.User.Password()
+		if err := socksAuthenticate(conn, d.proxyUrl.User.Username(), password); err != nil {
+			return socksAddr{}, err
+		}
+	case socksMethodNoAcceptable:
+		return socksAddr{}, errors.New("no acceptable authentication method")
+	default:
+		return socksAddr{}, fmt.Errorf("server selected an unsupported authentication method %d", buf[1])
+	}
+
+	if _, err := conn.Write(append([]byte{socksVersion, cmd, 0}, target.encode()...)); err != nil {
+		return socksAddr{}, err
+	}
+	buf = make([]byte, 3)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return socksAddr{}, err
+	}
+	if buf[1] != 0x00 {
+		reason, ok := socksReplies[buf[1]]
+		if !ok {
+			reason = fmt.Sprintf("unknown error %d", buf[1])
+		}
+		return socksAddr{}, fmt.Errorf("request to '%s' refused: %s", target, reason)
+	}
+	return readSocksAddr(conn)
+}
+
+func socksAuthenticate(conn net.Conn, username string, password string) error {
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("username and password must not exceed 255 bytes")
+	}
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[1] != 0x00 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+// socksAddr is either an ip or a host name, along with a port
+type socksAddr struct {
+	ip   net.IP
+	host string
+	port uint16
+}
+
+func (a socksAddr) Network() string {
+	return "udp"
+}
+
+func (a socksAddr) String() string {
+	if a.ip != nil {
+		return net.JoinHostPort(a.ip.String(), strconv.Itoa(int(a.port)))
+	}
+	return net.JoinHostPort(a.host, strconv.Itoa(int(a.port)))
+}
+
+func (a socksAddr) encode() []byte {
+	var b []byte
+	switch {
+	case a.ip.To4() != nil:
+		b = append([]byte{socksAtypIPv4}, a.ip.To4()...)
+	case a.ip != nil:
+		b = append([]byte{socksAtypIPv6}, a.ip.To16()...)
+	default:
+		b = append([]byte{socksAtypDomain, byte(len(a.host))}, a.host...)
+	}
+	return binary.BigEndian.AppendUint16(b, a.port)
+}
+
+func readSocksAddr(r io.Reader) (socksAddr, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return socksAddr{}, err
+	}
+	var a socksAddr
+	switch atyp[0] {
+	case socksAtypIPv4:
+		a.ip = make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(r, a.ip); err != nil {
+			return socksAddr{}, err
+		}
+	case socksAtypIPv6:
+		a.ip = make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(r, a.ip); err != nil {
+			return socksAddr{}, err
+		}
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return socksAddr{}, err
+		}
+		host := make([]byte, length[0])
+		if _, err := io.ReadFull(r, host); err != nil {
+			return socksAddr{}, err
+		}
+		a.host = string(host)
+	default:
+		return socksAddr{}, fmt.Errorf("unknown address type %d", atyp[0])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return socksAddr{}, err
+	}
+	a.port = binary.BigEndian.Uint16(port)
+	return a, nil
+}
+
+// socksUdpConn is a UDP socket connected to a target through a SOCKS5 relay. Each datagram is prefixed with the
+// SOCKS5 UDP request header. The association lasts as long as the control connection is open.
+type socksUdpConn struct {
+	*net.UDPConn
+	ctrl   net.Conn
+	header []byte
+	target socksAddr
+}
+
+func (c *socksUdpConn) Write(b []byte) (int, error) {
+	packet := make([]byte, 0, len(c.header)+len(b))
+	packet = append(packet, c.header...)
+	packet = append(packet, b...)
+	if _, err := c.UDPConn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socksUdpConn) Read(b []byte) (int, error) {
+	buf := make([]byte, socksUdpBufferSize)
+	for {
+		n, err := c.UDPConn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n < 4 || buf[2] != 0 {
+			continue // fragmented datagrams are not supported, drop them as RFC 1928 allows
+		}
+		r := bytes.NewReader(buf[3:n])
+		if _, err := readSocksAddr(r); err != nil {
+			continue
+		}
+		return copy(b, buf[n-r.Len():n]), nil
+	}
+}
+
+func (c *socksUdpConn) RemoteAddr() net.Addr {
+	return c.target
+}
+
+func (c *socksUdpConn) Close() error {
+	_ = c.ctrl.Close()
+	return c.UDPConn.Close()
+}
@@ -0,0 +1,316 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSocksServer is an in-process SOCKS5 server supporting CONNECT and UDP ASSOCIATE
+type fakeSocksServer struct {
+	listener net.Listener
+	username string
+	password string
+	lock     *sync.Mutex
+	targets  []string
+	// stallAssociate leaves the UDP ASSOCIATE requests unanswered
+	stallAssociate bool
+}
+
+func newFakeSocksServer(t *testing.T, username string, password string) *fakeSocksServer {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSocksServer{listener: listener, username: username, password: password, lock: &sync.Mutex{}}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSocksServer) url(scheme string) string {
+	if s.username == "" {
+		return fmt.Sprintf("%s://%s", scheme, s.listener.Addr())
+	}
+	return fmt.Sprintf("%s://%s:%s@%s", scheme, s.username, s.password, s.listener.Addr())
+}
+
+func (s *fakeSocksServer) recordedTargets() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.targets...)
+}
+
+func (s *fakeSocksServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	wanted := byte(socksMethodNoAuth)
+	if s.username != "" {
+		wanted = socksMethodUserPassword
+	}
+	if !containsByte(methods, wanted) {
+		_, _ = conn.Write([]byte{socksVersion, socksMethodNoAcceptable})
+		return
+	}
+	_, _ = conn.Write([]byte{socksVersion, wanted})
+	if wanted == socksMethodUserPassword {
+		buf := make([]byte, 2)
+		_, _ = io.ReadFull(conn, buf)
+		username := make([]byte, buf[1])
+		_, _ = io.ReadFull(conn, username)
+		_, _ = io.ReadFull(conn, buf[:1])
+		password := make([]byte, buf[0])
+		_, _ = io.ReadFull(conn, password)
+		if string(username) != s.username || string(password) != s.password {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+	}
+
+	request := make([]byte, 3)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	target, err := readSocksAddr(conn)
+	if err != nil {
+		return
+	}
+	switch request[1] {
+	case socksCmdConnect:
+		s.lock.Lock()
+		s.targets = append(s.targets, "tcp "+target.String())
+		s.lock.Unlock()
+		upstream, err := net.Dial("tcp", target.String())
+		if err != nil {
+			_, _ = conn.Write(append([]byte{socksVersion, 0x05, 0}, socksAddr{ip: net.IPv4zero}.encode()...))
+			return
+		}
+		defer func() { _ = upstream.Close() }()
+		local := upstream.LocalAddr().(*net.TCPAddr)
+		_, _ = conn.Write(append([]byte{socksVersion, 0, 0}, socksAddr{ip: local.IP, port: uint16(local.Port)}.encode()...))
+		go func() { _, _ = io.Copy(upstream, conn) }()
+		_, _ = io.Copy(conn, upstream)
+	case socksCmdUdpAssociate:
+		if s.stallAssociate {
+			_, _ = io.Copy(io.Discard, conn)
+			return
+		}
+		relay, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer func() { _ = relay.Close() }()
+		// reply with an unspecified address, the client has to relay on the address of the control connection
+		_, _ = conn.Write(append([]byte{socksVersion, 0, 0}, socksAddr{ip: net.IPv4zero, port: uint16(relay.LocalAddr().(*net.UDPAddr).Port)}.encode()...))
+		go s.relayUdp(relay)
+		_, _ = io.Copy(io.Discard, conn) // the association ends with the control connection
+	default:
+		_, _ = conn.Write(append([]byte{socksVersion, 0x07, 0}, socksAddr{ip: net.IPv4zero}.encode()...))
+	}
+}
+
+func (s *fakeSocksServer) relayUdp(relay *net.UDPConn) {
+	var client *net.UDPAddr
+	buf := make([]byte, socksUdpBufferSize)
+	for {
+		n, from, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			client = from
+			r := bytes.NewReader(buf[3:n])
+			target, err := readSocksAddr(r)
+			if err != nil {
+				continue
+			}
+			s.lock.Lock()
+			s.targets = append(s.targets, "udp "+target.String())
+			s.lock.Unlock()
+			dst, err := net.ResolveUDPAddr("udp4", target.String())
+			if err != nil {
+				continue
+			}
+			_, _ = relay.WriteToUDP(buf[n-r.Len():n], dst)
+			continue
+		}
+		packet := append([]byte{0, 0, 0}, socksAddr{ip: from.IP, port: uint16(from.Port)}.encode()...)
+		_, _ = relay.WriteToUDP(append(packet, buf[:n]...), client)
+	}
+}
+
+func containsByte(b []byte, c byte) bool {
+	for _, v := range b {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+func newUdpEchoServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func httpClientFor(d *Dialer) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:       d.HttpProxy(),
+			DialContext: d.DialContext,
+		},
+	}
+}
+
+func TestDialer_ShouldRelayHttpThroughSocks5(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	socks := newFakeSocksServer(t, "", "")
+	d, err := FromUrl(socks.url(SchemeSocks5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := httpClientFor(d).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, []string{"tcp " + server.Listener.Addr().String()}, socks.recordedTargets())
+}
+
+func TestDialer_ShouldAuthenticateAgainstSocks5(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	socks := newFakeSocksServer(t, "joal", "s3cr3t")
+
+	d, _ := FromUrl(socks.url(SchemeSocks5))
+	conn, err := d.DialContext(context.Background(), "tcp4", server.Listener.Addr().String())
+	assert.NoError(t, err)
+	if conn != nil {
+		_ = conn.Close()
+	}
+
+	d, _ = FromUrl(fmt.Sprintf("socks5://joal:wrong@%s", socks.listener.Addr()))
+	_, err = d.DialContext(context.Background(), "tcp4", server.Listener.Addr().String())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "authentication failed")
+		assert.NotContains(t, err.Error(), "wrong", "password should be redacted")
+	}
+
+	d, _ = FromUrl(fmt.Sprintf("socks5://%s", socks.listener.Addr()))
+	_, err = d.DialContext(context.Background(), "tcp4", server.Listener.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestDialer_ShouldRelayUdpThroughSocks5(t *testing.T) {
+	echo := newUdpEchoServer(t)
+	socks := newFakeSocksServer(t, "joal", "s3cr3t")
+	d, _ := FromUrl(socks.url(SchemeSocks5))
+
+	conn, err := d.DialContext(context.Background(), "udp4", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf[:n]))
+	assert.Equal(t, echo.LocalAddr().String(), conn.RemoteAddr().String())
+	assert.Equal(t, []string{"udp " + echo.LocalAddr().String()}, socks.recordedTargets())
+}
+
+func TestDialer_UdpThroughSocks5ShouldHonourContext(t *testing.T) {
+	socks := newFakeSocksServer(t, "", "")
+	socks.stallAssociate = true
+	d, _ := FromUrl(socks.url(SchemeSocks5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := d.DialContext(ctx, "udp4", "127.0.0.1:6969")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), socksHandshakeTimeout)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = d.DialContext(ctx, "udp4", "127.0.0.1:6969")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDialer_Socks5hShouldLetTheProxyResolveHostNames(t *testing.T) {
+	echo := newUdpEchoServer(t)
+	port := echo.LocalAddr().(*net.UDPAddr).Port
+
+	tests := []struct {
+		scheme     string
+		wantTarget string
+	}{
+		{scheme: SchemeSocks5, wantTarget: fmt.Sprintf("udp 127.0.0.1:%d", port)},
+		{scheme: SchemeSocks5h, wantTarget: fmt.Sprintf("udp localhost:%d", port)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			socks := newFakeSocksServer(t, "", "")
+			d, _ := FromUrl(socks.url(tt.scheme))
+			conn, err := d.DialContext(context.Background(), "udp4", fmt.Sprintf("localhost:%d", port))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+			_, _ = conn.Write([]byte("ping"))
+			_, err = conn.Read(make([]byte, 64))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{tt.wantTarget}, socks.recordedTargets())
+		})
+	}
+}
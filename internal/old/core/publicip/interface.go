@@ -0,0 +1,54 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// InterfaceResolver returns the public addresses of a local network interface, private ranges are ignored
+type InterfaceResolver struct {
+	name      string
+	listAddrs func(name string) ([]net.Addr, error)
+}
+
+func NewInterfaceResolver(name string) *InterfaceResolver {
+	return &InterfaceResolver{
+		name:      name,
+		listAddrs: interfaceAddrs,
+	}
+}
+
+func interfaceAddrs(name string) ([]net.Addr, error) {
+	if name == "" {
+		return net.InterfaceAddrs()
+	}
+	i, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return i.Addrs()
+}
+
+func (r *InterfaceResolver) Resolve(context.Context) (Addresses, error) {
+	addrs, err := r.listAddrs(r.name)
+	if err != nil {
+		return Addresses{}, fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	var found Addresses
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() {
+			continue
+		}
+		if found.IPv4 == nil && ipNet.IP.To4() != nil {
+			found.IPv4 = ipNet.IP.To4()
+		} else if found.IPv6 == nil && ipNet.IP.To4() == nil {
+			found.IPv6 = ipNet.IP
+		}
+	}
+	if found.IsEmpty() {
+		return Addresses{}, fmt.Errorf("no public address found on interface '%s'", r.name)
+	}
+	return found, nil
+}
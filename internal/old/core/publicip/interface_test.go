@@ -0,0 +1,45 @@
+package publicip
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func ipNet(cidr string) *net.IPNet {
+	ip, n, _ := net.ParseCIDR(cidr)
+	n.IP = ip
+	return n
+}
+
+func TestInterfaceResolver_ShouldIgnoreNonPublicAddresses(t *testing.T) {
+	r := NewInterfaceResolver("eth0")
+	r.listAddrs = func(name string) ([]net.Addr, error) {
+		assert.Equal(t, "eth0", name)
+		return []net.Addr{
+			ipNet("127.0.0.1/8"),
+			ipNet("192.168.1.10/24"),
+			ipNet("fe80::1/64"),
+			ipNet("fd00::1/64"),
+			ipNet("203.0.113.1/24"),
+			ipNet("2001:db8::1/64"),
+			ipNet("203.0.113.2/24"),
+		}, nil
+	}
+
+	addresses, err := r.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.1", addresses.IPv4.String())
+	assert.Equal(t, "2001:db8::1", addresses.IPv6.String())
+}
+
+func TestInterfaceResolver_ShouldFailWithoutPublicAddress(t *testing.T) {
+	r := NewInterfaceResolver("")
+	r.listAddrs = func(string) ([]net.Addr, error) {
+		return []net.Addr{ipNet("10.0.0.1/8")}, nil
+	}
+
+	_, err := r.Resolve(context.Background())
+	assert.Error(t, err)
+}
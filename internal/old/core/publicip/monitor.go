@@ -0,0 +1,137 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/stop"
+	"go.uber.org/zap"
+	"net"
+	"sync"
+	"time"
+)
+
+const resolveTimeout = 30 * time.Second
+
+// Monitor keeps the public ips up to date by resolving them periodically.
+// When they change, a PublicIpChangedEvent is broadcast and onChange is called, so the torrents can re-announce the new ips.
+type Monitor struct {
+	resolver        Resolver
+	refreshInterval time.Duration
+	onChange        func(previous Addresses, current Addresses)
+	addresses       Addresses
+	isRunning       bool
+	stopping        stop.Chan
+	lock            *sync.RWMutex
+}
+
+func NewMonitor(resolver Resolver, refreshInterval time.Duration, onChange func(previous Addresses, current Addresses)) *Monitor {
+	return &Monitor{
+		resolver:        resolver,
+		refreshInterval: refreshInterval,
+		onChange:        onChange,
+		stopping:        stop.NewChan(),
+		lock:            &sync.RWMutex{},
+	}
+}
+
+// Start blocks until the public ips are resolved, then refreshes them every refreshInterval in background
+func (m *Monitor) Start(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.isRunning {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addresses, err := m.resolver.Resolve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve public ip: %w", err)
+	}
+	m.addresses = addresses
+	m.isRunning = true
+	logs.GetLogger().Info("public ip: resolved", zap.Stringer("ipv4", addresses.IPv4), zap.Stringer("ipv6", addresses.IPv6))
+
+	if m.refreshInterval <= 0 {
+		return nil
+	}
+	go func(m *Monitor) {
+		refreshTicker := time.NewTicker(m.refreshInterval)
+		for {
+			select {
+			case <-refreshTicker.C:
+				m.refresh()
+			case stopRequest := <-m.stopping:
+				refreshTicker.Stop()
+				stopRequest.NotifyDone()
+				return
+			}
+		}
+	}(m)
+	return nil
+}
+
+func (m *Monitor) Stop(ctx context.Context) {
+	m.lock.Lock()
+	if !m.isRunning {
+		m.lock.Unlock()
+		return
+	}
+	m.isRunning = false
+	m.lock.Unlock()
+
+	if m.refreshInterval <= 0 {
+		return
+	}
+	stopReq := stop.NewRequest(ctx)
+	m.stopping <- stopReq
+	_ = stopReq.AwaitDone()
+}
+
+// Addresses returns the last known public ips
+func (m *Monitor) Addresses() Addresses {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.addresses
+}
+
+// ReportExternalIp feeds the resolver with the ip a tracker has seen the announce coming from, if it relies on it.
+// The ip is taken into account on the next refresh.
+func (m *Monitor) ReportExternalIp(ip net.IP) {
+	if reporter, ok := m.resolver.(ExternalIpReporter); ok {
+		reporter.ReportExternalIp(ip)
+	}
+}
+
+func (m *Monitor) refresh() {
+	log := logs.GetLogger()
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addresses, err := m.resolver.Resolve(ctx)
+	if err != nil {
+		// keep on announcing the last known ips
+		log.Warn("public ip: failed to refresh", zap.Error(err))
+		return
+	}
+
+	m.lock.Lock()
+	previous := m.addresses
+	m.addresses = addresses
+	m.lock.Unlock()
+	if previous.Equal(addresses) {
+		return
+	}
+
+	log.Info("public ip: changed", zap.Stringer("previous", previous), zap.Stringer("current", addresses))
+	broadcast.EmitPublicIpChanged(broadcast.PublicIpChangedEvent{
+		PreviousIPv4: previous.IPv4,
+		PreviousIPv6: previous.IPv6,
+		IPv4:         addresses.IPv4,
+		IPv6:         addresses.IPv6,
+	})
+	if m.onChange != nil {
+		m.onChange(previous, addresses)
+	}
+}
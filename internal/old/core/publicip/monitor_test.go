@@ -0,0 +1,94 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMonitor_StartShouldFailIfNoIpIsResolved(t *testing.T) {
+	m := NewMonitor(resolverFunc(func(context.Context) (Addresses, error) {
+		return Addresses{}, fmt.Errorf("nope")
+	}), time.Hour, nil)
+
+	assert.Error(t, m.Start(context.Background()))
+}
+
+func TestMonitor_ShouldNotifyWhenIpChanges(t *testing.T) {
+	lock := &sync.Mutex{}
+	current := Addresses{IPv4: net.ParseIP("203.0.113.1")}
+	resolver := resolverFunc(func(context.Context) (Addresses, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return current, nil
+	})
+
+	events := make(chan broadcast.PublicIpChangedEvent, 5)
+	sub := broadcast.Subscribe(func(event broadcast.PublicIpChangedEvent) {
+		events <- event
+	})
+	defer sub.Unsubscribe()
+
+	changes := make(chan Addresses, 5)
+	m := NewMonitor(resolver, 10*time.Millisecond, func(_ Addresses, c Addresses) {
+		changes <- c
+	})
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(context.Background())
+
+	// let a few refreshes go by with the same ip
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, changes, 0)
+
+	lock.Lock()
+	current = Addresses{IPv4: net.ParseIP("203.0.113.2")}
+	lock.Unlock()
+
+	select {
+	case c := <-changes:
+		assert.Equal(t, "203.0.113.2", c.IPv4.String())
+	case <-time.After(2 * time.Second):
+		t.Fatal("onChange has not been called")
+	}
+	select {
+	case e := <-events:
+		assert.Equal(t, "203.0.113.1", e.PreviousIPv4.String())
+		assert.Equal(t, "203.0.113.2", e.IPv4.String())
+	case <-time.After(2 * time.Second):
+		t.Fatal("PublicIpChangedEvent has not been broadcast")
+	}
+	assert.Equal(t, "203.0.113.2", m.Addresses().IPv4.String())
+}
+
+func TestMonitor_ShouldKeepLastKnownIpsOnRefreshFailure(t *testing.T) {
+	calls := 0
+	m := NewMonitor(resolverFunc(func(context.Context) (Addresses, error) {
+		calls++
+		if calls > 1 {
+			return Addresses{}, fmt.Errorf("nope")
+		}
+		return Addresses{IPv6: net.ParseIP("2001:db8::1")}, nil
+	}), 0, nil)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m.refresh()
+	assert.Equal(t, "2001:db8::1", m.Addresses().IPv6.String())
+}
+
+func TestMonitor_ReportExternalIpShouldReachTheResolver(t *testing.T) {
+	tracker := NewTrackerResolver()
+	m := NewMonitor(tracker, 0, nil)
+	m.ReportExternalIp(net.ParseIP("2001:db8::5"))
+
+	assert.NoError(t, m.Start(context.Background()))
+	assert.Equal(t, "2001:db8::5", m.Addresses().IPv6.String())
+}
@@ -0,0 +1,143 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProvidersResolver asks web services for the ip they see the request coming from.
+// All the providers are queried at once, over IPv4 and over IPv6. An ip is accepted when at least quorum providers agree.
+type ProvidersResolver struct {
+	urls    []string
+	quorum  int
+	clients map[string]*http.Client
+}
+
+func NewProvidersResolver(urls []string, quorum int, dialer *proxy.Dialer) (*ProvidersResolver, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("public ip providers list is empty")
+	}
+	if quorum < 1 || quorum > len(urls) {
+		return nil, fmt.Errorf("public ip providers quorum must be between 1 and %d (the number of providers), found %d", len(urls), quorum)
+	}
+	return &ProvidersResolver{
+		urls:   urls,
+		quorum: quorum,
+		clients: map[string]*http.Client{
+			"tcp4": newProviderClient(dialer, "tcp4"),
+			"tcp6": newProviderClient(dialer, "tcp6"),
+		},
+	}, nil
+}
+
+// newProviderClient returns a client that only dials over network, so the ip returned by the providers belongs to this family.
+// When a proxy is used, the ip is the one the proxy exits from: for an HTTP proxy, network is the family of the connection
+// to the proxy, for a SOCKS5 one it is the family of the connection to the provider.
+func newProviderClient(dialer *proxy.Dialer, network string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: dialer.HttpProxy(),
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			TLSHandshakeTimeout:   10 * time.Second,
+			DisableKeepAlives:     true, // no need to keep connection opened
+			IdleConnTimeout:       10 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+		},
+		Timeout: 10 * time.Second,
+	}
+}
+
+func (r *ProvidersResolver) Resolve(ctx context.Context) (Addresses, error) {
+	var found Addresses
+	var ipv4Err, ipv6Err error
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		found.IPv4, ipv4Err = r.resolveOver(ctx, "tcp4")
+	}()
+	go func() {
+		defer wg.Done()
+		found.IPv6, ipv6Err = r.resolveOver(ctx, "tcp6")
+	}()
+	wg.Wait()
+
+	if found.IsEmpty() {
+		return Addresses{}, fmt.Errorf("public ip providers: ipv4: %v, ipv6: %v", ipv4Err, ipv6Err)
+	}
+	return found, nil
+}
+
+func (r *ProvidersResolver) resolveOver(ctx context.Context, network string) (net.IP, error) {
+	log := logs.GetLogger()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // once the quorum is reached, the remaining queries are useless
+
+	type answer struct {
+		ip  net.IP
+		err error
+	}
+	answers := make(chan answer, len(r.urls))
+	for _, providerUrl := range r.urls {
+		go func(providerUrl string) {
+			ip, err := queryProvider(ctx, r.clients[network], providerUrl)
+			if err == nil && (network == "tcp4") != (ip.To4() != nil) {
+				// the provider answered with an address of the other family (proxy, NAT64, ...)
+				err = fmt.Errorf("provider '%s' answered '%s' over %s", providerUrl, ip, network)
+			}
+			answers <- answer{ip: ip, err: err}
+		}(providerUrl)
+	}
+
+	votes := make(map[string]int)
+	failures := 0
+	for range r.urls {
+		a := <-answers
+		if a.err != nil {
+			failures++
+			log.Debug("public ip provider failed", zap.String("network", network), zap.Error(a.err))
+			continue
+		}
+		votes[a.ip.String()]++
+		if votes[a.ip.String()] >= r.quorum {
+			return a.ip, nil
+		}
+	}
+	return nil, fmt.Errorf("quorum of %d not reached over %s (%d providers failed, answers: %v)", r.quorum, network, failures, votes)
+}
+
+func queryProvider(ctx context.Context, client *http.Client, providerUrl string) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", providerUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from '%s': %w", providerUrl, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("provider '%s' responded with status %s", providerUrl, resp.Status)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("provider '%s' responded with an invalid ip", providerUrl)
+	}
+	return ip, nil
+}
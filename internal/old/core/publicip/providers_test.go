@@ -0,0 +1,63 @@
+package publicip
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newIpServer(t *testing.T, body string, status int) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestNewProvidersResolver_ShouldValidateQuorum(t *testing.T) {
+	_, err := NewProvidersResolver([]string{}, 1, nil)
+	assert.Error(t, err)
+	_, err = NewProvidersResolver([]string{"http://a"}, 0, nil)
+	assert.Error(t, err)
+	_, err = NewProvidersResolver([]string{"http://a"}, 2, nil)
+	assert.Error(t, err)
+	_, err = NewProvidersResolver([]string{"http://a", "http://b"}, 2, nil)
+	assert.NoError(t, err)
+}
+
+func TestProvidersResolver_ShouldResolveWhenQuorumIsReached(t *testing.T) {
+	r, _ := NewProvidersResolver([]string{
+		newIpServer(t, "server error", 500).URL,
+		newIpServer(t, "203.0.113.1", 200).URL,
+		newIpServer(t, "203.0.113.2", 200).URL,
+		newIpServer(t, "203.0.113.1\n", 200).URL,
+	}, 2, nil)
+
+	addresses, err := r.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.1", addresses.IPv4.String())
+	assert.Nil(t, addresses.IPv6) // httptest servers only listen on ipv4
+}
+
+func TestProvidersResolver_ShouldFailWhenQuorumIsNotReached(t *testing.T) {
+	r, _ := NewProvidersResolver([]string{
+		newIpServer(t, "not an ip", 200).URL,
+		newIpServer(t, "203.0.113.1", 200).URL,
+		newIpServer(t, "203.0.113.2", 200).URL,
+	}, 2, nil)
+
+	_, err := r.Resolve(context.Background())
+	assert.Error(t, err)
+}
+
+func TestProvidersResolver_ShouldIgnoreAddressOfTheOtherFamily(t *testing.T) {
+	r, _ := NewProvidersResolver([]string{
+		newIpServer(t, "2001:db8::1", 200).URL,
+	}, 1, nil)
+
+	_, err := r.Resolve(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"net"
+	"strings"
+)
+
+// Addresses holds the public ips of the host, either one may be nil if the host has no public address for this family
+type Addresses struct {
+	IPv4 net.IP
+	IPv6 net.IP
+}
+
+func (a Addresses) IsEmpty() bool {
+	return a.IPv4 == nil && a.IPv6 == nil
+}
+
+func (a Addresses) Equal(other Addresses) bool {
+	return a.IPv4.Equal(other.IPv4) && a.IPv6.Equal(other.IPv6)
+}
+
+func (a Addresses) String() string {
+	return fmt.Sprintf("ipv4=%v ipv6=%v", a.IPv4, a.IPv6)
+}
+
+// with fills the missing families with the ones of other
+func (a Addresses) with(other Addresses) Addresses {
+	if a.IPv4 == nil {
+		a.IPv4 = other.IPv4
+	}
+	if a.IPv6 == nil {
+		a.IPv6 = other.IPv6
+	}
+	return a
+}
+
+type Resolver interface {
+	// Resolve returns the public addresses found, an error is returned if none is found
+	Resolve(ctx context.Context) (Addresses, error)
+}
+
+// ExternalIpReporter is implemented by resolvers relying on the ip the trackers have seen the announces coming from
+type ExternalIpReporter interface {
+	ReportExternalIp(ip net.IP)
+}
+
+const (
+	StaticStrategy    = "static"
+	ProvidersStrategy = "providers"
+	TrackerStrategy   = "tracker"
+	InterfaceStrategy = "interface"
+)
+
+func FromConfig(conf *core.PublicIpConfig, dialer *proxy.Dialer) (Resolver, error) {
+	if len(conf.Strategies) == 0 {
+		return nil, fmt.Errorf("at least one public ip strategy is required")
+	}
+	var resolvers []Resolver
+	for _, strategy := range conf.Strategies {
+		switch strings.ToLower(strategy) {
+		case StaticStrategy:
+			if conf.Static == nil {
+				return nil, fmt.Errorf("public ip strategy '%s' requires the 'static' configuration", strategy)
+			}
+			r, err := NewStaticResolver(conf.Static.IPv4, conf.Static.IPv6)
+			if err != nil {
+				return nil, err
+			}
+			resolvers = append(resolvers, r)
+		case ProvidersStrategy:
+			if conf.Providers == nil {
+				return nil, fmt.Errorf("public ip strategy '%s' requires the 'providers' configuration", strategy)
+			}
+			r, err := NewProvidersResolver(conf.Providers.Urls, conf.Providers.Quorum, dialer)
+			if err != nil {
+				return nil, err
+			}
+			resolvers = append(resolvers, r)
+		case TrackerStrategy:
+			resolvers = append(resolvers, NewTrackerResolver())
+		case InterfaceStrategy:
+			name := ""
+			if conf.Interface != nil {
+				name = conf.Interface.Name
+			}
+			resolvers = append(resolvers, NewInterfaceResolver(name))
+		default:
+			return nil, fmt.Errorf("public ip strategy '%s' is not supported", strategy)
+		}
+	}
+	if len(resolvers) == 1 {
+		if _, ok := resolvers[0].(*TrackerResolver); ok {
+			// Trackers can only report an ip once an announce has been sent, which needs a public ip
+			return nil, fmt.Errorf("public ip strategy '%s' needs to be followed by another strategy to use until a tracker reports an ip", TrackerStrategy)
+		}
+		return resolvers[0], nil
+	}
+	return &chainResolver{resolvers: resolvers}, nil
+}
+
+// chainResolver asks each resolver in turn, for each family the first resolver to find an ip wins
+type chainResolver struct {
+	resolvers []Resolver
+}
+
+func (c *chainResolver) Resolve(ctx context.Context) (Addresses, error) {
+	var found Addresses
+	var errs []string
+	for _, r := range c.resolvers {
+		addresses, err := r.Resolve(ctx)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		found = found.with(addresses)
+		if found.IPv4 != nil && found.IPv6 != nil {
+			break
+		}
+	}
+	if found.IsEmpty() {
+		return Addresses{}, fmt.Errorf("no public ip found: %s", strings.Join(errs, ", "))
+	}
+	return found, nil
+}
+
+func (c *chainResolver) ReportExternalIp(ip net.IP) {
+	for _, r := range c.resolvers {
+		if reporter, ok := r.(ExternalIpReporter); ok {
+			reporter.ReportExternalIp(ip)
+		}
+	}
+}
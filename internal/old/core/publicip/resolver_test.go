@@ -0,0 +1,102 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+type resolverFunc func(ctx context.Context) (Addresses, error)
+
+func (f resolverFunc) Resolve(ctx context.Context) (Addresses, error) {
+	return f(ctx)
+}
+
+func TestFromConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategies []string
+		wantErr    bool
+	}{
+		{name: "shouldFailWithoutStrategy", strategies: []string{}, wantErr: true},
+		{name: "shouldFailWithUnknownStrategy", strategies: []string{"dns"}, wantErr: true},
+		{name: "shouldFailWithTrackerAlone", strategies: []string{"tracker"}, wantErr: true},
+		{name: "shouldBuildStatic", strategies: []string{"static"}},
+		{name: "shouldBuildProviders", strategies: []string{"providers"}},
+		{name: "shouldBuildInterface", strategies: []string{"interface"}},
+		{name: "shouldBuildChain", strategies: []string{"tracker", "providers", "static"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := core.PublicIpConfig{}.Default()
+			conf.Static = &core.StaticPublicIpConfig{IPv4: "203.0.113.1"}
+			conf.Strategies = tt.strategies
+			_, err := FromConfig(conf, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestChainResolver_ShouldFillEachFamilyWithTheFirstFound(t *testing.T) {
+	calls := 0
+	chain := &chainResolver{resolvers: []Resolver{
+		resolverFunc(func(context.Context) (Addresses, error) {
+			calls++
+			return Addresses{}, fmt.Errorf("nope")
+		}),
+		resolverFunc(func(context.Context) (Addresses, error) {
+			calls++
+			return Addresses{IPv4: net.ParseIP("203.0.113.1")}, nil
+		}),
+		resolverFunc(func(context.Context) (Addresses, error) {
+			calls++
+			return Addresses{IPv4: net.ParseIP("203.0.113.2"), IPv6: net.ParseIP("2001:db8::1")}, nil
+		}),
+		resolverFunc(func(context.Context) (Addresses, error) {
+			calls++
+			return Addresses{IPv6: net.ParseIP("2001:db8::2")}, nil
+		}),
+	}}
+
+	addresses, err := chain.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.1", addresses.IPv4.String())
+	assert.Equal(t, "2001:db8::1", addresses.IPv6.String())
+	assert.Equal(t, 3, calls, "should stop once both families are found")
+}
+
+func TestChainResolver_ShouldFailIfNoneFound(t *testing.T) {
+	chain := &chainResolver{resolvers: []Resolver{
+		resolverFunc(func(context.Context) (Addresses, error) {
+			return Addresses{}, fmt.Errorf("nope")
+		}),
+		NewTrackerResolver(),
+	}}
+
+	_, err := chain.Resolve(context.Background())
+	assert.Error(t, err)
+}
+
+func TestChainResolver_ShouldForwardExternalIpToTrackerResolver(t *testing.T) {
+	tracker := NewTrackerResolver()
+	chain := &chainResolver{resolvers: []Resolver{tracker}}
+	chain.ReportExternalIp(net.ParseIP("203.0.113.9"))
+
+	addresses, err := chain.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.9", addresses.IPv4.String())
+}
+
+func TestAddresses_Equal(t *testing.T) {
+	a := Addresses{IPv4: net.ParseIP("203.0.113.1")}
+	assert.True(t, a.Equal(Addresses{IPv4: net.IPv4(203, 0, 113, 1).To4()}))
+	assert.False(t, a.Equal(Addresses{IPv4: net.ParseIP("203.0.113.1"), IPv6: net.ParseIP("2001:db8::1")}))
+	assert.False(t, a.Equal(Addresses{}))
+}
@@ -0,0 +1,38 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// StaticResolver always returns the ips set in the configuration
+type StaticResolver struct {
+	addresses Addresses
+}
+
+func NewStaticResolver(ipv4 string, ipv6 string) (*StaticResolver, error) {
+	r := &StaticResolver{}
+	if ipv4 != "" {
+		ip := net.ParseIP(ipv4)
+		if ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("static public ip '%s' is not a valid ipv4", ipv4)
+		}
+		r.addresses.IPv4 = ip.To4()
+	}
+	if ipv6 != "" {
+		ip := net.ParseIP(ipv6)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("static public ip '%s' is not a valid ipv6", ipv6)
+		}
+		r.addresses.IPv6 = ip
+	}
+	if r.addresses.IsEmpty() {
+		return nil, fmt.Errorf("static public ip strategy requires at least an ipv4 or an ipv6")
+	}
+	return r, nil
+}
+
+func (r *StaticResolver) Resolve(context.Context) (Addresses, error) {
+	return r.addresses, nil
+}
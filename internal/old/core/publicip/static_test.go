@@ -0,0 +1,41 @@
+package publicip
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewStaticResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		ipv4    string
+		ipv6    string
+		wantErr bool
+	}{
+		{name: "shouldFailWithoutIp", wantErr: true},
+		{name: "shouldFailWithInvalidIpv4", ipv4: "203.0.113", wantErr: true},
+		{name: "shouldFailWithIpv6AsIpv4", ipv4: "2001:db8::1", wantErr: true},
+		{name: "shouldFailWithIpv4AsIpv6", ipv6: "203.0.113.1", wantErr: true},
+		{name: "shouldSucceedWithIpv4", ipv4: "203.0.113.1"},
+		{name: "shouldSucceedWithIpv6", ipv6: "2001:db8::1"},
+		{name: "shouldSucceedWithBoth", ipv4: "203.0.113.1", ipv6: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewStaticResolver(tt.ipv4, tt.ipv6)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			addresses, _ := r.Resolve(context.Background())
+			if tt.ipv4 != "" {
+				assert.Equal(t, tt.ipv4, addresses.IPv4.String())
+			}
+			if tt.ipv6 != "" {
+				assert.Equal(t, tt.ipv6, addresses.IPv6.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// TrackerResolver returns the ips trackers have reported seeing the announces coming from (BEP 24 "external ip")
+type TrackerResolver struct {
+	addresses Addresses
+	lock      *sync.RWMutex
+}
+
+func NewTrackerResolver() *TrackerResolver {
+	return &TrackerResolver{
+		lock: &sync.RWMutex{},
+	}
+}
+
+func (r *TrackerResolver) ReportExternalIp(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if ip.To4() != nil {
+		r.addresses.IPv4 = ip.To4()
+	} else {
+		r.addresses.IPv6 = ip
+	}
+}
+
+func (r *TrackerResolver) Resolve(context.Context) (Addresses, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.addresses.IsEmpty() {
+		return Addresses{}, fmt.Errorf("no tracker has reported an external ip yet")
+	}
+	return r.addresses, nil
+}
@@ -0,0 +1,178 @@
+package routing
+
+import (
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	// DefaultRouteName is the route of the trackers matching no rule
+	DefaultRouteName = "default"
+	// NoProxy is the rule proxy value to connect directly, whatever the application proxy is
+	NoProxy = "none"
+)
+
+// Route is the way a tracker is reached: the proxy, the local address and the name resolution to use
+type Route struct {
+	name    string
+	hosts   []string
+	schemes []string
+	dialer  *proxy.Dialer
+}
+
+// NewRoute returns a route matching the trackers whose host matches one of the hosts globs and whose scheme is one of
+// schemes. An empty list matches anything.
+func NewRoute(name string, hosts []string, schemes []string, dialer *proxy.Dialer) (*Route, error) {
+	r := &Route{name: name, dialer: dialer}
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if _, err := path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("route '%s': invalid host glob '%s': %w", name, host, err)
+		}
+		r.hosts = append(r.hosts, host)
+	}
+	for _, scheme := range schemes {
+		r.schemes = append(r.schemes, strings.ToLower(strings.TrimSpace(scheme)))
+	}
+	return r, nil
+}
+
+// Name returns the name of the route, nil is the direct route named DefaultRouteName
+func (r *Route) Name() string {
+	if r == nil {
+		return DefaultRouteName
+	}
+	return r.name
+}
+
+// Dialer returns the dialer to reach the trackers with, nil (direct connection) for a nil route
+func (r *Route) Dialer() *proxy.Dialer {
+	if r == nil {
+		return nil
+	}
+	return r.dialer
+}
+
+func (r *Route) String() string {
+	return fmt.Sprintf("%s (%s)", r.Name(), r.Dialer())
+}
+
+func (r *Route) matches(u url.URL) bool {
+	return r.matchesHost(strings.ToLower(u.Hostname())) && r.matchesScheme(strings.ToLower(u.Scheme))
+}
+
+func (r *Route) matchesHost(host string) bool {
+	if len(r.hosts) == 0 {
+		return true
+	}
+	for _, glob := range r.hosts {
+		if ok, _ := path.Match(glob, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) matchesScheme(scheme string) bool {
+	if len(r.schemes) == 0 {
+		return true
+	}
+	for _, s := range r.schemes {
+		// udp4:// and udp6:// only force the address family
+		if s == scheme || s == strings.TrimRight(scheme, "46") {
+			return true
+		}
+	}
+	return false
+}
+
+// Router picks the route of a tracker, the first matching rule wins. A nil Router routes everything directly.
+type Router struct {
+	rules    []*Route
+	fallback *Route
+}
+
+func NewRouter(fallbackDialer *proxy.Dialer, rules ...*Route) *Router {
+	return &Router{
+		rules:    rules,
+		fallback: &Route{name: DefaultRouteName, dialer: fallbackDialer},
+	}
+}
+
+// FromConfig builds the Router, the trackers matching no rule, and the rules without a proxy, go through defaultDialer
+func FromConfig(conf *core.RoutingConfig, defaultDialer *proxy.Dialer) (*Router, error) {
+	var rules []*Route
+	names := map[string]bool{DefaultRouteName: true}
+	for i, rule := range conf.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("routing rule #%d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("routing rule name '%s' is reserved or used more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		options, err := ruleOptions(rule)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule '%s': %w", rule.Name, err)
+		}
+		var dialer *proxy.Dialer
+		switch strings.TrimSpace(rule.Proxy) {
+		case "":
+			dialer, err = defaultDialer.WithOptions(options)
+		case NoProxy:
+			dialer, err = proxy.NewDirect(options)
+		default:
+			dialer, err = proxy.New(rule.Proxy, options)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("routing rule '%s': %w", rule.Name, err)
+		}
+		route, err := NewRoute(rule.Name, rule.Hosts, rule.Schemes, dialer)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, route)
+	}
+	return NewRouter(defaultDialer, rules...), nil
+}
+
+func ruleOptions(rule *core.RouteRuleConfig) (proxy.Options, error) {
+	options := proxy.Options{}
+	if rule.BindAddress != "" {
+		options.LocalIp = net.ParseIP(rule.BindAddress)
+		if options.LocalIp == nil {
+			return proxy.Options{}, fmt.Errorf("bind address '%s' is not an ip", rule.BindAddress)
+		}
+	}
+	if rule.Dns != nil {
+		options.DnsServer = rule.Dns.Server
+		options.Hosts = make(map[string]net.IP, len(rule.Dns.Hosts))
+		for host, rawIp := range rule.Dns.Hosts {
+			ip := net.ParseIP(rawIp)
+			if ip == nil {
+				return proxy.Options{}, fmt.Errorf("dns override of '%s': '%s' is not an ip", host, rawIp)
+			}
+			options.Hosts[host] = ip
+		}
+	}
+	return options, nil
+}
+
+// Route returns the route to reach the tracker with
+func (r *Router) Route(u url.URL) *Route {
+	if r == nil {
+		return nil
+	}
+	for _, rule := range r.rules {
+		if rule.matches(u) {
+			return rule
+		}
+	}
+	return r.fallback
+}
@@ -0,0 +1,112 @@
+package routing
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/proxy"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter_Route(t *testing.T) {
+	private, _ := NewRoute("private", []string{"*.Private.org", "tracker.private.net"}, nil, nil)
+	udp, _ := NewRoute("udp", nil, []string{"udp"}, nil)
+	httpsOnLan, _ := NewRoute("https-lan", []string{"*.lan"}, []string{"https"}, nil)
+	router := NewRouter(nil, private, udp, httpsOnLan)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "http://tracker.private.org:8080/announce", want: "private"},
+		{url: "udp://tracker.private.org:6969", want: "private"},
+		{url: "https://TRACKER.private.net/announce", want: "private"},
+		{url: "http://private.org/announce", want: DefaultRouteName},
+		{url: "udp://tracker.example.org:6969", want: "udp"},
+		{url: "udp6://tracker.example.org:6969", want: "udp"},
+		{url: "https://tracker.lan/announce", want: "https-lan"},
+		{url: "http://tracker.lan/announce", want: DefaultRouteName},
+		{url: "http://tracker.example.org/announce", want: DefaultRouteName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			assert.Equal(t, tt.want, router.Route(*testutils.MustParseUrl(tt.url)).Name())
+		})
+	}
+}
+
+func TestRouter_NilShouldRouteDirectly(t *testing.T) {
+	route := (*Router)(nil).Route(*testutils.MustParseUrl("http://tracker.example.org"))
+	assert.Nil(t, route)
+	assert.Equal(t, DefaultRouteName, route.Name())
+	assert.Nil(t, route.Dialer())
+}
+
+func TestNewRoute_ShouldRefuseInvalidGlob(t *testing.T) {
+	_, err := NewRoute("broken", []string{"[a-"}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestFromConfig(t *testing.T) {
+	appProxy, _ := proxy.FromUrl("http://proxy.lan:3128")
+	router, err := FromConfig(&core.RoutingConfig{Rules: []*core.RouteRuleConfig{
+		{Name: "inherit", Hosts: []string{"inherit.org"}, BindAddress: "192.168.1.10"},
+		{Name: "direct", Hosts: []string{"direct.org"}, Proxy: NoProxy},
+		{Name: "socks", Hosts: []string{"socks.org"}, Proxy: "socks5://127.0.0.1:9050", Dns: &core.RouteDnsConfig{
+			Hosts:  map[string]string{"socks.org": "203.0.113.7"},
+			Server: "1.1.1.1",
+		}},
+	}}, appProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "http://proxy.lan:3128", router.Route(*testutils.MustParseUrl("http://inherit.org")).Dialer().String())
+	assert.Equal(t, "direct", router.Route(*testutils.MustParseUrl("http://direct.org")).Dialer().String())
+	assert.Equal(t, "socks5://127.0.0.1:9050", router.Route(*testutils.MustParseUrl("http://socks.org")).Dialer().String())
+	assert.Same(t, appProxy, router.Route(*testutils.MustParseUrl("http://other.org")).Dialer())
+}
+
+func TestFromConfig_NoProxyRouteShouldIgnoreTheProxyEnvironmentVariables(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://env-proxy.lan:3128")
+	t.Setenv("HTTPS_PROXY", "http://env-proxy.lan:3128")
+	router, err := FromConfig(&core.RoutingConfig{Rules: []*core.RouteRuleConfig{
+		{Name: "direct", Hosts: []string{"direct.org"}, Proxy: NoProxy, BindAddress: "127.0.0.1"},
+	}}, nil)
+	require.NoError(t, err)
+
+	direct := router.Route(*testutils.MustParseUrl("http://direct.org/announce")).Dialer()
+	assert.Nil(t, direct.HttpProxy())
+
+	// the trackers without rule still go through the proxy of the environment
+	proxyUrl, err := router.Route(*testutils.MustParseUrl("http://other.org/announce")).Dialer().HttpProxy()(httptest.NewRequest("GET", "http://other.org/announce", nil))
+	require.NoError(t, err)
+	require.NotNil(t, proxyUrl)
+	assert.Equal(t, "env-proxy.lan:3128", proxyUrl.Host)
+}
+
+func TestFromConfig_ShouldValidateRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule *core.RouteRuleConfig
+	}{
+		{name: "noName", rule: &core.RouteRuleConfig{}},
+		{name: "reservedName", rule: &core.RouteRuleConfig{Name: DefaultRouteName}},
+		{name: "invalidProxy", rule: &core.RouteRuleConfig{Name: "r", Proxy: "socks4://proxy.lan"}},
+		{name: "invalidBindAddress", rule: &core.RouteRuleConfig{Name: "r", BindAddress: "eth0"}},
+		{name: "invalidDnsOverride", rule: &core.RouteRuleConfig{Name: "r", Dns: &core.RouteDnsConfig{Hosts: map[string]string{"a.org": "nope"}}}},
+		{name: "invalidDnsServer", rule: &core.RouteRuleConfig{Name: "r", Dns: &core.RouteDnsConfig{Server: "dns.google"}}},
+		{name: "invalidGlob", rule: &core.RouteRuleConfig{Name: "r", Hosts: []string{"[a-"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromConfig(&core.RoutingConfig{Rules: []*core.RouteRuleConfig{tt.rule}}, nil)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := FromConfig(&core.RoutingConfig{Rules: []*core.RouteRuleConfig{{Name: "twice"}, {Name: "twice"}}}, nil)
+	assert.Error(t, err)
+}
@@ -7,6 +7,23 @@ import (
 
 const queueCapacity int = 1500
 
+// liveQueues are the queues created and not destroyed yet, the announces they hold are the ones waiting to be sent
+var liveQueues = struct {
+	queues map[*AnnounceQueue]struct{}
+	lock   *sync.Mutex
+}{queues: make(map[*AnnounceQueue]struct{}), lock: &sync.Mutex{}}
+
+// QueuedAnnounces returns the number of announces waiting in the queues to be sent
+func QueuedAnnounces() int {
+	liveQueues.lock.Lock()
+	defer liveQueues.lock.Unlock()
+	queued := 0
+	for q := range liveQueues.queues {
+		queued += q.Len()
+	}
+	return queued
+}
+
 type AnnounceQueue struct {
 	queue    chan *announces.AnnounceRequest
 	isClosed bool
@@ -14,11 +31,15 @@ type AnnounceQueue struct {
 }
 
 func NewAnnounceQueue() *AnnounceQueue {
-	return &AnnounceQueue{
+	q := &AnnounceQueue{
 		queue:    make(chan *announces.AnnounceRequest, queueCapacity),
 		isClosed: false,
 		lock:     &sync.RWMutex{},
 	}
+	liveQueues.lock.Lock()
+	liveQueues.queues[q] = struct{}{}
+	liveQueues.lock.Unlock()
+	return q
 }
 
 func (q *AnnounceQueue) Enqueue(req *announces.AnnounceRequest) {
@@ -32,6 +53,11 @@ func (q *AnnounceQueue) Enqueue(req *announces.AnnounceRequest) {
 	q.queue <- req
 }
 
+// Len returns the number of announces waiting to be sent
+func (q *AnnounceQueue) Len() int {
+	return len(q.queue)
+}
+
 func (q *AnnounceQueue) Request() <-chan *announces.AnnounceRequest {
 	return q.queue
 }
@@ -47,4 +73,8 @@ func (q *AnnounceQueue) DiscardFutureEnqueueAndDestroy() {
 	close(q.queue)
 
 	q.lock.Unlock()
+
+	liveQueues.lock.Lock()
+	delete(liveQueues.queues, q)
+	liveQueues.lock.Unlock()
 }
@@ -0,0 +1,25 @@
+package torrent2
+
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core/announces"
+	"testing"
+)
+
+func TestAnnounceQueue_ShouldCountTheQueuedAnnounces(t *testing.T) {
+	q := NewAnnounceQueue()
+	q.Enqueue(&announces.AnnounceRequest{})
+	q.Enqueue(&announces.AnnounceRequest{})
+	if q.Len() != 2 || QueuedAnnounces() != 2 {
+		t.Fatalf("expected 2 queued announces, got %d (%d overall)", q.Len(), QueuedAnnounces())
+	}
+
+	<-q.Request()
+	if q.Len() != 1 || QueuedAnnounces() != 1 {
+		t.Fatalf("expected 1 queued announce, got %d (%d overall)", q.Len(), QueuedAnnounces())
+	}
+
+	q.DiscardFutureEnqueueAndDestroy()
+	if QueuedAnnounces() != 0 {
+		t.Fatalf("a destroyed queue should not be counted, got %d", QueuedAnnounces())
+	}
+}
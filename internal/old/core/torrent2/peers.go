@@ -9,7 +9,8 @@ import (
 type Peers interface {
 	Seeders() int32
 	Leechers() int32
-	AddPeer(update SwarmUpdateRequest)
+	// AddPeer updates the swarm seen by a tracker and returns true if the elected swarm has changed
+	AddPeer(update SwarmUpdateRequest) bool
 	Reset()
 }
 
@@ -48,14 +49,16 @@ func (p *peersElector) Leechers() int32 {
 	return v
 }
 
-func (p *peersElector) AddPeer(update SwarmUpdateRequest) {
+func (p *peersElector) AddPeer(update SwarmUpdateRequest) bool {
 	newPeersStats := update.toPeersStats()
 
 	p.lock.Lock()
 	defer func() { p.lock.Unlock() }()
 
-	p.peers[newPeersStats.trackerHost] = peer{seeders: newPeersStats.seeders, leechers: newPeersStats.leechers}
+	previous := p.electedPeer
+	p.peers[newPeersStats.trackerHost] = newPeersStats
 	p.proceedToPeerElection()
+	return previous.seeders != p.electedPeer.seeders || previous.leechers != p.electedPeer.leechers
 }
 
 func (p *peersElector) Reset() {
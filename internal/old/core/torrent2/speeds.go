@@ -1,38 +1,41 @@
 package torrent2
 
-import "sync"
+import (
+	"github.com/anthonyraymond/joal-cli/internal/old/core/accounting"
+	"time"
+)
 
+// Speeds stores the torrent current speeds and counts the bytes they transfer. Implementation MUST be thread-safe
 type Speeds interface {
 	UploadSpeed() (bps int64)
 	SetUploadSpeed(bps int64)
+	// CollectUploaded returns the bytes uploaded since the previous collect
+	CollectUploaded() int64
 	Reset()
 }
 
 type speedsImpl struct {
-	upload int64
-	lock   *sync.Mutex
+	upload *accounting.Meter
 }
 
-func newSpeed() Speeds {
+func newSpeed(maxCatchUp time.Duration) Speeds {
 	return &speedsImpl{
-		upload: 0,
-		lock:   &sync.Mutex{},
+		upload: accounting.NewMeter(maxCatchUp),
 	}
 }
 
 func (s *speedsImpl) UploadSpeed() int64 {
-	s.lock.Lock()
-	v := s.upload
-	s.lock.Unlock()
-	return v
+	return s.upload.Speed()
 }
 
 func (s *speedsImpl) SetUploadSpeed(bps int64) {
-	s.lock.Lock()
-	s.upload = bps
-	s.lock.Unlock()
+	s.upload.SetSpeed(bps)
+}
+
+func (s *speedsImpl) CollectUploaded() int64 {
+	return s.upload.Collect()
 }
 
 func (s *speedsImpl) Reset() {
-	s.upload = 0
+	s.upload.Reset()
 }
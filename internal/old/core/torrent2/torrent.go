@@ -6,8 +6,10 @@ import (
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/announces"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/bandwidth"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
 	"github.com/anthonyraymond/joal-cli/internal/old/utils/stop"
 	"go.uber.org/atomic"
@@ -26,11 +28,20 @@ type Torrent interface {
 	Start(props AnnounceProps, announceQueue *AnnounceQueue, dispatcher bandwidth.SpeedDispatcher)
 	Stop(ctx context.Context)
 	InfoHash() torrent.InfoHash
+	// Name is the name of the torrent in its meta-info
+	Name() string
+	// AnnounceUrls returns the urls of all the trackers of the torrent, tier after tier
+	AnnounceUrls() []url.URL
 	Path() string
 	ChangePath(path string)
 	MoveTo(directory string) error
-	// AddDataFor add interval second worth of upload to Stats.Uploaded
-	AddDataFor(interval time.Duration)
+	// Size returns the total length of the torrent content in bytes
+	Size() int64
+	// AccountUpload adds the bytes uploaded since the previous accounting to Stats.Uploaded and returns them. The bytes
+	// uploaded until the torrent stopped are returned by the first accounting following Stop.
+	AccountUpload() int64
+	// Reannounce announces to the trackers right away rather than waiting for the next announce time, to publish a change of the public ip
+	Reannounce()
 }
 
 type torrentImpl struct {
@@ -44,11 +55,20 @@ type torrentImpl struct {
 	info          *slimInfo
 	isRunning     bool
 	stopping      stop.Chan
+	reannounce    chan struct{}
 	lock          *sync.Mutex
 	announceQueue *AnnounceQueue
+
+	// disabledTrackers are the urls of the trackers already reported as disabled, the report is not repeated on each Start
+	disabledTrackers map[string]bool
+	// routineDone is closed when the routine exits, it is nil when no routine runs
+	routineDone chan struct{}
+	// stoppedUploaded are the bytes uploaded between the last accounting and the stop of the torrent
+	stoppedUploaded int64
 }
 
-func FromFile(filePath string) (Torrent, error) {
+// FromFile parses a torrent file, accounting defines how the uploaded bytes are counted once the torrent is started
+func FromFile(filePath string, accounting *core.AccountingConfig) (Torrent, error) {
 	logger := logs.GetLogger().With(zap.String("torrent", filepath.Base(filePath)))
 	meta, err := metainfo.LoadFromFile(filePath)
 	if err != nil {
@@ -71,7 +91,7 @@ func FromFile(filePath string) (Torrent, error) {
 		path:  filePath,
 		stats: newStats(),
 		peers: newPeersElector(),
-		speed: newSpeed(),
+		speed: newSpeed(accounting.MaxCatchUp),
 		metaInfo: &slimMetaInfo{
 			Announce:     meta.Announce,
 			AnnounceList: meta.AnnounceList,
@@ -85,15 +105,16 @@ func FromFile(filePath string) (Torrent, error) {
 		info: &slimInfo{
 			PieceLength: info.PieceLength,
 			Name:        info.Name,
-			Length:      info.Length,
+			Length:      info.TotalLength(),
 			Private:     private,
 			Source:      info.Source,
 		},
-		trackers:  []*trackerImpl{},
-		infoHash:  infoHash,
-		isRunning: false,
-		stopping:  stop.NewChan(),
-		lock:      &sync.Mutex{},
+		trackers:   []*trackerImpl{},
+		infoHash:   infoHash,
+		isRunning:  false,
+		stopping:   stop.NewChan(),
+		reannounce: make(chan struct{}, 1),
+		lock:       &sync.Mutex{},
 	}, nil
 }
 
@@ -111,30 +132,48 @@ func (t *torrentImpl) Start(props AnnounceProps, announceQueue *AnnounceQueue, d
 	if t.isRunning {
 		return
 	}
+	// Stop may have given up waiting for the routine, a second routine must not run until it has exited
+	for t.routineDone != nil {
+		done := t.routineDone
+		t.lock.Unlock()
+		<-done
+		t.lock.Lock()
+		if t.isRunning {
+			return
+		}
+	}
 	t.isRunning = true
+	t.routineDone = make(chan struct{})
 
 	t.trackers = newTrackers(t.metaInfo.Announce, t.metaInfo.AnnounceList, props.SupportAnnounceList)
 	t.announceQueue = announceQueue
 
 	// Disable trackers based on client capabilities (UDP, HTTP, ...)
 	for _, track := range t.trackers {
+		reason := ""
 		if strings.Contains(strings.ToLower(track.Url().Scheme), "http") && !props.SupportHttpAnnounce {
-			track.enabled = false
+			reason = "the client does not support http announces"
 		} else if strings.Contains(strings.ToLower(track.Url().Scheme), "udp") && !props.SupportUdpAnnounce {
+			reason = "the client does not support udp announces"
+		}
+		if reason != "" {
 			track.enabled = false
+			t.emitTrackerDisabled(track, reason)
 		}
 	}
 
-	go torrentRoutine(t, props, dispatcher)
+	go torrentRoutine(t, props, dispatcher, t.routineDone)
 }
 
 func (t *torrentImpl) Stop(ctx context.Context) {
 	t.lock.Lock()
-	defer t.lock.Unlock()
 	if !t.isRunning {
+		t.lock.Unlock()
 		return
 	}
 	t.isRunning = false
+	// the routine must be able to take the lock while stopping (the dispatcher sets the speed when unregistering)
+	t.lock.Unlock()
 
 	logger := logs.GetLogger().With(zap.String("torrent", filepath.Base(t.path)))
 
@@ -150,6 +189,30 @@ func (t *torrentImpl) InfoHash() torrent.InfoHash {
 	return t.infoHash
 }
 
+func (t *torrentImpl) Name() string {
+	return t.info.Name
+}
+
+func (t *torrentImpl) AnnounceUrls() []url.URL {
+	var urls []url.URL
+	found := map[string]bool{}
+	add := func(rawUrl string) {
+		u, err := url.Parse(rawUrl)
+		if err != nil || rawUrl == "" || found[u.String()] {
+			return
+		}
+		found[u.String()] = true
+		urls = append(urls, *u)
+	}
+	add(t.metaInfo.Announce)
+	for _, tier := range t.metaInfo.AnnounceList {
+		for _, rawUrl := range tier {
+			add(rawUrl)
+		}
+	}
+	return urls
+}
+
 func (t *torrentImpl) Path() string {
 	return t.path
 }
@@ -166,16 +229,42 @@ func (t *torrentImpl) MoveTo(directory string) error {
 	return nil
 }
 
-func (t *torrentImpl) AddDataFor(interval time.Duration) {
+func (t *torrentImpl) Size() int64 {
+	return t.info.Length
+}
+
+func (t *torrentImpl) AccountUpload() int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	uploaded := t.stoppedUploaded
+	t.stoppedUploaded = 0
+	if !t.isRunning {
+		return uploaded
+	}
+	collected := t.speed.CollectUploaded()
+	t.stats.AddUploaded(collected)
+	return uploaded + collected
+}
+
+func (t *torrentImpl) Reannounce() {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	if !t.isRunning {
 		return
 	}
-	t.stats.AddUploaded(t.speed.UploadSpeed() * int64(interval.Seconds()))
+	select {
+	case t.reannounce <- struct{}{}:
+	default: // a re-announce is already pending
+	}
 }
 
-func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.SpeedDispatcher) {
+func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.SpeedDispatcher, done chan struct{}) {
+	defer func() {
+		t.lock.Lock()
+		t.routineDone = nil
+		t.lock.Unlock()
+		close(done)
+	}()
 	logger := logs.GetLogger().With(zap.String("torrent", t.info.Name))
 	t.peers.Reset()
 	t.stats.Reset()
@@ -183,12 +272,17 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 
 	unregisterDispatcher := dispatcher.Register(&bandwidth.RegisteredTorrent{
 		InfoHash: t.infoHash,
+		Size:     t.info.Length,
 		GetPeers: func() *bandwidth.Peers {
 			return &bandwidth.Peers{
 				Leechers: t.peers.Leechers(),
 				Seeders:  t.peers.Seeders(),
 			}
 		},
+		// only covers the current seeding session
+		GetContribution: func() (int64, int32) {
+			return t.stats.Uploaded(), t.peers.Leechers()
+		},
 		SetSpeed: func(bps int64) {
 			t.lock.Lock()
 			defer t.lock.Unlock()
@@ -207,12 +301,14 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 	dismissAnnounceResults := atomic.NewBool(false)
 	announceCallbacks := &announces.AnnounceCallbacks{
 		Success: func(response announces.AnnounceResponse) {
+			emitAnnounceSuccess(response)
 			if dismissAnnounceResults.Load() {
 				return
 			}
 			onAnnounceSuccess <- response
 		},
 		Failed: func(responseError announces.AnnounceResponseError) {
+			emitAnnounceFailed(responseError)
 			if dismissAnnounceResults.Load() {
 				return
 			}
@@ -226,6 +322,9 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 			_, currentTracker := findTracker(resp.Request.Url, t.trackers)
 			if currentTracker != nil {
 				currentTracker.state.startSent = true
+				if resp.TrackerId != "" {
+					currentTracker.state.trackerId = resp.TrackerId
+				}
 				currentTracker.Succeed(AnnounceHistory{
 					interval: resp.Interval,
 					seeders:  resp.Seeders,
@@ -233,15 +332,21 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 				})
 			}
 
-			t.peers.AddPeer(SwarmUpdateRequest{
+			if t.peers.AddPeer(SwarmUpdateRequest{
 				trackerUrl: currentTracker.Url(),
 				interval:   resp.Interval,
 				seeders:    resp.Seeders,
 				leechers:   resp.Leechers,
-			})
+			}) {
+				t.emitSwarmChanged()
+			}
 
 			if !timer.Stop() {
-				<-timer.C
+				// the timer may already have been received from, do not wait for a value that will never come
+				select {
+				case <-timer.C:
+				default:
+				}
 			}
 			nextAnnounce := getNextAnnounceTime(t.trackers, props.AnnounceToAllTiers, props.AnnounceToAllTrackers)
 			if nextAnnounce.IsZero() {
@@ -258,17 +363,29 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 				currentTracker.Failed(AnnounceHistory{
 					error: errorResponse.Error.Error(),
 				}, 250, int(errorResponse.Interval.Seconds()))
+				if shouldGiveUp(t.trackers, currentTracker) {
+					currentTracker.enabled = false
+					t.lock.Lock()
+					t.emitTrackerDisabled(currentTracker, fmt.Sprintf("gave up after %d consecutive failures", currentTracker.state.fails))
+					t.lock.Unlock()
+				}
 			}
-			t.peers.AddPeer(SwarmUpdateRequest{
+			if t.peers.AddPeer(SwarmUpdateRequest{
 				trackerUrl: currentTracker.Url(),
 				interval:   0, // set interval to 0 will force the entry to be evicted by the peer electors system
 				seeders:    0,
 				leechers:   0,
-			})
+			}) {
+				t.emitSwarmChanged()
+			}
 			deprioritizeTracker(t.trackers, trackerIndex)
 
 			if !timer.Stop() {
-				<-timer.C
+				// the timer may already have been received from, do not wait for a value that will never come
+				select {
+				case <-timer.C:
+				default:
+				}
 			}
 			nextAnnounce := getNextAnnounceTime(t.trackers, props.AnnounceToAllTiers, props.AnnounceToAllTrackers)
 			if nextAnnounce.IsZero() {
@@ -281,6 +398,16 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 		case <-onAnnounceTime:
 			t.announceToTrackers(props, announceCallbacks, tracker.None, context.Background())
 
+		case <-t.reannounce:
+			now := time.Now()
+			for _, tr := range t.trackers {
+				// trackers currently announcing will receive the new state with their next announce
+				if tr.state.startSent && !tr.state.updating {
+					tr.state.nextAnnounce = now
+				}
+			}
+			t.announceToTrackers(props, announceCallbacks, tracker.None, context.Background())
+
 		case stopRequest := <-t.stopping:
 			//goland:noinspection GoDeferInLoop
 			defer func() {
@@ -289,6 +416,12 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 			if unregisterDispatcher != nil {
 				unregisterDispatcher()
 			}
+			// the stop announce reports the bytes uploaded up to now, they are accounted even if the stop announce is skipped
+			t.lock.Lock()
+			uploaded := t.speed.CollectUploaded()
+			t.stats.AddUploaded(uploaded)
+			t.stoppedUploaded += uploaded
+			t.lock.Unlock()
 			dismissAnnounceResults.Store(true)
 			//drain announce response channels
 			drainSuccessResponseChan(onAnnounceSuccess)
@@ -301,7 +434,25 @@ func torrentRoutine(t *torrentImpl, props AnnounceProps, dispatcher bandwidth.Sp
 			for _, tr := range t.trackers {
 				tr.state.nextAnnounce = time.Now()
 			}
-			t.announceToTrackers(props, announceCallbacks, tracker.Stopped, stopRequest.Ctx())
+			stopAnnounced := make(chan struct{}, len(t.trackers))
+			stopCallbacks := &announces.AnnounceCallbacks{
+				Success: func(response announces.AnnounceResponse) {
+					emitAnnounceSuccess(response)
+					stopAnnounced <- struct{}{}
+				},
+				Failed: func(responseError announces.AnnounceResponseError) {
+					emitAnnounceFailed(responseError)
+					stopAnnounced <- struct{}{}
+				},
+			}
+			// wait for the results, the torrent is not reported as stopped while its stop announces are still running
+			for pending := t.announceToTrackers(props, stopCallbacks, tracker.Stopped, stopRequest.Ctx()); pending > 0; pending-- {
+				select {
+				case <-stopAnnounced:
+				case <-stopRequest.Ctx().Done():
+					pending = 0
+				}
+			}
 			t.stats.Reset()
 			t.peers.Reset()
 			t.speed.Reset()
@@ -337,7 +488,9 @@ func deprioritizeTracker(trackers []*trackerImpl, indexToDeprioritize int) {
 	}
 }
 
-func (t *torrentImpl) announceToTrackers(props AnnounceProps, callbacks *announces.AnnounceCallbacks, event tracker.AnnounceEvent, ctx context.Context) {
+// announceToTrackers enqueues an announce for each tracker ready to announce and returns the number of announces enqueued
+func (t *torrentImpl) announceToTrackers(props AnnounceProps, callbacks *announces.AnnounceCallbacks, event tracker.AnnounceEvent, ctx context.Context) int {
+	enqueued := 0
 	trackersToAnnounce := findAnnounceReadyTrackers(t.trackers, props.AnnounceToAllTiers, props.AnnounceToAllTrackers)
 
 	for _, currentTracker := range trackersToAnnounce {
@@ -359,11 +512,69 @@ func (t *torrentImpl) announceToTrackers(props AnnounceProps, callbacks *announc
 			Corrupt:           t.stats.Corrupted(),
 			Event:             event,
 			Private:           t.info.Private,
+			TrackerId:         currentTracker.state.trackerId,
 			AnnounceCallbacks: callbacks,
 		}
 
+		broadcast.EmitTorrentAnnouncing(broadcast.TorrentAnnouncingEvent{
+			Infohash:      t.infoHash,
+			TrackerUrl:    req.Url,
+			AnnounceEvent: req.Event,
+			Uploaded:      req.Uploaded,
+		})
 		t.announceQueue.Enqueue(req)
+		enqueued++
 	}
+	return enqueued
+}
+
+// emitTrackerDisabled reports a disabled tracker, once per tracker for the torrent. The lock has to be held by the caller
+func (t *torrentImpl) emitTrackerDisabled(track *trackerImpl, reason string) {
+	u := track.Url()
+	if t.disabledTrackers[u.String()] {
+		return
+	}
+	if t.disabledTrackers == nil {
+		t.disabledTrackers = make(map[string]bool)
+	}
+	t.disabledTrackers[u.String()] = true
+	broadcast.EmitTorrentTrackerDisabled(broadcast.TorrentTrackerDisabledEvent{
+		Infohash:   t.infoHash,
+		TrackerUrl: u,
+		Reason:     reason,
+	})
+}
+
+func (t *torrentImpl) emitSwarmChanged() {
+	broadcast.EmitTorrentSwarmChanged(broadcast.TorrentSwarmChangedEvent{
+		Infohash: t.infoHash,
+		Seeder:   t.peers.Seeders(),
+		Leechers: t.peers.Leechers(),
+	})
+}
+
+func emitAnnounceSuccess(response announces.AnnounceResponse) {
+	broadcast.EmitTorrentAnnounceSuccess(broadcast.TorrentAnnounceSuccessEvent{
+		Infohash:      response.Request.InfoHash,
+		TrackerUrl:    response.Request.Url,
+		AnnounceEvent: response.Request.Event,
+		Datetime:      time.Now(),
+		Seeder:        response.Seeders,
+		Leechers:      response.Leechers,
+		Interval:      response.Interval,
+		Route:         response.Route,
+	})
+}
+
+func emitAnnounceFailed(responseError announces.AnnounceResponseError) {
+	broadcast.EmitTorrentAnnounceFailed(broadcast.TorrentAnnounceFailedEvent{
+		Infohash:      responseError.Request.InfoHash,
+		TrackerUrl:    responseError.Request.Url,
+		AnnounceEvent: responseError.Request.Event,
+		Datetime:      time.Now(),
+		Error:         responseError.Error.Error(),
+		Route:         responseError.Route,
+	})
 }
 
 func getNextAnnounceTime(trackers []*trackerImpl, announceToAllTier bool, announceToAllTracker bool) time.Time {
@@ -1,8 +1,14 @@
 package torrent2
 
 import (
+	"context"
+	"github.com/anthonyraymond/joal-cli/internal/old/core"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/bandwidth"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/utils/stop"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -245,3 +251,184 @@ func prettyPrintUrl(urls []string) string {
 	b.WriteString("}")
 	return b.String()
 }
+
+func TestTorrent_ReannounceShouldNotBlockWhenAlreadyPending(t *testing.T) {
+	tor := &torrentImpl{
+		isRunning:  true,
+		reannounce: make(chan struct{}, 1),
+		lock:       &sync.Mutex{},
+	}
+	tor.Reannounce()
+	tor.Reannounce()
+	if len(tor.reannounce) != 1 {
+		t.Fatalf("expected one pending re-announce, found %d", len(tor.reannounce))
+	}
+
+	tor.isRunning = false
+	<-tor.reannounce
+	tor.Reannounce()
+	if len(tor.reannounce) != 0 {
+		t.Fatalf("expected no re-announce for a stopped torrent, found %d", len(tor.reannounce))
+	}
+}
+
+// registeringDispatcher hands the registered torrents to the test, unregister is called when a torrent unregisters
+type registeringDispatcher struct {
+	registered chan *bandwidth.RegisteredTorrent
+	unregister func()
+}
+
+func (d *registeringDispatcher) Start(_ *core.DispatcherConfig) error                 { return nil }
+func (d *registeringDispatcher) Stop()                                                {}
+func (d *registeringDispatcher) ReplaceSpeedConfig(_ *core.SpeedProviderConfig) error { return nil }
+func (d *registeringDispatcher) AccountUpload(_ int64)                                {}
+func (d *registeringDispatcher) Register(rt *bandwidth.RegisteredTorrent) func() {
+	d.registered <- rt
+	return func() {
+		if d.unregister != nil {
+			d.unregister()
+		}
+	}
+}
+
+func newTestTorrent() *torrentImpl {
+	return &torrentImpl{
+		path:       "joal.torrent",
+		stats:      newStats(),
+		peers:      newPeersElector(),
+		speed:      newSpeed(0),
+		metaInfo:   &slimMetaInfo{},
+		info:       &slimInfo{Name: "joal"},
+		stopping:   stop.NewChan(),
+		reannounce: make(chan struct{}, 1),
+		lock:       &sync.Mutex{},
+	}
+}
+
+func TestTorrent_StopShouldKeepTheBytesUploadedSinceTheLastAccounting(t *testing.T) {
+	tor := newTestTorrent()
+	dispatcher := &registeringDispatcher{registered: make(chan *bandwidth.RegisteredTorrent, 1)}
+	tor.Start(AnnounceProps{}, NewAnnounceQueue(), dispatcher)
+	(<-dispatcher.registered).SetSpeed(1_000_000)
+
+	time.Sleep(20 * time.Millisecond)
+	if uploaded := tor.AccountUpload(); uploaded <= 0 {
+		t.Fatalf("expected the running torrent to account its upload, got %d", uploaded)
+	}
+
+	// stopped in the middle of an accounting window
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tor.Stop(ctx)
+
+	if uploaded := tor.AccountUpload(); uploaded < 20_000 {
+		t.Fatalf("expected the bytes uploaded until the stop to be accounted, got %d", uploaded)
+	}
+	if uploaded := tor.AccountUpload(); uploaded != 0 {
+		t.Fatalf("expected the bytes uploaded until the stop to be accounted once, got %d", uploaded)
+	}
+}
+
+func TestTorrent_StartShouldWaitForTheStoppingRoutineToExit(t *testing.T) {
+	tor := newTestTorrent()
+	release := make(chan struct{})
+	dispatcher := &registeringDispatcher{
+		registered: make(chan *bandwidth.RegisteredTorrent, 2),
+		unregister: func() { <-release },
+	}
+	tor.Start(AnnounceProps{}, NewAnnounceQueue(), dispatcher)
+	<-dispatcher.registered
+
+	// Stop gives up waiting, the routine is still stopping
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tor.Stop(ctx)
+
+	started := make(chan struct{})
+	go func() {
+		tor.Start(AnnounceProps{}, NewAnnounceQueue(), dispatcher)
+		close(started)
+	}()
+	select {
+	case <-dispatcher.registered:
+		t.Fatal("a second routine has started while the previous one was stopping")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the torrent has not started once the previous routine exited")
+	}
+	<-dispatcher.registered
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tor.Stop(ctx)
+}
+
+func Test_shouldGiveUp(t *testing.T) {
+	failing := func(fails int16) *trackerImpl {
+		return &trackerImpl{url: &url.URL{Path: "failing"}, enabled: true, state: &trackerState{fails: fails}}
+	}
+	other := func(enabled bool) *trackerImpl {
+		return &trackerImpl{url: &url.URL{Path: "other"}, enabled: enabled, state: &trackerState{}}
+	}
+	tests := []struct {
+		name    string
+		failing *trackerImpl
+		others  []*trackerImpl
+		want    bool
+	}{
+		{name: "shouldKeepTrackerBelowMaxFails", failing: failing(trackerMaxConsecutiveFails - 1), others: []*trackerImpl{other(true)}, want: false},
+		{name: "shouldGiveUpTrackerAtMaxFails", failing: failing(trackerMaxConsecutiveFails), others: []*trackerImpl{other(true)}, want: true},
+		{name: "shouldKeepLastEnabledTracker", failing: failing(trackerMaxConsecutiveFails), others: []*trackerImpl{other(false)}, want: false},
+		{name: "shouldKeepSingleTracker", failing: failing(trackerMaxConsecutiveFails + 1), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackers := append([]*trackerImpl{tt.failing}, tt.others...)
+			if got := shouldGiveUp(trackers, tt.failing); got != tt.want {
+				t.Errorf("shouldGiveUp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTorrent_ShouldReportADisabledTrackerOnce(t *testing.T) {
+	tor := newTestTorrent()
+	tor.infoHash = [20]byte{4, 4}
+	tor.metaInfo = &slimMetaInfo{Announce: "udp://tracker.lan:6969/announce"}
+	disabled := make(chan broadcast.TorrentTrackerDisabledEvent, 10)
+	sub := broadcast.Subscribe(func(event broadcast.TorrentTrackerDisabledEvent) {
+		if event.Infohash == tor.infoHash {
+			disabled <- event
+		}
+	})
+	defer sub.Unsubscribe()
+
+	dispatcher := &registeringDispatcher{registered: make(chan *bandwidth.RegisteredTorrent, 1)}
+	for i := 0; i < 2; i++ {
+		tor.Start(AnnounceProps{SupportHttpAnnounce: true}, NewAnnounceQueue(), dispatcher)
+		<-dispatcher.registered
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		tor.Stop(ctx)
+		cancel()
+	}
+
+	select {
+	case event := <-disabled:
+		if event.TrackerUrl.Host != "tracker.lan:6969" {
+			t.Fatalf("unexpected tracker reported as disabled: %s", event.TrackerUrl.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the disabled tracker has not been reported")
+	}
+	select {
+	case <-disabled:
+		t.Fatal("the disabled tracker has been reported more than once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
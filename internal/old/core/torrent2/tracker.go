@@ -14,6 +14,9 @@ const trackerRetryDelayMin = 5 * time.Second
 // Never wait more than 60min before retrying
 const trackerRetryDelayMax = 60 * time.Minute
 
+// Number of consecutive fails after which a tracker is no longer announced to, unless it is the last enabled one
+const trackerMaxConsecutiveFails = 20
+
 // Maximum number of announce history to keep for each trackerImpl
 const trackerMaxHistorySize = 3
 
@@ -129,6 +132,20 @@ func (t *trackerImpl) Failed(announceHistory AnnounceHistory, backoffRatio int,
 	t.state.updating = false
 }
 
+// shouldGiveUp returns true if the tracker has failed too many times in a row to be announced to again. The last
+// enabled tracker is never given up on, the torrent would not announce at all.
+func shouldGiveUp(trackers []*trackerImpl, failing *trackerImpl) bool {
+	if !failing.enabled || failing.state.fails < trackerMaxConsecutiveFails {
+		return false
+	}
+	for _, t := range trackers {
+		if t != failing && t.enabled {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *trackerImpl) Reset() {
 	t.state = &trackerState{}
 }
@@ -151,6 +168,8 @@ type trackerState struct {
 	// true if we sent an announce to this trackerImpl, and we currently are waiting for an answer
 	updating        bool
 	announceHistory []AnnounceHistory
+	// "tracker id" sent back by the tracker, it must be sent along with the next announces
+	trackerId string
 }
 
 type AnnounceHistory struct {
@@ -0,0 +1,115 @@
+package external
+
+import (
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/pkg/pluginsdk"
+	"time"
+)
+
+type externalConfig struct {
+	// Plugins are the executables spawned and supervised by JOAL
+	Plugins []*processConfig `yaml:"plugins"`
+	// Socket accepts the plugins started outside of JOAL, it is disabled if nil
+	Socket  *socketConfig  `yaml:"socket"`
+	Restart *restartConfig `yaml:"restart"`
+	// HandshakeTimeout is the time a plugin has to send its handshake once connected
+	HandshakeTimeout time.Duration `yaml:"handshakeTimeout"`
+	// PingInterval is the delay between two pings, a plugin not answering a ping within PingTimeout is considered hung
+	PingInterval time.Duration `yaml:"pingInterval"`
+	PingTimeout  time.Duration `yaml:"pingTimeout"`
+}
+
+// Return a new externalConfig with the default values filled in
+func (c externalConfig) Default() *externalConfig {
+	return &externalConfig{
+		Plugins:          []*processConfig{},
+		Restart:          restartConfig{}.Default(),
+		HandshakeTimeout: 10 * time.Second,
+		PingInterval:     15 * time.Second,
+		PingTimeout:      5 * time.Second,
+	}
+}
+
+func (c *externalConfig) validate() error {
+	if c.Restart == nil {
+		c.Restart = restartConfig{}.Default()
+	}
+	if c.HandshakeTimeout <= 0 || c.PingInterval <= 0 || c.PingTimeout <= 0 {
+		return fmt.Errorf("handshakeTimeout, pingInterval and pingTimeout must be positive")
+	}
+	if c.Restart.InitialBackoff <= 0 || c.Restart.MaxBackoff < c.Restart.InitialBackoff {
+		return fmt.Errorf("restart.initialBackoff must be positive and lower than restart.maxBackoff")
+	}
+	names := make(map[string]bool, len(c.Plugins))
+	for _, p := range c.Plugins {
+		if p.Name == "" || p.Command == "" {
+			return fmt.Errorf("external plugins require a name and a command")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("external plugin '%s' is declared twice", p.Name)
+		}
+		names[p.Name] = true
+		if err := validateCapabilities(p.Capabilities); err != nil {
+			return fmt.Errorf("external plugin '%s': %w", p.Name, err)
+		}
+	}
+	if c.Socket != nil {
+		if c.Socket.Path == "" {
+			return fmt.Errorf("socket.path is required")
+		}
+		if err := validateCapabilities(c.Socket.Capabilities); err != nil {
+			return fmt.Errorf("socket: %w", err)
+		}
+	}
+	return nil
+}
+
+type processConfig struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	// Env holds KEY=value pairs added to the environment of JOAL
+	Env []string `yaml:"env"`
+	// Capabilities are the capabilities the plugin may be granted, all of them if empty
+	Capabilities []string `yaml:"capabilities"`
+}
+
+type socketConfig struct {
+	// Path of the unix socket, it is created with 0600 permissions
+	Path string `yaml:"path"`
+	// Capabilities are the capabilities the plugins connecting to the socket may be granted, all of them if empty
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// restartConfig delays the restart of a crashed plugin by InitialBackoff, doubled on each consecutive crash up to
+// MaxBackoff
+type restartConfig struct {
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
+// Return a new restartConfig with the default values filled in
+func (c restartConfig) Default() *restartConfig {
+	return &restartConfig{
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+	}
+}
+
+func validateCapabilities(capabilities []string) error {
+	for _, c := range capabilities {
+		if !contains(pluginsdk.Capabilities, c) {
+			return fmt.Errorf("unknown capability '%s', expected one of %v", c, pluginsdk.Capabilities)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
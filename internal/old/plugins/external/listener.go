@@ -0,0 +1,140 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// listener accepts the plugins started outside of JOAL on a unix socket. Such plugins are not restarted by JOAL, they
+// are expected to reconnect by themselves.
+type listener struct {
+	conf     *socketConfig
+	opts     *externalConfig
+	bridge   types.ICoreBridge
+	log      *zap.Logger
+	listener net.Listener
+
+	lock     *sync.Mutex
+	sessions map[*session]struct{}
+	cancel   context.CancelFunc
+	wg       *sync.WaitGroup
+}
+
+func newListener(conf *socketConfig, opts *externalConfig, bridge types.ICoreBridge, log *zap.Logger) *listener {
+	return &listener{
+		conf:     conf,
+		opts:     opts,
+		bridge:   bridge,
+		log:      log.With(zap.String("socket", conf.Path)),
+		lock:     &sync.Mutex{},
+		sessions: make(map[*session]struct{}),
+		wg:       &sync.WaitGroup{},
+	}
+}
+
+func (l *listener) start() error {
+	// a socket left behind by a previous run would make the listen fail
+	if err := os.Remove(l.conf.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket '%s': %w", l.conf.Path, err)
+	}
+	ln, err := net.Listen("unix", l.conf.Path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on socket '%s': %w", l.conf.Path, err)
+	}
+	if err := os.Chmod(l.conf.Path, 0600); err != nil {
+		_ = ln.Close()
+		return fmt.Errorf("failed to restrict permissions of socket '%s': %w", l.conf.Path, err)
+	}
+	l.listener = ln
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					l.log.Error("external plugins socket has been closed", zap.Error(err))
+				}
+				return
+			}
+			l.serve(ctx, conn)
+		}
+	}()
+	l.log.Info("accepting external plugins")
+	return nil
+}
+
+func (l *listener) serve(ctx context.Context, conn net.Conn) {
+	sess := newSession(conn, l.bridge, l.opts, l.conf.Capabilities, l.log)
+	l.lock.Lock()
+	l.sessions[sess] = struct{}{}
+	l.lock.Unlock()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		err := sess.run(ctx)
+		l.lock.Lock()
+		delete(l.sessions, sess)
+		l.lock.Unlock()
+		if err != nil {
+			l.log.Info("external plugin disconnected", zap.String("plugin", sess.name()), zap.Error(err))
+		}
+	}()
+}
+
+// connected returns the names of the plugins connected to the socket
+func (l *listener) connected() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var names []string
+	for sess := range l.sessions {
+		if sess.connected() {
+			names = append(names, sess.name())
+		}
+	}
+	return names
+}
+
+// stop asks the connected plugins to exit and closes the socket, the connections of the plugins still connected after
+// the grace period are closed
+func (l *listener) stop(ctx context.Context) {
+	if l.listener == nil {
+		return
+	}
+	l.lock.Lock()
+	for sess := range l.sessions {
+		sess.requestShutdown()
+	}
+	l.lock.Unlock()
+	_ = l.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(exitGracePeriod)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	l.listener = nil
+}
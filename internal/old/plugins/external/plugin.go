@@ -0,0 +1,176 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// plugin runs the out-of-process plugins, which talk to JOAL with the protocol of the pluginsdk package
+type plugin struct {
+	config    *externalConfig
+	log       *zap.Logger
+	bridge    types.ICoreBridge
+	configDir string
+	health    *types.HealthState
+	// lock guards supervisors and listener, which Health reads while the plugin may be reloading
+	lock        *sync.Mutex
+	supervisors []*supervisor
+	listener    *listener
+}
+
+func init() {
+	types.RegisterPlugin(types.PluginFactory{
+		Name:             "external",
+		Order:            30,
+		EnabledByDefault: true,
+		New:              newPlugin,
+	})
+}
+
+func (p *plugin) Name() string {
+	return "external"
+}
+
+func newPlugin(ctx types.PluginContext, config *types.PluginConfig) (types.IJoalPlugin, error) {
+	conf, err := decodeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &plugin{
+		config:    conf,
+		log:       ctx.Logger,
+		bridge:    ctx.Bridge,
+		configDir: ctx.ConfigDir,
+		health:    &types.HealthState{},
+		lock:      &sync.Mutex{},
+	}, nil
+}
+
+func decodeConfig(config *types.PluginConfig) (*externalConfig, error) {
+	conf := externalConfig{}.Default()
+	if err := config.Decode(conf); err != nil {
+		return nil, err
+	}
+	if err := conf.validate(); err != nil {
+		return nil, fmt.Errorf("invalid external plugins config: %w", err)
+	}
+	return conf, nil
+}
+
+func (p *plugin) Start() error {
+	conf := p.config
+	p.health.Set(types.Healthy, "")
+	if len(conf.Plugins) == 0 && conf.Socket == nil {
+		p.log.Debug("no external plugin configured")
+		return nil
+	}
+
+	var l *listener
+	if conf.Socket != nil {
+		l = newListener(conf.Socket, conf, p.bridge, p.log)
+		if err := l.start(); err != nil {
+			return err
+		}
+	}
+	var supervisors []*supervisor
+	for _, pc := range conf.Plugins {
+		// each plugin runs in a directory of its own, where it can keep its state
+		dir := filepath.Join(p.configDir, pc.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			p.log.Error("failed to create external plugin directory", zap.String("external", pc.Name), zap.Error(err))
+			dir = p.configDir
+		}
+		s := newSupervisor(pc, conf, p.bridge, dir, p.log)
+		s.start()
+		supervisors = append(supervisors, s)
+	}
+
+	p.lock.Lock()
+	p.supervisors = supervisors
+	p.listener = l
+	p.lock.Unlock()
+	p.log.Info("external plugins are enabled", zap.Int("count", len(supervisors)), zap.Bool("socket", l != nil))
+	return nil
+}
+
+// Health is degraded while a supervised plugin is not connected, the message lists the plugins connected to the socket
+func (p *plugin) Health() types.Health {
+	health := p.health.Get()
+	if health.Status != types.Healthy {
+		return health
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var failures []string
+	for _, s := range p.supervisors {
+		if err := s.health(); err != nil {
+			failures = append(failures, fmt.Sprintf("external plugin '%s': %s", s.conf.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return types.Health{Status: types.Degraded, Message: strings.Join(failures, "; ")}
+	}
+	if p.listener != nil {
+		connected := p.listener.connected()
+		if len(connected) == 0 {
+			return health
+		}
+		sort.Strings(connected)
+		return types.Health{Status: types.Healthy, Message: fmt.Sprintf("connected to the socket: [%s]", strings.Join(connected, ", "))}
+	}
+	return health
+}
+
+// Reload restarts all the external plugins with the new configuration
+func (p *plugin) Reload(config *types.PluginConfig) error {
+	conf, err := decodeConfig(config)
+	if err != nil {
+		return err
+	}
+	shutdown(p, nil)
+	p.config = conf
+	return p.Start()
+}
+
+func (p *plugin) Shutdown(ctx context.Context) {
+	p.log.Info("Shutting down plugin")
+	shutdown(p, ctx)
+}
+
+// a nil safe version of Shutdown()
+func shutdown(p *plugin, ctx context.Context) {
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), exitGracePeriod+time.Second)
+		defer cancel()
+	}
+
+	p.lock.Lock()
+	supervisors := p.supervisors
+	l := p.listener
+	p.supervisors = nil
+	p.listener = nil
+	p.lock.Unlock()
+
+	wg := &sync.WaitGroup{}
+	for _, s := range supervisors {
+		wg.Add(1)
+		go func(s *supervisor) {
+			defer wg.Done()
+			s.stop(ctx)
+		}(s)
+	}
+	if l != nil {
+		l.stop(ctx)
+	}
+	wg.Wait()
+	p.health.Set(types.Unhealthy, "stopped")
+}
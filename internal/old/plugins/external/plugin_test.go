@@ -0,0 +1,216 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/anthonyraymond/joal-cli/pkg/pluginsdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// buildExamplePlugin compiles the example plugin of the sdk, which is spawned by the tests
+func buildExamplePlugin(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("building the example plugin is slow")
+	}
+	bin := filepath.Join(t.TempDir(), "example")
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-o", bin, "github.com/anthonyraymond/joal-cli/pkg/pluginsdk/example")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return bin
+}
+
+func createPlugin(t *testing.T, bridge types.ICoreBridge, yml string) *plugin {
+	conf := &types.PluginConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte(yml), conf))
+	p, err := newPlugin(types.PluginContext{
+		Logger:    zap.NewNop(),
+		ConfigDir: t.TempDir(),
+		Bridge:    bridge,
+	}, conf)
+	require.NoError(t, err)
+	return p.(*plugin)
+}
+
+// waitForSubscription waits for a plugin to subscribe to the events, the events published before would be missed
+func waitForSubscription(t *testing.T, name string, count int) {
+	assert.Eventually(t, func() bool {
+		n := 0
+		for _, stats := range broadcast.Stats() {
+			if stats.Name == "external:"+name {
+				n++
+			}
+		}
+		return n == count
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPlugin_ShouldRunAndRestartTheExamplePlugin(t *testing.T) {
+	bin := buildExamplePlugin(t)
+	bridge := newFakeBridge()
+	p := createPlugin(t, bridge, fmt.Sprintf(`
+config:
+  plugins:
+    - name: auto-goal
+      command: %s
+      args: [-ratio, "3.5", -action, archive]
+  restart:
+    initialBackoff: 10ms
+    maxBackoff: 100ms
+`, bin))
+
+	require.NoError(t, p.Start())
+	defer p.Shutdown(contextWithTimeout(t))
+	waitForSubscription(t, "auto-goal", 1)
+	assert.Equal(t, types.Healthy, p.Health().Status)
+
+	ih := torrent.InfoHash{1}
+	broadcast.EmitTorrentAdded(broadcast.TorrentAddedEvent{Infohash: ih, Name: "debian.iso"})
+	g := bridge.receiveGoal(t)
+	assert.Equal(t, ih, g.infohash)
+	assert.Equal(t, &types.TorrentGoal{Ratio: 3.5, Action: "archive"}, g.goal)
+
+	// simulate a crash
+	s := p.supervisors[0]
+	s.lock.Lock()
+	require.NotNil(t, s.process)
+	require.NoError(t, s.process.Kill())
+	s.lock.Unlock()
+
+	assert.Eventually(t, func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.restarts == 1 && s.current != nil && s.current.connected()
+	}, 10*time.Second, 10*time.Millisecond)
+	waitForSubscription(t, "auto-goal", 1)
+	assert.Equal(t, types.Healthy, p.Health().Status)
+
+	ih = torrent.InfoHash{2}
+	broadcast.EmitTorrentAdded(broadcast.TorrentAddedEvent{Infohash: ih, Name: "ubuntu.iso"})
+	assert.Equal(t, ih, bridge.receiveGoal(t).infohash)
+}
+
+func TestPlugin_ShouldReportCrashingPluginsAsDegraded(t *testing.T) {
+	p := createPlugin(t, newFakeBridge(), `
+config:
+  plugins:
+    - name: missing
+      command: ./does-not-exist
+  restart:
+    initialBackoff: 10ms
+    maxBackoff: 20ms
+`)
+
+	require.NoError(t, p.Start())
+	defer p.Shutdown(contextWithTimeout(t))
+
+	assert.Eventually(t, func() bool {
+		return p.Health().Status == types.Degraded
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, p.Health().Message, "external plugin 'missing'")
+	assert.Contains(t, p.Health().Message, "does-not-exist")
+}
+
+func TestPlugin_ShouldAcceptPluginsOnSocket(t *testing.T) {
+	// unix socket paths are limited to ~100 chars, the test temp dir may be too long
+	dir, err := os.MkdirTemp("", "joal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "plugins.sock")
+	bridge := newFakeBridge()
+	p := createPlugin(t, bridge, fmt.Sprintf(`
+config:
+  socket:
+    path: %s
+    capabilities: [events]
+`, socket))
+
+	require.NoError(t, p.Start())
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	t.Setenv(pluginsdk.SocketEnv, socket)
+	served := make(chan error, 1)
+	events := make(chan pluginsdk.Event, 10)
+	go func() {
+		served <- pluginsdk.Serve(pluginsdk.Plugin{
+			Name:   "remote",
+			Events: []string{"TorrentRemoved"},
+			OnEvent: func(ctx context.Context, host *pluginsdk.Host, event pluginsdk.Event) {
+				events <- event
+			},
+		})
+	}()
+	waitForSubscription(t, "remote", 1)
+	assert.Equal(t, types.Health{Status: types.Healthy, Message: "connected to the socket: [remote]"}, p.Health())
+
+	broadcast.EmitTorrentRemoved(broadcast.TorrentRemovedEvent{Infohash: torrent.InfoHash{9}})
+	select {
+	case e := <-events:
+		assert.Equal(t, "TorrentRemoved", e.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	p.Shutdown(contextWithTimeout(t))
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin has not been asked to exit")
+	}
+}
+
+func TestPlugin_ShouldStartWithoutExternalPlugins(t *testing.T) {
+	p := createPlugin(t, newFakeBridge(), "")
+
+	assert.NoError(t, p.Start())
+	assert.Equal(t, types.Healthy, p.Health().Status)
+	p.Shutdown(contextWithTimeout(t))
+}
+
+func TestExternalConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    externalConfig
+		wantErr bool
+	}{
+		{name: "valid", conf: externalConfig{Plugins: []*processConfig{{Name: "a", Command: "a", Capabilities: []string{pluginsdk.CapabilityEvents}}}}},
+		{name: "missing-command", conf: externalConfig{Plugins: []*processConfig{{Name: "a"}}}, wantErr: true},
+		{name: "duplicated-name", conf: externalConfig{Plugins: []*processConfig{{Name: "a", Command: "a"}, {Name: "a", Command: "b"}}}, wantErr: true},
+		{name: "unknown-capability", conf: externalConfig{Plugins: []*processConfig{{Name: "a", Command: "a", Capabilities: []string{"root"}}}}, wantErr: true},
+		{name: "socket-without-path", conf: externalConfig{Socket: &socketConfig{}}, wantErr: true},
+		{name: "backoff-inverted", conf: externalConfig{Restart: &restartConfig{InitialBackoff: time.Minute, MaxBackoff: time.Second}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := externalConfig{}.Default()
+			conf.Plugins = tt.conf.Plugins
+			conf.Socket = tt.conf.Socket
+			if tt.conf.Restart != nil {
+				conf.Restart = tt.conf.Restart
+			}
+			err := conf.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/anthonyraymond/joal-cli/pkg/pluginsdk"
+	"go.uber.org/zap"
+	"io"
+	"sync"
+	"time"
+)
+
+// session is the host side of the connection to an external plugin: it answers the calls of the plugin, pushes the
+// events it subscribed to and pings it until the connection is closed
+type session struct {
+	conn    *pluginsdk.Conn
+	bridge  types.ICoreBridge
+	conf    *externalConfig
+	allowed []string
+	log     *zap.Logger
+
+	lock         *sync.Mutex
+	handshake    *pluginsdk.HandshakeParams
+	granted      []string
+	subscription *broadcast.Subscription
+	handshaken   chan struct{}
+}
+
+// newSession starts answering the plugin over rwc, allowed are the capabilities the plugin may be granted, all of them
+// if empty
+func newSession(rwc io.ReadWriteCloser, bridge types.ICoreBridge, conf *externalConfig, allowed []string, log *zap.Logger) *session {
+	s := &session{
+		bridge:     bridge,
+		conf:       conf,
+		allowed:    allowed,
+		log:        log,
+		lock:       &sync.Mutex{},
+		handshaken: make(chan struct{}),
+	}
+	s.conn = pluginsdk.NewConn(rwc, s.handle)
+	return s
+}
+
+// run waits for the handshake then pings the plugin until the connection is closed or ctx is done. The returned error
+// tells why the session ended, it is nil if ctx is done.
+func (s *session) run(ctx context.Context) error {
+	defer s.close()
+
+	timer := time.NewTimer(s.conf.HandshakeTimeout)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return nil
+	case <-s.conn.Done():
+		timer.Stop()
+		return s.connError()
+	case <-timer.C:
+		return fmt.Errorf("no handshake received within %s", s.conf.HandshakeTimeout)
+	case <-s.handshaken:
+		timer.Stop()
+	}
+
+	ticker := time.NewTicker(s.conf.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.conn.Done():
+			return s.connError()
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, s.conf.PingTimeout)
+			err := s.conn.Call(pingCtx, pluginsdk.MethodPing, nil, nil)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				return fmt.Errorf("plugin did not answer ping: %w", err)
+			}
+		}
+	}
+}
+
+// name returns the name the plugin gave in its handshake, empty until then
+func (s *session) name() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.handshake == nil {
+		return ""
+	}
+	return s.handshake.Name
+}
+
+// connected tells if the plugin has done its handshake and is still connected
+func (s *session) connected() bool {
+	select {
+	case <-s.conn.Done():
+		return false
+	case <-s.handshaken:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestShutdown asks the plugin to exit
+func (s *session) requestShutdown() {
+	_ = s.conn.Notify(pluginsdk.MethodShutdown, nil)
+}
+
+func (s *session) close() {
+	s.lock.Lock()
+	if s.subscription != nil {
+		s.subscription.Unsubscribe()
+		s.subscription = nil
+	}
+	s.lock.Unlock()
+	_ = s.conn.Close()
+}
+
+func (s *session) connError() error {
+	if err := s.conn.Err(); err != nil {
+		return err
+	}
+	return pluginsdk.ErrConnClosed
+}
+
+func (s *session) handle(_ context.Context, conn *pluginsdk.Conn, method string, params json.RawMessage) (interface{}, error) {
+	if method == pluginsdk.MethodHandshake {
+		return s.doHandshake(params)
+	}
+
+	s.lock.Lock()
+	handshaken := s.handshake != nil
+	s.lock.Unlock()
+	if !handshaken {
+		return nil, pluginsdk.NewError(pluginsdk.ErrCodeHandshakeRequired, "'%s' called before the handshake", method)
+	}
+
+	switch method {
+	case pluginsdk.MethodSubscribe:
+		if err := s.require(pluginsdk.CapabilityEvents); err != nil {
+			return nil, err
+		}
+		p := pluginsdk.SubscribeParams{}
+		if err := pluginsdk.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		s.subscribe(conn, p)
+		return struct{}{}, nil
+	case pluginsdk.MethodStartSeeding, pluginsdk.MethodStopSeeding, pluginsdk.MethodGetConfig,
+		pluginsdk.MethodUpdateConfig, pluginsdk.MethodAddTorrent, pluginsdk.MethodRemoveTorrent,
+		pluginsdk.MethodSetTorrentGoal, pluginsdk.MethodListClients:
+		if err := s.require(pluginsdk.CapabilityControl); err != nil {
+			return nil, err
+		}
+		result, err := s.callBridge(method, params)
+		if err != nil {
+			if _, ok := err.(*pluginsdk.Error); ok {
+				return nil, err
+			}
+			return nil, pluginsdk.NewError(pluginsdk.ErrCodeCoreFailure, "%s", err)
+		}
+		return result, nil
+	default:
+		return nil, pluginsdk.NewError(pluginsdk.ErrCodeMethodNotFound, "method '%s' not found", method)
+	}
+}
+
+// doHandshake agrees on the highest protocol version both sides support, and grants the capabilities asked for that
+// are allowed
+func (s *session) doHandshake(params json.RawMessage) (interface{}, error) {
+	p := &pluginsdk.HandshakeParams{}
+	if err := pluginsdk.DecodeParams(params, p); err != nil {
+		return nil, err
+	}
+	version := p.ProtocolVersion
+	if version > pluginsdk.ProtocolVersion {
+		version = pluginsdk.ProtocolVersion
+	}
+	if version < pluginsdk.MinProtocolVersion {
+		return nil, pluginsdk.NewError(pluginsdk.ErrCodeUnsupportedVersion, "protocol version %d is not supported, expected %d to %d", p.ProtocolVersion, pluginsdk.MinProtocolVersion, pluginsdk.ProtocolVersion)
+	}
+
+	granted := []string{}
+	for _, c := range p.Capabilities {
+		if !contains(pluginsdk.Capabilities, c) || contains(granted, c) {
+			continue
+		}
+		if len(s.allowed) > 0 && !contains(s.allowed, c) {
+			s.log.Warn("external plugin asked for a capability that is not allowed", zap.String("capability", c))
+			continue
+		}
+		granted = append(granted, c)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.handshake != nil {
+		return nil, pluginsdk.NewError(pluginsdk.ErrCodeInvalidRequest, "handshake already done")
+	}
+	s.handshake = p
+	s.granted = granted
+	close(s.handshaken)
+	s.log.Info("external plugin connected",
+		zap.String("plugin", p.Name),
+		zap.String("version", p.Version),
+		zap.Int("protocolVersion", version),
+		zap.Strings("capabilities", granted),
+	)
+	return &pluginsdk.HandshakeResult{ProtocolVersion: version, Capabilities: granted}, nil
+}
+
+func (s *session) require(capability string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !contains(s.granted, capability) {
+		return pluginsdk.NewError(pluginsdk.ErrCodeCapabilityNotGranted, "capability '%s' has not been granted", capability)
+	}
+	return nil
+}
+
+// subscribe replaces the subscription of the plugin, if any
+func (s *session) subscribe(conn *pluginsdk.Conn, p pluginsdk.SubscribeParams) {
+	options := []broadcast.SubscribeOption{
+		broadcast.WithName("external:" + s.name()),
+		broadcast.WithQueueSize(1024),
+	}
+	if p.AfterSeq > 0 {
+		options = append(options, broadcast.WithReplayAfter(p.AfterSeq))
+	}
+	subscription := broadcast.Subscribe(func(entry broadcast.Entry) {
+		if len(p.Types) > 0 && !contains(p.Types, entry.Type()) {
+			return
+		}
+		payload, err := json.Marshal(types.EventPayload(entry.Event))
+		if err != nil {
+			s.log.Warn("failed to encode event for external plugin", zap.String("type", entry.Type()), zap.Error(err))
+			return
+		}
+		_ = conn.Notify(pluginsdk.MethodEvent, &pluginsdk.Event{
+			Seq:     entry.Seq,
+			Time:    entry.Time,
+			Type:    entry.Type(),
+			Payload: payload,
+		})
+	}, options...)
+
+	s.lock.Lock()
+	previous := s.subscription
+	s.subscription = subscription
+	s.lock.Unlock()
+	if previous != nil {
+		previous.Unsubscribe()
+	}
+	select {
+	case <-conn.Done():
+		// the connection has been closed while subscribing, close may have missed the subscription
+		s.close()
+	default:
+	}
+}
+
+func (s *session) callBridge(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case pluginsdk.MethodStartSeeding:
+		return struct{}{}, s.bridge.StartSeeding()
+	case pluginsdk.MethodStopSeeding:
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		return struct{}{}, s.bridge.StopSeeding(ctx)
+	case pluginsdk.MethodGetConfig:
+		conf, err := s.bridge.GetCoreConfig()
+		if err != nil {
+			return nil, err
+		}
+		return toSdkConfig(conf), nil
+	case pluginsdk.MethodUpdateConfig:
+		p := pluginsdk.RuntimeConfig{}
+		if err := pluginsdk.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		conf, err := s.bridge.UpdateCoreConfig(&types.RuntimeConfig{
+			MinimumBytesPerSeconds: p.MinimumBytesPerSeconds,
+			MaximumBytesPerSeconds: p.MaximumBytesPerSeconds,
+			Client:                 p.Client,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return toSdkConfig(conf), nil
+	case pluginsdk.MethodAddTorrent:
+		p := pluginsdk.AddTorrentParams{}
+		if err := pluginsdk.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Filename == "" {
+			return nil, pluginsdk.NewError(pluginsdk.ErrCodeInvalidParams, "filename is required")
+		}
+		if !types.IsTorrentFileName(p.Filename) {
+			return nil, pluginsdk.NewError(pluginsdk.ErrCodeInvalidParams, "filename '%s' is not a .torrent file name", p.Filename)
+		}
+		return struct{}{}, s.bridge.AddTorrent(p.Filename, bytes.NewReader(p.Content))
+	case pluginsdk.MethodRemoveTorrent:
+		p := pluginsdk.RemoveTorrentParams{}
+		if err := pluginsdk.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		infohash, err := parseInfohash(p.Infohash)
+		if err != nil {
+			return nil, err
+		}
+		return struct{}{}, s.bridge.RemoveTorrent(infohash)
+	case pluginsdk.MethodSetTorrentGoal:
+		p := pluginsdk.SetTorrentGoalParams{}
+		if err := pluginsdk.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		infohash, err := parseInfohash(p.Infohash)
+		if err != nil {
+			return nil, err
+		}
+		var goal *types.TorrentGoal
+		if p.Goal != nil {
+			goal = &types.TorrentGoal{
+				Ratio:       p.Goal.Ratio,
+				SeedingTime: p.Goal.SeedingTime,
+				DailyUpload: p.Goal.DailyUpload,
+				Action:      p.Goal.Action,
+			}
+		}
+		return struct{}{}, s.bridge.SetTorrentGoal(infohash, goal)
+	case pluginsdk.MethodListClients:
+		clients, err := s.bridge.ListClientFiles()
+		if err != nil {
+			return nil, err
+		}
+		if clients == nil {
+			clients = []string{}
+		}
+		return clients, nil
+	}
+	return nil, pluginsdk.NewError(pluginsdk.ErrCodeMethodNotFound, "method '%s' not found", method)
+}
+
+func parseInfohash(s string) (metainfo.Hash, error) {
+	infohash := metainfo.Hash{}
+	if err := infohash.FromHexString(s); err != nil {
+		return infohash, pluginsdk.NewError(pluginsdk.ErrCodeInvalidParams, "invalid infohash '%s'", s)
+	}
+	return infohash, nil
+}
+
+func toSdkConfig(conf *types.RuntimeConfig) *pluginsdk.RuntimeConfig {
+	return &pluginsdk.RuntimeConfig{
+		MinimumBytesPerSeconds: conf.MinimumBytesPerSeconds,
+		MaximumBytesPerSeconds: conf.MaximumBytesPerSeconds,
+		Client:                 conf.Client,
+	}
+}
@@ -0,0 +1,312 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/anthonyraymond/joal-cli/pkg/pluginsdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type goalSet struct {
+	infohash torrent.InfoHash
+	goal     *types.TorrentGoal
+}
+
+// fakeBridge records the goals set and the torrents added by the plugins
+type fakeBridge struct {
+	goals    chan goalSet
+	torrents chan string
+}
+
+func newFakeBridge() *fakeBridge {
+	return &fakeBridge{goals: make(chan goalSet, 10), torrents: make(chan string, 10)}
+}
+
+func (b *fakeBridge) StartSeeding() error                   { return nil }
+func (b *fakeBridge) StopSeeding(ctx context.Context) error { return nil }
+func (b *fakeBridge) GetCoreConfig() (*types.RuntimeConfig, error) {
+	return &types.RuntimeConfig{MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 20, Client: "qbittorrent.client"}, nil
+}
+func (b *fakeBridge) UpdateCoreConfig(config *types.RuntimeConfig) (*types.RuntimeConfig, error) {
+	return config, nil
+}
+func (b *fakeBridge) AddTorrent(filename string, r io.Reader) error {
+	b.torrents <- filename
+	return nil
+}
+func (b *fakeBridge) RemoveTorrent(infohash torrent.InfoHash) error { return nil }
+func (b *fakeBridge) SetTorrentGoal(infohash torrent.InfoHash, goal *types.TorrentGoal) error {
+	b.goals <- goalSet{infohash: infohash, goal: goal}
+	return nil
+}
+func (b *fakeBridge) PauseTorrent(infohash torrent.InfoHash) error    { return nil }
+func (b *fakeBridge) ResumeTorrent(infohash torrent.InfoHash) error   { return nil }
+func (b *fakeBridge) AnnounceTorrent(infohash torrent.InfoHash) error { return nil }
+func (b *fakeBridge) ListClientFiles() ([]string, error)              { return []string{"qbittorrent.client"}, nil }
+func (b *fakeBridge) GetClientFile(file string) (*types.ClientFile, error) {
+	return nil, types.ErrClientNotFound
+}
+func (b *fakeBridge) ValidateClientFile(r io.Reader) (*types.ClientFile, error) {
+	return &types.ClientFile{}, nil
+}
+func (b *fakeBridge) SaveClientFile(file string, r io.Reader) (*types.ClientFile, error) {
+	return &types.ClientFile{File: file}, nil
+}
+
+func (b *fakeBridge) receiveGoal(t *testing.T) goalSet {
+	select {
+	case g := <-b.goals:
+		return g
+	case <-time.After(10 * time.Second):
+		t.Fatal("no goal has been set")
+		return goalSet{}
+	}
+}
+
+func contextWithTimeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func testConfig() *externalConfig {
+	conf := externalConfig{}.Default()
+	conf.HandshakeTimeout = 2 * time.Second
+	conf.PingInterval = 50 * time.Millisecond
+	conf.PingTimeout = time.Second
+	conf.Restart = &restartConfig{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+	return conf
+}
+
+// startSession runs a session over a pipe, the other end is returned to play the plugin
+func startSession(t *testing.T, bridge types.ICoreBridge, conf *externalConfig, allowed []string) (net.Conn, *session, <-chan error) {
+	host, plugin := net.Pipe()
+	s := newSession(host, bridge, conf, allowed, zap.NewNop())
+	ended := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ended <- s.run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		_ = plugin.Close()
+	})
+	return plugin, s, ended
+}
+
+func TestSession_ShouldNegotiateHandshake(t *testing.T) {
+	tests := []struct {
+		name             string
+		version          int
+		capabilities     []string
+		allowed          []string
+		wantVersion      int
+		wantCapabilities []string
+		wantErrCode      int
+	}{
+		{name: "all-capabilities", version: 1, capabilities: pluginsdk.Capabilities, wantVersion: 1, wantCapabilities: pluginsdk.Capabilities},
+		{name: "newer-plugin", version: pluginsdk.ProtocolVersion + 3, capabilities: []string{pluginsdk.CapabilityEvents}, wantVersion: pluginsdk.ProtocolVersion, wantCapabilities: []string{pluginsdk.CapabilityEvents}},
+		{name: "restricted", version: 1, capabilities: pluginsdk.Capabilities, allowed: []string{pluginsdk.CapabilityEvents}, wantVersion: 1, wantCapabilities: []string{pluginsdk.CapabilityEvents}},
+		{name: "unknown-capability", version: 1, capabilities: []string{"teleport", pluginsdk.CapabilityControl}, wantVersion: 1, wantCapabilities: []string{pluginsdk.CapabilityControl}},
+		{name: "outdated-plugin", version: pluginsdk.MinProtocolVersion - 1, wantErrCode: pluginsdk.ErrCodeUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rwc, _, _ := startSession(t, newFakeBridge(), testConfig(), tt.allowed)
+			conn := pluginsdk.NewConn(rwc, nil)
+			defer conn.Close()
+
+			res := pluginsdk.HandshakeResult{}
+			err := conn.Call(contextWithTimeout(t), pluginsdk.MethodHandshake, &pluginsdk.HandshakeParams{
+				ProtocolVersion: tt.version,
+				Name:            "test",
+				Capabilities:    tt.capabilities,
+			}, &res)
+
+			if tt.wantErrCode != 0 {
+				var rpcErr *pluginsdk.Error
+				require.ErrorAs(t, err, &rpcErr)
+				assert.Equal(t, tt.wantErrCode, rpcErr.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantVersion, res.ProtocolVersion)
+			assert.Equal(t, tt.wantCapabilities, res.Capabilities)
+		})
+	}
+}
+
+func TestSession_ShouldRequireHandshake(t *testing.T) {
+	rwc, _, _ := startSession(t, newFakeBridge(), testConfig(), nil)
+	conn := pluginsdk.NewConn(rwc, nil)
+	defer conn.Close()
+
+	err := conn.Call(contextWithTimeout(t), pluginsdk.MethodStartSeeding, nil, nil)
+
+	var rpcErr *pluginsdk.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, pluginsdk.ErrCodeHandshakeRequired, rpcErr.Code)
+}
+
+func TestSession_ShouldEndWithoutHandshake(t *testing.T) {
+	conf := testConfig()
+	conf.HandshakeTimeout = 50 * time.Millisecond
+	_, _, ended := startSession(t, newFakeBridge(), conf, nil)
+
+	select {
+	case err := <-ended:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no handshake")
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end")
+	}
+}
+
+func TestSession_ShouldEndWhenPingIsNotAnswered(t *testing.T) {
+	rwc, _, ended := startSession(t, newFakeBridge(), testConfig(), nil)
+	// a peer without handler answers the pings with an error, as a plugin that does not speak the protocol would
+	conn := pluginsdk.NewConn(rwc, nil)
+	defer conn.Close()
+	require.NoError(t, conn.Call(contextWithTimeout(t), pluginsdk.MethodHandshake, &pluginsdk.HandshakeParams{ProtocolVersion: 1}, nil))
+
+	select {
+	case err := <-ended:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ping")
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end")
+	}
+}
+
+func TestSession_ShouldServeSdkPlugin(t *testing.T) {
+	bridge := newFakeBridge()
+	rwc, s, _ := startSession(t, bridge, testConfig(), nil)
+	started := make(chan *pluginsdk.Host, 1)
+	events := make(chan pluginsdk.Event, 10)
+	go func() {
+		_ = pluginsdk.ServeConn(contextWithTimeout(t), rwc, pluginsdk.Plugin{
+			Name:   "sdk",
+			Events: []string{"TorrentGoalReached"},
+			OnStart: func(ctx context.Context, host *pluginsdk.Host) error {
+				started <- host
+				return nil
+			},
+			OnEvent: func(ctx context.Context, host *pluginsdk.Host, event pluginsdk.Event) {
+				events <- event
+			},
+		})
+	}()
+
+	var host *pluginsdk.Host
+	select {
+	case host = <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin has not started")
+	}
+	assert.True(t, host.Granted(pluginsdk.CapabilityControl))
+	assert.Equal(t, "sdk", s.name())
+
+	conf, err := host.GetConfig(contextWithTimeout(t))
+	require.NoError(t, err)
+	assert.Equal(t, "qbittorrent.client", conf.Client)
+	clients, err := host.ListClients(contextWithTimeout(t))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"qbittorrent.client"}, clients)
+	err = host.RemoveTorrent(contextWithTimeout(t), "not-an-infohash")
+	var rpcErr *pluginsdk.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, pluginsdk.ErrCodeInvalidParams, rpcErr.Code)
+
+	ih := torrent.InfoHash{3}
+	require.NoError(t, host.SetTorrentGoal(contextWithTimeout(t), ih.HexString(), &pluginsdk.TorrentGoal{Ratio: 2, Action: "pause"}))
+	g := bridge.receiveGoal(t)
+	assert.Equal(t, ih, g.infohash)
+	assert.Equal(t, &types.TorrentGoal{Ratio: 2, Action: "pause"}, g.goal)
+
+	// the subscription is made once OnStart returns, wait for it before publishing
+	assert.Eventually(t, func() bool {
+		for _, stats := range broadcast.Stats() {
+			if stats.Name == "external:sdk" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	broadcast.EmitTorrentRemoved(broadcast.TorrentRemovedEvent{Infohash: ih})
+	broadcast.EmitTorrentGoalReached(broadcast.TorrentGoalReachedEvent{Infohash: ih, Goal: "ratio", Action: "pause"})
+
+	select {
+	case e := <-events:
+		assert.Equal(t, "TorrentGoalReached", e.Type)
+		payload := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(e.Payload, &payload))
+		assert.Equal(t, ih.HexString(), payload["infohash"])
+		assert.Equal(t, "ratio", payload["goal"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestSession_ShouldRefuseTorrentFileNamesOutsideOfTheTorrentsDirectory(t *testing.T) {
+	bridge := newFakeBridge()
+	rwc, _, _ := startSession(t, bridge, testConfig(), nil)
+	started := make(chan *pluginsdk.Host, 1)
+	go func() {
+		_ = pluginsdk.ServeConn(contextWithTimeout(t), rwc, pluginsdk.Plugin{
+			Name: "uploader",
+			OnStart: func(ctx context.Context, host *pluginsdk.Host) error {
+				started <- host
+				return nil
+			},
+		})
+	}()
+	var host *pluginsdk.Host
+	select {
+	case host = <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin has not started")
+	}
+
+	for _, filename := range []string{"../core.yml", "../../escape.torrent", "archived/old.torrent", "/tmp/abs.torrent", "not-a-torrent.txt", ".."} {
+		err := host.AddTorrent(contextWithTimeout(t), filename, []byte("d4:infoe"))
+		var rpcErr *pluginsdk.Error
+		require.ErrorAs(t, err, &rpcErr, filename)
+		assert.Equal(t, pluginsdk.ErrCodeInvalidParams, rpcErr.Code, filename)
+	}
+	require.NoError(t, host.AddTorrent(contextWithTimeout(t), "ubuntu.torrent", []byte("d4:infoe")))
+	// only the valid one has reached the core
+	assert.Equal(t, "ubuntu.torrent", <-bridge.torrents)
+	assert.Len(t, bridge.torrents, 0)
+}
+
+func TestSession_ShouldRefuseCallsWithoutCapability(t *testing.T) {
+	rwc, _, _ := startSession(t, newFakeBridge(), testConfig(), []string{pluginsdk.CapabilityEvents})
+	started := make(chan error, 1)
+	go func() {
+		_ = pluginsdk.ServeConn(contextWithTimeout(t), rwc, pluginsdk.Plugin{
+			Name: "read-only",
+			OnStart: func(ctx context.Context, host *pluginsdk.Host) error {
+				started <- host.StartSeeding(ctx)
+				return nil
+			},
+		})
+	}()
+
+	select {
+	case err := <-started:
+		var rpcErr *pluginsdk.Error
+		require.ErrorAs(t, err, &rpcErr)
+		assert.Equal(t, pluginsdk.ErrCodeCapabilityNotGranted, rpcErr.Code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin has not started")
+	}
+}
@@ -0,0 +1,259 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// exitGracePeriod is the time a plugin has to exit once asked to, before being killed
+	exitGracePeriod = 5 * time.Second
+	// stableAfter is the uptime after which a crash is not considered consecutive to the previous one, the restart
+	// backoff starts over
+	stableAfter = 1 * time.Minute
+)
+
+// supervisor runs an external plugin executable, and restarts it with an exponential backoff whenever it crashes,
+// closes its connection or stops answering the pings
+type supervisor struct {
+	conf      *processConfig
+	opts      *externalConfig
+	bridge    types.ICoreBridge
+	configDir string
+	log       *zap.Logger
+
+	lock *sync.Mutex
+	// current and process are the session and the process of the running plugin, nil between two runs
+	current   *session
+	process   *os.Process
+	restarts  int
+	lastError error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newSupervisor(conf *processConfig, opts *externalConfig, bridge types.ICoreBridge, configDir string, log *zap.Logger) *supervisor {
+	return &supervisor{
+		conf:      conf,
+		opts:      opts,
+		bridge:    bridge,
+		configDir: configDir,
+		log:       log.With(zap.String("external", conf.Name)),
+		lock:      &sync.Mutex{},
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *supervisor) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		defer close(s.done)
+		s.supervise(ctx)
+	}()
+}
+
+// stop asks the plugin to exit and waits for it, the plugin is killed if it does not exit in time or if ctx is done
+func (s *supervisor) stop(ctx context.Context) {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+// health returns nil while the plugin is connected, or the reason why it is not
+func (s *supervisor) health() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current != nil && s.current.connected() {
+		return nil
+	}
+	if s.lastError == nil {
+		return fmt.Errorf("not connected yet")
+	}
+	return fmt.Errorf("restarting after %d crash(es), last one: %w", s.restarts, s.lastError)
+}
+
+func (s *supervisor) supervise(ctx context.Context) {
+	backoff := s.opts.Restart.InitialBackoff
+	for {
+		startedAt := time.Now()
+		err := s.runOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(startedAt) > stableAfter {
+			backoff = s.opts.Restart.InitialBackoff
+		}
+
+		s.lock.Lock()
+		s.restarts++
+		s.lastError = err
+		s.lock.Unlock()
+		s.log.Warn("external plugin stopped unexpectedly, restarting", zap.Error(err), zap.Duration("backoff", backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff *= 2
+		if backoff > s.opts.Restart.MaxBackoff {
+			backoff = s.opts.Restart.MaxBackoff
+		}
+	}
+}
+
+// runOnce spawns the plugin and serves it until it stops, the returned error tells why it stopped
+func (s *supervisor) runOnce(ctx context.Context) error {
+	// the pipes are created by hand rather than with cmd.StdoutPipe, which cmd.Wait closes while the session may
+	// still be reading
+	pluginStdin, hostStdin, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	hostStdout, pluginStdout, err := os.Pipe()
+	if err != nil {
+		_ = pluginStdin.Close()
+		_ = hostStdin.Close()
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	logs := &logWriter{log: s.log, lock: &sync.Mutex{}}
+
+	cmd := exec.Command(s.conf.Command, s.conf.Args...)
+	cmd.Dir = s.configDir
+	cmd.Env = append(os.Environ(), s.conf.Env...)
+	cmd.Stdin = pluginStdin
+	cmd.Stdout = pluginStdout
+	cmd.Stderr = logs
+	err = cmd.Start()
+	// the plugin ends of the pipes belong to the child process from now on
+	_ = pluginStdin.Close()
+	_ = pluginStdout.Close()
+	if err != nil {
+		_ = hostStdin.Close()
+		_ = hostStdout.Close()
+		return fmt.Errorf("failed to start '%s': %w", s.conf.Command, err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+		logs.flush()
+	}()
+
+	sess := newSession(&pipes{in: hostStdout, out: hostStdin}, s.bridge, s.opts, s.conf.Capabilities, s.log)
+	s.lock.Lock()
+	s.current = sess
+	s.process = cmd.Process
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.current = nil
+		s.process = nil
+		s.lock.Unlock()
+	}()
+	s.log.Debug("external plugin started", zap.Int("pid", cmd.Process.Pid))
+	sessionCtx, cancelSession := context.WithCancel(context.Background())
+	defer cancelSession()
+	sessionEnded := make(chan error, 1)
+	go func() {
+		sessionEnded <- sess.run(sessionCtx)
+	}()
+
+	select {
+	case err := <-exited:
+		cancelSession()
+		<-sessionEnded
+		if err == nil {
+			return fmt.Errorf("plugin exited")
+		}
+		return fmt.Errorf("plugin exited: %w", err)
+	case err := <-sessionEnded:
+		_ = cmd.Process.Kill()
+		<-exited
+		return err
+	case <-ctx.Done():
+		sess.requestShutdown()
+		timer := time.NewTimer(exitGracePeriod)
+		defer timer.Stop()
+		select {
+		case <-exited:
+		case <-timer.C:
+			s.log.Warn("external plugin did not exit in time, killing it")
+			_ = cmd.Process.Kill()
+			<-exited
+		}
+		cancelSession()
+		<-sessionEnded
+		return nil
+	}
+}
+
+// pipes joins the ends of the stdin and stdout pipes of a plugin kept by the host
+type pipes struct {
+	in  io.ReadCloser
+	out io.WriteCloser
+}
+
+func (p *pipes) Read(b []byte) (int, error) {
+	return p.in.Read(b)
+}
+
+func (p *pipes) Write(b []byte) (int, error) {
+	return p.out.Write(b)
+}
+
+func (p *pipes) Close() error {
+	errIn := p.in.Close()
+	if err := p.out.Close(); err != nil {
+		return err
+	}
+	return errIn
+}
+
+// logWriter logs each line written to the stderr of a plugin
+type logWriter struct {
+	log  *zap.Logger
+	lock *sync.Mutex
+	buf  []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.Info(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.buf) > 0 {
+		w.log.Info(string(w.buf))
+		w.buf = nil
+	}
+}
@@ -2,11 +2,15 @@ package plugins
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
 	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
-	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"io/fs"
 	"net/http"
+	"os"
 	"path/filepath"
 	"sync"
 )
@@ -15,25 +19,77 @@ type IPluginManager interface {
 	BootstrapPlugins(httpClient *http.Client)
 	StartPlugins()
 	ShutdownPlugins(context.Context)
+	// ReloadPlugins applies a new configuration to the started plugins, enabling or disabling a plugin requires a restart
+	ReloadPlugins(conf types.PluginsConfig)
+	Health() []types.PluginHealth
+}
+
+type managedPlugin struct {
+	factory types.PluginFactory
+	// plugin is nil if the plugin is disabled or failed to be created
+	plugin  types.IJoalPlugin
+	started bool
+	// health is reported until the plugin is started
+	health types.Health
 }
 
 type pluginManager struct {
 	pluginsRootDir string
 	bridge         types.ICoreBridge
-	enabledPlugins []types.IJoalPlugin
-	lock           *sync.Mutex
+	config         types.PluginsConfig
+	factories      []types.PluginFactory
+	// plugins are sorted in start order
+	plugins []*managedPlugin
+	// lock serializes the lifecycle operations
+	lock *sync.Mutex
+	// stateLock guards plugins and their state, it is never held while calling a plugin
+	stateLock *sync.RWMutex
 }
 
-func NewPluginManager(appRootDir string, coreBridge types.ICoreBridge) IPluginManager {
+// NewPluginManager manages the plugins registered with types.RegisterPlugin
+func NewPluginManager(appRootDir string, coreBridge types.ICoreBridge, conf types.PluginsConfig) IPluginManager {
+	return newPluginManager(appRootDir, coreBridge, conf, types.RegisteredPlugins())
+}
 
-	pm := &pluginManager{
+func newPluginManager(appRootDir string, coreBridge types.ICoreBridge, conf types.PluginsConfig, factories []types.PluginFactory) *pluginManager {
+	if conf == nil {
+		conf = types.PluginsConfig{}
+	}
+	return &pluginManager{
 		pluginsRootDir: filepath.Join(appRootDir, "plugins"),
 		bridge:         coreBridge,
-		enabledPlugins: []types.IJoalPlugin{},
+		config:         conf,
+		factories:      factories,
+		plugins:        []*managedPlugin{},
 		lock:           &sync.Mutex{},
+		stateLock:      &sync.RWMutex{},
 	}
+}
 
-	return pm
+// ApplyLegacyFlags enables or disables the plugins whose LegacyFlags are found in args, the flags override config.yml
+func ApplyLegacyFlags(conf types.PluginsConfig, args []string) types.PluginsConfig {
+	return applyLegacyFlags(conf, args, types.RegisteredPlugins())
+}
+
+func applyLegacyFlags(conf types.PluginsConfig, args []string, factories []types.PluginFactory) types.PluginsConfig {
+	log := logs.GetLogger()
+	if conf == nil {
+		conf = types.PluginsConfig{}
+	}
+	for _, arg := range args {
+		for _, factory := range factories {
+			enabled, ok := factory.LegacyFlags[arg]
+			if !ok {
+				continue
+			}
+			log.Warn("command line flag is deprecated, enable or disable the plugin in config.yml instead", zap.String("flag", arg), zap.String("plugin", factory.Name))
+			if conf[factory.Name] == nil {
+				conf[factory.Name] = &types.PluginConfig{}
+			}
+			conf[factory.Name].Enabled = &enabled
+		}
+	}
+	return conf
 }
 
 func (pm *pluginManager) BootstrapPlugins(httpClient *http.Client) {
@@ -42,41 +98,309 @@ func (pm *pluginManager) BootstrapPlugins(httpClient *http.Client) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
-	if web.ShouldEnablePlugin() {
-		p, err := web.BootStrap(pm.pluginsRootDir, pm.bridge, httpClient)
+	ordered, orderErrors := startOrder(pm.factories)
+	available := map[string]bool{}
+	for _, factory := range ordered {
+		mp := &managedPlugin{factory: factory}
+		pm.stateLock.Lock()
+		pm.plugins = append(pm.plugins, mp)
+		pm.stateLock.Unlock()
+		pluginLog := log.With(zap.String("plugin", factory.Name))
+
+		if !isEnabledIn(pm.config, factory) {
+			pm.setHealth(mp, types.Disabled, "")
+			continue
+		}
+		if err := orderErrors[factory.Name]; err != nil {
+			pm.setHealth(mp, types.Failed, err.Error())
+			pluginLog.Error("plugin can not be enabled", zap.Error(err))
+			continue
+		}
+		if err := missingDependency(factory, available); err != nil {
+			pm.setHealth(mp, types.Failed, err.Error())
+			pluginLog.Error("plugin can not be enabled", zap.Error(err))
+			continue
+		}
+
+		p, err := pm.create(factory, httpClient, pluginLog)
 		if err != nil {
-			log.Warn("Web plugin has failed to bootstrap, it will stay disabled")
-		} else {
-			pm.enabledPlugins = append(pm.enabledPlugins, p)
-			log.Debug("plugin enabled", zap.String("plugin", p.Name()))
+			pm.setHealth(mp, types.Failed, err.Error())
+			pluginLog.Warn("plugin has failed to bootstrap, it will stay disabled", zap.Error(err))
+			continue
 		}
+		pm.stateLock.Lock()
+		mp.plugin = p
+		mp.health = types.Health{Status: types.Unhealthy, Message: "not started"}
+		pm.stateLock.Unlock()
+		available[factory.Name] = true
+		pluginLog.Debug("plugin enabled")
+	}
+}
+
+func (pm *pluginManager) setHealth(mp *managedPlugin, status types.HealthStatus, message string) {
+	pm.stateLock.Lock()
+	defer pm.stateLock.Unlock()
+	mp.health = types.Health{Status: status, Message: message}
+}
+
+func (pm *pluginManager) setStarted(mp *managedPlugin, started bool) {
+	pm.stateLock.Lock()
+	defer pm.stateLock.Unlock()
+	mp.started = started
+	if !started {
+		mp.health = types.Health{Status: types.Unhealthy, Message: "stopped"}
 	}
 }
 
+// create runs the factory of the plugin, a panic is turned into an error
+func (pm *pluginManager) create(factory types.PluginFactory, httpClient *http.Client, log *zap.Logger) (p types.IJoalPlugin, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin has panicked: %v", r)
+		}
+	}()
+	configDir := filepath.Join(pm.pluginsRootDir, factory.Name)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create folder '%s': %w", configDir, err)
+	}
+	config, err := pm.pluginConfig(factory, log)
+	if err != nil {
+		return nil, err
+	}
+	return factory.New(types.PluginContext{
+		Logger:        log,
+		ConfigDir:     configDir,
+		Bridge:        pm.bridge,
+		HttpClient:    httpClient,
+		PluginsHealth: pm.Health,
+	}, config)
+}
+
+// pluginConfig returns the configuration of the plugin in config.yml. A plugin without a config key there is configured
+// by its legacy <name>.yml file, in its config directory, if the file is not empty.
+func (pm *pluginManager) pluginConfig(factory types.PluginFactory, log *zap.Logger) (*types.PluginConfig, error) {
+	conf := pm.config[factory.Name]
+	if conf != nil && conf.Config.Kind != 0 {
+		return conf, nil
+	}
+	legacyFile := filepath.Join(pm.pluginsRootDir, factory.Name, factory.Name+".yml")
+	content, err := os.ReadFile(legacyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy config file '%s': %w", legacyFile, err)
+	}
+	legacy := &types.PluginConfig{}
+	if err := yaml.Unmarshal(content, &legacy.Config); err != nil {
+		return nil, fmt.Errorf("failed to parse legacy config file '%s': %w", legacyFile, err)
+	}
+	if legacy.Config.Kind == 0 {
+		return conf, nil
+	}
+	if conf != nil {
+		legacy.Enabled = conf.Enabled
+	}
+	log.Warn("plugin is configured by its legacy config file, move its content under the 'config' key of the plugin in config.yml", zap.String("file", legacyFile))
+	return legacy, nil
+}
+
 func (pm *pluginManager) StartPlugins() {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 	log := logs.GetLogger()
-	for _, plugin := range pm.enabledPlugins {
-		err := plugin.Start()
-		if err != nil {
-			log.Error("plugin has failed to start", zap.String("plugin", plugin.Name()), zap.Error(err))
+
+	started := map[string]bool{}
+	for _, mp := range pm.plugins {
+		if mp.plugin == nil || mp.started {
+			started[mp.factory.Name] = mp.started
+			continue
 		}
+		pluginLog := log.With(zap.String("plugin", mp.factory.Name))
+		if err := missingDependency(mp.factory, started); err != nil {
+			pm.setHealth(mp, types.Failed, err.Error())
+			pluginLog.Error("plugin can not be started", zap.Error(err))
+			continue
+		}
+		if err := start(mp.plugin); err != nil {
+			pm.setHealth(mp, types.Failed, err.Error())
+			pluginLog.Error("plugin has failed to start", zap.Error(err))
+			mp.plugin.Shutdown(context.Background())
+			continue
+		}
+		pm.setStarted(mp, true)
+		started[mp.factory.Name] = true
 	}
 }
 
+// start starts the plugin, a panic is turned into an error
+func start(p types.IJoalPlugin) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin has panicked: %v", r)
+		}
+	}()
+	return p.Start()
+}
+
+// ShutdownPlugins stops the started plugins in the reverse order of their start
 func (pm *pluginManager) ShutdownPlugins(ctx context.Context) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
+	log := logs.GetLogger()
 
-	wg := &sync.WaitGroup{}
-	for _, plugin := range pm.enabledPlugins {
-		wg.Add(1)
-		go func(plugin types.IJoalPlugin) {
-			defer wg.Done()
-			plugin.Shutdown(ctx)
-		}(plugin)
+	for i := len(pm.plugins) - 1; i >= 0; i-- {
+		mp := pm.plugins[i]
+		if !mp.started {
+			continue
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("plugin has panicked while shutting down", zap.String("plugin", mp.factory.Name), zap.Any("panic", r))
+				}
+			}()
+			mp.plugin.Shutdown(ctx)
+		}()
+		pm.setStarted(mp, false)
 	}
+}
 
-	wg.Wait()
+func (pm *pluginManager) ReloadPlugins(conf types.PluginsConfig) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	log := logs.GetLogger()
+
+	if conf == nil {
+		conf = types.PluginsConfig{}
+	}
+	previous := pm.config
+	pm.config = conf
+	for _, mp := range pm.plugins {
+		pluginLog := log.With(zap.String("plugin", mp.factory.Name))
+		wasEnabled := isEnabledIn(previous, mp.factory)
+		if isEnabledIn(conf, mp.factory) != wasEnabled {
+			pluginLog.Warn("enabling or disabling a plugin requires a restart")
+		}
+		if !mp.started {
+			continue
+		}
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("plugin has panicked: %v", r)
+				}
+			}()
+			config, err := pm.pluginConfig(mp.factory, pluginLog)
+			if err != nil {
+				return err
+			}
+			return mp.plugin.Reload(config)
+		}()
+		if err != nil {
+			pluginLog.Error("plugin has failed to reload its configuration", zap.Error(err))
+			continue
+		}
+		pluginLog.Info("plugin configuration reloaded")
+	}
+}
+
+func isEnabledIn(conf types.PluginsConfig, factory types.PluginFactory) bool {
+	c, ok := conf[factory.Name]
+	if !ok || c == nil || c.Enabled == nil {
+		return factory.EnabledByDefault
+	}
+	return *c.Enabled
+}
+
+// Health returns the health of every registered plugin, in start order. It does not wait for the lifecycle operations,
+// a plugin may report the health of the plugins while it is being stopped.
+func (pm *pluginManager) Health() []types.PluginHealth {
+	pm.stateLock.RLock()
+	plugins := make([]managedPlugin, 0, len(pm.plugins))
+	for _, mp := range pm.plugins {
+		plugins = append(plugins, *mp)
+	}
+	pm.stateLock.RUnlock()
+
+	res := make([]types.PluginHealth, 0, len(plugins))
+	for _, mp := range plugins {
+		health := mp.health
+		if mp.started {
+			health = pluginHealth(mp.plugin)
+		}
+		res = append(res, types.PluginHealth{Name: mp.factory.Name, Health: health})
+	}
+	return res
+}
+
+func pluginHealth(p types.IJoalPlugin) (h types.Health) {
+	defer func() {
+		if r := recover(); r != nil {
+			h = types.Health{Status: types.Unhealthy, Message: fmt.Sprintf("plugin has panicked: %v", r)}
+		}
+	}()
+	return p.Health()
+}
+
+// startOrder sorts the factories so that each plugin comes after its dependencies, the plugins that do not depend on
+// each other keep the order of factories. The plugins with a missing dependency or in a dependency cycle are put last
+// with an error.
+func startOrder(factories []types.PluginFactory) ([]types.PluginFactory, map[string]error) {
+	errs := map[string]error{}
+	registered := map[string]bool{}
+	for _, f := range factories {
+		registered[f.Name] = true
+	}
+	for _, f := range factories {
+		for _, dep := range f.DependsOn {
+			if !registered[dep] {
+				errs[f.Name] = fmt.Errorf("plugin depends on '%s' which is not registered", dep)
+			}
+		}
+	}
+
+	var ordered []types.PluginFactory
+	placed := map[string]bool{}
+	for len(ordered) < len(factories) {
+		progress := false
+		for _, f := range factories {
+			if placed[f.Name] || errs[f.Name] != nil {
+				continue
+			}
+			ready := true
+			for _, dep := range f.DependsOn {
+				ready = ready && placed[dep]
+			}
+			if ready {
+				ordered = append(ordered, f)
+				placed[f.Name] = true
+				progress = true
+				// restart from the first factory to honor the order
+				break
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	for _, f := range factories {
+		if placed[f.Name] {
+			continue
+		}
+		if errs[f.Name] == nil {
+			errs[f.Name] = fmt.Errorf("plugin is part of a dependency cycle or depends on a plugin that can not start")
+		}
+		ordered = append(ordered, f)
+	}
+	return ordered, errs
+}
+
+func missingDependency(factory types.PluginFactory, available map[string]bool) error {
+	for _, dep := range factory.DependsOn {
+		if !available[dep] {
+			return fmt.Errorf("plugin depends on '%s' which is not available", dep)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,245 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeConfig struct {
+	Value string `yaml:"value"`
+}
+
+type fakePlugin struct {
+	name     string
+	startErr error
+	panics   bool
+	config   fakeConfig
+	journal  *[]string
+}
+
+func (p *fakePlugin) Name() string {
+	return p.name
+}
+
+func (p *fakePlugin) Start() error {
+	if p.panics {
+		panic("boom")
+	}
+	*p.journal = append(*p.journal, "start "+p.name)
+	return p.startErr
+}
+
+func (p *fakePlugin) Shutdown(_ context.Context) {
+	*p.journal = append(*p.journal, "shutdown "+p.name)
+}
+
+func (p *fakePlugin) Health() types.Health {
+	return types.Health{Status: types.Healthy, Message: p.config.Value}
+}
+
+func (p *fakePlugin) Reload(config *types.PluginConfig) error {
+	conf := fakeConfig{}
+	if err := config.Decode(&conf); err != nil {
+		return err
+	}
+	p.config = conf
+	return nil
+}
+
+type fakeFactoryOpts struct {
+	dependsOn []string
+	disabled  bool
+	newErr    error
+	startErr  error
+	panics    bool
+}
+
+func fakeFactory(journal *[]string, name string, opts fakeFactoryOpts) types.PluginFactory {
+	return types.PluginFactory{
+		Name:             name,
+		DependsOn:        opts.dependsOn,
+		EnabledByDefault: !opts.disabled,
+		New: func(ctx types.PluginContext, config *types.PluginConfig) (types.IJoalPlugin, error) {
+			if opts.newErr != nil {
+				return nil, opts.newErr
+			}
+			p := &fakePlugin{name: name, startErr: opts.startErr, panics: opts.panics, journal: journal}
+			if err := config.Decode(&p.config); err != nil {
+				return nil, err
+			}
+			return p, nil
+		},
+	}
+}
+
+func healthByName(pm IPluginManager) map[string]types.Health {
+	res := map[string]types.Health{}
+	for _, h := range pm.Health() {
+		res[h.Name] = h.Health
+	}
+	return res
+}
+
+func TestPluginManager_ShouldStartInDependencyOrderAndShutdownInReverse(t *testing.T) {
+	var journal []string
+	pm := newPluginManager(t.TempDir(), nil, nil, []types.PluginFactory{
+		fakeFactory(&journal, "a", fakeFactoryOpts{dependsOn: []string{"c"}}),
+		fakeFactory(&journal, "b", fakeFactoryOpts{}),
+		fakeFactory(&journal, "c", fakeFactoryOpts{dependsOn: []string{"b"}}),
+		fakeFactory(&journal, "d", fakeFactoryOpts{}),
+	})
+	pm.BootstrapPlugins(http.DefaultClient)
+	pm.StartPlugins()
+	pm.ShutdownPlugins(context.Background())
+
+	assert.Equal(t, []string{
+		"start b", "start c", "start a", "start d",
+		"shutdown d", "shutdown a", "shutdown c", "shutdown b",
+	}, journal)
+}
+
+func TestPluginManager_ShouldCreateAScopedConfigDir(t *testing.T) {
+	root := t.TempDir()
+	var configDir string
+	pm := newPluginManager(root, nil, nil, []types.PluginFactory{{
+		Name:             "scoped",
+		EnabledByDefault: true,
+		New: func(ctx types.PluginContext, config *types.PluginConfig) (types.IJoalPlugin, error) {
+			configDir = ctx.ConfigDir
+			return &fakePlugin{name: "scoped", journal: &[]string{}}, nil
+		},
+	}})
+	pm.BootstrapPlugins(http.DefaultClient)
+
+	assert.Equal(t, filepath.Join(root, "plugins", "scoped"), configDir)
+	info, err := os.Stat(configDir)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestPluginManager_ShouldIsolateFailures(t *testing.T) {
+	var journal []string
+	enabled := true
+	pm := newPluginManager(t.TempDir(), nil, types.PluginsConfig{"optional": {Enabled: &enabled}}, []types.PluginFactory{
+		fakeFactory(&journal, "healthy", fakeFactoryOpts{}),
+		fakeFactory(&journal, "broken-new", fakeFactoryOpts{newErr: errors.New("no config")}),
+		fakeFactory(&journal, "broken-start", fakeFactoryOpts{startErr: errors.New("port in use")}),
+		fakeFactory(&journal, "panics", fakeFactoryOpts{panics: true}),
+		fakeFactory(&journal, "needs-broken-new", fakeFactoryOpts{dependsOn: []string{"broken-new"}}),
+		fakeFactory(&journal, "needs-broken-start", fakeFactoryOpts{dependsOn: []string{"broken-start"}}),
+		fakeFactory(&journal, "needs-unknown", fakeFactoryOpts{dependsOn: []string{"unknown"}}),
+		fakeFactory(&journal, "cycle-1", fakeFactoryOpts{dependsOn: []string{"cycle-2"}}),
+		fakeFactory(&journal, "cycle-2", fakeFactoryOpts{dependsOn: []string{"cycle-1"}}),
+		fakeFactory(&journal, "disabled", fakeFactoryOpts{disabled: true}),
+		fakeFactory(&journal, "optional", fakeFactoryOpts{disabled: true}),
+	})
+	pm.BootstrapPlugins(http.DefaultClient)
+	pm.StartPlugins()
+
+	health := healthByName(pm)
+	assert.Equal(t, types.Healthy, health["healthy"].Status)
+	assert.Equal(t, types.Healthy, health["optional"].Status)
+	assert.Equal(t, types.Disabled, health["disabled"].Status)
+	for _, name := range []string{"broken-new", "broken-start", "panics", "needs-broken-new", "needs-broken-start", "needs-unknown", "cycle-1", "cycle-2"} {
+		assert.Equal(t, types.Failed, health[name].Status, name)
+		assert.NotEmpty(t, health[name].Message, name)
+	}
+	assert.Contains(t, health["broken-start"].Message, "port in use")
+	assert.Contains(t, health["panics"].Message, "boom")
+	assert.Contains(t, journal, "shutdown broken-start")
+	assert.NotContains(t, journal, "start needs-broken-start")
+}
+
+func TestPluginManager_ShouldReloadStartedPlugins(t *testing.T) {
+	var journal []string
+	decode := func(yml string) types.PluginsConfig {
+		conf := types.PluginsConfig{}
+		require.NoError(t, yaml.Unmarshal([]byte(yml), &conf))
+		return conf
+	}
+	pm := newPluginManager(t.TempDir(), nil, decode("a:\n  config:\n    value: first\n"), []types.PluginFactory{
+		fakeFactory(&journal, "a", fakeFactoryOpts{}),
+	})
+	pm.BootstrapPlugins(http.DefaultClient)
+	pm.StartPlugins()
+	assert.Equal(t, "first", healthByName(pm)["a"].Message)
+
+	pm.ReloadPlugins(decode("a:\n  config:\n    value: second\n"))
+	assert.Equal(t, "second", healthByName(pm)["a"].Message)
+
+	pm.ShutdownPlugins(context.Background())
+	assert.Equal(t, types.Unhealthy, healthByName(pm)["a"].Status)
+}
+
+func TestStartOrder_ShouldKeepFactoriesOrderAfterTheirDependencies(t *testing.T) {
+	var journal []string
+	factories := []types.PluginFactory{
+		fakeFactory(&journal, "z", fakeFactoryOpts{}),
+		fakeFactory(&journal, "a", fakeFactoryOpts{}),
+		fakeFactory(&journal, "b", fakeFactoryOpts{dependsOn: []string{"c"}}),
+		fakeFactory(&journal, "c", fakeFactoryOpts{}),
+	}
+	ordered, errs := startOrder(factories)
+
+	var names []string
+	for _, f := range ordered {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"z", "a", "c", "b"}, names)
+	assert.Empty(t, errs)
+}
+
+func TestPluginManager_ShouldReadLegacyConfigFileWhenConfigYmlDoesNotConfigureThePlugin(t *testing.T) {
+	var journal []string
+	root := t.TempDir()
+	for name, content := range map[string]string{"legacy": "value: from-file\n", "both": "value: from-file\n", "empty": ""} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "plugins", name), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "plugins", name, name+".yml"), []byte(content), 0644))
+	}
+	conf := types.PluginsConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte("both:\n  config:\n    value: from-config-yml\n"), &conf))
+	pm := newPluginManager(root, nil, conf, []types.PluginFactory{
+		fakeFactory(&journal, "legacy", fakeFactoryOpts{}),
+		fakeFactory(&journal, "both", fakeFactoryOpts{}),
+		fakeFactory(&journal, "empty", fakeFactoryOpts{}),
+	})
+	pm.BootstrapPlugins(http.DefaultClient)
+	pm.StartPlugins()
+	defer pm.ShutdownPlugins(context.Background())
+
+	health := healthByName(pm)
+	assert.Equal(t, "from-file", health["legacy"].Message)
+	assert.Equal(t, "from-config-yml", health["both"].Message)
+	assert.Equal(t, types.Healthy, health["empty"].Status)
+	assert.Equal(t, "", health["empty"].Message)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "plugins", "legacy", "legacy.yml"), []byte("value: reloaded\n"), 0644))
+	pm.ReloadPlugins(conf)
+	assert.Equal(t, "reloaded", healthByName(pm)["legacy"].Message)
+}
+
+func TestApplyLegacyFlags_ShouldOverrideConfigYml(t *testing.T) {
+	var journal []string
+	web := fakeFactory(&journal, "web", fakeFactoryOpts{})
+	web.LegacyFlags = map[string]bool{"--no-webui": false}
+	metrics := fakeFactory(&journal, "metrics", fakeFactoryOpts{disabled: true})
+	metrics.LegacyFlags = map[string]bool{"--metrics": true}
+	factories := []types.PluginFactory{web, metrics}
+
+	enabled := true
+	conf := applyLegacyFlags(types.PluginsConfig{"web": {Enabled: &enabled}}, []string{"--no-webui", "--metrics"}, factories)
+	assert.False(t, isEnabledIn(conf, web))
+	assert.True(t, isEnabledIn(conf, metrics))
+
+	conf = applyLegacyFlags(nil, nil, factories)
+	assert.True(t, isEnabledIn(conf, web))
+	assert.False(t, isEnabledIn(conf, metrics))
+}
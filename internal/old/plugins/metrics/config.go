@@ -0,0 +1,18 @@
+package metrics
+
+type metricsConfig struct {
+	Port int    `yaml:"port"`
+	Path string `yaml:"path"`
+	// TorrentLabels exports the per-torrent gauges with an infohash and a name label, otherwise the gauges are the sum
+	// over all the torrents. Each torrent adds a time series per gauge.
+	TorrentLabels bool `yaml:"torrentLabels"`
+}
+
+// Return a new metricsConfig with the default values filled in
+func (c metricsConfig) Default() *metricsConfig {
+	return &metricsConfig{
+		Port:          7042,
+		Path:          "/metrics",
+		TorrentLabels: false,
+	}
+}
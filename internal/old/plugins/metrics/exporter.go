@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/torrent2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const namespace = "joal"
+
+type torrentMetrics struct {
+	name     string
+	seeders  int32
+	leechers int32
+	// speed in bytes per second
+	speed    float64
+	uploaded int64
+}
+
+type announceKey struct {
+	infohash torrent.InfoHash
+	tracker  string
+	event    tracker.AnnounceEvent
+}
+
+// exporter turns the events of the broadcast bus into prometheus metrics. Counters and histograms are updated as the
+// events come, the gauges are computed from the state of the exporter on each scrape.
+type exporter struct {
+	torrentLabels      bool
+	announces          *prometheus.CounterVec
+	announceDuration   *prometheus.HistogramVec
+	torrents           map[torrent.InfoHash]*torrentMetrics
+	pendingAnnounces   map[announceKey]time.Time
+	availableBandwidth int64
+	lock               *sync.Mutex
+
+	torrentsDesc            *prometheus.Desc
+	seedersDesc             *prometheus.Desc
+	leechersDesc            *prometheus.Desc
+	speedDesc               *prometheus.Desc
+	uploadedDesc            *prometheus.Desc
+	bandwidthDesc           *prometheus.Desc
+	announceQueueDesc       *prometheus.Desc
+	announcesInFlightDesc   *prometheus.Desc
+	listenerConnectionsDesc *prometheus.Desc
+	subscriptionsDesc       *prometheus.Desc
+	subscriptionQueuedDesc  *prometheus.Desc
+	subscriptionDroppedDesc *prometheus.Desc
+}
+
+func newExporter(torrentLabels bool) *exporter {
+	var labels []string
+	perTorrent := "summed over all the torrents"
+	if torrentLabels {
+		labels = []string{"infohash", "name"}
+		perTorrent = "per torrent"
+	}
+	return &exporter{
+		torrentLabels: torrentLabels,
+		announces: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "announces_total",
+			Help:      "Number of announces by tracker host, announce event and outcome.",
+		}, []string{"tracker", "event", "outcome"}),
+		announceDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "announce_duration_seconds",
+			Help:      "Time between the start of an announce and its response, by tracker host.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"tracker"}),
+		torrents:         make(map[torrent.InfoHash]*torrentMetrics),
+		pendingAnnounces: make(map[announceKey]time.Time),
+		lock:             &sync.Mutex{},
+
+		torrentsDesc:            prometheus.NewDesc(namespace+"_torrents", "Number of seeded torrents.", nil, nil),
+		seedersDesc:             prometheus.NewDesc(namespace+"_torrent_seeders", "Seeders reported by the trackers, "+perTorrent+".", labels, nil),
+		leechersDesc:            prometheus.NewDesc(namespace+"_torrent_leechers", "Leechers reported by the trackers, "+perTorrent+".", labels, nil),
+		speedDesc:               prometheus.NewDesc(namespace+"_torrent_upload_speed_bytes", "Upload speed in bytes per second, "+perTorrent+".", labels, nil),
+		uploadedDesc:            prometheus.NewDesc(namespace+"_torrent_uploaded_bytes", "Uploaded bytes at the last announce, "+perTorrent+".", labels, nil),
+		bandwidthDesc:           prometheus.NewDesc(namespace+"_bandwidth_available_bytes", "Global upload bandwidth in bytes per second.", nil, nil),
+		announceQueueDesc:       prometheus.NewDesc(namespace+"_announce_queue_depth", "Announces queued and waiting to be sent.", nil, nil),
+		announcesInFlightDesc:   prometheus.NewDesc(namespace+"_announces_in_flight", "Announces started and waiting for a response.", nil, nil),
+		listenerConnectionsDesc: prometheus.NewDesc(namespace+"_listener_connections_total", "Peer connections accepted by the listener.", nil, nil),
+		subscriptionsDesc:       prometheus.NewDesc(namespace+"_event_subscriptions", "Listeners subscribed to the core events.", nil, nil),
+		subscriptionQueuedDesc:  prometheus.NewDesc(namespace+"_event_subscription_queued", "Events waiting to be delivered, by subscription.", []string{"subscription"}, nil),
+		subscriptionDroppedDesc: prometheus.NewDesc(namespace+"_event_subscription_dropped_total", "Events dropped because the queue was full, by subscription.", []string{"subscription"}, nil),
+	}
+}
+
+// subscribe starts feeding the exporter with the events of the broadcast bus
+func (e *exporter) subscribe() *broadcast.Subscription {
+	return broadcast.Subscribe(e.handle, broadcast.WithName("metrics"), broadcast.WithQueueSize(4096))
+}
+
+func (e *exporter) handle(entry broadcast.Entry) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch event := entry.Event.(type) {
+	case broadcast.TorrentAddedEvent:
+		if _, ok := e.torrents[event.Infohash]; !ok {
+			e.torrents[event.Infohash] = &torrentMetrics{}
+		}
+		e.torrents[event.Infohash].name = event.Name
+	case broadcast.TorrentRemovedEvent:
+		delete(e.torrents, event.Infohash)
+		for key := range e.pendingAnnounces {
+			if key.infohash == event.Infohash {
+				delete(e.pendingAnnounces, key)
+			}
+		}
+	case broadcast.SeedStoppedEvent:
+		e.pendingAnnounces = make(map[announceKey]time.Time)
+		for _, t := range e.torrents {
+			t.speed = 0
+		}
+	case broadcast.TorrentAnnouncingEvent:
+		e.pendingAnnounces[announceKey{event.Infohash, event.TrackerUrl.String(), event.AnnounceEvent}] = entry.Time
+		if t, ok := e.torrents[event.Infohash]; ok {
+			t.uploaded = event.Uploaded
+		}
+	case broadcast.TorrentAnnounceSuccessEvent:
+		e.announceDone(entry, event.Infohash, event.TrackerUrl, event.AnnounceEvent, "success")
+		if t, ok := e.torrents[event.Infohash]; ok {
+			t.seeders = event.Seeder
+			t.leechers = event.Leechers
+		}
+	case broadcast.TorrentAnnounceFailedEvent:
+		e.announceDone(entry, event.Infohash, event.TrackerUrl, event.AnnounceEvent, "failure")
+	case broadcast.TorrentSwarmChangedEvent:
+		if t, ok := e.torrents[event.Infohash]; ok {
+			t.seeders = event.Seeder
+			t.leechers = event.Leechers
+		}
+	case broadcast.GlobalBandwidthChangedEvent:
+		e.availableBandwidth = event.AvailableBandwidth
+	case broadcast.BandwidthWeightHasChangedEvent:
+		for infohash, t := range e.torrents {
+			t.speed = event.TorrentWeights[infohash]
+		}
+	}
+}
+
+// announceDone counts the announce and observes its latency if it has been seen starting. The lock has to be held by
+// the caller
+func (e *exporter) announceDone(entry broadcast.Entry, infohash torrent.InfoHash, trackerUrl url.URL, event tracker.AnnounceEvent, outcome string) {
+	host := trackerUrl.Hostname()
+	e.announces.WithLabelValues(host, event.String(), outcome).Inc()
+
+	key := announceKey{infohash, trackerUrl.String(), event}
+	if start, ok := e.pendingAnnounces[key]; ok {
+		e.announceDuration.WithLabelValues(host).Observe(entry.Time.Sub(start).Seconds())
+		delete(e.pendingAnnounces, key)
+	}
+}
+
+func (e *exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.announces.Describe(ch)
+	e.announceDuration.Describe(ch)
+	ch <- e.torrentsDesc
+	ch <- e.seedersDesc
+	ch <- e.leechersDesc
+	ch <- e.speedDesc
+	ch <- e.uploadedDesc
+	ch <- e.bandwidthDesc
+	ch <- e.announceQueueDesc
+	ch <- e.announcesInFlightDesc
+	ch <- e.listenerConnectionsDesc
+	ch <- e.subscriptionsDesc
+	ch <- e.subscriptionQueuedDesc
+	ch <- e.subscriptionDroppedDesc
+}
+
+func (e *exporter) Collect(ch chan<- prometheus.Metric) {
+	e.announces.Collect(ch)
+	e.announceDuration.Collect(ch)
+
+	e.lock.Lock()
+	ch <- prometheus.MustNewConstMetric(e.torrentsDesc, prometheus.GaugeValue, float64(len(e.torrents)))
+	if e.torrentLabels {
+		for infohash, t := range e.torrents {
+			e.collectTorrent(ch, t, infohash.HexString(), t.name)
+		}
+	} else {
+		sum := &torrentMetrics{}
+		for _, t := range e.torrents {
+			sum.seeders += t.seeders
+			sum.leechers += t.leechers
+			sum.speed += t.speed
+			sum.uploaded += t.uploaded
+		}
+		e.collectTorrent(ch, sum)
+	}
+	ch <- prometheus.MustNewConstMetric(e.bandwidthDesc, prometheus.GaugeValue, float64(e.availableBandwidth))
+	ch <- prometheus.MustNewConstMetric(e.announcesInFlightDesc, prometheus.GaugeValue, float64(len(e.pendingAnnounces)))
+	e.lock.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.announceQueueDesc, prometheus.GaugeValue, float64(torrent2.QueuedAnnounces()))
+	ch <- prometheus.MustNewConstMetric(e.listenerConnectionsDesc, prometheus.CounterValue, float64(emulatedclient.AcceptedConnections()))
+
+	// subscriptions sharing a name are reported together, to keep the label values unique
+	stats := broadcast.Stats()
+	queued := make(map[string]int)
+	dropped := make(map[string]uint64)
+	for _, s := range stats {
+		queued[s.Name] += s.Queued
+		dropped[s.Name] += s.Dropped
+	}
+	ch <- prometheus.MustNewConstMetric(e.subscriptionsDesc, prometheus.GaugeValue, float64(len(stats)))
+	for name := range queued {
+		ch <- prometheus.MustNewConstMetric(e.subscriptionQueuedDesc, prometheus.GaugeValue, float64(queued[name]), name)
+		ch <- prometheus.MustNewConstMetric(e.subscriptionDroppedDesc, prometheus.CounterValue, float64(dropped[name]), name)
+	}
+}
+
+func (e *exporter) collectTorrent(ch chan<- prometheus.Metric, t *torrentMetrics, labelValues ...string) {
+	ch <- prometheus.MustNewConstMetric(e.seedersDesc, prometheus.GaugeValue, float64(t.seeders), labelValues...)
+	ch <- prometheus.MustNewConstMetric(e.leechersDesc, prometheus.GaugeValue, float64(t.leechers), labelValues...)
+	ch <- prometheus.MustNewConstMetric(e.speedDesc, prometheus.GaugeValue, t.speed, labelValues...)
+	ch <- prometheus.MustNewConstMetric(e.uploadedDesc, prometheus.GaugeValue, float64(t.uploaded), labelValues...)
+}
+
+// handler serves the metrics of the exporter along with the go runtime and process metrics, in the prometheus text
+// format or in OpenMetrics if the scraper asks for it
+func (e *exporter) handler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e, prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/tracker"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/announces"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/torrent2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, e *exporter) string {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	e.handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func feed(e *exporter, start time.Time, events ...any) {
+	for i, event := range events {
+		e.handle(broadcast.Entry{Seq: uint64(i + 1), Time: start.Add(time.Duration(i) * 100 * time.Millisecond), Event: event})
+	}
+}
+
+func seedingEvents(ih1, ih2 torrent.InfoHash) []any {
+	trackerUrl, _ := url.Parse("http://tracker.example.org:8080/announce")
+	return []any{
+		broadcast.TorrentAddedEvent{Infohash: ih1, Name: "first"},
+		broadcast.TorrentAddedEvent{Infohash: ih2, Name: "second"},
+		broadcast.TorrentAnnouncingEvent{Infohash: ih1, TrackerUrl: *trackerUrl, AnnounceEvent: tracker.Started, Uploaded: 1000},
+		broadcast.TorrentAnnouncingEvent{Infohash: ih2, TrackerUrl: *trackerUrl, AnnounceEvent: tracker.Started, Uploaded: 500},
+		broadcast.TorrentAnnounceSuccessEvent{Infohash: ih1, TrackerUrl: *trackerUrl, AnnounceEvent: tracker.Started, Seeder: 20, Leechers: 10},
+		broadcast.TorrentAnnounceFailedEvent{Infohash: ih2, TrackerUrl: *trackerUrl, AnnounceEvent: tracker.Started},
+		broadcast.TorrentAnnouncingEvent{Infohash: ih2, TrackerUrl: *trackerUrl, AnnounceEvent: tracker.Started, Uploaded: 500},
+		broadcast.TorrentSwarmChangedEvent{Infohash: ih2, Seeder: 5, Leechers: 3},
+		broadcast.GlobalBandwidthChangedEvent{AvailableBandwidth: 3000},
+		broadcast.BandwidthWeightHasChangedEvent{TotalWeight: 3000, TorrentWeights: map[torrent.InfoHash]float64{ih1: 2000, ih2: 1000}},
+	}
+}
+
+func TestExporter_ShouldExportAnnouncesAndAggregatedTorrentGauges(t *testing.T) {
+	e := newExporter(false)
+	feed(e, time.Now(), seedingEvents(torrent.InfoHash{1}, torrent.InfoHash{2})...)
+
+	body := scrape(t, e)
+	for _, line := range []string{
+		`joal_announces_total{event="started",outcome="success",tracker="tracker.example.org"} 1`,
+		`joal_announces_total{event="started",outcome="failure",tracker="tracker.example.org"} 1`,
+		`joal_announce_duration_seconds_count{tracker="tracker.example.org"} 2`,
+		`joal_announce_duration_seconds_sum{tracker="tracker.example.org"} 0.4`,
+		`joal_announce_queue_depth 0`,
+		`joal_announces_in_flight 1`,
+		`joal_torrents 2`,
+		`joal_torrent_seeders 25`,
+		`joal_torrent_leechers 13`,
+		`joal_torrent_upload_speed_bytes 3000`,
+		`joal_torrent_uploaded_bytes 1500`,
+		`joal_bandwidth_available_bytes 3000`,
+		`joal_listener_connections_total 0`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.NotContains(t, body, "infohash=")
+}
+
+func TestExporter_ShouldLabelTorrentGaugesWhenOptedIn(t *testing.T) {
+	e := newExporter(true)
+	ih1 := torrent.InfoHash{1}
+	ih2 := torrent.InfoHash{2}
+	feed(e, time.Now(), seedingEvents(ih1, ih2)...)
+
+	body := scrape(t, e)
+	assert.Contains(t, body, `joal_torrent_seeders{infohash="`+ih1.HexString()+`",name="first"} 20`+"\n")
+	assert.Contains(t, body, `joal_torrent_seeders{infohash="`+ih2.HexString()+`",name="second"} 5`+"\n")
+	assert.Contains(t, body, `joal_torrent_upload_speed_bytes{infohash="`+ih1.HexString()+`",name="first"} 2000`+"\n")
+
+	feed(e, time.Now(), broadcast.TorrentRemovedEvent{Infohash: ih2})
+	body = scrape(t, e)
+	assert.NotContains(t, body, ih2.HexString())
+	assert.Contains(t, body, "joal_torrents 1\n")
+	assert.Contains(t, body, "joal_announces_in_flight 0\n")
+}
+
+func TestExporter_ShouldExportTheAnnouncesWaitingInTheQueue(t *testing.T) {
+	e := newExporter(false)
+	queue := torrent2.NewAnnounceQueue()
+	defer queue.DiscardFutureEnqueueAndDestroy()
+	queue.Enqueue(&announces.AnnounceRequest{})
+	queue.Enqueue(&announces.AnnounceRequest{})
+	// an announce taken from the queue is no longer waiting
+	<-queue.Request()
+
+	assert.Contains(t, scrape(t, e), "joal_announce_queue_depth 1\n")
+}
+
+func TestExporter_ShouldServeOpenMetricsWhenAccepted(t *testing.T) {
+	e := newExporter(false)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	rec := httptest.NewRecorder()
+	e.handler().ServeHTTP(rec, req)
+
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "application/openmetrics-text"))
+	assert.True(t, strings.HasSuffix(rec.Body.String(), "# EOF\n"))
+}
+
+func TestExporter_ShouldBeFedByTheBus(t *testing.T) {
+	e := newExporter(false)
+	sub := e.subscribe()
+	defer sub.Unsubscribe()
+
+	broadcast.EmitGlobalBandwidthChanged(broadcast.GlobalBandwidthChangedEvent{AvailableBandwidth: 4242})
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(scrape(t, e), "joal_bandwidth_available_bytes 4242\n")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, scrape(t, e), `joal_event_subscription_queued{subscription="metrics"} 0`+"\n")
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"time"
+)
+
+type plugin struct {
+	config      *metricsConfig
+	log         *zap.Logger
+	httpServer  *http.Server
+	unsubscribe func()
+	health      *types.HealthState
+}
+
+func init() {
+	// the plugin opens a port, it has to be enabled explicitly
+	types.RegisterPlugin(types.PluginFactory{
+		Name:             "metrics",
+		Order:            10,
+		EnabledByDefault: false,
+		LegacyFlags:      map[string]bool{"--metrics": true},
+		New:              newPlugin,
+	})
+}
+
+func (p *plugin) Name() string {
+	return "metrics"
+}
+
+func newPlugin(ctx types.PluginContext, config *types.PluginConfig) (types.IJoalPlugin, error) {
+	conf := metricsConfig{}.Default()
+	if err := config.Decode(conf); err != nil {
+		return nil, err
+	}
+	return &plugin{
+		config: conf,
+		log:    ctx.Logger,
+		health: &types.HealthState{},
+	}, nil
+}
+
+func (p *plugin) Start() error {
+	conf := p.config
+
+	e := newExporter(conf.TorrentLabels)
+	p.unsubscribe = e.subscribe().Unsubscribe
+
+	mux := http.NewServeMux()
+	mux.Handle(conf.Path, e.handler())
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.Port))
+	if err != nil {
+		shutdown(p, nil)
+		return fmt.Errorf("failed to start listener on port %d: %w", conf.Port, err)
+	}
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 15 * time.Second,
+	}
+	p.httpServer = server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.log.Error("metrics http server has been closed", zap.Error(err))
+			p.health.Set(types.Unhealthy, fmt.Sprintf("http server has been closed: %s", err))
+		}
+	}()
+	p.health.Set(types.Healthy, "")
+	p.log.Info("metrics are exposed", zap.Int("port", conf.Port), zap.String("path", conf.Path))
+	return nil
+}
+
+func (p *plugin) Health() types.Health {
+	return p.health.Get()
+}
+
+// Reload restarts the http server with the new configuration, the counters start over
+func (p *plugin) Reload(config *types.PluginConfig) error {
+	conf := metricsConfig{}.Default()
+	if err := config.Decode(conf); err != nil {
+		return err
+	}
+	shutdown(p, nil)
+	p.config = conf
+	return p.Start()
+}
+
+func (p *plugin) Shutdown(ctx context.Context) {
+	p.log.Info("Shutting down plugin")
+	shutdown(p, ctx)
+}
+
+// a nil safe version of Shutdown()
+func shutdown(p *plugin, ctx context.Context) {
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+	}
+
+	if p.httpServer != nil {
+		if err := p.httpServer.Shutdown(ctx); err != nil {
+			_ = p.httpServer.Close()
+		}
+		p.httpServer = nil
+	}
+	if p.unsubscribe != nil {
+		p.unsubscribe()
+		p.unsubscribe = nil
+	}
+	p.health.Set(types.Unhealthy, "stopped")
+}
@@ -2,15 +2,32 @@ package types
 
 import "C"
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/anacrolix/torrent"
 	"github.com/anthonyraymond/joal-cli/internal/old/core"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/emulatedclient"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/goals"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/manager2"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"time"
+)
+
+var (
+	ErrCoreNotReady       = errors.New("torrent manager is not available yet")
+	ErrTorrentNotFound    = manager2.ErrTorrentNotFound
+	ErrNotSeeding         = manager2.ErrNotSeeding
+	ErrTorrentPaused      = manager2.ErrTorrentPaused
+	ErrGoalReached        = manager2.ErrGoalReached
+	ErrClientNotFound     = errors.New("client file not found")
+	ErrInvalidClientFile  = errors.New("invalid client file")
+	ErrInvalidTorrentName = errors.New("invalid torrent file name")
 )
 
 type ICoreBridge interface {
@@ -20,7 +37,17 @@ type ICoreBridge interface {
 	UpdateCoreConfig(config *RuntimeConfig) (*RuntimeConfig, error)
 	AddTorrent(filename string, r io.Reader) error
 	RemoveTorrent(infohash torrent.InfoHash) error
+	// SetTorrentGoal sets the goal of a torrent, a nil goal falls back to the goals of the config
+	SetTorrentGoal(infohash torrent.InfoHash, goal *TorrentGoal) error
+	PauseTorrent(infohash torrent.InfoHash) error
+	ResumeTorrent(infohash torrent.InfoHash) error
+	AnnounceTorrent(infohash torrent.InfoHash) error
 	ListClientFiles() ([]string, error)
+	GetClientFile(file string) (*ClientFile, error)
+	// ValidateClientFile parses a client file without saving it, the error wraps ErrInvalidClientFile when it is invalid
+	ValidateClientFile(r io.Reader) (*ClientFile, error)
+	// SaveClientFile validates a client file and saves it in the clients directory, an existing file is replaced
+	SaveClientFile(file string, r io.Reader) (*ClientFile, error)
 }
 
 type Config struct {
@@ -34,6 +61,23 @@ type RuntimeConfig struct {
 	Client                 string `json:"client"`
 }
 
+type TorrentGoal struct {
+	Ratio float64 `json:"ratio"`
+	// SeedingTime in seconds
+	SeedingTime int64  `json:"seedingTime"`
+	DailyUpload int64  `json:"dailyUpload"`
+	Action      string `json:"action"`
+}
+
+// ClientFile describes a client that JOAL can emulate
+type ClientFile struct {
+	File                 string `json:"file,omitempty"`
+	Name                 string `json:"name"`
+	Version              string `json:"version"`
+	SupportsHttpAnnounce bool   `json:"supportsHttpAnnounce"`
+	SupportsUdpAnnounce  bool   `json:"supportsUdpAnnounce"`
+}
+
 type coreBridge struct {
 	manager      manager2.Manager
 	configLoader *core.CoreConfigLoader
@@ -48,7 +92,7 @@ func NewCoreBridge(loader *core.CoreConfigLoader, manager manager2.Manager) ICor
 
 func (b *coreBridge) StartSeeding() error {
 	if b.manager == nil {
-		return fmt.Errorf("torrent manager is not available yet")
+		return ErrCoreNotReady
 	}
 	b.manager.StartSeeding()
 	return nil
@@ -56,7 +100,7 @@ func (b *coreBridge) StartSeeding() error {
 
 func (b *coreBridge) StopSeeding(ctx context.Context) error {
 	if b.manager == nil {
-		return fmt.Errorf("torrent manager is not available yet")
+		return ErrCoreNotReady
 	}
 	b.manager.StopSeeding(ctx)
 
@@ -65,7 +109,7 @@ func (b *coreBridge) StopSeeding(ctx context.Context) error {
 
 func (b *coreBridge) GetCoreConfig() (*RuntimeConfig, error) {
 	if b.manager == nil {
-		return nil, fmt.Errorf("torrent manager is not available yet")
+		return nil, ErrCoreNotReady
 	}
 	conf, err := b.configLoader.ReadConfig()
 	if err != nil {
@@ -81,7 +125,7 @@ func (b *coreBridge) GetCoreConfig() (*RuntimeConfig, error) {
 
 func (b *coreBridge) UpdateCoreConfig(newConf *RuntimeConfig) (*RuntimeConfig, error) {
 	if b.manager == nil {
-		return nil, fmt.Errorf("torrent manager is not available yet")
+		return nil, ErrCoreNotReady
 	}
 	conf, err := b.configLoader.ReadConfig()
 	if err != nil {
@@ -108,6 +152,12 @@ func (b *coreBridge) UpdateCoreConfig(newConf *RuntimeConfig) (*RuntimeConfig, e
 }
 
 func (b *coreBridge) AddTorrent(filename string, r io.Reader) error {
+	if b.manager == nil {
+		return ErrCoreNotReady
+	}
+	if !IsTorrentFileName(filename) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidTorrentName, filename)
+	}
 	// Extract the content from the http request reader since the manager.SaveTorrentFile is asynchronous
 	content, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -119,13 +169,54 @@ func (b *coreBridge) AddTorrent(filename string, r io.Reader) error {
 
 func (b *coreBridge) RemoveTorrent(infohash torrent.InfoHash) error {
 	if b.manager == nil {
-		return fmt.Errorf("torrent manager is not available yet")
+		return ErrCoreNotReady
 	}
 
-	b.manager.ArchiveTorrent(infohash)
+	return b.manager.ArchiveTorrent(infohash)
+}
+
+func (b *coreBridge) SetTorrentGoal(infohash torrent.InfoHash, goal *TorrentGoal) error {
+	if b.manager == nil {
+		return ErrCoreNotReady
+	}
+
+	var g *core.GoalConfig
+	if goal != nil {
+		g = &core.GoalConfig{
+			Ratio:       goal.Ratio,
+			SeedingTime: time.Duration(goal.SeedingTime) * time.Second,
+			DailyUpload: goal.DailyUpload,
+			Action:      goal.Action,
+		}
+		if err := goals.ValidateGoal(g); err != nil {
+			return err
+		}
+	}
+	b.manager.SetTorrentGoal(infohash, g)
 	return nil
 }
 
+func (b *coreBridge) PauseTorrent(infohash torrent.InfoHash) error {
+	if b.manager == nil {
+		return ErrCoreNotReady
+	}
+	return b.manager.PauseTorrent(infohash)
+}
+
+func (b *coreBridge) ResumeTorrent(infohash torrent.InfoHash) error {
+	if b.manager == nil {
+		return ErrCoreNotReady
+	}
+	return b.manager.ResumeTorrent(infohash)
+}
+
+func (b *coreBridge) AnnounceTorrent(infohash torrent.InfoHash) error {
+	if b.manager == nil {
+		return ErrCoreNotReady
+	}
+	return b.manager.AnnounceTorrent(infohash)
+}
+
 func (b *coreBridge) ListClientFiles() ([]string, error) {
 	config, err := b.configLoader.ReadConfig()
 	if err != nil {
@@ -142,11 +233,89 @@ func (b *coreBridge) ListClientFiles() ([]string, error) {
 		if file.IsDir() {
 			continue
 		}
-		ext := filepath.Ext(file.Name())
-		if ext != "yml" && ext != "yaml" {
+		if !isClientFile(file.Name()) {
 			continue
 		}
 		clients = append(clients, file.Name())
 	}
 	return clients, nil
 }
+
+func (b *coreBridge) GetClientFile(file string) (*ClientFile, error) {
+	config, err := b.configLoader.ReadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if filepath.Base(file) != file || !isClientFile(file) {
+		return nil, ErrClientNotFound
+	}
+
+	f, err := os.Open(filepath.Join(config.ClientsDir, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open client file '%s': %w", file, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	client, err := parseClientFile(f)
+	if err != nil {
+		return nil, err
+	}
+	client.File = file
+	return client, nil
+}
+
+func (b *coreBridge) ValidateClientFile(r io.Reader) (*ClientFile, error) {
+	return parseClientFile(r)
+}
+
+func (b *coreBridge) SaveClientFile(file string, r io.Reader) (*ClientFile, error) {
+	config, err := b.configLoader.ReadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if filepath.Base(file) != file || !isClientFile(file) {
+		return nil, fmt.Errorf("%w: '%s' is not a .yml file name", ErrInvalidClientFile, file)
+	}
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client file from reader: %w", err)
+	}
+	client, err := parseClientFile(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(config.ClientsDir, file)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write client file '%s': %w", path, err)
+	}
+	client.File = file
+	return client, nil
+}
+
+func parseClientFile(r io.Reader) (*ClientFile, error) {
+	client, err := emulatedclient.FromReader(r, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidClientFile, err)
+	}
+	return &ClientFile{
+		Name:                 client.GetName(),
+		Version:              client.GetVersion(),
+		SupportsHttpAnnounce: client.SupportsHttpAnnounce(),
+		SupportsUdpAnnounce:  client.SupportsUdpAnnounce(),
+	}, nil
+}
+
+// IsTorrentFileName tells whether name is a bare .torrent file name, which can not point outside the torrents directory
+func IsTorrentFileName(name string) bool {
+	return filepath.Base(name) == name && filepath.Ext(name) == ".torrent"
+}
+
+func isClientFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yml" || ext == ".yaml"
+}
@@ -0,0 +1,118 @@
+package types
+
+import (
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"net/url"
+)
+
+func trackerUrls(urls []url.URL) []string {
+	res := make([]string, 0, len(urls))
+	for _, u := range urls {
+		res = append(res, u.String())
+	}
+	return res
+}
+
+// EventPayload converts an event of the bus to its json representation, as exposed to the clients and plugins
+func EventPayload(event any) interface{} {
+	switch e := event.(type) {
+	case broadcast.SeedStartedEvent:
+		return map[string]interface{}{"client": e.Client, "version": e.Version}
+	case broadcast.SeedStoppedEvent:
+		return map[string]interface{}{}
+	case broadcast.ConfigChangedEvent:
+		return map[string]interface{}{"needRestartToTakeEffect": e.NeedRestartToTakeEffect}
+	case broadcast.TorrentAddedEvent:
+		return map[string]interface{}{
+			"infohash": e.Infohash.String(),
+			"name":     e.Name,
+			"file":     e.File,
+			"size":     e.Size,
+			"trackers": trackerUrls(e.TrackerAnnounceUrls),
+		}
+	case broadcast.TorrentAnnouncingEvent:
+		return map[string]interface{}{
+			"infohash":      e.Infohash.String(),
+			"tracker":       e.TrackerUrl.String(),
+			"announceEvent": e.AnnounceEvent.String(),
+			"uploaded":      e.Uploaded,
+		}
+	case broadcast.TorrentAnnounceSuccessEvent:
+		return map[string]interface{}{
+			"infohash":      e.Infohash.String(),
+			"tracker":       e.TrackerUrl.String(),
+			"announceEvent": e.AnnounceEvent.String(),
+			"datetime":      e.Datetime,
+			"seeders":       e.Seeder,
+			"leechers":      e.Leechers,
+			"interval":      int(e.Interval.Seconds()),
+			"route":         e.Route,
+		}
+	case broadcast.TorrentAnnounceFailedEvent:
+		return map[string]interface{}{
+			"infohash":      e.Infohash.String(),
+			"tracker":       e.TrackerUrl.String(),
+			"announceEvent": e.AnnounceEvent.String(),
+			"datetime":      e.Datetime,
+			"error":         e.Error,
+			"route":         e.Route,
+		}
+	case broadcast.TorrentTrackerDisabledEvent:
+		return map[string]interface{}{"infohash": e.Infohash.String(), "tracker": e.TrackerUrl.String(), "reason": e.Reason}
+	case broadcast.TorrentSwarmChangedEvent:
+		return map[string]interface{}{"infohash": e.Infohash.String(), "seeders": e.Seeder, "leechers": e.Leechers}
+	case broadcast.TorrentRemovedEvent:
+		return map[string]interface{}{"infohash": e.Infohash.String()}
+	case broadcast.TorrentGoalReachedEvent:
+		return map[string]interface{}{
+			"infohash":    e.Infohash.String(),
+			"goal":        e.Goal,
+			"action":      e.Action,
+			"uploaded":    e.Uploaded,
+			"seedingTime": int64(e.SeedingTime.Seconds()),
+		}
+	case broadcast.TorrentPausedEvent:
+		return map[string]interface{}{"infohash": e.Infohash.String(), "reason": e.Reason}
+	case broadcast.TorrentResumedEvent:
+		return map[string]interface{}{"infohash": e.Infohash.String()}
+	case broadcast.NoticeableErrorEvent:
+		message := ""
+		if e.Error != nil {
+			message = e.Error.Error()
+		}
+		return map[string]interface{}{"error": message, "datetime": e.Datetime}
+	case broadcast.GlobalBandwidthChangedEvent:
+		return map[string]interface{}{"availableBandwidth": e.AvailableBandwidth, "speedProfile": e.SpeedProfile}
+	case broadcast.BandwidthWeightHasChangedEvent:
+		weights := make(map[string]float64, len(e.TorrentWeights))
+		for infohash, weight := range e.TorrentWeights {
+			weights[metainfo.Hash(infohash).String()] = weight
+		}
+		return map[string]interface{}{"totalWeight": e.TotalWeight, "weights": weights}
+	case broadcast.BandwidthBudgetChangedEvent:
+		return map[string]interface{}{
+			"limit":       e.Limit,
+			"consumed":    e.Consumed,
+			"period":      e.Period,
+			"periodStart": e.PeriodStart,
+			"periodEnd":   e.PeriodEnd,
+		}
+	case broadcast.BandwidthBudgetThresholdReachedEvent:
+		return map[string]interface{}{
+			"threshold": e.Threshold,
+			"limit":     e.Limit,
+			"consumed":  e.Consumed,
+			"periodEnd": e.PeriodEnd,
+		}
+	case broadcast.PublicIpChangedEvent:
+		return map[string]interface{}{
+			"previousIpv4": e.PreviousIPv4,
+			"previousIpv6": e.PreviousIPv6,
+			"ipv4":         e.IPv4,
+			"ipv6":         e.IPv6,
+		}
+	default:
+		return event
+	}
+}
@@ -2,12 +2,145 @@ package types
 
 import (
 	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 type IJoalPlugin interface {
 	Name() string
-	// decide if the plugin should be enabled (most of the time i will be based on program arguments)
 	Start() error
 	// Shutdown the plugin. It should be safe to call shutdown in any case even if the plugin wasn't started
 	Shutdown(ctx context.Context)
+	Health() Health
+	// Reload applies a new configuration to a started plugin
+	Reload(config *PluginConfig) error
+}
+
+type HealthStatus string
+
+const (
+	Healthy  HealthStatus = "healthy"
+	Degraded HealthStatus = "degraded"
+	// Unhealthy plugins are not working
+	Unhealthy HealthStatus = "unhealthy"
+	// Failed plugins could not be created or started
+	Failed HealthStatus = "failed"
+	// Disabled plugins are not enabled in the configuration
+	Disabled HealthStatus = "disabled"
+)
+
+type Health struct {
+	Status  HealthStatus `json:"status"`
+	Message string       `json:"message,omitempty"`
+}
+
+type PluginHealth struct {
+	Name string `json:"name"`
+	Health
+}
+
+// PluginsConfig is the plugins section of config.yml, by plugin name
+type PluginsConfig map[string]*PluginConfig
+
+type PluginConfig struct {
+	// Enabled overrides the EnabledByDefault of the plugin
+	Enabled *bool `yaml:"enabled"`
+	// Config is decoded by the plugin with Decode
+	Config yaml.Node `yaml:"config"`
+}
+
+// Decode decodes the config of the plugin into v, which holds the default values. v is left untouched if the config
+// is missing.
+func (c *PluginConfig) Decode(v interface{}) error {
+	if c == nil || c.Config.Kind == 0 {
+		return nil
+	}
+	if err := c.Config.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode plugin config: %w", err)
+	}
+	return nil
+}
+
+// PluginContext holds what a plugin is given on creation
+type PluginContext struct {
+	// Logger is scoped to the plugin
+	Logger *zap.Logger
+	// ConfigDir is a directory dedicated to the plugin, it exists when the plugin is created
+	ConfigDir  string
+	Bridge     ICoreBridge
+	HttpClient *http.Client
+	// PluginsHealth returns the health of all the plugins
+	PluginsHealth func() []PluginHealth
+}
+
+type PluginFactory struct {
+	Name string
+	// DependsOn are the names of the plugins that must be started before this one, the plugin is not started if one of
+	// them fails
+	DependsOn []string
+	// Order sorts the plugins that do not depend on each other, the lowest start first
+	Order            int
+	EnabledByDefault bool
+	// LegacyFlags are the command line flags that enabled (true) or disabled (false) the plugin before it was configured
+	// in config.yml, they still override it
+	LegacyFlags map[string]bool
+	New         func(ctx PluginContext, config *PluginConfig) (IJoalPlugin, error)
+}
+
+var registry = struct {
+	factories map[string]PluginFactory
+	lock      *sync.Mutex
+}{
+	factories: make(map[string]PluginFactory),
+	lock:      &sync.Mutex{},
+}
+
+// RegisterPlugin makes a plugin available to the plugin manager, plugins register themselves in an init function
+func RegisterPlugin(factory PluginFactory) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, ok := registry.factories[factory.Name]; ok {
+		panic(fmt.Sprintf("plugin '%s' is already registered", factory.Name))
+	}
+	registry.factories[factory.Name] = factory
+}
+
+// RegisteredPlugins returns the registered factories sorted by Order then by name
+func RegisteredPlugins() []PluginFactory {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	factories := make([]PluginFactory, 0, len(registry.factories))
+	for _, f := range registry.factories {
+		factories = append(factories, f)
+	}
+	sort.Slice(factories, func(i, j int) bool {
+		if factories[i].Order != factories[j].Order {
+			return factories[i].Order < factories[j].Order
+		}
+		return factories[i].Name < factories[j].Name
+	})
+	return factories
+}
+
+// HealthState holds the health of a plugin, it is safe for concurrent use
+type HealthState struct {
+	v atomic.Value
+}
+
+func (s *HealthState) Set(status HealthStatus, message string) {
+	s.v.Store(Health{Status: status, Message: message})
+}
+
+// Get returns the last health set, Unhealthy if none has been set yet
+func (s *HealthState) Get() Health {
+	h, ok := s.v.Load().(Health)
+	if !ok {
+		return Health{Status: Unhealthy, Message: "not started"}
+	}
+	return h
 }
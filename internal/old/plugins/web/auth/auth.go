@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenPrefix makes the tokens recognizable, e.g. by secret scanners
+	tokenPrefix = "joal_"
+	hashPrefix  = "sha256:"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Scope restricts what a token is allowed to do
+type Scope string
+
+const (
+	// ScopeRead may only read the state of joal
+	ScopeRead Scope = "read"
+	// ScopeAdmin may do everything
+	ScopeAdmin Scope = "admin"
+)
+
+// Allows returns true when the scope grants the required one
+func (s Scope) Allows(required Scope) bool {
+	return s == ScopeAdmin || s == required
+}
+
+func (s Scope) valid() bool {
+	return s == ScopeRead || s == ScopeAdmin
+}
+
+// TokenConfig is an api token as stored in the configuration, only the hash of the token is stored
+type TokenConfig struct {
+	Name  string `yaml:"name"`
+	Scope Scope  `yaml:"scope"`
+	Hash  string `yaml:"hash"`
+}
+
+// Identity is who made a request
+type Identity struct {
+	Name  string `json:"name"`
+	Scope Scope  `json:"scope"`
+}
+
+// Session is issued on login, it is identified by a random id sent back as a cookie
+type Session struct {
+	Id string
+	// StompPasscode authenticates the STOMP connection of the session, it is readable by the web ui unlike the cookie
+	StompPasscode string
+	Identity      *Identity
+	ExpiresAt     time.Time
+}
+
+type storedToken struct {
+	identity *Identity
+	digest   []byte
+}
+
+// Authenticator verifies the api tokens and holds the sessions
+type Authenticator struct {
+	enabled    bool
+	tokens     []*storedToken
+	sessionTtl time.Duration
+	lock       *sync.Mutex
+	// the sessions are indexed by the hash of their id and of their stomp passcode, so the lookup does not leak the secrets through timing
+	sessions       map[string]*Session
+	stompPasscodes map[string]*Session
+	now            func() time.Time
+}
+
+func NewAuthenticator(tokens []*TokenConfig, sessionTtl time.Duration) (*Authenticator, error) {
+	a := &Authenticator{
+		enabled:        len(tokens) > 0,
+		tokens:         []*storedToken{},
+		sessionTtl:     sessionTtl,
+		lock:           &sync.Mutex{},
+		sessions:       map[string]*Session{},
+		stompPasscodes: map[string]*Session{},
+		now:            time.Now,
+	}
+	names := map[string]bool{}
+	for _, t := range tokens {
+		if t.Name == "" {
+			return nil, fmt.Errorf("api token has no name")
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("api token '%s' is declared twice", t.Name)
+		}
+		names[t.Name] = true
+		if !t.Scope.valid() {
+			return nil, fmt.Errorf("api token '%s' has an invalid scope '%s', expected '%s' or '%s'", t.Name, t.Scope, ScopeRead, ScopeAdmin)
+		}
+		digest, err := parseHash(t.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("api token '%s': %w", t.Name, err)
+		}
+		a.tokens = append(a.tokens, &storedToken{identity: &Identity{Name: t.Name, Scope: t.Scope}, digest: digest})
+	}
+	return a, nil
+}
+
+// Enabled returns false when no token is configured, the api is then left open
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// AddToken registers a new random token which is not part of the configuration, and returns it. The internal
+// tokens do not enable the authentication.
+func (a *Authenticator) AddToken(name string, scope Scope) (string, error) {
+	token, hash, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	digest, _ := parseHash(hash)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.tokens = append(a.tokens, &storedToken{identity: &Identity{Name: name, Scope: scope}, digest: digest})
+	return token, nil
+}
+
+// VerifyToken returns the identity of the token, all the tokens are compared in constant time
+func (a *Authenticator) VerifyToken(token string) (*Identity, error) {
+	digest := sha256.Sum256([]byte(token))
+	a.lock.Lock()
+	tokens := a.tokens
+	a.lock.Unlock()
+
+	var found *Identity
+	for _, t := range tokens {
+		// no early return, the time spent does not depend on which token matched
+		if subtle.ConstantTimeCompare(digest[:], t.digest) == 1 {
+			found = t.identity
+		}
+	}
+	if found == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return found, nil
+}
+
+// CreateSession opens a session for the identity, it expires after the session ttl
+func (a *Authenticator) CreateSession(identity *Identity) (*Session, error) {
+	id, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	passcode, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{Id: id, StompPasscode: passcode, Identity: identity, ExpiresAt: a.now().Add(a.sessionTtl)}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.purgeExpiredSessions()
+	a.sessions[digestOf(id)] = session
+	a.stompPasscodes[digestOf(passcode)] = session
+	return session, nil
+}
+
+// VerifySession returns the identity of a live session
+func (a *Authenticator) VerifySession(id string) (*Identity, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	session, ok := a.sessions[digestOf(id)]
+	if !ok || !a.now().Before(session.ExpiresAt) {
+		return nil, ErrInvalidCredentials
+	}
+	return session.Identity, nil
+}
+
+// DeleteSession ends the session, it does nothing if the session does not exist
+func (a *Authenticator) DeleteSession(id string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	session, ok := a.sessions[digestOf(id)]
+	if !ok {
+		return
+	}
+	delete(a.sessions, digestOf(session.Id))
+	delete(a.stompPasscodes, digestOf(session.StompPasscode))
+}
+
+// Authenticate is called on STOMP CONNECT, the passcode is either an api token or the stomp passcode of a session.
+// The login is not verified, it only names the client.
+func (a *Authenticator) Authenticate(_, passcode string) bool {
+	_, err := a.VerifyStompPasscode(passcode)
+	return err == nil
+}
+
+// VerifyStompPasscode returns the identity of the api token or of the live session the stomp passcode belongs to
+func (a *Authenticator) VerifyStompPasscode(passcode string) (*Identity, error) {
+	if identity, err := a.VerifyToken(passcode); err == nil {
+		return identity, nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	session, ok := a.stompPasscodes[digestOf(passcode)]
+	if !ok || !a.now().Before(session.ExpiresAt) {
+		return nil, ErrInvalidCredentials
+	}
+	return session.Identity, nil
+}
+
+// must be called with the lock held
+func (a *Authenticator) purgeExpiredSessions() {
+	now := a.now()
+	for key, session := range a.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(a.sessions, key)
+			delete(a.stompPasscodes, digestOf(session.StompPasscode))
+		}
+	}
+}
+
+// GenerateToken returns a new random api token, and the hash to store in the configuration
+func GenerateToken() (string, string, error) {
+	random, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	token := tokenPrefix + random
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hash of the token, as stored in the configuration
+func HashToken(token string) string {
+	return hashPrefix + digestOf(token)
+}
+
+func parseHash(hash string) ([]byte, error) {
+	if !strings.HasPrefix(hash, hashPrefix) {
+		return nil, fmt.Errorf("hash must start with '%s'", hashPrefix)
+	}
+	digest, err := hex.DecodeString(strings.TrimPrefix(hash, hashPrefix))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("hash is not a hex encoded sha256")
+	}
+	return digest, nil
+}
+
+func digestOf(value string) string {
+	digest := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(digest[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuthenticator(t *testing.T) (*Authenticator, string, string) {
+	readToken, readHash, err := GenerateToken()
+	require.NoError(t, err)
+	adminToken, adminHash, err := GenerateToken()
+	require.NoError(t, err)
+	a, err := NewAuthenticator([]*TokenConfig{
+		{Name: "grafana", Scope: ScopeRead, Hash: readHash},
+		{Name: "ui", Scope: ScopeAdmin, Hash: adminHash},
+	}, time.Hour)
+	require.NoError(t, err)
+	return a, readToken, adminToken
+}
+
+func TestGenerateToken_ShouldReturnTokenAndItsHash(t *testing.T) {
+	token, hash, err := GenerateToken()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(token, "joal_"))
+	assert.Equal(t, HashToken(token), hash)
+	assert.True(t, strings.HasPrefix(hash, "sha256:"))
+	other, _, _ := GenerateToken()
+	assert.NotEqual(t, token, other)
+}
+
+func TestScope_Allows(t *testing.T) {
+	assert.True(t, ScopeRead.Allows(ScopeRead))
+	assert.False(t, ScopeRead.Allows(ScopeAdmin))
+	assert.True(t, ScopeAdmin.Allows(ScopeRead))
+	assert.True(t, ScopeAdmin.Allows(ScopeAdmin))
+}
+
+func TestNewAuthenticator_ShouldRejectInvalidTokens(t *testing.T) {
+	_, hash, _ := GenerateToken()
+	tests := []struct {
+		name   string
+		tokens []*TokenConfig
+	}{
+		{name: "no-name", tokens: []*TokenConfig{{Scope: ScopeRead, Hash: hash}}},
+		{name: "duplicated-name", tokens: []*TokenConfig{{Name: "a", Scope: ScopeRead, Hash: hash}, {Name: "a", Scope: ScopeAdmin, Hash: hash}}},
+		{name: "invalid-scope", tokens: []*TokenConfig{{Name: "a", Scope: "write", Hash: hash}}},
+		{name: "no-hash-prefix", tokens: []*TokenConfig{{Name: "a", Scope: ScopeRead, Hash: strings.TrimPrefix(hash, "sha256:")}}},
+		{name: "plain-text-token", tokens: []*TokenConfig{{Name: "a", Scope: ScopeRead, Hash: "sha256:my-password"}}},
+		{name: "truncated-hash", tokens: []*TokenConfig{{Name: "a", Scope: ScopeRead, Hash: hash[:20]}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAuthenticator(tt.tokens, time.Hour)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAuthenticator_ShouldBeDisabledWithoutTokens(t *testing.T) {
+	a, err := NewAuthenticator(nil, time.Hour)
+	require.NoError(t, err)
+	assert.False(t, a.Enabled())
+
+	token, err := a.AddToken("internal", ScopeAdmin)
+	require.NoError(t, err)
+	assert.False(t, a.Enabled())
+	identity, err := a.VerifyToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Name: "internal", Scope: ScopeAdmin}, identity)
+}
+
+func TestAuthenticator_ShouldVerifyTokens(t *testing.T) {
+	a, readToken, adminToken := newTestAuthenticator(t)
+	assert.True(t, a.Enabled())
+
+	identity, err := a.VerifyToken(readToken)
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Name: "grafana", Scope: ScopeRead}, identity)
+	identity, err = a.VerifyToken(adminToken)
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Name: "ui", Scope: ScopeAdmin}, identity)
+
+	for _, invalid := range []string{"", "joal_nope", readToken + "x", HashToken(readToken)} {
+		_, err = a.VerifyToken(invalid)
+		assert.ErrorIs(t, err, ErrInvalidCredentials, invalid)
+	}
+}
+
+func TestAuthenticator_ShouldManageSessions(t *testing.T) {
+	a, readToken, _ := newTestAuthenticator(t)
+	identity, _ := a.VerifyToken(readToken)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	session, err := a.CreateSession(identity)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), session.ExpiresAt)
+	assert.NotEqual(t, session.Id, session.StompPasscode)
+
+	got, err := a.VerifySession(session.Id)
+	require.NoError(t, err)
+	assert.Equal(t, identity, got)
+	_, err = a.VerifySession(session.StompPasscode)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	a.DeleteSession(session.Id)
+	_, err = a.VerifySession(session.Id)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.False(t, a.Authenticate("", session.StompPasscode))
+}
+
+func TestAuthenticator_ShouldExpireSessions(t *testing.T) {
+	a, readToken, _ := newTestAuthenticator(t)
+	identity, _ := a.VerifyToken(readToken)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	session, err := a.CreateSession(identity)
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour)
+
+	_, err = a.VerifySession(session.Id)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.False(t, a.Authenticate("", session.StompPasscode))
+	_, _ = a.CreateSession(identity)
+	assert.Len(t, a.sessions, 1)
+	assert.Len(t, a.stompPasscodes, 1)
+}
+
+func TestAuthenticator_ShouldAuthenticateStompWithTokensAndSessions(t *testing.T) {
+	a, readToken, adminToken := newTestAuthenticator(t)
+	identity, _ := a.VerifyToken(readToken)
+	session, err := a.CreateSession(identity)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		passcode string
+		want     bool
+	}{
+		{name: "read-token", passcode: readToken, want: true},
+		{name: "admin-token", passcode: adminToken, want: true},
+		{name: "session-passcode", passcode: session.StompPasscode, want: true},
+		{name: "session-id", passcode: session.Id, want: false},
+		{name: "empty", passcode: "", want: false},
+		{name: "unknown", passcode: "joal_nope", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, a.Authenticate("any-login", tt.passcode))
+		})
+	}
+}
+
+func TestAuthenticator_ShouldReturnTheScopeOfStompPasscodes(t *testing.T) {
+	a, readToken, adminToken := newTestAuthenticator(t)
+	adminIdentity, _ := a.VerifyToken(adminToken)
+	session, err := a.CreateSession(adminIdentity)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		passcode string
+		want     Scope
+	}{
+		{name: "read-token", passcode: readToken, want: ScopeRead},
+		{name: "admin-token", passcode: adminToken, want: ScopeAdmin},
+		{name: "admin-session-passcode", passcode: session.StompPasscode, want: ScopeAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := a.VerifyStompPasscode(tt.passcode)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, identity.Scope)
+		})
+	}
+	_, err = a.VerifyStompPasscode("joal_nope")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
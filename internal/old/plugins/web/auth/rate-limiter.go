@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter counts the failed attempts per key (usually the remote ip) over a sliding window
+type RateLimiter struct {
+	attempts int
+	window   time.Duration
+	lock     *sync.Mutex
+	failures map[string][]time.Time
+	now      func() time.Time
+}
+
+func NewRateLimiter(attempts int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		attempts: attempts,
+		window:   window,
+		lock:     &sync.Mutex{},
+		failures: map[string][]time.Time{},
+		now:      time.Now,
+	}
+}
+
+// Allow returns false when the key has failed too many times, along with the time to wait before the next attempt
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	failures := l.recentFailures(key)
+	if len(failures) < l.attempts {
+		return true, 0
+	}
+	return false, failures[0].Add(l.window).Sub(l.now())
+}
+
+// Fail records a failed attempt
+func (l *RateLimiter) Fail(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.failures[key] = append(l.recentFailures(key), l.now())
+	// forget the keys which have not failed lately, so the map does not grow forever
+	for k := range l.failures {
+		l.recentFailures(k)
+	}
+}
+
+// Reset forgets the failed attempts of the key, after a successful one
+func (l *RateLimiter) Reset(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.failures, key)
+}
+
+// must be called with the lock held
+func (l *RateLimiter) recentFailures(key string) []time.Time {
+	failures := l.failures[key]
+	threshold := l.now().Add(-l.window)
+	for len(failures) > 0 && !failures[0].After(threshold) {
+		failures = failures[1:]
+	}
+	if len(failures) == 0 {
+		delete(l.failures, key)
+	} else {
+		l.failures[key] = failures
+	}
+	return failures
+}
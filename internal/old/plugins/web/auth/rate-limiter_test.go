@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_ShouldBlockAfterTooManyFailures(t *testing.T) {
+	l := NewRateLimiter(3, time.Minute)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := l.Allow("1.2.3.4")
+		assert.True(t, allowed)
+		l.Fail("1.2.3.4")
+		now = now.Add(10 * time.Second)
+	}
+
+	allowed, retryAfter := l.Allow("1.2.3.4")
+	assert.False(t, allowed)
+	assert.Equal(t, 30*time.Second, retryAfter)
+	allowed, _ = l.Allow("5.6.7.8")
+	assert.True(t, allowed, "other keys are not blocked")
+
+	now = now.Add(30 * time.Second)
+	allowed, _ = l.Allow("1.2.3.4")
+	assert.True(t, allowed, "the oldest failure has left the window")
+}
+
+func TestRateLimiter_ShouldForgetFailuresOnReset(t *testing.T) {
+	l := NewRateLimiter(1, time.Minute)
+	l.Fail("1.2.3.4")
+	allowed, _ := l.Allow("1.2.3.4")
+	assert.False(t, allowed)
+
+	l.Reset("1.2.3.4")
+
+	allowed, _ = l.Allow("1.2.3.4")
+	assert.True(t, allowed)
+}
+
+func TestRateLimiter_ShouldForgetOldKeys(t *testing.T) {
+	l := NewRateLimiter(3, time.Minute)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.Fail("1.2.3.4")
+	_, _ = l.Allow("5.6.7.8")
+	assert.Len(t, l.failures, 1)
+
+	now = now.Add(2 * time.Minute)
+	l.Fail("9.9.9.9")
+
+	assert.Len(t, l.failures, 1)
+	assert.Contains(t, l.failures, "9.9.9.9")
+}
@@ -12,9 +12,6 @@ var (
 	staticFilesDirFromRoot = func(pluginRootDir string) string {
 		return filepath.Join(pluginRootDir, "web-resources")
 	}
-	webConfigFilePathFromRoot = func(pluginRootDir string) string {
-		return filepath.Join(pluginRootDir, "web.yml")
-	}
 )
 
 func bootstrap(configRoot string, client *http.Client, log *zap.Logger) error {
@@ -22,13 +19,7 @@ func bootstrap(configRoot string, client *http.Client, log *zap.Logger) error {
 		return fmt.Errorf("failed to create folder '%s': %w", configRoot, err)
 	}
 
-	f, err := os.OpenFile(webConfigFilePathFromRoot(configRoot), os.O_CREATE, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create '%s' file: %w", webConfigFilePathFromRoot(configRoot), err)
-	}
-	_ = f.Close()
-
-	err = bootstrapWebUi(configRoot, client, log)
+	err := bootstrapWebUi(configRoot, client, log)
 	if err != nil {
 		return fmt.Errorf("failed to download webui: %w", err)
 	}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
 	"math"
 	"strings"
 	"time"
@@ -11,6 +12,8 @@ type webConfig struct {
 	Http      *httpConfig      `yaml:"http"`
 	WebSocket *webSocketConfig `yaml:"webSocket"`
 	Stomp     *stompConfig     `yaml:"stomp"`
+	Auth      *authConfig      `yaml:"auth"`
+	Cors      *corsConfig      `yaml:"cors"`
 }
 
 // Return a new webConfig with the default values filled in
@@ -19,10 +22,14 @@ func (c webConfig) Default() *webConfig {
 		Http:      httpConfig{}.Default(),
 		WebSocket: webSocketConfig{}.Default(),
 		Stomp:     stompConfig{}.Default(),
+		Auth:      authConfig{}.Default(),
+		Cors:      corsConfig{}.Default(),
 	}
 }
 
 type httpConfig struct {
+	// BindAddress is the ip or host the server listens on, all the interfaces when empty
+	BindAddress              string        `yaml:"bindAddress"`
 	Port                     int           `yaml:"port"`
 	SecretPathPrefix         string        `yaml:"secretPathPrefix"`
 	ReadTimeout              time.Duration `yaml:"readTimeout"`
@@ -33,11 +40,13 @@ type httpConfig struct {
 	WebUiUrl                 string        `yaml:"webUiUrl"`
 	HttpApiUrl               string        `yaml:"httpApiUrl"`
 	WsNegotiationEndpointUrl string        `yaml:"wsNegotiationEndpointUrl"`
+	Tls                      *tlsConfig    `yaml:"tls"`
 }
 
 // Return a new HttpConfig with the default values filled in
 func (c httpConfig) Default() *httpConfig {
 	return &httpConfig{
+		BindAddress:              "",
 		Port:                     7041,
 		SecretPathPrefix:         "/secret-path-prefix",
 		ReadTimeout:              15 * time.Second,
@@ -48,6 +57,7 @@ func (c httpConfig) Default() *httpConfig {
 		WebUiUrl:                 "/ui",
 		HttpApiUrl:               "/api",
 		WsNegotiationEndpointUrl: "/ws",
+		Tls:                      tlsConfig{}.Default(),
 	}
 }
 
@@ -61,6 +71,35 @@ func (c *httpConfig) withSecretPathPrefix(path string) string {
 	return fmt.Sprintf("/%s%s", c.SecretPathPrefix, path)
 }
 
+type tlsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CertFile and KeyFile are PEM encoded, when both are empty a self-signed certificate is generated in the plugin directory
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// SelfSignedHosts are the dns names and ips the self-signed certificate is valid for
+	SelfSignedHosts []string `yaml:"selfSignedHosts"`
+	// ClientCaFile is a PEM file of certificate authorities, when set the clients must present a certificate signed by one of them
+	ClientCaFile string `yaml:"clientCaFile"`
+}
+
+// Return a new tlsConfig with the default values filled in
+func (c tlsConfig) Default() *tlsConfig {
+	return &tlsConfig{
+		Enabled:         false,
+		CertFile:        "",
+		KeyFile:         "",
+		SelfSignedHosts: []string{"localhost", "127.0.0.1", "::1"},
+		ClientCaFile:    "",
+	}
+}
+
+func (c *tlsConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("http.tls.certFile and http.tls.keyFile must be set together")
+	}
+	return nil
+}
+
 type webSocketConfig struct {
 	AcceptedSubProtocols []string `yaml:"acceptedSubProtocols"`
 	InsecureSkipVerify   bool     `yaml:"insecureSkipVerify"`
@@ -79,23 +118,71 @@ func (c webSocketConfig) Default() *webSocketConfig {
 }
 
 type stompConfig struct {
-	Login     string        `yaml:"login"`
-	Password  string        `yaml:"password"`
 	HeartBeat time.Duration `yaml:"heartBeat"`
 }
 
-func (c *stompConfig) Authenticate(login, passcode string) bool {
-	if c.Login == "" || c.Password == "" {
-		return true
-	}
-	return c.Login == login && c.Password == passcode
-}
-
 // Return a new stompConfig with the default values filled in
 func (c stompConfig) Default() *stompConfig {
 	return &stompConfig{
-		Login:     "",
-		Password:  "",
 		HeartBeat: 15 * time.Second,
 	}
 }
+
+// authConfig protects the http api and the stomp server, they are left open when no token is configured
+type authConfig struct {
+	// Tokens are the api tokens, only their hash is stored. Use `joal token generate` to create one.
+	Tokens         []*auth.TokenConfig `yaml:"tokens"`
+	SessionTtl     time.Duration       `yaml:"sessionTtl"`
+	LoginRateLimit *rateLimitConfig    `yaml:"loginRateLimit"`
+}
+
+// Return a new authConfig with the default values filled in
+func (c authConfig) Default() *authConfig {
+	return &authConfig{
+		Tokens:         []*auth.TokenConfig{},
+		SessionTtl:     12 * time.Hour,
+		LoginRateLimit: rateLimitConfig{}.Default(),
+	}
+}
+
+func (c *authConfig) validate() error {
+	if _, err := auth.NewAuthenticator(c.Tokens, c.SessionTtl); err != nil {
+		return err
+	}
+	if c.SessionTtl <= 0 {
+		return fmt.Errorf("auth.sessionTtl must be positive")
+	}
+	if c.LoginRateLimit.Attempts <= 0 {
+		return fmt.Errorf("auth.loginRateLimit.attempts must be positive")
+	}
+	if c.LoginRateLimit.Window <= 0 {
+		return fmt.Errorf("auth.loginRateLimit.window must be positive")
+	}
+	return nil
+}
+
+// rateLimitConfig allows Attempts failed attempts per Window for each remote ip
+type rateLimitConfig struct {
+	Attempts int           `yaml:"attempts"`
+	Window   time.Duration `yaml:"window"`
+}
+
+// Return a new rateLimitConfig with the default values filled in
+func (c rateLimitConfig) Default() *rateLimitConfig {
+	return &rateLimitConfig{
+		Attempts: 5,
+		Window:   time.Minute,
+	}
+}
+
+type corsConfig struct {
+	// AllowedOrigins may be cross-origin callers of the api, "*" allows them all. When empty only same-origin calls are allowed.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+// Return a new corsConfig with the default values filled in
+func (c corsConfig) Default() *corsConfig {
+	return &corsConfig{
+		AllowedOrigins: []string{},
+	}
+}
@@ -31,17 +31,66 @@ const StompMessageDestination = "/joal-core-events"
 
 const StompTypePrefix = "@STOMP_API"
 const (
-	SeedStartedStompType                  stompType = StompTypePrefix + "/SEED/STARTED"
-	SeedStopStompType                               = StompTypePrefix + "/SEED/STOPPED"
-	ConfigChangedStompType                          = StompTypePrefix + "/CONFIG/CHANGED"
-	TorrentAddedStompType                           = StompTypePrefix + "/TORRENT/ADDED"
-	TorrentChangedStompType                         = StompTypePrefix + "/TORRENT/CHANGED"
-	TorrentRemovedStompType                         = StompTypePrefix + "/TORRENT/REMOVED"
-	BandwidthRangeChangedStompType                  = StompTypePrefix + "/BANDWIDTH/RANGE_CHANGED"
-	BandwidthDistributionChangedStompType           = StompTypePrefix + "/BANDWIDTH/DISTRIBUTION_CHANGED"
-	ErrorUnexpectedStompType                        = StompTypePrefix + "/UNEXPECTED_ERROR"
+	SeedStartedStompType                     stompType = StompTypePrefix + "/SEED/STARTED"
+	SeedStopStompType                                  = StompTypePrefix + "/SEED/STOPPED"
+	ConfigChangedStompType                             = StompTypePrefix + "/CONFIG/CHANGED"
+	TorrentAddedStompType                              = StompTypePrefix + "/TORRENT/ADDED"
+	TorrentChangedStompType                            = StompTypePrefix + "/TORRENT/CHANGED"
+	TorrentRemovedStompType                            = StompTypePrefix + "/TORRENT/REMOVED"
+	TorrentGoalReachedStompType                        = StompTypePrefix + "/TORRENT/GOAL_REACHED"
+	BandwidthRangeChangedStompType                     = StompTypePrefix + "/BANDWIDTH/RANGE_CHANGED"
+	BandwidthDistributionChangedStompType              = StompTypePrefix + "/BANDWIDTH/DISTRIBUTION_CHANGED"
+	BandwidthBudgetChangedStompType                    = StompTypePrefix + "/BANDWIDTH/BUDGET_CHANGED"
+	BandwidthBudgetThresholdReachedStompType           = StompTypePrefix + "/BANDWIDTH/BUDGET_THRESHOLD_REACHED"
+	ErrorUnexpectedStompType                           = StompTypePrefix + "/UNEXPECTED_ERROR"
+	PublicIpChangedStompType                           = StompTypePrefix + "/PUBLIC_IP/CHANGED"
 )
 
+// subscribe routes the core events to the On* methods, a late subscriber only needs the latest state of each torrent
+// so the events of a torrent are coalesced when the stomp publisher lags behind
+func (l *appStateCoreListener) subscribe() *broadcast.Subscription {
+	return broadcast.Subscribe(func(event any) {
+		switch e := event.(type) {
+		case broadcast.SeedStartedEvent:
+			l.OnSeedStart(e)
+		case broadcast.SeedStoppedEvent:
+			l.OnSeedStop(e)
+		case broadcast.ConfigChangedEvent:
+			l.OnConfigChanged(e)
+		case broadcast.TorrentAddedEvent:
+			l.OnTorrentAdded(e)
+		case broadcast.TorrentAnnouncingEvent:
+			l.OnTorrentAnnouncing(e)
+		case broadcast.TorrentAnnounceSuccessEvent:
+			l.OnTorrentAnnounceSuccess(e)
+		case broadcast.TorrentAnnounceFailedEvent:
+			l.OnTorrentAnnounceFailed(e)
+		case broadcast.TorrentSwarmChangedEvent:
+			l.OnTorrentSwarmChanged(e)
+		case broadcast.TorrentRemovedEvent:
+			l.OnTorrentRemoved(e)
+		case broadcast.TorrentGoalReachedEvent:
+			l.OnTorrentGoalReached(e)
+		case broadcast.TorrentPausedEvent:
+			l.OnTorrentPaused(e)
+		case broadcast.TorrentResumedEvent:
+			l.OnTorrentResumed(e)
+		case broadcast.GlobalBandwidthChangedEvent:
+			l.OnGlobalBandwidthChanged(e)
+		case broadcast.BandwidthWeightHasChangedEvent:
+			l.OnBandwidthWeightHasChanged(e)
+		case broadcast.BandwidthBudgetChangedEvent:
+			l.OnBandwidthBudgetChanged(e)
+		case broadcast.BandwidthBudgetThresholdReachedEvent:
+			l.OnBandwidthBudgetThresholdReached(e)
+		case broadcast.NoticeableErrorEvent:
+			l.OnNoticeableError(e)
+		case broadcast.PublicIpChangedEvent:
+			l.OnPublicIpChanged(e)
+		}
+	}, broadcast.WithName("web"), broadcast.WithOverflowPolicy(broadcast.CoalesceByInfohash))
+}
+
 func (l *appStateCoreListener) OnSeedStart(event broadcast.SeedStartedEvent) {
 	log := logs.GetLogger()
 	l.lock.Lock()
@@ -195,6 +244,7 @@ func (l *appStateCoreListener) OnTorrentAnnounceSuccess(event broadcast.TorrentA
 		Seeders:       event.Seeder,
 		Leechers:      event.Leechers,
 		Interval:      int(event.Interval.Seconds()),
+		Route:         event.Route,
 	}
 
 	for i, h := range tr.AnnounceHistory {
@@ -240,6 +290,7 @@ func (l *appStateCoreListener) OnTorrentAnnounceFailed(event broadcast.TorrentAn
 		WasSuccessful: false,
 		Datetime:      event.Datetime,
 		Error:         event.Error,
+		Route:         event.Route,
 	}
 
 	for i, h := range tr.AnnounceHistory {
@@ -305,6 +356,71 @@ func (l *appStateCoreListener) OnTorrentRemoved(event broadcast.TorrentRemovedEv
 	}
 }
 
+func (l *appStateCoreListener) OnTorrentGoalReached(event broadcast.TorrentGoalReachedEvent) {
+	log := logs.GetLogger()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	payload := map[string]interface{}{}
+	payload["infohash"] = event.Infohash.String()
+	payload["goal"] = event.Goal
+	payload["action"] = event.Action
+	payload["uploaded"] = event.Uploaded
+	payload["seedingTime"] = int64(event.SeedingTime.Seconds())
+
+	err := sendToStompTopic(l.stompPublisher, StompMessageDestination, &stompPayload{
+		Type:    TorrentGoalReachedStompType,
+		Payload: payload,
+	})
+	if err != nil {
+		log.Error("Failed to send onTorrentGoalReached stomp message", zap.Error(err))
+	}
+}
+
+func (l *appStateCoreListener) OnTorrentPaused(event broadcast.TorrentPausedEvent) {
+	log := logs.GetLogger()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.hasTorrent(event.Infohash) {
+		return
+	}
+
+	t := l.state.Torrents[event.Infohash.String()]
+	t.Paused = true
+	t.PausedReason = event.Reason
+
+	err := sendToStompTopic(l.stompPublisher, StompMessageDestination, &stompPayload{
+		Type:    TorrentChangedStompType,
+		Payload: t,
+	})
+	if err != nil {
+		log.Error("Failed to send onTorrentPaused stomp message", zap.Error(err))
+	}
+}
+
+func (l *appStateCoreListener) OnTorrentResumed(event broadcast.TorrentResumedEvent) {
+	log := logs.GetLogger()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.hasTorrent(event.Infohash) {
+		return
+	}
+
+	t := l.state.Torrents[event.Infohash.String()]
+	t.Paused = false
+	t.PausedReason = ""
+
+	err := sendToStompTopic(l.stompPublisher, StompMessageDestination, &stompPayload{
+		Type:    TorrentChangedStompType,
+		Payload: t,
+	})
+	if err != nil {
+		log.Error("Failed to send onTorrentResumed stomp message", zap.Error(err))
+	}
+}
+
 func (l *appStateCoreListener) OnGlobalBandwidthChanged(event broadcast.GlobalBandwidthChangedEvent) {
 	log := logs.GetLogger()
 	l.lock.Lock()
@@ -317,9 +433,11 @@ func (l *appStateCoreListener) OnGlobalBandwidthChanged(event broadcast.GlobalBa
 	}
 
 	l.state.Bandwidth.CurrentBandwidth = event.AvailableBandwidth
+	l.state.Bandwidth.SpeedProfile = event.SpeedProfile
 
 	payload := map[string]interface{}{}
 	payload["currentBandwidth"] = l.state.Bandwidth.CurrentBandwidth
+	payload["speedProfile"] = l.state.Bandwidth.SpeedProfile
 
 	err := sendToStompTopic(l.stompPublisher, StompMessageDestination, &stompPayload{
 		Type:    BandwidthRangeChangedStompType,
@@ -342,9 +460,13 @@ func (l *appStateCoreListener) OnBandwidthWeightHasChanged(event broadcast.Bandw
 	newBandwidthMap := make(map[string]*torrentBandwidthState, len(event.TorrentWeights))
 
 	for infohash, weight := range event.TorrentWeights {
+		percent := float32(0)
+		if event.TotalWeight > 0 {
+			percent = float32(weight) / float32(event.TotalWeight)
+		}
 		newBandwidthMap[infohash.String()] = &torrentBandwidthState{
 			Infohash:           infohash.String(),
-			PercentOfBandwidth: float32(weight) / float32(event.TotalWeight),
+			PercentOfBandwidth: percent,
 		}
 	}
 
@@ -359,6 +481,54 @@ func (l *appStateCoreListener) OnBandwidthWeightHasChanged(event broadcast.Bandw
 	}
 }
 
+func (l *appStateCoreListener) OnBandwidthBudgetChanged(event broadcast.BandwidthBudgetChangedEvent) {
+	log := logs.GetLogger()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.state.Bandwidth == nil {
+		l.state.Bandwidth = &bandwidthState{
+			Torrents: map[string]*torrentBandwidthState{},
+		}
+	}
+
+	l.state.Bandwidth.Budget = &budgetState{
+		Limit:       event.Limit,
+		Consumed:    event.Consumed,
+		Period:      event.Period,
+		PeriodStart: event.PeriodStart,
+		PeriodEnd:   event.PeriodEnd,
+	}
+
+	err := sendToStompTopic(l.stompPublisher, StompMessageDestination, &stompPayload{
+		Type:    BandwidthBudgetChangedStompType,
+		Payload: l.state.Bandwidth.Budget,
+	})
+	if err != nil {
+		log.Error("Failed to send onBandwidthBudgetChanged stomp message", zap.Error(err))
+	}
+}
+
+func (l *appStateCoreListener) OnBandwidthBudgetThresholdReached(event broadcast.BandwidthBudgetThresholdReachedEvent) {
+	log := logs.GetLogger()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	payload := map[string]interface{}{}
+	payload["percent"] = int(event.Threshold * 100)
+	payload["limit"] = event.Limit
+	payload["consumed"] = event.Consumed
+	payload["periodEnd"] = event.PeriodEnd
+
+	err := sendToStompTopic(l.stompPublisher, StompMessageDestination, &stompPayload{
+		Type:    BandwidthBudgetThresholdReachedStompType,
+		Payload: payload,
+	})
+	if err != nil {
+		log.Error("Failed to send onBandwidthBudgetThresholdReached stomp message", zap.Error(err))
+	}
+}
+
 func (l *appStateCoreListener) OnNoticeableError(event broadcast.NoticeableErrorEvent) {
 	log := logs.GetLogger()
 	l.lock.Lock()
@@ -377,6 +547,31 @@ func (l *appStateCoreListener) OnNoticeableError(event broadcast.NoticeableError
 	}
 }
 
+func (l *appStateCoreListener) OnPublicIpChanged(event broadcast.PublicIpChangedEvent) {
+	log := logs.GetLogger()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	payload := map[string]interface{}{}
+	payload["ipv4"] = event.IPv4
+	payload["ipv6"] = event.IPv6
+
+	err := sendToStompTopic(l.stompPublisher, StompMessageDestination, &stompPayload{
+		Type:    PublicIpChangedStompType,
+		Payload: payload,
+	})
+	if err != nil {
+		log.Error("Failed to send onPublicIpChanged stomp message", zap.Error(err))
+	}
+}
+
+// read gives a consistent view of the state to the reader, the state must not be retained after read returns
+func (l *appStateCoreListener) read(reader func(s *state)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	reader(l.state)
+}
+
 func (l *appStateCoreListener) hasTorrent(infohash torrent.InfoHash) bool {
 	_, exists := l.state.Torrents[infohash.String()]
 
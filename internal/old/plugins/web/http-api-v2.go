@@ -0,0 +1,756 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/gorilla/mux"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTorrentPageSize = 50
+	maxTorrentPageSize     = 500
+	// maxUploadSize bounds the size of the uploaded client files
+	maxUploadSize = 1 << 20
+)
+
+const (
+	torrentStatusSeeding = "seeding"
+	torrentStatusPaused  = "paused"
+	torrentStatusStopped = "stopped"
+)
+
+// apiV2 serves the resources of the versioned api. Its routes are declared in a table, which also generates the
+// OpenAPI document served at /openapi.json.
+type apiV2 struct {
+	getBridgeOrNil func() types.ICoreBridge
+	readState      func(reader func(s *state))
+	openApi        []byte
+}
+
+type apiRoute struct {
+	method  string
+	path    string
+	id      string
+	tag     string
+	summary string
+	query   []queryParam
+	// request is a sample of the json body, nil when the route does not read a json body
+	request interface{}
+	// multipart is true when the body is a multipart form holding a 'file'
+	multipart bool
+	status    int
+	// response is a sample of the json body of the response, nil when the response has no body
+	response interface{}
+	errors   []int
+	handler  func(w http.ResponseWriter, r *http.Request) error
+}
+
+type queryParam struct {
+	name        string
+	kind        string // integer or string
+	description string
+	enum        []string
+}
+
+// apiError is the body of all the error responses of the api
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists the invalid fields of the request body
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newApiError(status int, code string, format string, args ...interface{}) *apiError {
+	return &apiError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type torrentResource struct {
+	Infohash string `json:"infohash"`
+	Name     string `json:"name"`
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	// Status is seeding, paused or stopped
+	Status string `json:"status"`
+	// PausedReason is either user or the goal reached by the torrent
+	PausedReason string `json:"pausedReason,omitempty"`
+	Seeders      int32  `json:"seeders"`
+	Leechers     int32  `json:"leechers"`
+	// Uploaded is the number of bytes reported on the last announce
+	Uploaded           int64    `json:"uploaded"`
+	PercentOfBandwidth float32  `json:"percentOfBandwidth"`
+	Trackers           []string `json:"trackers"`
+}
+
+type torrentPage struct {
+	Items  []*torrentResource `json:"items"`
+	Total  int                `json:"total"`
+	Offset int                `json:"offset"`
+	Limit  int                `json:"limit"`
+}
+
+type trackerResource struct {
+	Url             string                 `json:"url"`
+	IsAnnouncing    bool                   `json:"isAnnouncing"`
+	InUse           bool                   `json:"inUse"`
+	Seeders         int32                  `json:"seeders"`
+	Leechers        int32                  `json:"leechers"`
+	Interval        int                    `json:"interval"`
+	AnnounceHistory []*announceResultState `json:"announceHistory"`
+}
+
+type clientResource struct {
+	File                 string `json:"file"`
+	Valid                bool   `json:"valid"`
+	Error                string `json:"error,omitempty"`
+	Name                 string `json:"name,omitempty"`
+	Version              string `json:"version,omitempty"`
+	SupportsHttpAnnounce bool   `json:"supportsHttpAnnounce"`
+	SupportsUdpAnnounce  bool   `json:"supportsUdpAnnounce"`
+}
+
+// configPatch holds the fields to change, the omitted ones keep their value
+type configPatch struct {
+	MinimumBytesPerSeconds *int64  `json:"minimumBytesPerSeconds,omitempty"`
+	MaximumBytesPerSeconds *int64  `json:"maximumBytesPerSeconds,omitempty"`
+	Client                 *string `json:"client,omitempty"`
+}
+
+type statsResource struct {
+	Started  bool              `json:"started"`
+	Client   *clientState      `json:"client,omitempty"`
+	Torrents torrentCounts     `json:"torrents"`
+	Uploaded int64             `json:"uploaded"`
+	Seeders  int64             `json:"seeders"`
+	Leechers int64             `json:"leechers"`
+	Speed    *speedStatsResult `json:"speed,omitempty"`
+}
+
+type torrentCounts struct {
+	Total   int `json:"total"`
+	Seeding int `json:"seeding"`
+	Paused  int `json:"paused"`
+}
+
+type speedStatsResult struct {
+	CurrentBandwidth int64        `json:"currentBandwidth"`
+	SpeedProfile     string       `json:"speedProfile,omitempty"`
+	Budget           *budgetState `json:"budget,omitempty"`
+}
+
+func registerApiV2Routes(subrouter *mux.Router, api *apiV2) {
+	routes := api.routes()
+	doc, err := json.Marshal(newOpenApiDocument(routes))
+	if err != nil {
+		// the document only depends on the route table
+		panic(fmt.Sprintf("failed to generate the OpenAPI document: %v", err))
+	}
+	api.openApi = doc
+
+	for _, route := range routes {
+		subrouter.Handle(route.path, api.handle(route.handler)).Methods(route.method)
+	}
+	subrouter.NotFoundHandler = api.handle(func(w http.ResponseWriter, r *http.Request) error {
+		return newApiError(http.StatusNotFound, "not_found", "no resource at '%s'", r.URL.Path)
+	})
+	subrouter.MethodNotAllowedHandler = api.handle(func(w http.ResponseWriter, r *http.Request) error {
+		return newApiError(http.StatusMethodNotAllowed, "method_not_allowed", "method %s is not allowed on '%s'", r.Method, r.URL.Path)
+	})
+}
+
+func (a *apiV2) routes() []*apiRoute {
+	torrentsQuery := []queryParam{
+		{name: "offset", kind: "integer", description: "number of torrents to skip, defaults to 0"},
+		{name: "limit", kind: "integer", description: fmt.Sprintf("number of torrents per page, between 1 and %d, defaults to %d", maxTorrentPageSize, defaultTorrentPageSize)},
+		{name: "status", kind: "string", description: "only the torrents with this status", enum: []string{torrentStatusSeeding, torrentStatusPaused, torrentStatusStopped}},
+		{name: "name", kind: "string", description: "only the torrents whose name contains this text, case insensitive"},
+		{name: "tracker", kind: "string", description: "only the torrents announcing to this tracker, by url or host"},
+		{name: "sort", kind: "string", description: "name, size, uploaded, seeders or leechers, prefixed by '-' for a descending order, defaults to name"},
+	}
+	return []*apiRoute{
+		{method: http.MethodGet, path: "/torrents", id: "listTorrents", tag: "torrents", summary: "List the torrents",
+			query: torrentsQuery, status: http.StatusOK, response: torrentPage{}, errors: []int{http.StatusBadRequest}, handler: a.listTorrents},
+		{method: http.MethodGet, path: "/torrents/{infohash}", id: "getTorrent", tag: "torrents", summary: "Get a torrent",
+			status: http.StatusOK, response: torrentResource{}, errors: []int{http.StatusBadRequest, http.StatusNotFound}, handler: a.getTorrent},
+		{method: http.MethodDelete, path: "/torrents/{infohash}", id: "deleteTorrent", tag: "torrents", summary: "Archive a torrent",
+			status: http.StatusNoContent, errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable}, handler: a.deleteTorrent},
+		{method: http.MethodPost, path: "/torrents/{infohash}/pause", id: "pauseTorrent", tag: "torrents", summary: "Pause a torrent until it is resumed",
+			status: http.StatusNoContent, errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable}, handler: a.pauseTorrent},
+		{method: http.MethodPost, path: "/torrents/{infohash}/resume", id: "resumeTorrent", tag: "torrents", summary: "Resume a paused torrent",
+			status: http.StatusNoContent, errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable}, handler: a.resumeTorrent},
+		{method: http.MethodPost, path: "/torrents/{infohash}/announce", id: "announceTorrent", tag: "torrents", summary: "Announce a torrent right away",
+			status: http.StatusAccepted, errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable}, handler: a.announceTorrent},
+		{method: http.MethodGet, path: "/torrents/{infohash}/trackers", id: "listTorrentTrackers", tag: "torrents", summary: "List the trackers of a torrent",
+			status: http.StatusOK, response: []trackerResource{}, errors: []int{http.StatusBadRequest, http.StatusNotFound}, handler: a.listTrackers},
+		{method: http.MethodGet, path: "/clients", id: "listClients", tag: "clients", summary: "List the client files",
+			status: http.StatusOK, response: []clientResource{}, errors: []int{http.StatusServiceUnavailable}, handler: a.listClients},
+		{method: http.MethodPost, path: "/clients", id: "uploadClient", tag: "clients", summary: "Upload a client file, an existing file is replaced",
+			multipart: true, status: http.StatusCreated, response: clientResource{}, errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusServiceUnavailable}, handler: a.uploadClient},
+		{method: http.MethodPost, path: "/clients/validate", id: "validateClient", tag: "clients", summary: "Validate a client file without saving it",
+			multipart: true, status: http.StatusOK, response: clientResource{}, errors: []int{http.StatusBadRequest, http.StatusServiceUnavailable}, handler: a.validateClient},
+		{method: http.MethodGet, path: "/clients/{file}", id: "getClient", tag: "clients", summary: "Get a client file",
+			status: http.StatusOK, response: clientResource{}, errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}, handler: a.getClient},
+		{method: http.MethodGet, path: "/config", id: "getConfig", tag: "config", summary: "Get the runtime configuration",
+			status: http.StatusOK, response: types.RuntimeConfig{}, errors: []int{http.StatusServiceUnavailable}, handler: a.getConfig},
+		{method: http.MethodPatch, path: "/config", id: "patchConfig", tag: "config", summary: "Change some fields of the runtime configuration, applied on the next start",
+			request: configPatch{}, status: http.StatusOK, response: types.RuntimeConfig{}, errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusServiceUnavailable}, handler: a.patchConfig},
+		{method: http.MethodGet, path: "/stats", id: "getStats", tag: "stats", summary: "Get the statistics of the seeding session",
+			status: http.StatusOK, response: statsResource{}, handler: a.getStats},
+		{method: http.MethodGet, path: "/openapi.json", id: "getOpenApi", tag: "meta", summary: "Get the OpenAPI document of this api",
+			status: http.StatusOK, response: map[string]interface{}{}, handler: a.getOpenApi},
+	}
+}
+
+// handle writes the error returned by the handler as an apiError
+func (a *apiV2) handle(handler func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := handler(w, r)
+		if err == nil {
+			return
+		}
+		apiErr := toApiError(err)
+		writeJson(w, apiErr.Status, apiErr)
+	})
+}
+
+func toApiError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, types.ErrCoreNotReady):
+		return newApiError(http.StatusServiceUnavailable, "core_not_ready", "%s", err)
+	case errors.Is(err, types.ErrTorrentNotFound):
+		return newApiError(http.StatusNotFound, "torrent_not_found", "%s", err)
+	case errors.Is(err, types.ErrClientNotFound):
+		return newApiError(http.StatusNotFound, "client_not_found", "%s", err)
+	case errors.Is(err, types.ErrNotSeeding):
+		return newApiError(http.StatusConflict, "not_seeding", "%s", err)
+	case errors.Is(err, types.ErrTorrentPaused):
+		return newApiError(http.StatusConflict, "torrent_paused", "%s", err)
+	case errors.Is(err, types.ErrGoalReached):
+		return newApiError(http.StatusConflict, "goal_reached", "%s, raise its goal to resume it", err)
+	case errors.Is(err, types.ErrInvalidClientFile):
+		return newApiError(http.StatusUnprocessableEntity, "invalid_client_file", "%s", err)
+	default:
+		return newApiError(http.StatusInternalServerError, "internal_error", "%s", err)
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (a *apiV2) bridge() (types.ICoreBridge, error) {
+	bridge := a.getBridgeOrNil()
+	if bridge == nil {
+		return nil, types.ErrCoreNotReady
+	}
+	return bridge, nil
+}
+
+func (a *apiV2) listTorrents(w http.ResponseWriter, r *http.Request) error {
+	query, err := parseTorrentQuery(r)
+	if err != nil {
+		return err
+	}
+
+	var torrents []*torrentResource
+	a.readState(func(s *state) {
+		for _, t := range s.Torrents {
+			res := toTorrentResource(s, t)
+			if query.matches(res) {
+				torrents = append(torrents, res)
+			}
+		}
+	})
+	query.sort(torrents)
+
+	page := &torrentPage{Items: []*torrentResource{}, Total: len(torrents), Offset: query.offset, Limit: query.limit}
+	if query.offset < len(torrents) {
+		end := query.offset + query.limit
+		if end > len(torrents) {
+			end = len(torrents)
+		}
+		page.Items = torrents[query.offset:end]
+	}
+	writeJson(w, http.StatusOK, page)
+	return nil
+}
+
+func (a *apiV2) getTorrent(w http.ResponseWriter, r *http.Request) error {
+	infohash, err := infohashFromPath(r)
+	if err != nil {
+		return err
+	}
+
+	var res *torrentResource
+	a.readState(func(s *state) {
+		if t, found := s.Torrents[infohash.String()]; found {
+			res = toTorrentResource(s, t)
+		}
+	})
+	if res == nil {
+		return types.ErrTorrentNotFound
+	}
+	writeJson(w, http.StatusOK, res)
+	return nil
+}
+
+func (a *apiV2) deleteTorrent(w http.ResponseWriter, r *http.Request) error {
+	return a.torrentCommand(w, r, http.StatusNoContent, types.ICoreBridge.RemoveTorrent)
+}
+
+func (a *apiV2) pauseTorrent(w http.ResponseWriter, r *http.Request) error {
+	return a.torrentCommand(w, r, http.StatusNoContent, types.ICoreBridge.PauseTorrent)
+}
+
+func (a *apiV2) resumeTorrent(w http.ResponseWriter, r *http.Request) error {
+	return a.torrentCommand(w, r, http.StatusNoContent, types.ICoreBridge.ResumeTorrent)
+}
+
+func (a *apiV2) announceTorrent(w http.ResponseWriter, r *http.Request) error {
+	return a.torrentCommand(w, r, http.StatusAccepted, types.ICoreBridge.AnnounceTorrent)
+}
+
+// torrentCommand applies the command to the torrent of the path and answers with the status, without body
+func (a *apiV2) torrentCommand(w http.ResponseWriter, r *http.Request, status int, command func(types.ICoreBridge, metainfo.Hash) error) error {
+	infohash, err := infohashFromPath(r)
+	if err != nil {
+		return err
+	}
+	bridge, err := a.bridge()
+	if err != nil {
+		return err
+	}
+	if err := command(bridge, infohash); err != nil {
+		return err
+	}
+	w.WriteHeader(status)
+	return nil
+}
+
+func (a *apiV2) listTrackers(w http.ResponseWriter, r *http.Request) error {
+	infohash, err := infohashFromPath(r)
+	if err != nil {
+		return err
+	}
+
+	var trackers []*trackerResource
+	found := false
+	a.readState(func(s *state) {
+		var t *torrentState
+		if t, found = s.Torrents[infohash.String()]; !found {
+			return
+		}
+		trackers = make([]*trackerResource, 0, len(t.Trackers))
+		for u, tr := range t.Trackers {
+			trackers = append(trackers, &trackerResource{
+				Url:             u,
+				IsAnnouncing:    tr.IsAnnouncing,
+				InUse:           tr.InUse,
+				Seeders:         tr.Seeders,
+				Leechers:        tr.Leechers,
+				Interval:        tr.Interval,
+				AnnounceHistory: append([]*announceResultState{}, tr.AnnounceHistory...),
+			})
+		}
+	})
+	if !found {
+		return types.ErrTorrentNotFound
+	}
+	sort.Slice(trackers, func(i, j int) bool { return trackers[i].Url < trackers[j].Url })
+	writeJson(w, http.StatusOK, trackers)
+	return nil
+}
+
+func (a *apiV2) listClients(w http.ResponseWriter, r *http.Request) error {
+	bridge, err := a.bridge()
+	if err != nil {
+		return err
+	}
+	files, err := bridge.ListClientFiles()
+	if err != nil {
+		return err
+	}
+
+	clients := make([]*clientResource, 0, len(files))
+	for _, file := range files {
+		client, err := bridge.GetClientFile(file)
+		if err != nil && !errors.Is(err, types.ErrInvalidClientFile) {
+			return err
+		}
+		clients = append(clients, toClientResource(file, client, err))
+	}
+	writeJson(w, http.StatusOK, clients)
+	return nil
+}
+
+func (a *apiV2) getClient(w http.ResponseWriter, r *http.Request) error {
+	bridge, err := a.bridge()
+	if err != nil {
+		return err
+	}
+	file := mux.Vars(r)["file"]
+	client, err := bridge.GetClientFile(file)
+	if err != nil && !errors.Is(err, types.ErrInvalidClientFile) {
+		return err
+	}
+	writeJson(w, http.StatusOK, toClientResource(file, client, err))
+	return nil
+}
+
+func (a *apiV2) uploadClient(w http.ResponseWriter, r *http.Request) error {
+	bridge, err := a.bridge()
+	if err != nil {
+		return err
+	}
+	file, filename, err := uploadedFile(w, r)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	client, err := bridge.SaveClientFile(filename, file)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusCreated, toClientResource(filename, client, nil))
+	return nil
+}
+
+func (a *apiV2) validateClient(w http.ResponseWriter, r *http.Request) error {
+	bridge, err := a.bridge()
+	if err != nil {
+		return err
+	}
+	file, filename, err := uploadedFile(w, r)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	client, err := bridge.ValidateClientFile(file)
+	if err != nil && !errors.Is(err, types.ErrInvalidClientFile) {
+		return err
+	}
+	writeJson(w, http.StatusOK, toClientResource(filename, client, err))
+	return nil
+}
+
+func (a *apiV2) getConfig(w http.ResponseWriter, r *http.Request) error {
+	bridge, err := a.bridge()
+	if err != nil {
+		return err
+	}
+	conf, err := bridge.GetCoreConfig()
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusOK, conf)
+	return nil
+}
+
+func (a *apiV2) patchConfig(w http.ResponseWriter, r *http.Request) error {
+	bridge, err := a.bridge()
+	if err != nil {
+		return err
+	}
+	patch := &configPatch{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(patch); err != nil {
+		return newApiError(http.StatusBadRequest, "invalid_body", "failed to parse the request body: %s", err)
+	}
+
+	conf, err := bridge.GetCoreConfig()
+	if err != nil {
+		return err
+	}
+	if patch.MinimumBytesPerSeconds != nil {
+		conf.MinimumBytesPerSeconds = *patch.MinimumBytesPerSeconds
+	}
+	if patch.MaximumBytesPerSeconds != nil {
+		conf.MaximumBytesPerSeconds = *patch.MaximumBytesPerSeconds
+	}
+	if patch.Client != nil {
+		conf.Client = *patch.Client
+	}
+	clients, err := bridge.ListClientFiles()
+	if err != nil {
+		return err
+	}
+	if fields := validateRuntimeConfig(conf, clients); len(fields) > 0 {
+		apiErr := newApiError(http.StatusUnprocessableEntity, "invalid_config", "the configuration is invalid")
+		apiErr.Fields = fields
+		return apiErr
+	}
+
+	conf, err = bridge.UpdateCoreConfig(conf)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusOK, conf)
+	return nil
+}
+
+func validateRuntimeConfig(conf *types.RuntimeConfig, clients []string) []fieldError {
+	var fields []fieldError
+	if conf.MinimumBytesPerSeconds < 0 {
+		fields = append(fields, fieldError{Field: "minimumBytesPerSeconds", Message: "must be greater than or equal to 0"})
+	}
+	if conf.MaximumBytesPerSeconds < conf.MinimumBytesPerSeconds {
+		fields = append(fields, fieldError{Field: "maximumBytesPerSeconds", Message: "must be greater than or equal to minimumBytesPerSeconds"})
+	}
+	known := false
+	for _, c := range clients {
+		if c == conf.Client {
+			known = true
+			break
+		}
+	}
+	if !known {
+		fields = append(fields, fieldError{Field: "client", Message: fmt.Sprintf("unknown client file '%s'", conf.Client)})
+	}
+	return fields
+}
+
+func (a *apiV2) getStats(w http.ResponseWriter, r *http.Request) error {
+	stats := &statsResource{}
+	a.readState(func(s *state) {
+		if s.Global != nil {
+			stats.Started = s.Global.Started
+			if s.Global.Client != nil {
+				client := *s.Global.Client
+				stats.Client = &client
+			}
+		}
+		for _, t := range s.Torrents {
+			stats.Torrents.Total++
+			if t.Paused {
+				stats.Torrents.Paused++
+			} else if stats.Started {
+				stats.Torrents.Seeding++
+			}
+			stats.Uploaded += t.Uploaded
+			stats.Seeders += int64(t.Seeders)
+			stats.Leechers += int64(t.Leechers)
+		}
+		if s.Bandwidth != nil {
+			stats.Speed = &speedStatsResult{CurrentBandwidth: s.Bandwidth.CurrentBandwidth, SpeedProfile: s.Bandwidth.SpeedProfile}
+			if s.Bandwidth.Budget != nil {
+				budget := *s.Bandwidth.Budget
+				stats.Speed.Budget = &budget
+			}
+		}
+	})
+	writeJson(w, http.StatusOK, stats)
+	return nil
+}
+
+func (a *apiV2) getOpenApi(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(a.openApi)
+	return nil
+}
+
+// toTorrentResource copies the state of a torrent, it must be called while the state is read
+func toTorrentResource(s *state, t *torrentState) *torrentResource {
+	res := &torrentResource{
+		Infohash:     t.Infohash,
+		Name:         t.Name,
+		File:         t.File,
+		Size:         t.Size,
+		Status:       torrentStatusStopped,
+		PausedReason: t.PausedReason,
+		Seeders:      t.Seeders,
+		Leechers:     t.Leechers,
+		Uploaded:     t.Uploaded,
+		Trackers:     make([]string, 0, len(t.Trackers)),
+	}
+	if t.Paused {
+		res.Status = torrentStatusPaused
+	} else if s.Global != nil && s.Global.Started {
+		res.Status = torrentStatusSeeding
+	}
+	if s.Bandwidth != nil {
+		if b, found := s.Bandwidth.Torrents[t.Infohash]; found {
+			res.PercentOfBandwidth = b.PercentOfBandwidth
+		}
+	}
+	for u := range t.Trackers {
+		res.Trackers = append(res.Trackers, u)
+	}
+	sort.Strings(res.Trackers)
+	return res
+}
+
+func toClientResource(file string, client *types.ClientFile, err error) *clientResource {
+	if err != nil || client == nil {
+		res := &clientResource{File: file}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		return res
+	}
+	return &clientResource{
+		File:                 file,
+		Valid:                true,
+		Name:                 client.Name,
+		Version:              client.Version,
+		SupportsHttpAnnounce: client.SupportsHttpAnnounce,
+		SupportsUdpAnnounce:  client.SupportsUdpAnnounce,
+	}
+}
+
+func infohashFromPath(r *http.Request) (metainfo.Hash, error) {
+	infohash := metainfo.Hash{}
+	if err := infohash.FromHexString(mux.Vars(r)["infohash"]); err != nil {
+		return infohash, newApiError(http.StatusBadRequest, "invalid_infohash", "the infohash must be 40 hexadecimal characters")
+	}
+	return infohash, nil
+}
+
+// uploadedFile returns the 'file' of a multipart form with its sanitized name
+func uploadedFile(w http.ResponseWriter, r *http.Request) (multipart.File, string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", newApiError(http.StatusBadRequest, "invalid_body", "a multipart form with a 'file' is expected: %s", err)
+	}
+	filename := filepath.Base(filepath.Clean(header.Filename))
+	if filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		_ = file.Close()
+		return nil, "", newApiError(http.StatusBadRequest, "invalid_filename", "invalid file name '%s'", header.Filename)
+	}
+	return file, filename, nil
+}
+
+type torrentQuery struct {
+	offset  int
+	limit   int
+	status  string
+	name    string
+	tracker string
+	sortKey string
+	desc    bool
+}
+
+func parseTorrentQuery(r *http.Request) (*torrentQuery, error) {
+	params := r.URL.Query()
+	query := &torrentQuery{limit: defaultTorrentPageSize, sortKey: "name"}
+	if offset := params.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return nil, newApiError(http.StatusBadRequest, "invalid_query", "'offset' query param must be a positive integer")
+		}
+		query.offset = n
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxTorrentPageSize {
+			return nil, newApiError(http.StatusBadRequest, "invalid_query", "'limit' query param must be between 1 and %d", maxTorrentPageSize)
+		}
+		query.limit = n
+	}
+	switch status := params.Get("status"); status {
+	case "", torrentStatusSeeding, torrentStatusPaused, torrentStatusStopped:
+		query.status = status
+	default:
+		return nil, newApiError(http.StatusBadRequest, "invalid_query", "'status' query param must be one of %s, %s or %s", torrentStatusSeeding, torrentStatusPaused, torrentStatusStopped)
+	}
+	query.name = strings.ToLower(params.Get("name"))
+	query.tracker = params.Get("tracker")
+	if u, err := url.Parse(query.tracker); err == nil && u.Host != "" {
+		// the trackers are known by scheme and host
+		query.tracker = normalizeTrackerAnnounceUrl(*u).String()
+	}
+	if s := params.Get("sort"); s != "" {
+		query.desc = strings.HasPrefix(s, "-")
+		query.sortKey = strings.TrimPrefix(s, "-")
+		switch query.sortKey {
+		case "name", "size", "uploaded", "seeders", "leechers":
+		default:
+			return nil, newApiError(http.StatusBadRequest, "invalid_query", "'sort' query param must be one of name, size, uploaded, seeders or leechers")
+		}
+	}
+	return query, nil
+}
+
+func (q *torrentQuery) matches(t *torrentResource) bool {
+	if q.status != "" && t.Status != q.status {
+		return false
+	}
+	if q.name != "" && !strings.Contains(strings.ToLower(t.Name), q.name) {
+		return false
+	}
+	if q.tracker == "" {
+		return true
+	}
+	for _, u := range t.Trackers {
+		if u == q.tracker || strings.HasSuffix(u, "://"+q.tracker) {
+			return true
+		}
+	}
+	return false
+}
+
+// sort orders the torrents by the sort key, the infohash breaks the ties to keep the pages stable
+func (q *torrentQuery) sort(torrents []*torrentResource) {
+	compare := func(a, b *torrentResource) int {
+		switch q.sortKey {
+		case "size":
+			return compareInt64(a.Size, b.Size)
+		case "uploaded":
+			return compareInt64(a.Uploaded, b.Uploaded)
+		case "seeders":
+			return compareInt64(int64(a.Seeders), int64(b.Seeders))
+		case "leechers":
+			return compareInt64(int64(a.Leechers), int64(b.Leechers))
+		default:
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+	}
+	sort.Slice(torrents, func(i, j int) bool {
+		c := compare(torrents[i], torrents[j])
+		if q.desc {
+			c = -c
+		}
+		if c == 0 {
+			return torrents[i].Infohash < torrents[j].Infohash
+		}
+		return c < 0
+	})
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
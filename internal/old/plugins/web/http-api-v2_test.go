@@ -0,0 +1,432 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeBridge answers with the configured error and records the torrents it has been called with
+type fakeBridge struct {
+	err     error
+	conf    *types.RuntimeConfig
+	called  []torrent.InfoHash
+	clients map[string]*types.ClientFile
+}
+
+func newFakeBridge() *fakeBridge {
+	return &fakeBridge{
+		conf: &types.RuntimeConfig{MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 20, Client: "qbittorrent.yml"},
+		clients: map[string]*types.ClientFile{
+			"qbittorrent.yml": {File: "qbittorrent.yml", Name: "qBittorrent", Version: "3.3.1", SupportsHttpAnnounce: true},
+			"broken.yml":      nil,
+		},
+	}
+}
+
+func (b *fakeBridge) StartSeeding() error                   { return b.err }
+func (b *fakeBridge) StopSeeding(ctx context.Context) error { return b.err }
+func (b *fakeBridge) GetCoreConfig() (*types.RuntimeConfig, error) {
+	conf := *b.conf
+	return &conf, b.err
+}
+func (b *fakeBridge) UpdateCoreConfig(config *types.RuntimeConfig) (*types.RuntimeConfig, error) {
+	b.conf = config
+	return config, b.err
+}
+func (b *fakeBridge) AddTorrent(filename string, r io.Reader) error { return b.err }
+func (b *fakeBridge) RemoveTorrent(infohash torrent.InfoHash) error { return b.record(infohash) }
+func (b *fakeBridge) SetTorrentGoal(infohash torrent.InfoHash, goal *types.TorrentGoal) error {
+	return b.record(infohash)
+}
+func (b *fakeBridge) PauseTorrent(infohash torrent.InfoHash) error    { return b.record(infohash) }
+func (b *fakeBridge) ResumeTorrent(infohash torrent.InfoHash) error   { return b.record(infohash) }
+func (b *fakeBridge) AnnounceTorrent(infohash torrent.InfoHash) error { return b.record(infohash) }
+func (b *fakeBridge) ListClientFiles() ([]string, error) {
+	return []string{"broken.yml", "qbittorrent.yml"}, b.err
+}
+func (b *fakeBridge) GetClientFile(file string) (*types.ClientFile, error) {
+	client, found := b.clients[file]
+	if !found {
+		return nil, types.ErrClientNotFound
+	}
+	if client == nil {
+		return nil, fmt.Errorf("%w: missing name", types.ErrInvalidClientFile)
+	}
+	return client, nil
+}
+func (b *fakeBridge) ValidateClientFile(r io.Reader) (*types.ClientFile, error) {
+	content, _ := io.ReadAll(r)
+	if !strings.Contains(string(content), "name:") {
+		return nil, fmt.Errorf("%w: missing name", types.ErrInvalidClientFile)
+	}
+	return &types.ClientFile{Name: "uTorrent", Version: "3.5.0"}, nil
+}
+func (b *fakeBridge) SaveClientFile(file string, r io.Reader) (*types.ClientFile, error) {
+	client, err := b.ValidateClientFile(r)
+	if err != nil {
+		return nil, err
+	}
+	client.File = file
+	b.clients[file] = client
+	return client, nil
+}
+
+func (b *fakeBridge) record(infohash torrent.InfoHash) error {
+	b.called = append(b.called, infohash)
+	return b.err
+}
+
+func testState() *state {
+	return &state{
+		Global: &globalState{Started: true, Client: &clientState{Name: "qBittorrent", Version: "3.3.1"}},
+		Torrents: map[string]*torrentState{
+			torrent.InfoHash{1}.String(): {
+				Infohash: torrent.InfoHash{1}.String(), Name: "Ubuntu", Size: 300, Seeders: 10, Leechers: 1, Uploaded: 1000,
+				Trackers: map[string]*torrentTrackersState{
+					"http://ubuntu.example.org": {InUse: true, Seeders: 10, Leechers: 1, Interval: 1800},
+					"udp://open.example.org":    {},
+				},
+			},
+			torrent.InfoHash{2}.String(): {
+				Infohash: torrent.InfoHash{2}.String(), Name: "debian", Size: 100, Seeders: 5, Leechers: 8, Uploaded: 3000,
+				Paused: true, PausedReason: "ratio",
+				Trackers: map[string]*torrentTrackersState{"https://debian.example.org": {}},
+			},
+			torrent.InfoHash{3}.String(): {
+				Infohash: torrent.InfoHash{3}.String(), Name: "Fedora", Size: 200, Seeders: 1, Leechers: 0, Uploaded: 2000,
+				Trackers: map[string]*torrentTrackersState{"udp://open.example.org": {}},
+			},
+		},
+		Bandwidth: &bandwidthState{
+			CurrentBandwidth: 150,
+			Torrents:         map[string]*torrentBandwidthState{torrent.InfoHash{1}.String(): {PercentOfBandwidth: 0.75}},
+		},
+	}
+}
+
+func newApiV2Server(t *testing.T, bridge types.ICoreBridge, s *state) *httptest.Server {
+	router := mux.NewRouter()
+	registerApiV2Routes(router.PathPrefix("/api/v2").Subrouter(), &apiV2{
+		getBridgeOrNil: func() types.ICoreBridge { return bridge },
+		readState:      func(reader func(s *state)) { reader(s) },
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doRequest(t *testing.T, method string, url string, contentType string, body io.Reader) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, body)
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = res.Body.Close() }()
+	content, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, content
+}
+
+func decodeApiError(t *testing.T, body []byte) *apiError {
+	apiErr := &apiError{}
+	require.NoError(t, json.Unmarshal(body, apiErr), string(body))
+	return apiErr
+}
+
+func multipartBody(t *testing.T, filename string, content string) (string, io.Reader) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, _ = part.Write([]byte(content))
+	require.NoError(t, writer.Close())
+	return writer.FormDataContentType(), body
+}
+
+func TestApiV2_ShouldListTorrents(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantNames []string
+		wantTotal int
+	}{
+		{name: "default-sort-by-name", query: "", wantNames: []string{"debian", "Fedora", "Ubuntu"}, wantTotal: 3},
+		{name: "sort-descending", query: "?sort=-uploaded", wantNames: []string{"debian", "Fedora", "Ubuntu"}, wantTotal: 3},
+		{name: "sort-by-size", query: "?sort=size", wantNames: []string{"debian", "Fedora", "Ubuntu"}, wantTotal: 3},
+		{name: "sort-by-seeders", query: "?sort=-seeders", wantNames: []string{"Ubuntu", "debian", "Fedora"}, wantTotal: 3},
+		{name: "paginate", query: "?offset=1&limit=1", wantNames: []string{"Fedora"}, wantTotal: 3},
+		{name: "offset-out-of-range", query: "?offset=10", wantNames: []string{}, wantTotal: 3},
+		{name: "filter-status", query: "?status=paused", wantNames: []string{"debian"}, wantTotal: 1},
+		{name: "filter-name", query: "?name=UBU", wantNames: []string{"Ubuntu"}, wantTotal: 1},
+		{name: "filter-tracker-host", query: "?tracker=open.example.org", wantNames: []string{"Fedora", "Ubuntu"}, wantTotal: 2},
+		{name: "filter-tracker-url", query: "?tracker=https://debian.example.org/announce", wantNames: []string{"debian"}, wantTotal: 1},
+	}
+	server := newApiV2Server(t, newFakeBridge(), testState())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doRequest(t, http.MethodGet, server.URL+"/api/v2/torrents"+tt.query, "", nil)
+			require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+
+			page := &torrentPage{}
+			require.NoError(t, json.Unmarshal(body, page))
+			names := []string{}
+			for _, item := range page.Items {
+				names = append(names, item.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+			assert.Equal(t, tt.wantTotal, page.Total)
+		})
+	}
+}
+
+func TestApiV2_ShouldRefuseInvalidTorrentQuery(t *testing.T) {
+	server := newApiV2Server(t, newFakeBridge(), testState())
+	for _, query := range []string{"?limit=0", "?limit=501", "?offset=-1", "?status=lost", "?sort=ratio"} {
+		t.Run(query, func(t *testing.T) {
+			res, body := doRequest(t, http.MethodGet, server.URL+"/api/v2/torrents"+query, "", nil)
+
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+			apiErr := decodeApiError(t, body)
+			assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+			assert.Equal(t, "invalid_query", apiErr.Code)
+		})
+	}
+}
+
+func TestApiV2_ShouldGetTorrentAndTrackers(t *testing.T) {
+	server := newApiV2Server(t, newFakeBridge(), testState())
+
+	res, body := doRequest(t, http.MethodGet, server.URL+"/api/v2/torrents/"+torrent.InfoHash{1}.HexString(), "", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, &torrentResource{
+		Infohash:           torrent.InfoHash{1}.HexString(),
+		Name:               "Ubuntu",
+		Size:               300,
+		Status:             torrentStatusSeeding,
+		Seeders:            10,
+		Leechers:           1,
+		Uploaded:           1000,
+		PercentOfBandwidth: 0.75,
+		Trackers:           []string{"http://ubuntu.example.org", "udp://open.example.org"},
+	}, decodeJsonAs(t, body, &torrentResource{}))
+
+	res, body = doRequest(t, http.MethodGet, server.URL+"/api/v2/torrents/"+torrent.InfoHash{2}.HexString(), "", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	paused := decodeJsonAs(t, body, &torrentResource{}).(*torrentResource)
+	assert.Equal(t, torrentStatusPaused, paused.Status)
+	assert.Equal(t, "ratio", paused.PausedReason)
+
+	res, body = doRequest(t, http.MethodGet, server.URL+"/api/v2/torrents/"+torrent.InfoHash{1}.HexString()+"/trackers", "", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var trackers []*trackerResource
+	require.NoError(t, json.Unmarshal(body, &trackers))
+	require.Len(t, trackers, 2)
+	assert.Equal(t, "http://ubuntu.example.org", trackers[0].Url)
+	assert.True(t, trackers[0].InUse)
+	assert.Equal(t, 1800, trackers[0].Interval)
+	assert.Equal(t, "udp://open.example.org", trackers[1].Url)
+
+	res, body = doRequest(t, http.MethodGet, server.URL+"/api/v2/torrents/"+torrent.InfoHash{9}.HexString()+"/trackers", "", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "torrent_not_found", decodeApiError(t, body).Code)
+
+	res, body = doRequest(t, http.MethodGet, server.URL+"/api/v2/torrents/not-an-infohash", "", nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "invalid_infohash", decodeApiError(t, body).Code)
+}
+
+func decodeJsonAs(t *testing.T, body []byte, v interface{}) interface{} {
+	require.NoError(t, json.Unmarshal(body, v), string(body))
+	return v
+}
+
+func TestApiV2_ShouldApplyTorrentCommands(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		action     string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "pause", method: http.MethodPost, action: "/pause", wantStatus: http.StatusNoContent},
+		{name: "resume", method: http.MethodPost, action: "/resume", wantStatus: http.StatusNoContent},
+		{name: "announce", method: http.MethodPost, action: "/announce", wantStatus: http.StatusAccepted},
+		{name: "delete", method: http.MethodDelete, action: "", wantStatus: http.StatusNoContent},
+		{name: "unknown-torrent", method: http.MethodPost, action: "/pause", err: types.ErrTorrentNotFound, wantStatus: http.StatusNotFound, wantCode: "torrent_not_found"},
+		{name: "resume-goal-reached", method: http.MethodPost, action: "/resume", err: types.ErrGoalReached, wantStatus: http.StatusConflict, wantCode: "goal_reached"},
+		{name: "announce-paused", method: http.MethodPost, action: "/announce", err: types.ErrTorrentPaused, wantStatus: http.StatusConflict, wantCode: "torrent_paused"},
+		{name: "announce-not-seeding", method: http.MethodPost, action: "/announce", err: types.ErrNotSeeding, wantStatus: http.StatusConflict, wantCode: "not_seeding"},
+		{name: "core-not-ready", method: http.MethodDelete, action: "", err: types.ErrCoreNotReady, wantStatus: http.StatusServiceUnavailable, wantCode: "core_not_ready"},
+		{name: "core-failure", method: http.MethodDelete, action: "", err: fmt.Errorf("disk full"), wantStatus: http.StatusInternalServerError, wantCode: "internal_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bridge := newFakeBridge()
+			bridge.err = tt.err
+			server := newApiV2Server(t, bridge, testState())
+
+			res, body := doRequest(t, tt.method, server.URL+"/api/v2/torrents/"+torrent.InfoHash{1}.HexString()+tt.action, "", nil)
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, []torrent.InfoHash{{1}}, bridge.called)
+			if tt.wantCode == "" {
+				assert.Empty(t, body)
+				return
+			}
+			apiErr := decodeApiError(t, body)
+			assert.Equal(t, tt.wantStatus, apiErr.Status)
+			assert.Equal(t, tt.wantCode, apiErr.Code)
+		})
+	}
+}
+
+func TestApiV2_ShouldServeClients(t *testing.T) {
+	bridge := newFakeBridge()
+	server := newApiV2Server(t, bridge, testState())
+
+	res, body := doRequest(t, http.MethodGet, server.URL+"/api/v2/clients", "", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var clients []*clientResource
+	require.NoError(t, json.Unmarshal(body, &clients))
+	require.Len(t, clients, 2)
+	assert.Equal(t, &clientResource{File: "broken.yml", Error: "invalid client file: missing name"}, clients[0])
+	assert.Equal(t, &clientResource{File: "qbittorrent.yml", Valid: true, Name: "qBittorrent", Version: "3.3.1", SupportsHttpAnnounce: true}, clients[1])
+
+	res, body = doRequest(t, http.MethodGet, server.URL+"/api/v2/clients/missing.yml", "", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "client_not_found", decodeApiError(t, body).Code)
+
+	contentType, form := multipartBody(t, "utorrent.yml", "name: uTorrent")
+	res, body = doRequest(t, http.MethodPost, server.URL+"/api/v2/clients/validate", contentType, form)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, &clientResource{File: "utorrent.yml", Valid: true, Name: "uTorrent", Version: "3.5.0"}, decodeJsonAs(t, body, &clientResource{}))
+	assert.NotContains(t, bridge.clients, "utorrent.yml")
+
+	contentType, form = multipartBody(t, "utorrent.yml", "version: 1")
+	res, body = doRequest(t, http.MethodPost, server.URL+"/api/v2/clients/validate", contentType, form)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.False(t, decodeJsonAs(t, body, &clientResource{}).(*clientResource).Valid)
+
+	contentType, form = multipartBody(t, "../utorrent.yml", "name: uTorrent")
+	res, body = doRequest(t, http.MethodPost, server.URL+"/api/v2/clients", contentType, form)
+	require.Equal(t, http.StatusCreated, res.StatusCode, string(body))
+	assert.Equal(t, "utorrent.yml", decodeJsonAs(t, body, &clientResource{}).(*clientResource).File)
+	assert.Contains(t, bridge.clients, "utorrent.yml")
+
+	contentType, form = multipartBody(t, "invalid.yml", "version: 1")
+	res, body = doRequest(t, http.MethodPost, server.URL+"/api/v2/clients", contentType, form)
+	assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+	assert.Equal(t, "invalid_client_file", decodeApiError(t, body).Code)
+
+	res, body = doRequest(t, http.MethodPost, server.URL+"/api/v2/clients", "application/json", strings.NewReader("{}"))
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "invalid_body", decodeApiError(t, body).Code)
+}
+
+func TestApiV2_ShouldPatchConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantConf   *types.RuntimeConfig
+		wantFields []string
+	}{
+		{name: "partial", body: `{"maximumBytesPerSeconds": 500}`, wantStatus: http.StatusOK, wantConf: &types.RuntimeConfig{MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 500, Client: "qbittorrent.yml"}},
+		{name: "empty", body: `{}`, wantStatus: http.StatusOK, wantConf: &types.RuntimeConfig{MinimumBytesPerSeconds: 10, MaximumBytesPerSeconds: 20, Client: "qbittorrent.yml"}},
+		{name: "negative-minimum", body: `{"minimumBytesPerSeconds": -1}`, wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"minimumBytesPerSeconds"}},
+		{name: "inverted-range", body: `{"minimumBytesPerSeconds": 50}`, wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"maximumBytesPerSeconds"}},
+		{name: "unknown-client", body: `{"client": "deluge.yml", "maximumBytesPerSeconds": 1}`, wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"maximumBytesPerSeconds", "client"}},
+		{name: "unknown-field", body: `{"speed": 1}`, wantStatus: http.StatusBadRequest},
+		{name: "not-json", body: `speed=1`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bridge := newFakeBridge()
+			server := newApiV2Server(t, bridge, testState())
+
+			res, body := doRequest(t, http.MethodPatch, server.URL+"/api/v2/config", "application/json", strings.NewReader(tt.body))
+
+			require.Equal(t, tt.wantStatus, res.StatusCode, string(body))
+			if tt.wantConf != nil {
+				assert.Equal(t, tt.wantConf, decodeJsonAs(t, body, &types.RuntimeConfig{}))
+				assert.Equal(t, tt.wantConf, bridge.conf)
+				return
+			}
+			apiErr := decodeApiError(t, body)
+			var fields []string
+			for _, f := range apiErr.Fields {
+				fields = append(fields, f.Field)
+			}
+			assert.Equal(t, tt.wantFields, fields)
+			assert.Equal(t, int64(20), bridge.conf.MaximumBytesPerSeconds)
+		})
+	}
+}
+
+func TestApiV2_ShouldComputeStats(t *testing.T) {
+	server := newApiV2Server(t, newFakeBridge(), testState())
+
+	res, body := doRequest(t, http.MethodGet, server.URL+"/api/v2/stats", "", nil)
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, &statsResource{
+		Started:  true,
+		Client:   &clientState{Name: "qBittorrent", Version: "3.3.1"},
+		Torrents: torrentCounts{Total: 3, Seeding: 2, Paused: 1},
+		Uploaded: 6000,
+		Seeders:  16,
+		Leechers: 9,
+		Speed:    &speedStatsResult{CurrentBandwidth: 150},
+	}, decodeJsonAs(t, body, &statsResource{}))
+}
+
+func TestApiV2_ShouldAnswerJsonErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		bridge     types.ICoreBridge
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "unknown-path", method: http.MethodGet, path: "/nothing", bridge: newFakeBridge(), wantStatus: http.StatusNotFound, wantCode: "not_found"},
+		{name: "wrong-method", method: http.MethodPut, path: "/config", bridge: newFakeBridge(), wantStatus: http.StatusMethodNotAllowed, wantCode: "method_not_allowed"},
+		{name: "core-not-loaded", method: http.MethodGet, path: "/config", bridge: nil, wantStatus: http.StatusServiceUnavailable, wantCode: "core_not_ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mux.NewRouter()
+			registerApiV2Routes(router.PathPrefix("/api/v2").Subrouter(), &apiV2{
+				getBridgeOrNil: func() types.ICoreBridge { return tt.bridge },
+				readState:      func(reader func(s *state)) { reader(testState()) },
+			})
+			server := httptest.NewServer(router)
+			defer server.Close()
+
+			res, body := doRequest(t, tt.method, server.URL+"/api/v2"+tt.path, "", nil)
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			apiErr := decodeApiError(t, body)
+			assert.Equal(t, tt.wantStatus, apiErr.Status)
+			assert.Equal(t, tt.wantCode, apiErr.Code)
+			assert.NotEmpty(t, apiErr.Message)
+		})
+	}
+}
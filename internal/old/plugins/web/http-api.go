@@ -2,14 +2,17 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
 	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
 	"github.com/gorilla/mux"
 	"net/http"
 	"path/filepath"
 )
 
-func registerApiRoutes(subrouter *mux.Router, getBridgeOrNil func() types.ICoreBridge, getState func() *state) {
+func registerApiRoutes(subrouter *mux.Router, getBridgeOrNil func() types.ICoreBridge, getState func() *state, getPluginsHealth func() []types.PluginHealth) {
 	subrouter.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		err := json.NewEncoder(w).Encode(getState())
@@ -74,7 +77,7 @@ func registerApiRoutes(subrouter *mux.Router, getBridgeOrNil func() types.ICoreB
 			return
 		}
 
-		var userConf *types.RuntimeConfig
+		userConf := &types.RuntimeConfig{}
 		err := json.NewDecoder(r.Body).Decode(userConf)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -121,6 +124,10 @@ func registerApiRoutes(subrouter *mux.Router, getBridgeOrNil func() types.ICoreB
 		}
 
 		err = bridge.AddTorrent(filename, file)
+		if errors.Is(err, types.ErrInvalidTorrentName) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -151,6 +158,59 @@ func registerApiRoutes(subrouter *mux.Router, getBridgeOrNil func() types.ICoreB
 		err = bridge.RemoveTorrent(infohash)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods(http.MethodDelete)
+
+	subrouter.HandleFunc("/torrent/goal", func(w http.ResponseWriter, r *http.Request) {
+		bridge := getBridgeOrNil()
+		if bridge == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		infohash, err := parseInfohashParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		goal := &types.TorrentGoal{}
+		err = json.NewDecoder(r.Body).Decode(goal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer func() { _ = r.Body.Close() }()
+
+		err = bridge.SetTorrentGoal(infohash, goal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods(http.MethodPut)
+
+	subrouter.HandleFunc("/torrent/goal", func(w http.ResponseWriter, r *http.Request) {
+		bridge := getBridgeOrNil()
+		if bridge == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		infohash, err := parseInfohashParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = bridge.SetTorrentGoal(infohash, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		w.WriteHeader(http.StatusNoContent)
@@ -176,4 +236,43 @@ func registerApiRoutes(subrouter *mux.Router, getBridgeOrNil func() types.ICoreB
 			return
 		}
 	}).Methods(http.MethodGet)
+
+	subrouter.HandleFunc("/plugins", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(getPluginsHealth())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods(http.MethodGet)
+
+	subrouter.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseHistoryQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, hasMore := broadcast.History(query)
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(toJournalPage(entries, hasMore, query))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}).Methods(http.MethodGet)
+}
+
+func parseInfohashParam(r *http.Request) (metainfo.Hash, error) {
+	infohash := metainfo.Hash{}
+	param := r.URL.Query().Get("infohash")
+	if param == "" {
+		return infohash, fmt.Errorf("'infohash' query param is required")
+	}
+	err := infohash.FromHexString(param)
+	if err != nil {
+		return infohash, fmt.Errorf("failed to parse infohash")
+	}
+	return infohash, nil
 }
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"errors"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// headerCountingRecorder counts the status codes written, a handler must answer once
+type headerCountingRecorder struct {
+	*httptest.ResponseRecorder
+	headers int
+}
+
+func (r *headerCountingRecorder) WriteHeader(code int) {
+	r.headers++
+	r.ResponseRecorder.WriteHeader(code)
+}
+
+func serveApi(bridge types.ICoreBridge, req *http.Request) *headerCountingRecorder {
+	router := mux.NewRouter()
+	registerApiRoutes(router.PathPrefix("/api").Subrouter(),
+		func() types.ICoreBridge { return bridge },
+		func() *state { return testState() },
+		func() []types.PluginHealth { return nil },
+	)
+	rec := &headerCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestApi_PutConfigurationShouldUpdateTheConfig(t *testing.T) {
+	bridge := newFakeBridge()
+	req := httptest.NewRequest(http.MethodPut, "/api/configuration", strings.NewReader(`{"minimumBytesPerSeconds": 100, "maximumBytesPerSeconds": 200, "client": "utorrent.yml"}`))
+
+	rec := serveApi(bridge, req)
+
+	want := &types.RuntimeConfig{MinimumBytesPerSeconds: 100, MaximumBytesPerSeconds: 200, Client: "utorrent.yml"}
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, want, bridge.conf)
+	assert.Equal(t, want, decodeJsonAs(t, rec.Body.Bytes(), &types.RuntimeConfig{}))
+}
+
+func TestApi_PutConfigurationShouldRefuseInvalidJson(t *testing.T) {
+	bridge := newFakeBridge()
+	req := httptest.NewRequest(http.MethodPut, "/api/configuration", strings.NewReader(`speed=1`))
+
+	rec := serveApi(bridge, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, int64(20), bridge.conf.MaximumBytesPerSeconds)
+}
+
+func TestApi_DeleteTorrentShouldAnswerOnceWhenTheRemoveFails(t *testing.T) {
+	bridge := newFakeBridge()
+	bridge.err = errors.New("archive failed")
+	req := httptest.NewRequest(http.MethodDelete, "/api/torrent?infohash="+torrent.InfoHash{1}.HexString(), nil)
+
+	rec := serveApi(bridge, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, 1, rec.headers)
+	assert.Equal(t, "archive failed\n", rec.Body.String())
+	assert.Equal(t, []torrent.InfoHash{{1}}, bridge.called)
+}
+
+func TestApi_DeleteTorrentShouldRemoveTheTorrent(t *testing.T) {
+	bridge := newFakeBridge()
+	req := httptest.NewRequest(http.MethodDelete, "/api/torrent?infohash="+torrent.InfoHash{1}.HexString(), nil)
+
+	rec := serveApi(bridge, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, 1, rec.headers)
+	assert.Equal(t, []torrent.InfoHash{{1}}, bridge.called)
+}
@@ -0,0 +1,196 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
+	"github.com/gorilla/mux"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sessionCookieName = "joal_session"
+
+const (
+	loginRouteName  = "auth.login"
+	logoutRouteName = "auth.logout"
+)
+
+type identityContextKey struct{}
+
+// httpAuth protects the api: a request is authenticated by an api token sent as a bearer token, or by the
+// session cookie issued on login. Read scoped identities may only use the safe methods.
+type httpAuth struct {
+	authenticator *auth.Authenticator
+	loginLimiter  *auth.RateLimiter
+	cookiePath    string
+}
+
+type loginRequest struct {
+	Token string `json:"token"`
+}
+
+type loginResponse struct {
+	Name      string     `json:"name"`
+	Scope     auth.Scope `json:"scope"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	// StompPasscode is the passcode the web ui sends on STOMP CONNECT, the session cookie is not readable from javascript
+	StompPasscode string `json:"stompPasscode"`
+}
+
+// registerAuthRoutes registers the login endpoints and protects all the routes of the subrouter
+func registerAuthRoutes(subrouter *mux.Router, a *httpAuth) {
+	if !a.authenticator.Enabled() {
+		return
+	}
+	subrouter.Handle("/auth/login", a.handle(a.login)).Methods(http.MethodPost).Name(loginRouteName)
+	subrouter.Handle("/auth/logout", a.handle(a.logout)).Methods(http.MethodPost).Name(logoutRouteName)
+	subrouter.Handle("/auth/me", a.handle(a.me)).Methods(http.MethodGet)
+	subrouter.Use(a.middleware)
+}
+
+func (a *httpAuth) handle(handler func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r); err != nil {
+			apiErr := toApiError(err)
+			writeJson(w, apiErr.Status, apiErr)
+		}
+	})
+}
+
+func (a *httpAuth) middleware(next http.Handler) http.Handler {
+	return a.handle(func(w http.ResponseWriter, r *http.Request) error {
+		if route := mux.CurrentRoute(r); route != nil && (route.GetName() == loginRouteName || route.GetName() == logoutRouteName) {
+			next.ServeHTTP(w, r)
+			return nil
+		}
+		identity, err := a.identify(w, r)
+		if err != nil {
+			return err
+		}
+		if !identity.Scope.Allows(requiredScope(r.Method)) {
+			return newApiError(http.StatusForbidden, "forbidden", "token '%s' has the '%s' scope, '%s' is required", identity.Name, identity.Scope, requiredScope(r.Method))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity)))
+		return nil
+	})
+}
+
+// identify authenticates the bearer token if any, the session cookie otherwise
+func (a *httpAuth) identify(w http.ResponseWriter, r *http.Request) (*auth.Identity, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header {
+			return nil, a.unauthorized(w, "the authorization header must hold a bearer token")
+		}
+		return a.verifyToken(w, r, token)
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		identity, err := a.authenticator.VerifySession(cookie.Value)
+		if err != nil {
+			return nil, a.unauthorized(w, "session is invalid or expired")
+		}
+		return identity, nil
+	}
+	return nil, a.unauthorized(w, "authentication is required")
+}
+
+// verifyToken verifies the token, the failed attempts are rate limited per remote ip
+func (a *httpAuth) verifyToken(w http.ResponseWriter, r *http.Request, token string) (*auth.Identity, error) {
+	ip := remoteIp(r)
+	if allowed, retryAfter := a.loginLimiter.Allow(ip); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return nil, newApiError(http.StatusTooManyRequests, "too_many_attempts", "too many failed attempts, retry in %s", retryAfter.Round(time.Second))
+	}
+	identity, err := a.authenticator.VerifyToken(token)
+	if err != nil {
+		a.loginLimiter.Fail(ip)
+		return nil, a.unauthorized(w, "token is invalid")
+	}
+	a.loginLimiter.Reset(ip)
+	return identity, nil
+}
+
+func (a *httpAuth) unauthorized(w http.ResponseWriter, message string) error {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="joal"`)
+	return newApiError(http.StatusUnauthorized, "unauthorized", "%s", message)
+}
+
+func (a *httpAuth) login(w http.ResponseWriter, r *http.Request) error {
+	body := &loginRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(body); err != nil {
+		return newApiError(http.StatusBadRequest, "invalid_body", "failed to parse the request body: %v", err)
+	}
+	identity, err := a.verifyToken(w, r, body.Token)
+	if err != nil {
+		return err
+	}
+	session, err := a.authenticator.CreateSession(identity)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.Id,
+		Path:     a.cookiePath,
+		Expires:  session.ExpiresAt,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeJson(w, http.StatusOK, &loginResponse{
+		Name:          identity.Name,
+		Scope:         identity.Scope,
+		ExpiresAt:     session.ExpiresAt,
+		StompPasscode: session.StompPasscode,
+	})
+	return nil
+}
+
+func (a *httpAuth) logout(w http.ResponseWriter, r *http.Request) error {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		a.authenticator.DeleteSession(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     a.cookiePath,
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *httpAuth) me(w http.ResponseWriter, r *http.Request) error {
+	identity, ok := r.Context().Value(identityContextKey{}).(*auth.Identity)
+	if !ok {
+		return errors.New("request has no identity")
+	}
+	writeJson(w, http.StatusOK, identity)
+	return nil
+}
+
+// requiredScope is read for the safe methods, admin for the others
+func requiredScope(method string) auth.Scope {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return auth.ScopeRead
+	default:
+		return auth.ScopeAdmin
+	}
+}
+
+func remoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
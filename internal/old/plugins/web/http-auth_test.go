@@ -0,0 +1,243 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type authTestServer struct {
+	*httptest.Server
+	readToken  string
+	adminToken string
+}
+
+func newAuthTestServer(t *testing.T, attempts int) *authTestServer {
+	readToken, readHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+	adminToken, adminHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+	authenticator, err := auth.NewAuthenticator([]*auth.TokenConfig{
+		{Name: "grafana", Scope: auth.ScopeRead, Hash: readHash},
+		{Name: "ui", Scope: auth.ScopeAdmin, Hash: adminHash},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	bridge := newFakeBridge()
+	router := mux.NewRouter()
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	registerAuthRoutes(apiRouter, &httpAuth{
+		authenticator: authenticator,
+		loginLimiter:  auth.NewRateLimiter(attempts, time.Minute),
+		cookiePath:    "/",
+	})
+	registerApiV2Routes(apiRouter.PathPrefix("/v2").Subrouter(), &apiV2{
+		getBridgeOrNil: func() types.ICoreBridge { return bridge },
+		readState:      func(reader func(s *state)) { reader(testState()) },
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return &authTestServer{Server: server, readToken: readToken, adminToken: adminToken}
+}
+
+func (s *authTestServer) login(t *testing.T, token string) (*http.Response, []byte) {
+	body, _ := json.Marshal(&loginRequest{Token: token})
+	return doRequest(t, http.MethodPost, s.URL+"/api/auth/login", "application/json", strings.NewReader(string(body)))
+}
+
+func sessionCookie(res *http.Response) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == sessionCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func doAuthenticatedRequest(t *testing.T, method string, url string, authorization string, cookie *http.Cookie) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = res.Body.Close() }()
+	content, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, content
+}
+
+func TestHttpAuth_ShouldEnforceScopes(t *testing.T) {
+	server := newAuthTestServer(t, 100)
+	readSession := sessionCookie(func() *http.Response { res, _ := server.login(t, server.readToken); return res }())
+	adminSession := sessionCookie(func() *http.Response { res, _ := server.login(t, server.adminToken); return res }())
+	require.NotNil(t, readSession)
+	require.NotNil(t, adminSession)
+	pauseUrl := server.URL + "/api/v2/torrents/" + torrent.InfoHash{1}.String() + "/pause"
+
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        *http.Cookie
+		method        string
+		url           string
+		wantStatus    int
+		wantCode      string
+	}{
+		{name: "anonymous-read", method: http.MethodGet, url: server.URL + "/api/v2/torrents", wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "anonymous-write", method: http.MethodPost, url: pauseUrl, wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "invalid-token", authorization: "Bearer joal_nope", method: http.MethodGet, url: server.URL + "/api/v2/torrents", wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "not-a-bearer-token", authorization: "Basic " + server.adminToken, method: http.MethodGet, url: server.URL + "/api/v2/torrents", wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "invalid-session", cookie: &http.Cookie{Name: sessionCookieName, Value: "nope"}, method: http.MethodGet, url: server.URL + "/api/v2/torrents", wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "read-token-read", authorization: "Bearer " + server.readToken, method: http.MethodGet, url: server.URL + "/api/v2/torrents", wantStatus: http.StatusOK},
+		{name: "read-token-write", authorization: "Bearer " + server.readToken, method: http.MethodPost, url: pauseUrl, wantStatus: http.StatusForbidden, wantCode: "forbidden"},
+		{name: "read-session-read", cookie: readSession, method: http.MethodGet, url: server.URL + "/api/v2/stats", wantStatus: http.StatusOK},
+		{name: "read-session-write", cookie: readSession, method: http.MethodPost, url: pauseUrl, wantStatus: http.StatusForbidden, wantCode: "forbidden"},
+		{name: "admin-token-read", authorization: "Bearer " + server.adminToken, method: http.MethodGet, url: server.URL + "/api/v2/torrents", wantStatus: http.StatusOK},
+		{name: "admin-token-write", authorization: "Bearer " + server.adminToken, method: http.MethodPost, url: pauseUrl, wantStatus: http.StatusNoContent},
+		{name: "admin-session-read", cookie: adminSession, method: http.MethodGet, url: server.URL + "/api/v2/stats", wantStatus: http.StatusOK},
+		{name: "admin-session-write", cookie: adminSession, method: http.MethodPost, url: pauseUrl, wantStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doAuthenticatedRequest(t, tt.method, tt.url, tt.authorization, tt.cookie)
+
+			require.Equal(t, tt.wantStatus, res.StatusCode, string(body))
+			if tt.wantCode != "" {
+				assert.Equal(t, tt.wantCode, decodeApiError(t, body).Code)
+			}
+			if tt.wantStatus == http.StatusUnauthorized {
+				assert.NotEmpty(t, res.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestHttpAuth_ShouldLogin(t *testing.T) {
+	server := newAuthTestServer(t, 100)
+
+	res, body := server.login(t, server.readToken)
+
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	login := decodeJsonAs(t, body, &loginResponse{}).(*loginResponse)
+	assert.Equal(t, "grafana", login.Name)
+	assert.Equal(t, auth.ScopeRead, login.Scope)
+	assert.NotEmpty(t, login.StompPasscode)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), login.ExpiresAt, time.Minute)
+	cookie := sessionCookie(res)
+	require.NotNil(t, cookie)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	assert.Equal(t, "/", cookie.Path)
+	assert.NotEqual(t, login.StompPasscode, cookie.Value)
+
+	res, body = doAuthenticatedRequest(t, http.MethodGet, server.URL+"/api/auth/me", "", cookie)
+	require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	assert.Equal(t, &auth.Identity{Name: "grafana", Scope: auth.ScopeRead}, decodeJsonAs(t, body, &auth.Identity{}))
+}
+
+func TestHttpAuth_ShouldRejectInvalidLogin(t *testing.T) {
+	server := newAuthTestServer(t, 100)
+
+	res, body := server.login(t, "joal_nope")
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, "unauthorized", decodeApiError(t, body).Code)
+	assert.Nil(t, sessionCookie(res))
+
+	res, body = doRequest(t, http.MethodPost, server.URL+"/api/auth/login", "application/json", strings.NewReader("{"))
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "invalid_body", decodeApiError(t, body).Code)
+}
+
+func TestHttpAuth_ShouldLogout(t *testing.T) {
+	server := newAuthTestServer(t, 100)
+	res, _ := server.login(t, server.readToken)
+	cookie := sessionCookie(res)
+	require.NotNil(t, cookie)
+
+	res, _ = doAuthenticatedRequest(t, http.MethodPost, server.URL+"/api/auth/logout", "", cookie)
+
+	require.Equal(t, http.StatusNoContent, res.StatusCode, "a read scoped session may logout")
+	cleared := sessionCookie(res)
+	require.NotNil(t, cleared)
+	assert.Empty(t, cleared.Value)
+	res, _ = doAuthenticatedRequest(t, http.MethodGet, server.URL+"/api/auth/me", "", cookie)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestHttpAuth_ShouldRateLimitFailedAttempts(t *testing.T) {
+	server := newAuthTestServer(t, 2)
+
+	for i := 0; i < 2; i++ {
+		res, _ := server.login(t, "joal_nope")
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	}
+
+	res, body := server.login(t, server.adminToken)
+	require.Equal(t, http.StatusTooManyRequests, res.StatusCode, "valid tokens are blocked as well")
+	assert.Equal(t, "too_many_attempts", decodeApiError(t, body).Code)
+	assert.NotEmpty(t, res.Header.Get("Retry-After"))
+	res, _ = doAuthenticatedRequest(t, http.MethodGet, server.URL+"/api/v2/torrents", "Bearer "+server.adminToken, nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode, "bearer tokens share the limit of the login")
+}
+
+func TestHttpAuth_ShouldNotProtectWhenNoTokenIsConfigured(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(nil, time.Hour)
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	registerAuthRoutes(apiRouter, &httpAuth{authenticator: authenticator, loginLimiter: auth.NewRateLimiter(1, time.Minute), cookiePath: "/"})
+	bridge := newFakeBridge()
+	registerApiV2Routes(apiRouter.PathPrefix("/v2").Subrouter(), &apiV2{
+		getBridgeOrNil: func() types.ICoreBridge { return bridge },
+		readState:      func(reader func(s *state)) { reader(testState()) },
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	res, _ := doRequest(t, http.MethodPost, server.URL+"/api/v2/torrents/"+torrent.InfoHash{1}.String()+"/pause", "", nil)
+
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+}
+
+func TestWithCors(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	tests := []struct {
+		name       string
+		allowed    []string
+		origin     string
+		wantOrigin string
+	}{
+		{name: "same-origin-only", allowed: nil, origin: "https://evil.example.org", wantOrigin: ""},
+		{name: "allowed-origin", allowed: []string{"https://ui.example.org"}, origin: "https://ui.example.org", wantOrigin: "https://ui.example.org"},
+		{name: "other-origin", allowed: []string{"https://ui.example.org"}, origin: "https://evil.example.org", wantOrigin: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/torrents", nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+
+			withCors(handler, &corsConfig{AllowedOrigins: tt.allowed}).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+			if tt.wantOrigin != "" {
+				assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultJournalPageSize = 100
+	maxJournalPageSize     = 1000
+)
+
+type journalEntry struct {
+	Seq     uint64      `json:"seq"`
+	Time    time.Time   `json:"time"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+type journalPage struct {
+	Entries []journalEntry `json:"entries"`
+	// Next is the 'after' query param of the next page
+	Next    uint64 `json:"next"`
+	HasMore bool   `json:"hasMore"`
+}
+
+// parseHistoryQuery reads the 'after', 'since', 'infohash', 'tracker', 'type' and 'limit' query params, all optional
+func parseHistoryQuery(r *http.Request) (broadcast.HistoryQuery, error) {
+	params := r.URL.Query()
+	query := broadcast.HistoryQuery{Limit: defaultJournalPageSize}
+	if after := params.Get("after"); after != "" {
+		seq, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			return query, fmt.Errorf("failed to parse 'after' query param")
+		}
+		query.AfterSeq = seq
+	}
+	if since := params.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return query, fmt.Errorf("failed to parse 'since' query param, expected a RFC 3339 date")
+		}
+		query.Since = t
+	}
+	if params.Get("infohash") != "" {
+		infohash, err := parseInfohashParam(r)
+		if err != nil {
+			return query, err
+		}
+		query.Infohash = &infohash
+	}
+	query.Tracker = params.Get("tracker")
+	for _, t := range params["type"] {
+		for _, eventType := range strings.Split(t, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				query.Types = append(query.Types, eventType)
+			}
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxJournalPageSize {
+			return query, fmt.Errorf("'limit' query param must be between 1 and %d", maxJournalPageSize)
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
+func toJournalPage(entries []broadcast.Entry, hasMore bool, query broadcast.HistoryQuery) *journalPage {
+	page := &journalPage{
+		Entries: make([]journalEntry, 0, len(entries)),
+		Next:    query.AfterSeq,
+		HasMore: hasMore,
+	}
+	for _, e := range entries {
+		page.Entries = append(page.Entries, journalEntry{
+			Seq:     e.Seq,
+			Time:    e.Time,
+			Type:    e.Type(),
+			Payload: types.EventPayload(e.Event),
+		})
+		page.Next = e.Seq
+	}
+	return page
+}
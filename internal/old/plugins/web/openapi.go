@@ -0,0 +1,240 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const openApiVersion = "3.0.3"
+
+var pathParamPattern = regexp.MustCompile(`{([^}]+)}`)
+
+var pathParamDescriptions = map[string]string{
+	"infohash": "torrent infohash (hex encoded)",
+	"file":     "client file name",
+}
+
+type openApiDocument struct {
+	OpenApi    string                                  `json:"openapi"`
+	Info       openApiInfo                             `json:"info"`
+	Servers    []openApiServer                         `json:"servers"`
+	Paths      map[string]map[string]*openApiOperation `json:"paths"`
+	Components openApiComponents                       `json:"components"`
+}
+
+type openApiInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openApiServer struct {
+	Url string `json:"url"`
+}
+
+type openApiComponents struct {
+	Schemas map[string]*schema `json:"schemas"`
+}
+
+type openApiOperation struct {
+	OperationId string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*openApiParameter         `json:"parameters,omitempty"`
+	RequestBody *openApiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openApiResponse `json:"responses"`
+}
+
+type openApiParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *schema `json:"schema"`
+}
+
+type openApiRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openApiMediaType `json:"content"`
+}
+
+type openApiResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openApiMediaType `json:"content,omitempty"`
+}
+
+type openApiMediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// newOpenApiDocument describes the routes, the schemas of the bodies are generated from the json tags of the samples
+func newOpenApiDocument(routes []*apiRoute) *openApiDocument {
+	schemas := &schemaGenerator{components: map[string]*schema{}}
+	errorSchema := schemas.of(reflect.TypeOf(apiError{}))
+
+	doc := &openApiDocument{
+		OpenApi: openApiVersion,
+		Info:    openApiInfo{Title: "JOAL", Version: "2"},
+		// relative to the location of the document, which is served at the root of the api
+		Servers:    []openApiServer{{Url: "."}},
+		Paths:      map[string]map[string]*openApiOperation{},
+		Components: openApiComponents{Schemas: schemas.components},
+	}
+	for _, route := range routes {
+		op := &openApiOperation{
+			OperationId: route.id,
+			Summary:     route.summary,
+			Responses:   map[string]*openApiResponse{},
+		}
+		if route.tag != "" {
+			op.Tags = []string{route.tag}
+		}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.path, -1) {
+			op.Parameters = append(op.Parameters, &openApiParameter{
+				Name:        match[1],
+				In:          "path",
+				Description: pathParamDescriptions[match[1]],
+				Required:    true,
+				Schema:      &schema{Type: "string"},
+			})
+		}
+		for _, q := range route.query {
+			op.Parameters = append(op.Parameters, &openApiParameter{
+				Name:        q.name,
+				In:          "query",
+				Description: q.description,
+				Schema:      &schema{Type: q.kind, Enum: q.enum},
+			})
+		}
+		switch {
+		case route.multipart:
+			op.RequestBody = &openApiRequestBody{Required: true, Content: map[string]*openApiMediaType{
+				"multipart/form-data": {Schema: &schema{
+					Type:       "object",
+					Properties: map[string]*schema{"file": {Type: "string", Format: "binary"}},
+					Required:   []string{"file"},
+				}},
+			}}
+		case route.request != nil:
+			op.RequestBody = &openApiRequestBody{Required: true, Content: map[string]*openApiMediaType{
+				"application/json": {Schema: schemas.of(reflect.TypeOf(route.request))},
+			}}
+		}
+
+		success := &openApiResponse{Description: http.StatusText(route.status)}
+		if route.response != nil {
+			success.Content = map[string]*openApiMediaType{"application/json": {Schema: schemas.of(reflect.TypeOf(route.response))}}
+		}
+		op.Responses[strconv.Itoa(route.status)] = success
+		for _, status := range append(route.errors, http.StatusInternalServerError) {
+			op.Responses[strconv.Itoa(status)] = &openApiResponse{
+				Description: http.StatusText(status),
+				Content:     map[string]*openApiMediaType{"application/json": {Schema: errorSchema}},
+			}
+		}
+
+		if doc.Paths[route.path] == nil {
+			doc.Paths[route.path] = map[string]*openApiOperation{}
+		}
+		doc.Paths[route.path][strings.ToLower(route.method)] = op
+	}
+	return doc
+}
+
+// schemaGenerator generates the schemas of the go types, the structs are added to the components and referenced
+type schemaGenerator struct {
+	components map[string]*schema
+}
+
+func (g *schemaGenerator) of(t reflect.Type) *schema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.of(t.Elem())
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.of(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.of(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, done := g.components[name]; !done {
+			// registered before the fields to stop on recursive types
+			g.components[name] = &schema{}
+			g.components[name] = g.structSchema(t)
+		}
+		return &schema{Ref: "#/components/schemas/" + name}
+	default:
+		// interface{}: any value
+		return &schema{}
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: map[string]*schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := g.of(field.Type)
+		if field.Type.Kind() == reflect.Ptr && property.Ref == "" {
+			property.Nullable = true
+		}
+		s.Properties[name] = property
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// schemaName is the exported name of the type, without the Resource suffix
+func schemaName(t reflect.Type) string {
+	name := strings.TrimSuffix(t.Name(), "Resource")
+	if name == "" {
+		return "Object"
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenApi_ShouldDescribeAllRoutes(t *testing.T) {
+	api := &apiV2{}
+	routes := api.routes()
+
+	doc := newOpenApiDocument(routes)
+
+	assert.Equal(t, "3.0.3", doc.OpenApi)
+	for _, route := range routes {
+		op, found := doc.Paths[route.path][strings.ToLower(route.method)]
+		require.True(t, found, "%s %s is not documented", route.method, route.path)
+		assert.NotEmpty(t, op.Summary)
+		assert.Contains(t, op.Responses, "500")
+	}
+	getTorrent := doc.Paths["/torrents/{infohash}"]["get"]
+	require.Len(t, getTorrent.Parameters, 1)
+	assert.Equal(t, &openApiParameter{Name: "infohash", In: "path", Description: "torrent infohash (hex encoded)", Required: true, Schema: &schema{Type: "string"}}, getTorrent.Parameters[0])
+	assert.Equal(t, "#/components/schemas/Torrent", getTorrent.Responses["200"].Content["application/json"].Schema.Ref)
+	assert.Equal(t, "#/components/schemas/ApiError", getTorrent.Responses["404"].Content["application/json"].Schema.Ref)
+	assert.Nil(t, doc.Paths["/torrents/{infohash}/pause"]["post"].Responses["204"].Content)
+	assert.Contains(t, doc.Paths["/clients"]["post"].RequestBody.Content, "multipart/form-data")
+}
+
+func TestOpenApi_ShouldResolveAllReferences(t *testing.T) {
+	doc, err := json.Marshal(newOpenApiDocument((&apiV2{}).routes()))
+	require.NoError(t, err)
+
+	var parsed struct {
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(doc, &parsed))
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := node["$ref"].(string); ok {
+				assert.Contains(t, parsed.Components.Schemas, strings.TrimPrefix(ref, "#/components/schemas/"))
+			}
+			for _, child := range node {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	var raw interface{}
+	require.NoError(t, json.Unmarshal(doc, &raw))
+	walk(raw)
+}
+
+func TestSchemaGenerator_ShouldFollowJsonTags(t *testing.T) {
+	type nested struct {
+		At time.Time `json:"at"`
+	}
+	type sample struct {
+		Name     string          `json:"name"`
+		Count    int32           `json:"count,omitempty"`
+		Ratio    float64         `json:"ratio"`
+		Optional *int64          `json:"optional,omitempty"`
+		Tags     []string        `json:"tags"`
+		Labels   map[string]bool `json:"labels"`
+		Nested   *nested         `json:"nested"`
+		Any      interface{}     `json:"any"`
+		Ignored  string          `json:"-"`
+		private  string
+		Untagged string
+	}
+	g := &schemaGenerator{components: map[string]*schema{}}
+
+	ref := g.of(reflect.TypeOf(sample{}))
+
+	assert.Equal(t, &schema{Ref: "#/components/schemas/Sample"}, ref)
+	assert.Equal(t, &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"name":     {Type: "string"},
+			"count":    {Type: "integer", Format: "int32"},
+			"ratio":    {Type: "number", Format: "double"},
+			"optional": {Type: "integer", Format: "int64", Nullable: true},
+			"tags":     {Type: "array", Items: &schema{Type: "string"}},
+			"labels":   {Type: "object", AdditionalProperties: &schema{Type: "boolean"}},
+			"nested":   {Ref: "#/components/schemas/Nested"},
+			"any":      {},
+			"Untagged": {Type: "string"},
+		},
+		Required: []string{"name", "ratio", "tags", "labels", "nested", "any", "Untagged"},
+	}, g.components["Sample"])
+	assert.Equal(t, &schema{Type: "string", Format: "date-time"}, g.components["Nested"].Properties["at"])
+}
+
+func TestApiV2_ShouldServeOpenApiDocument(t *testing.T) {
+	server := newApiV2Server(t, newFakeBridge(), testState())
+
+	res, body := doRequest(t, http.MethodGet, server.URL+"/api/v2/openapi.json", "", nil)
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	doc := &openApiDocument{}
+	require.NoError(t, json.Unmarshal(body, doc))
+	assert.Contains(t, doc.Paths, "/openapi.json")
+	assert.Contains(t, doc.Components.Schemas, "Torrent")
+}
@@ -2,10 +2,10 @@ package web
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
-	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
 	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
 	"github.com/go-stomp/stomp/v3"
 	stompServer "github.com/go-stomp/stomp/v3/server"
 	"github.com/gorilla/mux"
@@ -13,75 +13,116 @@ import (
 	"go.uber.org/zap"
 	"net"
 	"net/http"
-	"net/url"
-	"nhooyr.io/websocket"
-	"os"
-	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 )
 
 type plugin struct {
-	configLoader       *webConfigLoader
+	config             *webConfig
+	log                *zap.Logger
+	configDir          string
 	staticFilesDir     string
 	coreBridge         types.ICoreBridge
+	pluginsHealth      func() []types.PluginHealth
+	authenticator      *auth.Authenticator
 	stompServer        net.Listener
 	stompPublisher     *stomp.Conn
 	coreListener       *appStateCoreListener
 	httpServer         *http.Server
 	wsListener         net.Listener
 	unregisterListener func()
+	health             *types.HealthState
 }
 
-func (w *plugin) Name() string {
-	return "Web UI"
+func init() {
+	types.RegisterPlugin(types.PluginFactory{
+		Name:             "web",
+		EnabledByDefault: true,
+		LegacyFlags:      map[string]bool{"--no-webui": false},
+		New:              newPlugin,
+	})
 }
 
-func ShouldEnablePlugin() bool {
-	for _, arg := range os.Args {
-		if arg == "--no-webui" {
-			return false
-		}
-	}
-	return true
+func (w *plugin) Name() string {
+	return "web"
 }
 
-func BootStrap(pluginsRootDir string, coreBridge types.ICoreBridge, client *http.Client) (types.IJoalPlugin, error) {
-	configRoot := filepath.Join(pluginsRootDir, "web")
+func newPlugin(ctx types.PluginContext, config *types.PluginConfig) (types.IJoalPlugin, error) {
+	conf, err := decodeConfig(config)
+	if err != nil {
+		return nil, err
+	}
 
 	p := &plugin{
-		configLoader:       newWebConfigLoader(configRoot),
-		staticFilesDir:     staticFilesDirFromRoot(configRoot),
-		coreBridge:         coreBridge,
+		config:             conf,
+		log:                ctx.Logger,
+		configDir:          ctx.ConfigDir,
+		staticFilesDir:     staticFilesDirFromRoot(ctx.ConfigDir),
+		coreBridge:         ctx.Bridge,
+		pluginsHealth:      ctx.PluginsHealth,
+		authenticator:      nil,
 		stompServer:        nil,
 		stompPublisher:     nil,
 		coreListener:       nil,
 		httpServer:         nil,
 		wsListener:         nil,
 		unregisterListener: nil,
+		health:             &types.HealthState{},
 	}
 
-	log := logs.GetLogger().With(zap.String("plugin", p.Name()))
-
-	err := bootstrap(configRoot, client, log)
+	err = bootstrap(ctx.ConfigDir, ctx.HttpClient, p.log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to bootstrap web plugin: %w", err)
 	}
 	return p, nil
 }
 
+func decodeConfig(config *types.PluginConfig) (*webConfig, error) {
+	conf := webConfig{}.Default()
+	if err := config.Decode(conf); err != nil {
+		return nil, err
+	}
+	if err := conf.Auth.validate(); err != nil {
+		return nil, fmt.Errorf("invalid web plugin config: %w", err)
+	}
+	if err := conf.Http.Tls.validate(); err != nil {
+		return nil, fmt.Errorf("invalid web plugin config: %w", err)
+	}
+	return conf, nil
+}
+
 func (w *plugin) Start() error {
-	log := logs.GetLogger().With(zap.String("plugin", w.Name()))
+	conf := w.config
+	log := w.log
 
-	conf, err := w.configLoader.ReadConfig()
-	if err != nil {
-		return err
-	}
 	w.coreListener = &appStateCoreListener{
 		state: state{}.initialState(),
 		lock:  &sync.Mutex{},
 	}
 
+	authenticator, err := auth.NewAuthenticator(conf.Auth.Tokens, conf.Auth.SessionTtl)
+	if err != nil {
+		return fmt.Errorf("failed to create the authenticator: %w", err)
+	}
+	w.authenticator = authenticator
+	// The publisher connects to our own stomp server, it has its own token which never leaves the process
+	publisherToken, err := authenticator.AddToken("stomp-publisher", auth.ScopeAdmin)
+	if err != nil {
+		return fmt.Errorf("failed to create the stomp publisher token: %w", err)
+	}
+	if !authenticator.Enabled() {
+		log.Warn("no api token is configured, the web api and the stomp server are not protected")
+	}
+
+	var httpTls *tls.Config
+	if conf.Http.Tls.Enabled {
+		httpTls, err = newServerTlsConfig(conf.Http.Tls, w.configDir)
+		if err != nil {
+			return fmt.Errorf("failed to setup tls: %w", err)
+		}
+	}
+
 	// Create a listener for the websocket server
 	//  The listener is a faked one, if a client comes to the http websocket negotiation endpoint
 	//  he will be upgraded then be available to the wsListener#Accept() (just like a real net.Listener)
@@ -92,32 +133,41 @@ func (w *plugin) Start() error {
 	}
 	w.wsListener = wsListener
 
+	// The failed logins are rate limited per remote ip, whether they come from the http api or from stomp
+	loginLimiter := auth.NewRateLimiter(conf.Auth.LoginRateLimit.Attempts, conf.Auth.LoginRateLimit.Window)
+
 	// Start the stomp server, so it's ready to accept connection as soon as the HTTP server is up
-	startStompServer(conf.Stomp, w.wsListener, log)
+	startStompServer(conf.Stomp, authenticator, loginLimiter, w.wsListener, log)
 
 	router := mux.NewRouter()
 	// Register web ui static files endpoint
 	router.Handle(conf.Http.withSecretPathPrefix(conf.Http.WebUiUrl), webUiStaticFilesHandler(conf.Http.WebUiUrl, w.staticFilesDir)) // TODO: replace with a SPA handler from gorilla/mux documentation
 	// Register HTTP API
-	registerApiRoutes(router.PathPrefix(conf.Http.withSecretPathPrefix(conf.Http.HttpApiUrl)).Subrouter(), func() types.ICoreBridge { return w.coreBridge }, func() *state { return w.coreListener.state })
+	apiRouter := router.PathPrefix(conf.Http.withSecretPathPrefix(conf.Http.HttpApiUrl)).Subrouter()
+	registerAuthRoutes(apiRouter, &httpAuth{
+		authenticator: authenticator,
+		loginLimiter:  loginLimiter,
+		cookiePath:    conf.Http.withSecretPathPrefix("/"),
+	})
+	registerApiRoutes(apiRouter, func() types.ICoreBridge { return w.coreBridge }, func() *state { return w.coreListener.state }, w.pluginsHealth)
+	registerApiV2Routes(apiRouter.PathPrefix("/v2").Subrouter(), &apiV2{
+		getBridgeOrNil: func() types.ICoreBridge { return w.coreBridge },
+		readState:      w.coreListener.read,
+	})
 	// Register the websocket negotiation endpoint
 	router.HandleFunc(conf.Http.withSecretPathPrefix(conf.Http.WsNegotiationEndpointUrl), wsListener.HttpNegotiationHandleFunc(conf.WebSocket))
 
-	// TODO: move cors somewhere else (maybe in startHttpServer), and move params in config
-	handler := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{http.MethodOptions, http.MethodGet, http.MethodPost, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodConnect, http.MethodHead, http.MethodTrace},
-		Debug:          false,
-	}).Handler(router)
 	// Start Http server
-	w.httpServer, err = startHttpServer(handler, conf.Http, log)
+	var httpAddr net.Addr
+	w.httpServer, httpAddr, err = startHttpServer(withCors(router, conf.Cors), conf.Http, httpTls, log, w.health)
 	if err != nil {
 		shutdown(w, nil)
 		return err
 	}
+	log.Info("web server is listening", zap.Stringer("address", httpAddr), zap.Bool("tls", httpTls != nil))
 
-	// Create a client connected to our stomp server to be able to dispatch messages
-	stompPublisher, err := createStompPublisher(conf.Http, conf.WebSocket, conf.Stomp)
+	// Create an in-process client of our stomp server to be able to dispatch messages
+	stompPublisher, err := createStompPublisher(wsListener, publisherToken)
 	if err != nil {
 		shutdown(w, nil)
 		return fmt.Errorf("failed to create the stomp publisher: %w", err)
@@ -125,19 +175,33 @@ func (w *plugin) Start() error {
 
 	w.stompPublisher = stompPublisher
 	w.coreListener.stompPublisher = stompPublisher
-	w.unregisterListener = broadcast.RegisterListener(w.coreListener)
+	w.unregisterListener = w.coreListener.subscribe().Unsubscribe
+	w.health.Set(types.Healthy, "")
 
 	return nil
 }
 
+func (w *plugin) Health() types.Health {
+	return w.health.Get()
+}
+
+// Reload restarts the servers with the new configuration, the connected web ui have to reconnect
+func (w *plugin) Reload(config *types.PluginConfig) error {
+	conf, err := decodeConfig(config)
+	if err != nil {
+		return err
+	}
+	shutdown(w, nil)
+	w.config = conf
+	return w.Start()
+}
+
 func (w *plugin) AfterCoreLoaded(coreBridge types.ICoreBridge) {
 	w.coreBridge = coreBridge
 }
 
 func (w *plugin) Shutdown(ctx context.Context) {
-	log := logs.GetLogger().With(zap.String("plugin", w.Name()))
-
-	log.Info("Shutting down plugin")
+	w.log.Info("Shutting down plugin")
 	shutdown(w, ctx)
 }
 
@@ -151,28 +215,39 @@ func shutdown(w *plugin, ctx context.Context) {
 
 	if w.wsListener != nil {
 		_ = w.wsListener.Close()
+		w.wsListener = nil
 	}
 	if w.httpServer != nil {
 		if err := w.httpServer.Shutdown(ctx); err != nil {
 			_ = w.httpServer.Close()
 		}
+		w.httpServer = nil
 	}
 	if w.stompPublisher != nil {
 		_ = w.stompPublisher.Disconnect()
+		w.stompPublisher = nil
 	}
 	if w.stompServer != nil {
 		_ = w.stompServer.Close()
+		w.stompServer = nil
 	}
 	if w.unregisterListener != nil {
 		w.unregisterListener()
+		w.unregisterListener = nil
 	}
+	w.health.Set(types.Unhealthy, "stopped")
 }
 
-func startHttpServer(httpHandler http.Handler, config *httpConfig, log *zap.Logger) (*http.Server, error) {
+// startHttpServer serves over tls when httpTls is not nil, it returns the address the server listens on
+func startHttpServer(httpHandler http.Handler, config *httpConfig, httpTls *tls.Config, log *zap.Logger, health *types.HealthState) (*http.Server, net.Addr, error) {
 	// Create a listener for the HTTP server
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
+	listener, err := net.Listen("tcp", net.JoinHostPort(config.BindAddress, strconv.Itoa(config.Port)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to start listenet on port %d: %w", config.Port, err)
+		return nil, nil, fmt.Errorf("failed to start listener on %s: %w", net.JoinHostPort(config.BindAddress, strconv.Itoa(config.Port)), err)
+	}
+	addr := listener.Addr()
+	if httpTls != nil {
+		listener = tls.NewListener(listener, httpTls)
 	}
 	server := &http.Server{
 		Handler:           httpHandler,
@@ -188,16 +263,24 @@ func startHttpServer(httpHandler http.Handler, config *httpConfig, log *zap.Logg
 		if err := server.Serve(listener); err != nil {
 			if err != http.ErrServerClosed {
 				log.Error("http server has been closed", zap.Error(err))
+				health.Set(types.Unhealthy, fmt.Sprintf("http server has been closed: %s", err))
 			}
 		}
 	}()
-	return server, nil
+	return server, addr, nil
 }
 
-func startStompServer(config *stompConfig, wsListener net.Listener, log *zap.Logger) {
+// startStompServer protects the server with the authenticator when it is enabled: the failed CONNECT count against the
+// loginLimiter of the remote ip and only the admin scoped connections may SEND
+func startStompServer(config *stompConfig, authenticator *auth.Authenticator, loginLimiter *auth.RateLimiter, wsListener net.Listener, log *zap.Logger) {
+	var stompAuthenticator stompServer.Authenticator
+	if authenticator.Enabled() {
+		stompAuthenticator = authenticator
+		wsListener = &stompGuardListener{Listener: wsListener, guard: &stompGuard{authenticator: authenticator, loginLimiter: loginLimiter}}
+	}
 	go func() {
 		err := (&stompServer.Server{
-			Authenticator: config,
+			Authenticator: stompAuthenticator,
 			HeartBeat:     config.HeartBeat,
 			Log:           wrapZapLogger(log),
 		}).Serve(wsListener)
@@ -207,35 +290,42 @@ func startStompServer(config *stompConfig, wsListener net.Listener, log *zap.Log
 	}()
 }
 
-func createStompPublisher(httpConf *httpConfig, wsConfig *webSocketConfig, stompConfig *stompConfig) (*stomp.Conn, error) {
-	negotiationEndpoint, err := url.Parse(fmt.Sprintf("ws://localhost:%d%s", httpConf.Port, httpConf.withSecretPathPrefix(httpConf.WsNegotiationEndpointUrl)))
+// createStompPublisher connects in-process to the stomp server accepting on the listener, the messages it sends are
+// delivered to the subscribers just like the ones of a network client
+func createStompPublisher(wsListener *websocketListener, token string) (*stomp.Conn, error) {
+	c, err := wsListener.dialInProcess()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stomp negotiation endpoint URL: %w", err)
+		return nil, fmt.Errorf("failed to connect to the stomp server: %w", err)
 	}
-
-	c, _, err := websocket.Dial(context.Background(), negotiationEndpoint.String(), &websocket.DialOptions{
-		Subprotocols:         wsConfig.AcceptedSubProtocols,
-		CompressionMode:      0,
-		CompressionThreshold: 0,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to local websocket endpoint: %w", err)
-	}
-	c.SetReadLimit(int64(wsConfig.MaxReadLimit))
 	conn, err := stomp.Connect(
-		websocket.NetConn(context.Background(), c, websocket.MessageText),
-		stomp.ConnOpt.Login(stompConfig.Login, stompConfig.Password),
-		stomp.ConnOpt.Host(negotiationEndpoint.Host), // FIXME: this may need an adaptation
-		stomp.ConnOpt.HeartBeat(stompConfig.HeartBeat, stompConfig.HeartBeat),
+		c,
+		stomp.ConnOpt.Login("stomp-publisher", token),
+		// there is no network in between, the connection can not silently die
+		stomp.ConnOpt.HeartBeat(0, 0),
 		stomp.ConnOpt.UseStomp,
 	)
 	if err != nil {
-		_ = c.Close(websocket.StatusGoingAway, "closing websocket because STOMP connect have failed")
+		_ = c.Close()
 		return nil, fmt.Errorf("failed to start stomp publisher: %w", err)
 	}
 	return conn, nil
 }
 
+// withCors allows the configured origins to call the api, the cookies are sent along so the web ui may be served
+// from another origin. Without allowed origin, the browsers only allow same-origin calls.
+func withCors(handler http.Handler, config *corsConfig) http.Handler {
+	if len(config.AllowedOrigins) == 0 {
+		return handler
+	}
+	return cors.New(cors.Options{
+		AllowedOrigins:   config.AllowedOrigins,
+		AllowedMethods:   []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		Debug:            false,
+	}).Handler(handler)
+}
+
 func webUiStaticFilesHandler(webUiUrl string, staticFilesPath string) http.Handler {
 	return http.StripPrefix(webUiUrl, http.FileServer(http.Dir(staticFilesPath)))
 }
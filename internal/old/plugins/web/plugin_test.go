@@ -0,0 +1,199 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"nhooyr.io/websocket"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startStompStack starts the stomp server, its in-process publisher and an https server exposing the websocket endpoint
+func startStompStack(t *testing.T, authenticator *auth.Authenticator, loginLimiter *auth.RateLimiter) (*webConfig, net.Addr) {
+	conf := webConfig{}.Default()
+	conf.Http.BindAddress = "127.0.0.1"
+	conf.Http.Port = 0
+	httpTls, err := newServerTlsConfig(&tlsConfig{Enabled: true, SelfSignedHosts: []string{"127.0.0.1"}}, t.TempDir())
+	require.NoError(t, err)
+
+	wsListener, err := newWebSocketListener()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = wsListener.Close() })
+	startStompServer(conf.Stomp, authenticator, loginLimiter, wsListener, zap.NewNop())
+
+	publisherToken, err := authenticator.AddToken("stomp-publisher", auth.ScopeAdmin)
+	require.NoError(t, err)
+	publisher, err := createStompPublisher(wsListener, publisherToken)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = publisher.Disconnect() })
+	coreListener := &appStateCoreListener{state: state{}.initialState(), lock: &sync.Mutex{}, stompPublisher: publisher}
+	t.Cleanup(coreListener.subscribe().Unsubscribe)
+
+	router := mux.NewRouter()
+	router.HandleFunc(conf.Http.withSecretPathPrefix(conf.Http.WsNegotiationEndpointUrl), wsListener.HttpNegotiationHandleFunc(conf.WebSocket))
+	server, addr, err := startHttpServer(router, conf.Http, httpTls, zap.NewNop(), &types.HealthState{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	return conf, addr
+}
+
+func connectStompClient(t *testing.T, conf *webConfig, addr net.Addr, passcode string) (*stomp.Conn, error) {
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	c, _, err := websocket.Dial(context.Background(), "wss://"+addr.String()+conf.Http.withSecretPathPrefix(conf.Http.WsNegotiationEndpointUrl), &websocket.DialOptions{
+		HTTPClient:   httpClient,
+		Subprotocols: conf.WebSocket.AcceptedSubProtocols,
+	})
+	require.NoError(t, err)
+	conn, err := stomp.Connect(
+		websocket.NetConn(context.Background(), c, websocket.MessageText),
+		stomp.ConnOpt.Login("ui", passcode),
+		stomp.ConnOpt.HeartBeat(0, 0),
+	)
+	if err != nil {
+		_ = c.Close(websocket.StatusNormalClosure, "")
+		return nil, err
+	}
+	t.Cleanup(func() { _ = conn.Disconnect() })
+	return conn, nil
+}
+
+func TestStompPublisher_ShouldDeliverCoreEventsToSubscribers(t *testing.T) {
+	readToken, readHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+	authenticator, err := auth.NewAuthenticator([]*auth.TokenConfig{{Name: "ui", Scope: auth.ScopeRead, Hash: readHash}}, time.Hour)
+	require.NoError(t, err)
+	conf, addr := startStompStack(t, authenticator, auth.NewRateLimiter(5, time.Minute))
+
+	client, err := connectStompClient(t, conf, addr, readToken)
+	require.NoError(t, err)
+	subscription, err := client.Subscribe(StompMessageDestination, stomp.AckAuto)
+	require.NoError(t, err)
+
+	// the subscription is registered asynchronously by the server, the event is emitted until it is received
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			broadcast.EmitSeedStart(broadcast.SeedStartedEvent{Client: "qBittorrent", Version: "4.1.0"})
+		case msg := <-subscription.C:
+			require.NoError(t, msg.Err)
+			payload := &struct {
+				Type    stompType    `json:"type"`
+				Payload *globalState `json:"payload"`
+			}{}
+			require.NoError(t, json.Unmarshal(msg.Body, payload))
+			assert.Equal(t, SeedStartedStompType, payload.Type)
+			assert.Equal(t, &globalState{Started: true, Client: &clientState{Name: "qBittorrent", Version: "4.1.0"}}, payload.Payload)
+			return
+		case <-timeout:
+			t.Fatal("no event has been received")
+		}
+	}
+}
+
+func TestStompServer_ShouldRejectInvalidPasscode(t *testing.T) {
+	_, readHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+	authenticator, err := auth.NewAuthenticator([]*auth.TokenConfig{{Name: "ui", Scope: auth.ScopeRead, Hash: readHash}}, time.Hour)
+	require.NoError(t, err)
+	conf, addr := startStompStack(t, authenticator, auth.NewRateLimiter(5, time.Minute))
+
+	_, err = connectStompClient(t, conf, addr, "joal_nope")
+
+	assert.Error(t, err)
+}
+
+func TestStompServer_ShouldOnlyAcceptSendFromAdminScope(t *testing.T) {
+	readToken, readHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+	adminToken, adminHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+	authenticator, err := auth.NewAuthenticator([]*auth.TokenConfig{
+		{Name: "grafana", Scope: auth.ScopeRead, Hash: readHash},
+		{Name: "ui", Scope: auth.ScopeAdmin, Hash: adminHash},
+	}, time.Hour)
+	require.NoError(t, err)
+	readIdentity, _ := authenticator.VerifyToken(readToken)
+	readSession, err := authenticator.CreateSession(readIdentity)
+	require.NoError(t, err)
+	adminIdentity, _ := authenticator.VerifyToken(adminToken)
+	adminSession, err := authenticator.CreateSession(adminIdentity)
+	require.NoError(t, err)
+	conf, addr := startStompStack(t, authenticator, auth.NewRateLimiter(5, time.Minute))
+
+	tests := []struct {
+		name     string
+		passcode string
+		wantSent bool
+	}{
+		{name: "read-token", passcode: readToken, wantSent: false},
+		{name: "read-session", passcode: readSession.StompPasscode, wantSent: false},
+		{name: "admin-token", passcode: adminToken, wantSent: true},
+		{name: "admin-session", passcode: adminSession.StompPasscode, wantSent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := connectStompClient(t, conf, addr, tt.passcode)
+			require.NoError(t, err)
+
+			err = client.Send("/joal/"+tt.name, "text/plain", []byte("hello"), stomp.SendOpt.Receipt)
+
+			if tt.wantSent {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				// the client may not have noticed the ERROR yet, Disconnect would wait forever for its receipt
+				_ = client.MustDisconnect()
+			}
+		})
+	}
+}
+
+func TestStompServer_ShouldRateLimitFailedConnect(t *testing.T) {
+	readToken, readHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+	adminToken, adminHash, err := auth.GenerateToken()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		passcode string
+	}{
+		{name: "read-token", passcode: readToken},
+		{name: "admin-token", passcode: adminToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, err := auth.NewAuthenticator([]*auth.TokenConfig{
+				{Name: "grafana", Scope: auth.ScopeRead, Hash: readHash},
+				{Name: "ui", Scope: auth.ScopeAdmin, Hash: adminHash},
+			}, time.Hour)
+			require.NoError(t, err)
+			loginLimiter := auth.NewRateLimiter(2, time.Minute)
+			conf, addr := startStompStack(t, authenticator, loginLimiter)
+
+			for i := 0; i < 2; i++ {
+				_, err := connectStompClient(t, conf, addr, "joal_nope")
+				require.Error(t, err)
+			}
+			_, err = connectStompClient(t, conf, addr, tt.passcode)
+			assert.Error(t, err, "valid passcodes are blocked as well")
+			allowed, _ := loginLimiter.Allow("127.0.0.1")
+			assert.False(t, allowed, "the http api shares the limit of the stomp server")
+		})
+	}
+}
@@ -42,14 +42,17 @@ type runtimeConfigState struct {
 }
 
 type torrentState struct {
-	Infohash string                           `json:"infohash"`
-	Name     string                           `json:"name"`
-	File     string                           `json:"file"`
-	Size     int64                            `json:"size"`
-	Seeders  int32                            `json:"seeders"`
-	Leechers int32                            `json:"leechers"`
-	Uploaded int64                            `json:"uploaded"`
-	Trackers map[string]*torrentTrackersState `json:"trackers"`
+	Infohash string `json:"infohash"`
+	Name     string `json:"name"`
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	Seeders  int32  `json:"seeders"`
+	Leechers int32  `json:"leechers"`
+	Uploaded int64  `json:"uploaded"`
+	Paused   bool   `json:"paused"`
+	// PausedReason is either user or the goal reached by the torrent
+	PausedReason string                           `json:"pausedReason,omitempty"`
+	Trackers     map[string]*torrentTrackersState `json:"trackers"`
 }
 
 type torrentTrackersState struct {
@@ -70,11 +73,22 @@ type announceResultState struct {
 	Leechers      int32     `json:"leechers"`
 	Interval      int       `json:"interval"`
 	Error         string    `json:"reason,omitempty"`
+	Route         string    `json:"route,omitempty"`
 }
 
 type bandwidthState struct {
 	CurrentBandwidth int64                             `json:"currentBandwidth"`
+	SpeedProfile     string                            `json:"speedProfile,omitempty"`
 	Torrents         map[string]*torrentBandwidthState `json:"torrents"`
+	Budget           *budgetState                      `json:"budget,omitempty"`
+}
+
+type budgetState struct {
+	Limit       int64     `json:"limit"`
+	Consumed    int64     `json:"consumed"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
 }
 
 type torrentBandwidthState struct {
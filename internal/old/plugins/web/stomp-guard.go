@@ -0,0 +1,110 @@
+package web
+
+import (
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
+	"github.com/go-stomp/stomp/v3/frame"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// stompGuard filters the frames the clients send to the stomp server: the failed CONNECT are rate limited per remote
+// ip, and only the admin scoped connections may SEND. The go-stomp server can not do it by itself, its authenticator
+// neither knows the address of the client nor tells the scope of the connection.
+type stompGuard struct {
+	authenticator *auth.Authenticator
+	loginLimiter  *auth.RateLimiter
+}
+
+// stompGuardListener guards the connections it accepts
+type stompGuardListener struct {
+	net.Listener
+	guard *stompGuard
+}
+
+func (l *stompGuardListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.guard.wrap(conn), nil
+}
+
+// guardedStompConn is the connection handed to the stomp server, it reads the frames which passed the guard
+type guardedStompConn struct {
+	net.Conn
+	frames    *io.PipeReader
+	writeLock *sync.Mutex
+}
+
+func (c *guardedStompConn) Read(p []byte) (int, error) {
+	return c.frames.Read(p)
+}
+
+func (c *guardedStompConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *guardedStompConn) Close() error {
+	_ = c.frames.Close()
+	return c.Conn.Close()
+}
+
+// reject answers the client with an ERROR frame and closes the connection
+func (c *guardedStompConn) reject(message string) {
+	c.writeLock.Lock()
+	_ = frame.NewWriter(c.Conn).Write(frame.New(frame.ERROR, frame.Message, message))
+	c.writeLock.Unlock()
+	_ = c.Close()
+}
+
+func (g *stompGuard) wrap(conn net.Conn) net.Conn {
+	frames, forward := io.Pipe()
+	c := &guardedStompConn{Conn: conn, frames: frames, writeLock: &sync.Mutex{}}
+	go g.filter(c, forward)
+	return c
+}
+
+// filter forwards the frames of the client to the stomp server until the connection is closed or a frame is rejected
+func (g *stompGuard) filter(c *guardedStompConn, forward *io.PipeWriter) {
+	reader := frame.NewReader(c.Conn)
+	writer := frame.NewWriter(forward)
+	ip := c.RemoteAddr().String()
+	var scope auth.Scope
+	for {
+		f, err := reader.Read()
+		if err != nil {
+			_ = forward.CloseWithError(err)
+			return
+		}
+		if f != nil {
+			switch f.Command {
+			case frame.CONNECT, frame.STOMP:
+				if allowed, retryAfter := g.loginLimiter.Allow(ip); !allowed {
+					c.reject(fmt.Sprintf("too many failed attempts, retry in %s", retryAfter.Round(time.Second)))
+					return
+				}
+				identity, err := g.authenticator.VerifyStompPasscode(f.Header.Get(frame.Passcode))
+				if err != nil {
+					// the frame is forwarded anyway, the stomp server answers the failed CONNECT
+					g.loginLimiter.Fail(ip)
+					break
+				}
+				g.loginLimiter.Reset(ip)
+				scope = identity.Scope
+			case frame.SEND:
+				if !scope.Allows(auth.ScopeAdmin) {
+					c.reject(fmt.Sprintf("the connection has the '%s' scope, '%s' is required to send messages", scope, auth.ScopeAdmin))
+					return
+				}
+			}
+		}
+		if err := writer.Write(f); err != nil {
+			return // the stomp server has closed the connection
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	selfSignedCertFile = "self-signed-cert.pem"
+	selfSignedKeyFile  = "self-signed-key.pem"
+	// selfSignedValidity is the lifetime of the generated certificates, they are renewed on start within selfSignedRenewBefore of their expiry
+	selfSignedValidity    = 365 * 24 * time.Hour
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// newServerTlsConfig loads the configured certificate, or the self-signed one of the plugin directory
+func newServerTlsConfig(conf *tlsConfig, pluginDir string) (*tls.Config, error) {
+	var certificate tls.Certificate
+	var err error
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		certificate, err = tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+	} else {
+		certificate, err = loadOrCreateSelfSignedCertificate(pluginDir, conf.SelfSignedHosts, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.ClientCaFile != "" {
+		pemCerts, err := os.ReadFile(conf.ClientCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client ca file: %w", err)
+		}
+		clientCas := x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("client ca file '%s' holds no PEM certificate", conf.ClientCaFile)
+		}
+		config.ClientCAs = clientCas
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// loadOrCreateSelfSignedCertificate reuses the certificate persisted in dir, unless it expires soon or does not cover the hosts
+func loadOrCreateSelfSignedCertificate(dir string, hosts []string, now time.Time) (tls.Certificate, error) {
+	certPath := filepath.Join(dir, selfSignedCertFile)
+	keyPath := filepath.Join(dir, selfSignedKeyFile)
+
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err == nil && now.Add(selfSignedRenewBefore).Before(leaf.NotAfter) && sameHosts(certificateHosts(leaf), hosts) {
+			certificate.Leaf = leaf
+			return certificate, nil
+		}
+	}
+
+	generated, err := generateCertificate(pkix.Name{Organization: []string{"joal"}, CommonName: "joal self-signed"}, hosts, now, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(generated.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to marshal self-signed certificate key: %w", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: generated.Certificate[0]}), 0644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to write self-signed certificate: %w", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to write self-signed certificate key: %w", err)
+	}
+	return *generated, nil
+}
+
+// generateCertificate returns a self-signed certificate valid for the hosts, which may be dns names or ips
+func generateCertificate(subject pkix.Name, hosts []string, now time.Time, usage x509.ExtKeyUsage) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func certificateHosts(cert *x509.Certificate) []string {
+	hosts := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+func sameHosts(a []string, b []string) bool {
+	normalize := func(hosts []string) []string {
+		normalized := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			if ip := net.ParseIP(host); ip != nil {
+				host = ip.String()
+			}
+			normalized = append(normalized, host)
+		}
+		sort.Strings(normalized)
+		return normalized
+	}
+	na, nb := normalize(a), normalize(b)
+	if len(na) != len(nb) {
+		return false
+	}
+	for i := range na {
+		if na[i] != nb[i] {
+			return false
+		}
+	}
+	return true
+}
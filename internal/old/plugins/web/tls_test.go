@@ -0,0 +1,147 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateSelfSignedCertificate_ShouldPersistAndReuse(t *testing.T) {
+	dir := t.TempDir()
+	hosts := []string{"localhost", "127.0.0.1"}
+	now := time.Now()
+
+	created, err := loadOrCreateSelfSignedCertificate(dir, hosts, now)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"localhost"}, created.Leaf.DNSNames)
+	assert.Equal(t, "127.0.0.1", created.Leaf.IPAddresses[0].String())
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, created.Leaf.ExtKeyUsage)
+	info, err := os.Stat(filepath.Join(dir, selfSignedKeyFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reused, err := loadOrCreateSelfSignedCertificate(dir, []string{"127.0.0.1", "localhost"}, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, created.Certificate, reused.Certificate)
+}
+
+func TestLoadOrCreateSelfSignedCertificate_ShouldRenew(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		delay time.Duration
+	}{
+		{name: "expiring-soon", hosts: []string{"localhost"}, delay: selfSignedValidity - selfSignedRenewBefore + time.Hour},
+		{name: "hosts-changed", hosts: []string{"localhost", "joal.lan"}, delay: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			now := time.Now()
+			created, err := loadOrCreateSelfSignedCertificate(dir, []string{"localhost"}, now)
+			require.NoError(t, err)
+
+			renewed, err := loadOrCreateSelfSignedCertificate(dir, tt.hosts, now.Add(tt.delay))
+			require.NoError(t, err)
+
+			assert.NotEqual(t, created.Certificate, renewed.Certificate)
+			persisted, err := loadOrCreateSelfSignedCertificate(dir, tt.hosts, now.Add(tt.delay))
+			require.NoError(t, err)
+			assert.Equal(t, renewed.Certificate, persisted.Certificate)
+		})
+	}
+}
+
+func writeCertificate(t *testing.T, dir string, cert *tls.Certificate) (string, string) {
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+	return certPath, keyPath
+}
+
+func TestNewServerTlsConfig_ShouldLoadConfiguredCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cert, err := generateCertificate(pkix.Name{CommonName: "joal.example.org"}, []string{"joal.example.org"}, time.Now(), x509.ExtKeyUsageServerAuth)
+	require.NoError(t, err)
+	certPath, keyPath := writeCertificate(t, dir, cert)
+
+	config, err := newServerTlsConfig(&tlsConfig{Enabled: true, CertFile: certPath, KeyFile: keyPath}, dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, cert.Certificate, config.Certificates[0].Certificate)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	assert.NoFileExists(t, filepath.Join(dir, selfSignedCertFile))
+
+	_, err = newServerTlsConfig(&tlsConfig{Enabled: true, CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyPath}, dir)
+	assert.Error(t, err)
+	_, err = newServerTlsConfig(&tlsConfig{Enabled: true, ClientCaFile: keyPath}, dir)
+	assert.Error(t, err, "the client ca file holds no certificate")
+}
+
+func TestTlsConfig_ShouldRequireCertAndKeyTogether(t *testing.T) {
+	assert.NoError(t, (&tlsConfig{}).validate())
+	assert.NoError(t, (&tlsConfig{CertFile: "cert.pem", KeyFile: "key.pem"}).validate())
+	assert.Error(t, (&tlsConfig{CertFile: "cert.pem"}).validate())
+	assert.Error(t, (&tlsConfig{KeyFile: "key.pem"}).validate())
+}
+
+func TestHttpServer_ShouldRequireClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, err := generateCertificate(pkix.Name{CommonName: "trusted client"}, nil, time.Now(), x509.ExtKeyUsageClientAuth)
+	require.NoError(t, err)
+	otherCert, err := generateCertificate(pkix.Name{CommonName: "unknown client"}, nil, time.Now(), x509.ExtKeyUsageClientAuth)
+	require.NoError(t, err)
+	caPath, _ := writeCertificate(t, dir, clientCert)
+	config, err := newServerTlsConfig(&tlsConfig{Enabled: true, SelfSignedHosts: []string{"127.0.0.1"}, ClientCaFile: caPath}, dir)
+	require.NoError(t, err)
+	httpConf := httpConfig{}.Default()
+	httpConf.BindAddress = "127.0.0.1"
+	httpConf.Port = 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	server, addr, err := startHttpServer(handler, httpConf, config, zap.NewNop(), &types.HealthState{})
+	require.NoError(t, err)
+	defer func() { _ = server.Close() }()
+
+	tests := []struct {
+		name    string
+		cert    *tls.Certificate
+		wantErr bool
+	}{
+		{name: "no-certificate", cert: nil, wantErr: true},
+		{name: "unknown-certificate", cert: otherCert, wantErr: true},
+		{name: "trusted-certificate", cert: clientCert, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientTls := &tls.Config{InsecureSkipVerify: true}
+			if tt.cert != nil {
+				clientTls.Certificates = []tls.Certificate{*tt.cert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTls}}
+
+			res, err := client.Get("https://" + addr.String() + "/")
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_ = res.Body.Close()
+			assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		})
+	}
+}
@@ -57,7 +57,7 @@ func (w *websocketListener) HttpNegotiationHandleFunc(conf *webSocketConfig) fun
 		client.SetReadLimit(int64(conf.MaxReadLimit))
 
 		select {
-		case w.connChan <- websocket.NetConn(context.Background(), client, websocket.MessageText):
+		case w.connChan <- &websocketConn{Conn: websocket.NetConn(context.Background(), client, websocket.MessageText), remoteIp: remoteIp(request)}:
 			return
 		case <-time.After(5 * time.Second):
 			log.Warn("Websocket connection upgraded successfully but the stomp server has not claimed it before timeout")
@@ -65,6 +65,27 @@ func (w *websocketListener) HttpNegotiationHandleFunc(conf *webSocketConfig) fun
 	}
 }
 
+// dialInProcess returns a connection to the server accepting on this listener, the frames are exchanged in memory
+// so they do not depend on the http server, its tls or its bind address
+func (w *websocketListener) dialInProcess() (net.Conn, error) {
+	w.lock.RLock()
+	if w.closed {
+		w.lock.RUnlock()
+		return nil, fmt.Errorf("listener is closed")
+	}
+	w.lock.RUnlock()
+
+	client, server := net.Pipe()
+	select {
+	case w.connChan <- server:
+		return client, nil
+	case <-time.After(5 * time.Second):
+		_ = client.Close()
+		_ = server.Close()
+		return nil, fmt.Errorf("the stomp server has not claimed the in-process connection before timeout")
+	}
+}
+
 func (w *websocketListener) Accept() (net.Conn, error) {
 	w.lock.RLock()
 	if w.closed {
@@ -97,7 +118,19 @@ func (w *websocketListener) Addr() net.Addr {
 	return websocketAddr{}
 }
 
+// websocketConn remembers the ip of the client the websocket has been negotiated with, the connection of the library
+// does not know it
+type websocketConn struct {
+	net.Conn
+	remoteIp string
+}
+
+func (c *websocketConn) RemoteAddr() net.Addr {
+	return websocketAddr{ip: c.remoteIp}
+}
+
 type websocketAddr struct {
+	ip string
 }
 
 func (a websocketAddr) Network() string {
@@ -105,5 +138,8 @@ func (a websocketAddr) Network() string {
 }
 
 func (a websocketAddr) String() string {
+	if a.ip != "" {
+		return a.ip
+	}
 	return "websocket/unknown-addr"
 }
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"fmt"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// Names of the events a webhook can be notified of
+const (
+	AnnounceFailureStreak = "announceFailureStreak"
+	TrackerDisabled       = "trackerDisabled"
+	GoalReached           = "goalReached"
+	SeedStopped           = "seedStopped"
+	NoticeableError       = "noticeableError"
+)
+
+var knownEvents = []string{AnnounceFailureStreak, TrackerDisabled, GoalReached, SeedStopped, NoticeableError}
+
+type webhooksConfig struct {
+	Webhooks []*webhookConfig `yaml:"webhooks"`
+	// FailureStreak is the number of consecutive failed announces to a tracker that triggers an announceFailureStreak
+	FailureStreak int `yaml:"failureStreak"`
+}
+
+// Return a new webhooksConfig with the default values filled in
+func (c webhooksConfig) Default() *webhooksConfig {
+	return &webhooksConfig{
+		Webhooks:      []*webhookConfig{},
+		FailureStreak: 5,
+	}
+}
+
+func (c *webhooksConfig) validate() error {
+	for _, w := range c.Webhooks {
+		if err := w.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type webhookConfig struct {
+	Name string `yaml:"name"`
+	Url  string `yaml:"url"`
+	// Events are the events sent to the webhook, all of them if empty
+	Events []string `yaml:"events"`
+	// Template is a text/template rendering the body from a notification, the notification is sent as json if empty
+	Template    string `yaml:"template"`
+	ContentType string `yaml:"contentType"`
+	// Secret signs the body with HMAC-SHA256, the signature is sent in the X-Joal-Signature header
+	Secret  string        `yaml:"secret"`
+	Timeout time.Duration `yaml:"timeout"`
+	Retry   *retryConfig  `yaml:"retry"`
+	// RateLimit applies to each event separately
+	RateLimit *rateLimitConfig `yaml:"rateLimit"`
+}
+
+type retryConfig struct {
+	MaxAttempts int `yaml:"maxAttempts"`
+	// Backoff is the delay before the first retry, it doubles on each retry up to MaxBackoff
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// Return a new retryConfig with the default values filled in
+func (c retryConfig) Default() *retryConfig {
+	return &retryConfig{
+		MaxAttempts: 4,
+		Backoff:     2 * time.Second,
+		MaxBackoff:  1 * time.Minute,
+	}
+}
+
+// rateLimitConfig allows Burst notifications at once, then one per Interval
+type rateLimitConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Burst    int           `yaml:"burst"`
+}
+
+// Return a new rateLimitConfig with the default values filled in
+func (c rateLimitConfig) Default() *rateLimitConfig {
+	return &rateLimitConfig{
+		Interval: 1 * time.Minute,
+		Burst:    5,
+	}
+}
+
+// validate checks the webhook and fills in the default values of the missing fields
+func (c *webhookConfig) validate() error {
+	u, err := url.Parse(c.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("webhook '%s' url must be an absolute http(s) url", c.Name)
+	}
+	for _, e := range c.Events {
+		known := false
+		for _, k := range knownEvents {
+			known = known || e == k
+		}
+		if !known {
+			return fmt.Errorf("webhook '%s' has an unknown event '%s', expected one of %v", c.Name, e, knownEvents)
+		}
+	}
+	if c.Template != "" {
+		if _, err := parseTemplate(c.Name, c.Template); err != nil {
+			return fmt.Errorf("webhook '%s' has an invalid template: %w", c.Name, err)
+		}
+	}
+	if c.ContentType == "" {
+		c.ContentType = "application/json"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.Retry == nil {
+		c.Retry = retryConfig{}.Default()
+	}
+	if c.Retry.MaxAttempts < 1 {
+		c.Retry.MaxAttempts = 1
+	}
+	if c.RateLimit == nil {
+		c.RateLimit = rateLimitConfig{}.Default()
+	}
+	return nil
+}
+
+func (c *webhookConfig) accepts(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
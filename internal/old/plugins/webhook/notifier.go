@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"time"
+)
+
+// notification is the data sent to the webhooks, as json or to their template
+type notification struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+	Infohash string    `json:"infohash,omitempty"`
+	Torrent  string    `json:"torrent,omitempty"`
+	Tracker  string    `json:"tracker,omitempty"`
+	// Details holds the fields specific to the event
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type streakKey struct {
+	infohash torrent.InfoHash
+	tracker  string
+}
+
+// notifier turns the events of the broadcast bus into notifications. It is only used by the goroutine of its
+// subscription.
+type notifier struct {
+	failureStreak int
+	names         map[torrent.InfoHash]string
+	failures      map[streakKey]int
+}
+
+func newNotifier(failureStreak int) *notifier {
+	if failureStreak < 1 {
+		failureStreak = 1
+	}
+	return &notifier{
+		failureStreak: failureStreak,
+		names:         make(map[torrent.InfoHash]string),
+		failures:      make(map[streakKey]int),
+	}
+}
+
+// notificationOf returns the notification for the entry, false if the entry does not call for one
+func (n *notifier) notificationOf(entry broadcast.Entry) (notification, bool) {
+	switch event := entry.Event.(type) {
+	case broadcast.TorrentAddedEvent:
+		n.names[event.Infohash] = event.Name
+	case broadcast.TorrentRemovedEvent:
+		delete(n.names, event.Infohash)
+		for key := range n.failures {
+			if key.infohash == event.Infohash {
+				delete(n.failures, key)
+			}
+		}
+	case broadcast.TorrentAnnounceSuccessEvent:
+		delete(n.failures, streakKey{event.Infohash, event.TrackerUrl.String()})
+	case broadcast.TorrentAnnounceFailedEvent:
+		key := streakKey{event.Infohash, event.TrackerUrl.String()}
+		n.failures[key]++
+		// notify once per streak, when it reaches the threshold
+		if n.failures[key] != n.failureStreak {
+			return notification{}, false
+		}
+		return notification{
+			Event:    AnnounceFailureStreak,
+			Time:     entry.Time,
+			Message:  fmt.Sprintf("%d consecutive announces of %s to %s have failed: %s", n.failureStreak, n.torrentName(event.Infohash), event.TrackerUrl.Host, event.Error),
+			Infohash: event.Infohash.HexString(),
+			Torrent:  n.names[event.Infohash],
+			Tracker:  event.TrackerUrl.String(),
+			Details:  map[string]interface{}{"failures": n.failureStreak, "error": event.Error},
+		}, true
+	case broadcast.TorrentTrackerDisabledEvent:
+		return notification{
+			Event:    TrackerDisabled,
+			Time:     entry.Time,
+			Message:  fmt.Sprintf("tracker %s of %s is disabled: %s", event.TrackerUrl.Host, n.torrentName(event.Infohash), event.Reason),
+			Infohash: event.Infohash.HexString(),
+			Torrent:  n.names[event.Infohash],
+			Tracker:  event.TrackerUrl.String(),
+			Details:  map[string]interface{}{"reason": event.Reason},
+		}, true
+	case broadcast.TorrentGoalReachedEvent:
+		return notification{
+			Event:    GoalReached,
+			Time:     entry.Time,
+			Message:  fmt.Sprintf("%s has reached its %s goal, it will %s", n.torrentName(event.Infohash), event.Goal, event.Action),
+			Infohash: event.Infohash.HexString(),
+			Torrent:  n.names[event.Infohash],
+			Details: map[string]interface{}{
+				"goal":        event.Goal,
+				"action":      event.Action,
+				"uploaded":    event.Uploaded,
+				"seedingTime": int64(event.SeedingTime.Seconds()),
+			},
+		}, true
+	case broadcast.SeedStoppedEvent:
+		n.failures = make(map[streakKey]int)
+		return notification{
+			Event:   SeedStopped,
+			Time:    entry.Time,
+			Message: "seeding has stopped",
+		}, true
+	case broadcast.NoticeableErrorEvent:
+		message := "unknown error"
+		if event.Error != nil {
+			message = event.Error.Error()
+		}
+		return notification{
+			Event:   NoticeableError,
+			Time:    entry.Time,
+			Message: message,
+		}, true
+	}
+	return notification{}, false
+}
+
+func (n *notifier) torrentName(infohash torrent.InfoHash) string {
+	if name, ok := n.names[infohash]; ok && name != "" {
+		return name
+	}
+	return infohash.HexString()
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"errors"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNotifier_ShouldNotifyOncePerFailureStreak(t *testing.T) {
+	ih := torrent.InfoHash{1}
+	first, _ := url.Parse("http://first.example.org/announce")
+	second, _ := url.Parse("http://second.example.org/announce")
+	failed := func(u *url.URL) broadcast.TorrentAnnounceFailedEvent {
+		return broadcast.TorrentAnnounceFailedEvent{Infohash: ih, TrackerUrl: *u, Error: "timeout"}
+	}
+	succeed := func(u *url.URL) broadcast.TorrentAnnounceSuccessEvent {
+		return broadcast.TorrentAnnounceSuccessEvent{Infohash: ih, TrackerUrl: *u}
+	}
+
+	tests := []struct {
+		name   string
+		events []any
+		want   int
+	}{
+		{name: "below-threshold", events: []any{failed(first), failed(first)}, want: 0},
+		{name: "threshold", events: []any{failed(first), failed(first), failed(first)}, want: 1},
+		{name: "once-per-streak", events: []any{failed(first), failed(first), failed(first), failed(first), failed(first)}, want: 1},
+		{name: "reset-by-success", events: []any{failed(first), failed(first), succeed(first), failed(first), failed(first)}, want: 0},
+		{name: "new-streak-after-success", events: []any{failed(first), failed(first), failed(first), succeed(first), failed(first), failed(first), failed(first)}, want: 2},
+		{name: "per-tracker", events: []any{failed(first), failed(second), failed(first), failed(second), failed(first)}, want: 1},
+		{name: "reset-by-removal", events: []any{failed(first), failed(first), broadcast.TorrentRemovedEvent{Infohash: ih}, failed(first)}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNotifier(3)
+			count := 0
+			for _, event := range tt.events {
+				if notif, ok := n.notificationOf(broadcast.Entry{Event: event}); ok {
+					assert.Equal(t, AnnounceFailureStreak, notif.Event)
+					count++
+				}
+			}
+			assert.Equal(t, tt.want, count)
+		})
+	}
+}
+
+func TestNotifier_ShouldDescribeEvents(t *testing.T) {
+	ih := torrent.InfoHash{1}
+	trackerUrl, _ := url.Parse("udp://tracker.example.org:6969")
+	now := time.Now()
+	n := newNotifier(1)
+	_, ok := n.notificationOf(broadcast.Entry{Event: broadcast.TorrentAddedEvent{Infohash: ih, Name: "ubuntu.iso"}})
+	assert.False(t, ok)
+
+	notif, ok := n.notificationOf(broadcast.Entry{Time: now, Event: broadcast.TorrentTrackerDisabledEvent{Infohash: ih, TrackerUrl: *trackerUrl, Reason: "no udp"}})
+	assert.True(t, ok)
+	assert.Equal(t, notification{
+		Event:    TrackerDisabled,
+		Time:     now,
+		Message:  "tracker tracker.example.org:6969 of ubuntu.iso is disabled: no udp",
+		Infohash: ih.HexString(),
+		Torrent:  "ubuntu.iso",
+		Tracker:  "udp://tracker.example.org:6969",
+		Details:  map[string]interface{}{"reason": "no udp"},
+	}, notif)
+
+	notif, ok = n.notificationOf(broadcast.Entry{Event: broadcast.TorrentGoalReachedEvent{Infohash: ih, Goal: "ratio", Action: "pause"}})
+	assert.True(t, ok)
+	assert.Equal(t, GoalReached, notif.Event)
+	assert.Equal(t, "ubuntu.iso has reached its ratio goal, it will pause", notif.Message)
+
+	notif, ok = n.notificationOf(broadcast.Entry{Event: broadcast.NoticeableErrorEvent{Error: errors.New("disk full")}})
+	assert.True(t, ok)
+	assert.Equal(t, NoticeableError, notif.Event)
+	assert.Equal(t, "disk full", notif.Message)
+
+	notif, ok = n.notificationOf(broadcast.Entry{Event: broadcast.SeedStoppedEvent{}})
+	assert.True(t, ok)
+	assert.Equal(t, SeedStopped, notif.Event)
+
+	_, ok = n.notificationOf(broadcast.Entry{Event: broadcast.SeedStartedEvent{}})
+	assert.False(t, ok)
+}
@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type plugin struct {
+	config      *webhooksConfig
+	log         *zap.Logger
+	client      *http.Client
+	senders     []*sender
+	unsubscribe func()
+	health      *types.HealthState
+	// lock guards senders, which Health reads while the plugin may be reloading
+	lock *sync.Mutex
+}
+
+func init() {
+	types.RegisterPlugin(types.PluginFactory{
+		Name:             "webhook",
+		Order:            20,
+		EnabledByDefault: true,
+		LegacyFlags:      map[string]bool{"--no-webhooks": false},
+		New:              newPlugin,
+	})
+}
+
+func (p *plugin) Name() string {
+	return "webhook"
+}
+
+func newPlugin(ctx types.PluginContext, config *types.PluginConfig) (types.IJoalPlugin, error) {
+	conf, err := decodeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &plugin{
+		config: conf,
+		log:    ctx.Logger,
+		client: ctx.HttpClient,
+		health: &types.HealthState{},
+		lock:   &sync.Mutex{},
+	}, nil
+}
+
+func decodeConfig(config *types.PluginConfig) (*webhooksConfig, error) {
+	conf := webhooksConfig{}.Default()
+	if err := config.Decode(conf); err != nil {
+		return nil, err
+	}
+	if err := conf.validate(); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %w", err)
+	}
+	return conf, nil
+}
+
+func (p *plugin) Start() error {
+	conf := p.config
+	p.health.Set(types.Healthy, "")
+	if len(conf.Webhooks) == 0 {
+		p.log.Debug("no webhook configured")
+		return nil
+	}
+
+	var senders []*sender
+	for _, w := range conf.Webhooks {
+		s, err := newSender(w, p.client, p.log)
+		if err != nil {
+			return err
+		}
+		senders = append(senders, s)
+	}
+	for _, s := range senders {
+		s.start()
+	}
+	p.lock.Lock()
+	p.senders = senders
+	p.lock.Unlock()
+
+	n := newNotifier(conf.FailureStreak)
+	p.unsubscribe = broadcast.Subscribe(func(entry broadcast.Entry) {
+		notification, ok := n.notificationOf(entry)
+		if !ok {
+			return
+		}
+		for _, s := range senders {
+			s.notify(notification)
+		}
+	},
+		broadcast.WithName("webhooks"),
+		broadcast.WithQueueSize(1024),
+		broadcast.WithEventTypes(
+			broadcast.TorrentAddedEvent{},
+			broadcast.TorrentRemovedEvent{},
+			broadcast.TorrentAnnounceSuccessEvent{},
+			broadcast.TorrentAnnounceFailedEvent{},
+			broadcast.TorrentTrackerDisabledEvent{},
+			broadcast.TorrentGoalReachedEvent{},
+			broadcast.SeedStoppedEvent{},
+			broadcast.NoticeableErrorEvent{},
+		),
+	).Unsubscribe
+	p.log.Info("webhooks are enabled", zap.Int("count", len(senders)))
+	return nil
+}
+
+// Health is degraded while the last delivery of a webhook has failed
+func (p *plugin) Health() types.Health {
+	health := p.health.Get()
+	if health.Status != types.Healthy {
+		return health
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var failures []string
+	for _, s := range p.senders {
+		if err := s.failure(); err != "" {
+			failures = append(failures, fmt.Sprintf("webhook '%s': %s", s.conf.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return types.Health{Status: types.Degraded, Message: strings.Join(failures, "; ")}
+	}
+	return health
+}
+
+// Reload replaces the webhooks, the pending notifications and the failure streaks are forgotten
+func (p *plugin) Reload(config *types.PluginConfig) error {
+	conf, err := decodeConfig(config)
+	if err != nil {
+		return err
+	}
+	shutdown(p, nil)
+	p.config = conf
+	return p.Start()
+}
+
+func (p *plugin) Shutdown(ctx context.Context) {
+	p.log.Info("Shutting down plugin")
+	shutdown(p, ctx)
+}
+
+// a nil safe version of Shutdown()
+func shutdown(p *plugin, ctx context.Context) {
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+	}
+
+	if p.unsubscribe != nil {
+		p.unsubscribe()
+		p.unsubscribe = nil
+	}
+	p.lock.Lock()
+	senders := p.senders
+	p.senders = nil
+	p.lock.Unlock()
+	wg := &sync.WaitGroup{}
+	for _, s := range senders {
+		wg.Add(1)
+		go func(s *sender) {
+			defer wg.Done()
+			s.stop(ctx)
+		}(s)
+	}
+	wg.Wait()
+	p.health.Set(types.Unhealthy, "stopped")
+}
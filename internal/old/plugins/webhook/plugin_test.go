@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/anthonyraymond/joal-cli/internal/old/core/broadcast"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func contextWithTimeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func pluginConfig(t *testing.T, yml string) *types.PluginConfig {
+	conf := &types.PluginConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte(yml), conf))
+	return conf
+}
+
+func createPlugin(t *testing.T, config *types.PluginConfig) types.IJoalPlugin {
+	p, err := newPlugin(types.PluginContext{
+		Logger:     zap.NewNop(),
+		ConfigDir:  t.TempDir(),
+		HttpClient: http.DefaultClient,
+	}, config)
+	require.NoError(t, err)
+	return p
+}
+
+func TestPlugin_ShouldNotifyBusEvents(t *testing.T) {
+	r := newReceiver()
+	defer r.server.Close()
+	p := createPlugin(t, pluginConfig(t, fmt.Sprintf(`
+config:
+  webhooks:
+    - name: test
+      url: %s
+      events: [goalReached]
+      template: '{"text": {{ json .Message }}}'
+`, r.server.URL)))
+
+	require.NoError(t, p.Start())
+	defer p.Shutdown(contextWithTimeout(t))
+
+	ih := torrent.InfoHash{7}
+	broadcast.EmitTorrentAdded(broadcast.TorrentAddedEvent{Infohash: ih, Name: "debian.iso"})
+	broadcast.EmitSeedStop(broadcast.SeedStoppedEvent{})
+	broadcast.EmitTorrentGoalReached(broadcast.TorrentGoalReachedEvent{Infohash: ih, Goal: "ratio", Action: "archive"})
+
+	body := string(r.receive(t).body)
+	assert.True(t, strings.HasPrefix(body, `{"text": "debian.iso has reached its ratio goal`), body)
+	r.assertNoMoreRequest(t)
+	assert.Equal(t, types.Healthy, p.Health().Status)
+}
+
+func TestPlugin_ShouldReportFailedDeliveriesAsDegraded(t *testing.T) {
+	r := newReceiver(http.StatusBadRequest)
+	defer r.server.Close()
+	p := createPlugin(t, pluginConfig(t, fmt.Sprintf(`
+config:
+  webhooks:
+    - name: test
+      url: %s
+      events: [noticeableError]
+`, r.server.URL)))
+
+	require.NoError(t, p.Start())
+	defer p.Shutdown(contextWithTimeout(t))
+	broadcast.EmitNoticeableError(broadcast.NoticeableErrorEvent{})
+
+	r.receive(t)
+	assert.Eventually(t, func() bool {
+		return p.Health().Status == types.Degraded
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, p.Health().Message, "status 400")
+}
+
+func TestPlugin_ShouldReloadWebhooks(t *testing.T) {
+	first := newReceiver()
+	defer first.server.Close()
+	second := newReceiver()
+	defer second.server.Close()
+	webhookTo := func(u string) *types.PluginConfig {
+		return pluginConfig(t, fmt.Sprintf("config:\n  webhooks:\n    - name: test\n      url: %s\n      events: [goalReached]\n", u))
+	}
+	p := createPlugin(t, webhookTo(first.server.URL))
+	require.NoError(t, p.Start())
+	defer p.Shutdown(contextWithTimeout(t))
+
+	require.NoError(t, p.Reload(webhookTo(second.server.URL)))
+	broadcast.EmitTorrentGoalReached(broadcast.TorrentGoalReachedEvent{Goal: "ratio"})
+
+	second.receive(t)
+	first.assertNoMoreRequest(t)
+}
+
+func TestPlugin_ShouldRejectInvalidConfig(t *testing.T) {
+	_, err := newPlugin(types.PluginContext{Logger: zap.NewNop()}, pluginConfig(t, "config:\n  webhooks:\n    - url: ftp://example.org\n"))
+	assert.Error(t, err)
+}
+
+func TestPlugin_ShouldStartWithoutWebhooks(t *testing.T) {
+	p := createPlugin(t, nil)
+
+	assert.NoError(t, p.Start())
+	p.Shutdown(contextWithTimeout(t))
+}
@@ -0,0 +1,210 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const (
+	signatureHeader = "X-Joal-Signature"
+	eventHeader     = "X-Joal-Event"
+	senderQueueSize = 64
+)
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value, to embed strings in json templates safely
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// sender delivers the notifications to a webhook one at a time, retrying with an exponential backoff
+type sender struct {
+	conf     *webhookConfig
+	template *template.Template
+	client   *http.Client
+	log      *zap.Logger
+	// limiters are only used by the caller of notify
+	limiters map[string]*rate.Limiter
+	queue    chan notification
+	// lastError is the error of the last delivery, empty if it succeeded
+	lastError atomic.Value
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func newSender(conf *webhookConfig, client *http.Client, log *zap.Logger) (*sender, error) {
+	s := &sender{
+		conf:     conf,
+		client:   client,
+		log:      log.With(zap.String("webhook", conf.Name)),
+		limiters: make(map[string]*rate.Limiter),
+		queue:    make(chan notification, senderQueueSize),
+		done:     make(chan struct{}),
+	}
+	if conf.Template != "" {
+		t, err := parseTemplate(conf.Name, conf.Template)
+		if err != nil {
+			return nil, fmt.Errorf("webhook '%s' has an invalid template: %w", conf.Name, err)
+		}
+		s.template = t
+	}
+	return s, nil
+}
+
+func (s *sender) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		defer close(s.done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-s.queue:
+				if err := s.deliver(ctx, n); err != nil {
+					s.lastError.Store(err.Error())
+					s.log.Warn("webhook: failed to deliver notification", zap.String("event", n.Event), zap.Error(err))
+					continue
+				}
+				s.lastError.Store("")
+			}
+		}
+	}()
+}
+
+// failure returns the error of the last delivery, empty if it succeeded
+func (s *sender) failure() string {
+	err, _ := s.lastError.Load().(string)
+	return err
+}
+
+// stop abandons the pending notifications and waits for the delivery in progress to give up
+func (s *sender) stop(ctx context.Context) {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+// notify queues the notification if the webhook accepts its event and the rate limit of the event allows it
+func (s *sender) notify(n notification) {
+	if !s.conf.accepts(n.Event) {
+		return
+	}
+	limiter, ok := s.limiters[n.Event]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(s.conf.RateLimit.Interval), s.conf.RateLimit.Burst)
+		s.limiters[n.Event] = limiter
+	}
+	log := s.log.With(zap.String("event", n.Event))
+	if !limiter.Allow() {
+		log.Debug("webhook: notification dropped by the rate limit")
+		return
+	}
+	select {
+	case s.queue <- n:
+	default:
+		log.Warn("webhook: too many pending notifications, notification dropped")
+	}
+}
+
+func (s *sender) deliver(ctx context.Context, n notification) error {
+	body, err := s.render(n)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.conf.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, n.Event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.conf.Retry.MaxAttempts {
+			return fmt.Errorf("gave up after %d attempt(s): %w", attempt, err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+		if s.conf.Retry.MaxBackoff > 0 && backoff > s.conf.Retry.MaxBackoff {
+			backoff = s.conf.Retry.MaxBackoff
+		}
+	}
+}
+
+func (s *sender) render(n notification) ([]byte, error) {
+	if s.template == nil {
+		body, err := json.Marshal(n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal notification: %w", err)
+		}
+		return body, nil
+	}
+	buf := &bytes.Buffer{}
+	if err := s.template.Execute(buf, n); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends the body once, and tells if a failure is worth retrying
+func (s *sender) post(ctx context.Context, event string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.conf.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", s.conf.ContentType)
+	req.Header.Set(eventHeader, event)
+	if s.conf.Secret != "" {
+		req.Header.Set(signatureHeader, "sha256="+sign(s.conf.Secret, body))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post to webhook: %w", err)
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the body
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
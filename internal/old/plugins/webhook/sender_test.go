@@ -0,0 +1,209 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook answering with the given status codes in order, then 204
+type receiver struct {
+	server   *httptest.Server
+	statuses []int
+	requests chan receivedRequest
+	lock     *sync.Mutex
+}
+
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{
+		statuses: statuses,
+		requests: make(chan receivedRequest, 20),
+		lock:     &sync.Mutex{},
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.requests <- receivedRequest{header: req.Header, body: body}
+		r.lock.Lock()
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status = r.statuses[0]
+			r.statuses = r.statuses[1:]
+		}
+		r.lock.Unlock()
+		w.WriteHeader(status)
+	}))
+	return r
+}
+
+func (r *receiver) receive(t *testing.T) receivedRequest {
+	select {
+	case req := <-r.requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request received")
+		return receivedRequest{}
+	}
+}
+
+func (r *receiver) assertNoMoreRequest(t *testing.T) {
+	select {
+	case <-r.requests:
+		t.Fatal("unexpected request")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func startSender(t *testing.T, conf *webhookConfig) *sender {
+	require.NoError(t, conf.validate())
+	s, err := newSender(conf, http.DefaultClient, zap.NewNop())
+	require.NoError(t, err)
+	s.start()
+	t.Cleanup(func() { s.stop(contextWithTimeout(t)) })
+	return s
+}
+
+func TestSender_ShouldPostSignedJson(t *testing.T) {
+	r := newReceiver()
+	defer r.server.Close()
+	s := startSender(t, &webhookConfig{Name: "test", Url: r.server.URL, Secret: "s3cr3t"})
+
+	s.notify(notification{Event: GoalReached, Message: "done", Infohash: "abc"})
+
+	req := r.receive(t)
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, GoalReached, req.header.Get(eventHeader))
+	assert.Equal(t, "sha256="+sign("s3cr3t", req.body), req.header.Get(signatureHeader))
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(req.body, &got))
+	assert.Equal(t, GoalReached, got["event"])
+	assert.Equal(t, "done", got["message"])
+	assert.Equal(t, "abc", got["infohash"])
+}
+
+func TestSender_ShouldRenderTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "slack", template: `{"text": {{ json .Message }}}`, want: `{"text": "a \"quoted\" message"}`},
+		{name: "discord", template: `{"content": {{ json .Message }}, "username": "joal"}`, want: `{"content": "a \"quoted\" message", "username": "joal"}`},
+		{name: "gotify", template: `{"title": {{ json .Event }}, "message": {{ json .Message }}, "priority": 5}`, want: `{"title": "noticeableError", "message": "a \"quoted\" message", "priority": 5}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver()
+			defer r.server.Close()
+			s := startSender(t, &webhookConfig{Name: tt.name, Url: r.server.URL, Template: tt.template})
+
+			s.notify(notification{Event: NoticeableError, Message: `a "quoted" message`})
+
+			assert.Equal(t, tt.want, string(r.receive(t).body))
+		})
+	}
+}
+
+func TestSender_ShouldRetryWithBackoff(t *testing.T) {
+	r := newReceiver(http.StatusInternalServerError, http.StatusTooManyRequests)
+	defer r.server.Close()
+	s := startSender(t, &webhookConfig{Name: "test", Url: r.server.URL, Retry: &retryConfig{MaxAttempts: 3, Backoff: 50 * time.Millisecond}})
+
+	start := time.Now()
+	s.notify(notification{Event: SeedStopped})
+
+	r.receive(t)
+	r.receive(t)
+	r.receive(t)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	r.assertNoMoreRequest(t)
+}
+
+func TestSender_ShouldGiveUpAfterMaxAttempts(t *testing.T) {
+	r := newReceiver(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	defer r.server.Close()
+	s := startSender(t, &webhookConfig{Name: "test", Url: r.server.URL, Retry: &retryConfig{MaxAttempts: 2, Backoff: time.Millisecond}})
+
+	s.notify(notification{Event: SeedStopped})
+
+	r.receive(t)
+	r.receive(t)
+	r.assertNoMoreRequest(t)
+}
+
+func TestSender_ShouldNotRetryClientErrors(t *testing.T) {
+	r := newReceiver(http.StatusBadRequest)
+	defer r.server.Close()
+	s := startSender(t, &webhookConfig{Name: "test", Url: r.server.URL, Retry: &retryConfig{MaxAttempts: 3, Backoff: time.Millisecond}})
+
+	s.notify(notification{Event: SeedStopped})
+
+	r.receive(t)
+	r.assertNoMoreRequest(t)
+}
+
+func TestSender_ShouldRateLimitEachEvent(t *testing.T) {
+	r := newReceiver()
+	defer r.server.Close()
+	s := startSender(t, &webhookConfig{Name: "test", Url: r.server.URL, RateLimit: &rateLimitConfig{Interval: time.Hour, Burst: 2}})
+
+	for i := 0; i < 5; i++ {
+		s.notify(notification{Event: NoticeableError})
+	}
+	s.notify(notification{Event: SeedStopped})
+
+	events := map[string]int{}
+	for i := 0; i < 3; i++ {
+		events[r.receive(t).header.Get(eventHeader)]++
+	}
+	r.assertNoMoreRequest(t)
+	assert.Equal(t, map[string]int{NoticeableError: 2, SeedStopped: 1}, events)
+}
+
+func TestSender_ShouldOnlySendSelectedEvents(t *testing.T) {
+	r := newReceiver()
+	defer r.server.Close()
+	s := startSender(t, &webhookConfig{Name: "test", Url: r.server.URL, Events: []string{GoalReached}})
+
+	s.notify(notification{Event: SeedStopped})
+	s.notify(notification{Event: GoalReached})
+
+	assert.Equal(t, GoalReached, r.receive(t).header.Get(eventHeader))
+	r.assertNoMoreRequest(t)
+}
+
+func TestWebhookConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    webhookConfig
+		wantErr bool
+	}{
+		{name: "valid", conf: webhookConfig{Url: "https://example.org/hook", Events: []string{GoalReached}}},
+		{name: "relative-url", conf: webhookConfig{Url: "/hook"}, wantErr: true},
+		{name: "unknown-event", conf: webhookConfig{Url: "https://example.org/hook", Events: []string{"torrentAdded"}}, wantErr: true},
+		{name: "invalid-template", conf: webhookConfig{Url: "https://example.org/hook", Template: "{{ .Message "}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.conf.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, tt.conf.Retry)
+			assert.NotNil(t, tt.conf.RateLimit)
+		})
+	}
+}
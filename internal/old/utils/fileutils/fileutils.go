@@ -3,6 +3,7 @@ package fileutils
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 func FileExistsStrict(path string) bool {
@@ -60,3 +61,24 @@ func DirExists(path string) (bool, error) {
 	}
 	return true, nil
 }
+
+// WriteFileAtomic writes the content aside and renames it over path, a crash while writing does not lose the previous file
+func WriteFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to replace file '%s': %w", path, err)
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package randutils
 import (
 	cryptorand "crypto/rand"
 	"encoding/binary"
+	"fmt"
+	"math"
 	mrand "math/rand"
 )
 
@@ -34,3 +36,26 @@ func RangeUint32(minInclusive uint32, maxInclusive uint32) uint32 {
 
 	return uint32(Range(int64(minInclusive), int64(maxInclusive)))
 }
+
+// Return a random number between min and max, both included. It panics if min is greater than max
+func RangeInclusive(minInclusive int64, maxInclusive int64) int64 {
+	if minInclusive > maxInclusive {
+		panic(fmt.Sprintf("invalid range [%d, %d]", minInclusive, maxInclusive))
+	}
+	// computed in uint64, maxInclusive-minInclusive+1 overflows an int64 for large bounds
+	span := uint64(maxInclusive) - uint64(minInclusive)
+	if span < math.MaxInt64 {
+		return int64(uint64(minInclusive) + uint64(globalRand.Int63n(int64(span)+1)))
+	}
+	// the span does not fit in Int63n, the draws out of the span are rejected (at most half of them)
+	for {
+		if v := globalRand.Uint64(); v <= span {
+			return int64(uint64(minInclusive) + v)
+		}
+	}
+}
+
+// Return a random number between min and max, both included. It panics if min is greater than max
+func RangeUint32Inclusive(minInclusive uint32, maxInclusive uint32) uint32 {
+	return uint32(RangeInclusive(int64(minInclusive), int64(maxInclusive)))
+}
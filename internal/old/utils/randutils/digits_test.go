@@ -2,6 +2,7 @@ package randutils
 
 import (
 	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
 )
 
@@ -70,3 +71,48 @@ func TestRangeUint32(t *testing.T) {
 		})
 	}
 }
+
+func TestRangeInclusiveShouldIncludeBothBounds(t *testing.T) {
+	seen := map[int64]bool{}
+	for i := 0; i < 500; i++ {
+		seen[RangeInclusive(-1, 1)] = true
+	}
+	assert.Len(t, seen, 3)
+	assert.True(t, seen[-1])
+	assert.True(t, seen[1])
+}
+
+func TestRangeInclusiveShouldNotOverflowOnLargeBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		min  int64
+		max  int64
+	}{
+		{name: "full-range", min: math.MinInt64, max: math.MaxInt64},
+		{name: "span-of-max-int64", min: -1, max: math.MaxInt64},
+		{name: "span-above-max-int64", min: math.MinInt64 / 2, max: math.MaxInt64},
+		{name: "upper-bound", min: math.MaxInt64 - 1, max: math.MaxInt64},
+		{name: "lower-bound", min: math.MinInt64, max: math.MinInt64 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 200; i++ {
+				actual := RangeInclusive(tt.min, tt.max)
+				assert.LessOrEqual(t, actual, tt.max)
+				assert.GreaterOrEqual(t, actual, tt.min)
+			}
+		})
+	}
+}
+
+func TestRangeInclusiveShouldPanicOnInvertedBounds(t *testing.T) {
+	assert.Panics(t, func() { RangeInclusive(2, 1) })
+}
+
+func TestRangeUint32InclusiveShouldIncludeBothBounds(t *testing.T) {
+	seen := map[uint32]bool{}
+	for i := 0; i < 500; i++ {
+		seen[RangeUint32Inclusive(math.MaxUint32-1, math.MaxUint32)] = true
+	}
+	assert.Len(t, seen, 2)
+}
@@ -15,9 +15,10 @@ type request struct {
 }
 
 func NewRequest(ctx context.Context) Request {
+	// buffered, the routine must not block on NotifyDone when AwaitDone has given up waiting
 	return &request{
 		ctx:          ctx,
-		doneStopping: make(chan struct{}),
+		doneStopping: make(chan struct{}, 1),
 	}
 }
 
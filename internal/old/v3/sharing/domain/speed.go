@@ -14,12 +14,12 @@ func (s *Speed) recalculateSpeed(bytes ByteAmount, timespan time.Duration) error
 		return fmt.Errorf("can not calculate speed for negative duration, [%s] received", timespan)
 	}
 
-	if bytes.GetInBytes() == 0 {
+	if bytes.GetInBytes() == 0 || timespan <= 0 {
 		s.bps = 0
 		return nil
 	}
 
-	s.bps = bytes.GetInBytes() * int64(timespan.Seconds())
+	s.bps = int64(float64(bytes.GetInBytes()) / timespan.Seconds())
 	return nil
 }
 
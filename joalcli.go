@@ -7,8 +7,12 @@ import (
 	"github.com/anthonyraymond/joal-cli/internal/old/core/logs"
 	"github.com/anthonyraymond/joal-cli/internal/old/core/manager2"
 	"github.com/anthonyraymond/joal-cli/internal/old/plugins"
+	_ "github.com/anthonyraymond/joal-cli/internal/old/plugins/external"
+	_ "github.com/anthonyraymond/joal-cli/internal/old/plugins/metrics"
 	"github.com/anthonyraymond/joal-cli/internal/old/plugins/types"
-	"net"
+	_ "github.com/anthonyraymond/joal-cli/internal/old/plugins/web"
+	_ "github.com/anthonyraymond/joal-cli/internal/old/plugins/webhook"
+	"go.uber.org/zap"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,6 +28,13 @@ import (
 const configRootFolder = `D:\temp\trash\joaltest`
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(runClientCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		os.Exit(runTokenCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	defer func() { _ = logs.GetLogger().Sync() }()
 
 	configLocation := getConfigRootFolder()
@@ -38,14 +49,16 @@ func main() {
 		panic(err)
 	}
 
+	dialer, err := appConfig.Proxy.Dialer()
+	if err != nil {
+		panic(err)
+	}
+
 	// Create an Http Client (almost similar to http.DefaultClient, but we apply our proxy configuration)
 	httpClient := &http.Client{
 		Transport: &http.Transport{
-			Proxy: appConfig.Proxy.Proxy(),
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
+			Proxy:                 dialer.HttpProxy(),
+			DialContext:           dialer.DialContext,
 			ForceAttemptHTTP2:     true,
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
@@ -59,9 +72,9 @@ func main() {
 		panic(err)
 	}
 
-	manager, _ := manager2.Run(coreConfigLoader)
+	manager, _ := manager2.Run(coreConfigLoader, dialer)
 	bridge := types.NewCoreBridge(coreConfigLoader, manager)
-	pluginManager := plugins.NewPluginManager(configLocation, bridge)
+	pluginManager := plugins.NewPluginManager(configLocation, bridge, plugins.ApplyLegacyFlags(appConfig.Plugins, os.Args[1:]))
 	pluginManager.BootstrapPlugins(httpClient)
 	pluginManager.StartPlugins()
 
@@ -71,9 +84,20 @@ func main() {
 	}
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	<-sigs
+	// SIGHUP reloads the configuration of the plugins
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		conf, err := readConfig(configLocation)
+		if err != nil {
+			logs.GetLogger().Error("failed to reload config", zap.Error(err))
+			continue
+		}
+		pluginManager.ReloadPlugins(plugins.ApplyLegacyFlags(conf.Plugins, os.Args[1:]))
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 	manager.StopSeeding(ctx)
@@ -0,0 +1,59 @@
+// Command example is an out-of-process plugin giving a ratio goal to every torrent added to JOAL.
+//
+// Build it and declare it in the config.yml of JOAL:
+//
+//	plugins:
+//	  external:
+//	    config:
+//	      plugins:
+//	        - name: auto-goal
+//	          command: /path/to/example
+//	          args: [-ratio, "3", -action, pause]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/anthonyraymond/joal-cli/pkg/pluginsdk"
+	"log"
+)
+
+func main() {
+	ratio := flag.Float64("ratio", 2, "ratio goal given to the added torrents")
+	action := flag.String("action", "pause", "action taken when the goal is reached: pause or archive")
+	flag.Parse()
+
+	// stdout is used to talk to JOAL, the logs go to stderr which JOAL forwards to its own logs
+	log.SetFlags(0)
+
+	err := pluginsdk.Serve(pluginsdk.Plugin{
+		Name:         "auto-goal",
+		Version:      "1.0.0",
+		Capabilities: []string{pluginsdk.CapabilityEvents, pluginsdk.CapabilityControl},
+		Events:       []string{"TorrentAdded"},
+		OnStart: func(ctx context.Context, host *pluginsdk.Host) error {
+			log.Printf("started with protocol version %d, ratio goal %.2f", host.ProtocolVersion(), *ratio)
+			return nil
+		},
+		OnEvent: func(ctx context.Context, host *pluginsdk.Host, event pluginsdk.Event) {
+			var added struct {
+				Infohash string `json:"infohash"`
+				Name     string `json:"name"`
+			}
+			if err := json.Unmarshal(event.Payload, &added); err != nil {
+				log.Printf("failed to decode event: %s", err)
+				return
+			}
+			goal := &pluginsdk.TorrentGoal{Ratio: *ratio, Action: *action}
+			if err := host.SetTorrentGoal(ctx, added.Infohash, goal); err != nil {
+				log.Printf("failed to set the goal of %s: %s", added.Name, err)
+				return
+			}
+			log.Printf("%s will %s at ratio %.2f", added.Name, *action, *ratio)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
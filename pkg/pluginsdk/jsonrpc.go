@@ -0,0 +1,340 @@
+package pluginsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const jsonrpcVersion = "2.0"
+
+// writeTimeout bounds the time a message takes to be written, the connection to a peer that does not read its messages
+// anymore is closed rather than blocking the writers forever
+const writeTimeout = 30 * time.Second
+
+// Error codes of the JSON-RPC 2.0 specification, and the ones specific to the plugin protocol
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+	// ErrCodeUnsupportedVersion is returned by the handshake when the host and the plugin have no protocol version in
+	// common
+	ErrCodeUnsupportedVersion = -32000
+	// ErrCodeHandshakeRequired is returned for any call made before the handshake
+	ErrCodeHandshakeRequired = -32001
+	// ErrCodeCapabilityNotGranted is returned for a call to a method whose capability has not been granted
+	ErrCodeCapabilityNotGranted = -32002
+	// ErrCodeCoreFailure is returned when the core of JOAL fails to perform the operation
+	ErrCodeCoreFailure = -32003
+)
+
+// ErrConnClosed is returned by the calls made on, or interrupted by, a closed connection
+var ErrConnClosed = errors.New("connection closed")
+
+// Error is a JSON-RPC error object, a Handler can return one to choose the code of the error
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func NewError(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// message is any of a request, a notification or a response. A request without ID is a notification.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// response is written instead of message so that a successful response always holds a result, even a null one
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// Handler answers the requests and notifications received by conn. The returned value is ignored for notifications.
+type Handler func(ctx context.Context, conn *Conn, method string, params json.RawMessage) (interface{}, error)
+
+// Conn is a JSON-RPC 2.0 peer: it both sends and receives requests over a stream of newline delimited json messages.
+// The requests received are handled concurrently, the notifications one at a time in the order they have been
+// received.
+type Conn struct {
+	rwc     io.ReadWriteCloser
+	handler Handler
+	// writes are written to rwc by a dedicated goroutine, which reports the outcome on written
+	writes       chan []byte
+	written      chan error
+	writeTimeout time.Duration
+	writeLock    *sync.Mutex
+	lock         *sync.Mutex
+	nextID       uint64
+	pending      map[uint64]chan *message
+	// notifications are handed to the handler by a dedicated goroutine, so that a notification handler can make calls
+	notifications chan *message
+	ctx           context.Context
+	cancel        context.CancelFunc
+	closeOnce     *sync.Once
+	err           error
+}
+
+// NewConn starts reading messages from rwc, the handler may be nil if the peer is not expected to send requests
+func NewConn(rwc io.ReadWriteCloser, handler Handler) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Conn{
+		rwc:           rwc,
+		handler:       handler,
+		writes:        make(chan []byte),
+		written:       make(chan error, 1),
+		writeTimeout:  writeTimeout,
+		writeLock:     &sync.Mutex{},
+		lock:          &sync.Mutex{},
+		pending:       make(map[uint64]chan *message),
+		notifications: make(chan *message, 64),
+		ctx:           ctx,
+		cancel:        cancel,
+		closeOnce:     &sync.Once{},
+	}
+	go c.read()
+	go c.writeMessages()
+	go c.dispatchNotifications()
+	return c
+}
+
+// Call sends a request and decodes its result into result, which may be nil to discard it
+func (c *Conn) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	raw, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	if c.ctx.Err() != nil {
+		c.lock.Unlock()
+		return ErrConnClosed
+	}
+	c.nextID++
+	id := c.nextID
+	reply := make(chan *message, 1)
+	c.pending[id] = reply
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}()
+
+	if err := c.write(&message{JSONRPC: jsonrpcVersion, ID: &id, Method: method, Params: raw}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	case res := <-reply:
+		if res.Error != nil {
+			return res.Error
+		}
+		if result == nil || len(res.Result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(res.Result, result); err != nil {
+			return fmt.Errorf("failed to decode the result of '%s': %w", method, err)
+		}
+		return nil
+	}
+}
+
+// Notify sends a notification, which the peer does not answer
+func (c *Conn) Notify(method string, params interface{}) error {
+	raw, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{JSONRPC: jsonrpcVersion, Method: method, Params: raw})
+}
+
+// Done is closed once the connection is closed, by either side
+func (c *Conn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Err returns the reason why the connection has been closed, nil while it is open or if it has been closed by Close
+func (c *Conn) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Close closes the underlying stream, the pending calls return ErrConnClosed
+func (c *Conn) Close() error {
+	return c.closeWithError(nil)
+}
+
+func (c *Conn) closeWithError(reason error) error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		c.err = reason
+		c.cancel()
+		c.lock.Unlock()
+		err = c.rwc.Close()
+	})
+	return err
+}
+
+// write returns once the message is written, the connection is closed if the peer has not read it within writeTimeout
+func (c *Conn) write(m interface{}) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	data = append(data, '\n')
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.ctx.Err() != nil {
+		return ErrConnClosed
+	}
+	timer := time.NewTimer(c.writeTimeout)
+	defer timer.Stop()
+	select {
+	case c.writes <- data:
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	}
+	select {
+	case err := <-c.written:
+		if err != nil {
+			_ = c.closeWithError(fmt.Errorf("failed to write message: %w", err))
+			return ErrConnClosed
+		}
+		return nil
+	case <-timer.C:
+		_ = c.closeWithError(fmt.Errorf("failed to write message: the peer has not read it within %s", c.writeTimeout))
+		return ErrConnClosed
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	}
+}
+
+// writeMessages writes the messages handed by write, closing the connection unblocks a write the peer does not read
+func (c *Conn) writeMessages() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case data := <-c.writes:
+			_, err := c.rwc.Write(data)
+			c.written <- err
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Conn) read() {
+	decoder := json.NewDecoder(c.rwc)
+	for {
+		m := &message{}
+		if err := decoder.Decode(m); err != nil {
+			_ = c.closeWithError(fmt.Errorf("failed to read message: %w", err))
+			return
+		}
+
+		switch {
+		case m.Method == "" && m.ID != nil:
+			c.lock.Lock()
+			reply, ok := c.pending[*m.ID]
+			c.lock.Unlock()
+			if ok {
+				reply <- m
+			}
+		case m.Method == "":
+			// a response without id answers a request that could not be parsed, there is no one to give it to
+		case m.ID == nil:
+			select {
+			case c.notifications <- m:
+			case <-c.ctx.Done():
+				return
+			}
+		default:
+			go c.handleRequest(m)
+		}
+	}
+}
+
+func (c *Conn) dispatchNotifications() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case m := <-c.notifications:
+			if c.handler != nil {
+				_, _ = c.handler(c.ctx, c, m.Method, m.Params)
+			}
+		}
+	}
+}
+
+func (c *Conn) handleRequest(m *message) {
+	if c.handler == nil {
+		_ = c.write(&message{JSONRPC: jsonrpcVersion, ID: m.ID, Error: NewError(ErrCodeMethodNotFound, "method '%s' not found", m.Method)})
+		return
+	}
+	result, err := c.handler(c.ctx, c, m.Method, m.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = NewError(ErrCodeInternal, "%s", err.Error())
+		}
+		_ = c.write(&message{JSONRPC: jsonrpcVersion, ID: m.ID, Error: rpcErr})
+		return
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		_ = c.write(&message{JSONRPC: jsonrpcVersion, ID: m.ID, Error: NewError(ErrCodeInternal, "failed to encode result: %s", err)})
+		return
+	}
+	_ = c.write(&response{JSONRPC: jsonrpcVersion, ID: m.ID, Result: raw})
+}
+
+func marshalParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode params: %w", err)
+	}
+	return raw, nil
+}
+
+// DecodeParams decodes the params of a request into v, the error is an ErrCodeInvalidParams Error
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return NewError(ErrCodeInvalidParams, "invalid params: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package pluginsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func contextWithTimeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// connPair returns two connected peers, the server answering with handler
+func connPair(t *testing.T, handler Handler) (client *Conn, server *Conn) {
+	c, s := net.Pipe()
+	client = NewConn(c, nil)
+	server = NewConn(s, handler)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func TestConn_ShouldCallAndDecodeResult(t *testing.T) {
+	client, _ := connPair(t, func(ctx context.Context, conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+		p := map[string]int{}
+		if err := DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"method": method, "sum": p["a"] + p["b"]}, nil
+	})
+
+	var result struct {
+		Method string `json:"method"`
+		Sum    int    `json:"sum"`
+	}
+	require.NoError(t, client.Call(contextWithTimeout(t), "add", map[string]int{"a": 1, "b": 2}, &result))
+	assert.Equal(t, "add", result.Method)
+	assert.Equal(t, 3, result.Sum)
+}
+
+func TestConn_ShouldReturnErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "rpc-error", err: NewError(ErrCodeCapabilityNotGranted, "denied"), wantCode: ErrCodeCapabilityNotGranted},
+		{name: "wrapped-rpc-error", err: errors.Join(errors.New("context"), NewError(ErrCodeInvalidParams, "bad")), wantCode: ErrCodeInvalidParams},
+		{name: "plain-error", err: errors.New("boom"), wantCode: ErrCodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := connPair(t, func(ctx context.Context, conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+				return nil, tt.err
+			})
+
+			err := client.Call(contextWithTimeout(t), "fail", nil, nil)
+
+			var rpcErr *Error
+			require.ErrorAs(t, err, &rpcErr)
+			assert.Equal(t, tt.wantCode, rpcErr.Code)
+		})
+	}
+}
+
+func TestConn_ShouldAnswerMethodNotFoundWithoutHandler(t *testing.T) {
+	c, s := net.Pipe()
+	client := NewConn(c, nil)
+	defer client.Close()
+	server := NewConn(s, nil)
+	defer server.Close()
+
+	err := client.Call(contextWithTimeout(t), "anything", nil, nil)
+
+	var rpcErr *Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, ErrCodeMethodNotFound, rpcErr.Code)
+}
+
+func TestConn_ShouldHandleNotificationsInOrder(t *testing.T) {
+	lock := &sync.Mutex{}
+	var received []int
+	client, _ := connPair(t, func(ctx context.Context, conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+		var n int
+		_ = json.Unmarshal(params, &n)
+		lock.Lock()
+		received = append(received, n)
+		lock.Unlock()
+		return nil, nil
+	})
+
+	var want []int
+	for i := 0; i < 100; i++ {
+		require.NoError(t, client.Notify("count", i))
+		want = append(want, i)
+	}
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == len(want)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, want, received)
+}
+
+func TestConn_ShouldLetNotificationHandlersCallBack(t *testing.T) {
+	answered := make(chan string, 1)
+	c, s := net.Pipe()
+	client := NewConn(c, func(ctx context.Context, conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+		return "pong", nil
+	})
+	defer client.Close()
+	server := NewConn(s, func(ctx context.Context, conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+		var res string
+		if err := conn.Call(ctx, "ping", nil, &res); err != nil {
+			return nil, err
+		}
+		answered <- res
+		return nil, nil
+	})
+	defer server.Close()
+
+	require.NoError(t, client.Notify("event", nil))
+
+	select {
+	case res := <-answered:
+		assert.Equal(t, "pong", res)
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification handler did not get an answer")
+	}
+}
+
+func TestConn_ShouldFailPendingCallsOnClose(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	client, server := connPair(t, func(ctx context.Context, conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.Call(contextWithTimeout(t), "slow", nil, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = server.Close()
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrConnClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending call has not been interrupted")
+	}
+	<-client.Done()
+	assert.Error(t, client.Err())
+	assert.ErrorIs(t, client.Call(contextWithTimeout(t), "closed", nil, nil), ErrConnClosed)
+}
+
+func TestConn_ShouldCloseWhenThePeerDoesNotRead(t *testing.T) {
+	c, s := net.Pipe() // s is never read
+	client := NewConn(c, nil)
+	client.writeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = s.Close()
+	})
+
+	returned := make(chan error, 1)
+	go func() {
+		returned <- client.Notify("event", map[string]int{"a": 1})
+	}()
+	select {
+	case err := <-returned:
+		assert.ErrorIs(t, err, ErrConnClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the write to a peer that does not read should have been bounded")
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the connection should have been closed")
+	}
+	assert.Error(t, client.Err())
+	assert.ErrorIs(t, client.Call(contextWithTimeout(t), "add", nil, nil), ErrConnClosed)
+}
@@ -0,0 +1,205 @@
+package pluginsdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// Plugin describes an out-of-process plugin, only Name is mandatory
+type Plugin struct {
+	Name    string
+	Version string
+	// Capabilities are the capabilities asked for in the handshake, all of them if nil
+	Capabilities []string
+	// OnStart is called once the handshake is done, the plugin stops if it returns an error
+	OnStart func(ctx context.Context, host *Host) error
+	// OnEvent is called for each event, one at a time and in order. The plugin subscribes to the events only if it is
+	// set.
+	OnEvent func(ctx context.Context, host *Host, event Event)
+	// Events are the types of the events given to OnEvent, all of them if empty
+	Events []string
+	// ReplayAfter replays the events published after this sequence number before the live ones
+	ReplayAfter uint64
+	// OnShutdown is called before Serve returns, whether JOAL asked the plugin to exit or the connection has been lost
+	OnShutdown func()
+}
+
+// Serve runs the plugin until JOAL asks it to exit. It speaks over stdin and stdout when the plugin is spawned by
+// JOAL, in which case the plugin must log to stderr, or over the unix socket given by the SocketEnv environment
+// variable.
+func Serve(p Plugin) error {
+	if path := os.Getenv(SocketEnv); path != "" {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return fmt.Errorf("failed to connect to JOAL socket '%s': %w", path, err)
+		}
+		return ServeConn(context.Background(), conn, p)
+	}
+	return ServeConn(context.Background(), &stdio{in: os.Stdin, out: os.Stdout}, p)
+}
+
+// ServeConn runs the plugin over rwc until JOAL asks it to exit, the connection is lost or ctx is done
+func ServeConn(ctx context.Context, rwc io.ReadWriteCloser, p Plugin) error {
+	shutdown := make(chan struct{})
+	shutdownOnce := &sync.Once{}
+	host := &Host{}
+	conn := NewConn(rwc, func(ctx context.Context, _ *Conn, method string, params json.RawMessage) (interface{}, error) {
+		switch method {
+		case MethodPing:
+			return struct{}{}, nil
+		case MethodEvent:
+			if p.OnEvent == nil {
+				return nil, nil
+			}
+			event := Event{}
+			if err := DecodeParams(params, &event); err != nil {
+				return nil, err
+			}
+			p.OnEvent(ctx, host, event)
+			return nil, nil
+		case MethodShutdown:
+			shutdownOnce.Do(func() { close(shutdown) })
+			return nil, nil
+		default:
+			return nil, NewError(ErrCodeMethodNotFound, "method '%s' not found", method)
+		}
+	})
+	host.conn = conn
+	defer func() { _ = conn.Close() }()
+
+	capabilities := p.Capabilities
+	if capabilities == nil {
+		capabilities = Capabilities
+	}
+	handshake := HandshakeResult{}
+	err := conn.Call(ctx, MethodHandshake, &HandshakeParams{
+		ProtocolVersion: ProtocolVersion,
+		Name:            p.Name,
+		Version:         p.Version,
+		Capabilities:    capabilities,
+	}, &handshake)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	host.protocolVersion = handshake.ProtocolVersion
+	host.capabilities = handshake.Capabilities
+
+	if p.OnStart != nil {
+		if err := p.OnStart(ctx, host); err != nil {
+			return fmt.Errorf("failed to start plugin: %w", err)
+		}
+	}
+	if p.OnEvent != nil {
+		if err := conn.Call(ctx, MethodSubscribe, &SubscribeParams{Types: p.Events, AfterSeq: p.ReplayAfter}, nil); err != nil {
+			return fmt.Errorf("failed to subscribe to events: %w", err)
+		}
+	}
+
+	select {
+	case <-shutdown:
+	case <-ctx.Done():
+	case <-conn.Done():
+		err = fmt.Errorf("connection to JOAL lost: %w", conn.Err())
+	}
+	if p.OnShutdown != nil {
+		p.OnShutdown()
+	}
+	return err
+}
+
+// Host calls the methods of JOAL, the ones requiring a capability that has not been granted fail with an
+// ErrCodeCapabilityNotGranted Error
+type Host struct {
+	conn            *Conn
+	protocolVersion int
+	capabilities    []string
+}
+
+// ProtocolVersion is the version of the protocol agreed on in the handshake
+func (h *Host) ProtocolVersion() int {
+	return h.protocolVersion
+}
+
+// Granted tells if the capability has been granted by the handshake
+func (h *Host) Granted(capability string) bool {
+	for _, c := range h.capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Host) StartSeeding(ctx context.Context) error {
+	return h.conn.Call(ctx, MethodStartSeeding, nil, nil)
+}
+
+func (h *Host) StopSeeding(ctx context.Context) error {
+	return h.conn.Call(ctx, MethodStopSeeding, nil, nil)
+}
+
+func (h *Host) GetConfig(ctx context.Context) (*RuntimeConfig, error) {
+	conf := &RuntimeConfig{}
+	if err := h.conn.Call(ctx, MethodGetConfig, nil, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// UpdateConfig saves the config and returns it, the changes take effect once the seed restarts
+func (h *Host) UpdateConfig(ctx context.Context, config *RuntimeConfig) (*RuntimeConfig, error) {
+	conf := &RuntimeConfig{}
+	if err := h.conn.Call(ctx, MethodUpdateConfig, config, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (h *Host) AddTorrent(ctx context.Context, filename string, content []byte) error {
+	return h.conn.Call(ctx, MethodAddTorrent, &AddTorrentParams{Filename: filename, Content: content}, nil)
+}
+
+func (h *Host) RemoveTorrent(ctx context.Context, infohash string) error {
+	return h.conn.Call(ctx, MethodRemoveTorrent, &RemoveTorrentParams{Infohash: infohash}, nil)
+}
+
+// SetTorrentGoal sets the goal of a torrent, a nil goal falls back to the goals of the config
+func (h *Host) SetTorrentGoal(ctx context.Context, infohash string, goal *TorrentGoal) error {
+	return h.conn.Call(ctx, MethodSetTorrentGoal, &SetTorrentGoalParams{Infohash: infohash, Goal: goal}, nil)
+}
+
+// ListClients returns the file names of the emulated clients
+func (h *Host) ListClients(ctx context.Context) ([]string, error) {
+	var clients []string
+	if err := h.conn.Call(ctx, MethodListClients, nil, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// stdio joins the stdin and stdout of the plugin process
+type stdio struct {
+	in  io.ReadCloser
+	out io.WriteCloser
+}
+
+func (s *stdio) Read(p []byte) (int, error) {
+	return s.in.Read(p)
+}
+
+func (s *stdio) Write(p []byte) (int, error) {
+	return s.out.Write(p)
+}
+
+func (s *stdio) Close() error {
+	errIn := s.in.Close()
+	if err := s.out.Close(); err != nil {
+		return err
+	}
+	return errIn
+}
@@ -0,0 +1,124 @@
+// Package pluginsdk implements the protocol spoken between JOAL and its out-of-process plugins, and makes writing such
+// a plugin in Go a matter of filling a Plugin and calling Serve.
+//
+// The protocol is JSON-RPC 2.0 over a stream of newline delimited json messages: the stdin and stdout of a plugin
+// spawned by JOAL, or a connection to the unix socket JOAL listens on. Both sides send requests:
+//   - the plugin starts with a MethodHandshake, then calls the methods of the host granted by the handshake
+//   - the host pings the plugin with MethodPing, pushes the events with MethodEvent and asks the plugin to exit with
+//     MethodShutdown
+package pluginsdk
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProtocolVersion is the latest version of the protocol, it is only incremented on breaking changes. The host and the
+// plugin agree on the highest version both of them support.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest version of the protocol still supported
+const MinProtocolVersion = 1
+
+// SocketEnv is the environment variable holding the path of the unix socket Serve connects to, Serve speaks over
+// stdin and stdout when it is not set
+const SocketEnv = "JOAL_PLUGIN_SOCKET"
+
+// Capabilities a plugin can ask for in its handshake
+const (
+	// CapabilityEvents allows to subscribe to the events of JOAL
+	CapabilityEvents = "events"
+	// CapabilityControl allows to act on the core: start and stop seeding, edit the config, add and remove torrents
+	CapabilityControl = "control"
+)
+
+// Capabilities are all the capabilities of the latest version of the protocol
+var Capabilities = []string{CapabilityEvents, CapabilityControl}
+
+// Methods of the host
+const (
+	MethodHandshake = "joal.handshake"
+	// MethodSubscribe requires CapabilityEvents, the events are then pushed with MethodEvent
+	MethodSubscribe = "joal.subscribe"
+	// The following methods require CapabilityControl
+	MethodStartSeeding   = "joal.startSeeding"
+	MethodStopSeeding    = "joal.stopSeeding"
+	MethodGetConfig      = "joal.getConfig"
+	MethodUpdateConfig   = "joal.updateConfig"
+	MethodAddTorrent     = "joal.addTorrent"
+	MethodRemoveTorrent  = "joal.removeTorrent"
+	MethodSetTorrentGoal = "joal.setTorrentGoal"
+	MethodListClients    = "joal.listClients"
+)
+
+// Methods of the plugin
+const (
+	// MethodPing is a request the plugin has to answer in time, or it is considered hung and restarted
+	MethodPing = "plugin.ping"
+	// MethodEvent is a notification holding an Event
+	MethodEvent = "plugin.event"
+	// MethodShutdown is a notification asking the plugin to exit
+	MethodShutdown = "plugin.shutdown"
+)
+
+type HandshakeParams struct {
+	// ProtocolVersion is the highest version supported by the plugin
+	ProtocolVersion int    `json:"protocolVersion"`
+	Name            string `json:"name"`
+	Version         string `json:"version"`
+	// Capabilities are the capabilities the plugin asks for
+	Capabilities []string `json:"capabilities"`
+}
+
+type HandshakeResult struct {
+	// ProtocolVersion is the version used for the rest of the session
+	ProtocolVersion int `json:"protocolVersion"`
+	// Capabilities are the capabilities granted to the plugin, the ones asked for minus the ones the host refuses
+	Capabilities []string `json:"capabilities"`
+}
+
+type SubscribeParams struct {
+	// Types are the types of the events to receive, all of them if empty
+	Types []string `json:"types,omitempty"`
+	// AfterSeq replays the events of the journal of JOAL published after this sequence number before the live ones, it
+	// lets a restarted plugin catch up with the events it missed
+	AfterSeq uint64 `json:"afterSeq,omitempty"`
+}
+
+// Event is an event of JOAL, its payload is the one of the history endpoint of the web API
+type Event struct {
+	Seq     uint64          `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type RuntimeConfig struct {
+	MinimumBytesPerSeconds int64  `json:"minimumBytesPerSeconds"`
+	MaximumBytesPerSeconds int64  `json:"maximumBytesPerSeconds"`
+	Client                 string `json:"client"`
+}
+
+type AddTorrentParams struct {
+	Filename string `json:"filename"`
+	// Content is the torrent file, base64 encoded
+	Content []byte `json:"content"`
+}
+
+type RemoveTorrentParams struct {
+	Infohash string `json:"infohash"`
+}
+
+type TorrentGoal struct {
+	Ratio float64 `json:"ratio"`
+	// SeedingTime in seconds
+	SeedingTime int64  `json:"seedingTime"`
+	DailyUpload int64  `json:"dailyUpload"`
+	Action      string `json:"action"`
+}
+
+type SetTorrentGoalParams struct {
+	Infohash string `json:"infohash"`
+	// Goal is the goal of the torrent, nil falls back to the goals of the config
+	Goal *TorrentGoal `json:"goal"`
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
+	"io"
+)
+
+// runTokenCommand handles `joal token <subcommand>`, it returns the process exit code
+func runTokenCommand(args []string, out io.Writer, errOut io.Writer) int {
+	if len(args) == 0 || args[0] != "generate" {
+		_, _ = fmt.Fprintln(errOut, "usage: joal token generate [-scope read|admin] <name>")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("token generate", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	scope := flags.String("scope", string(auth.ScopeRead), "scope of the token: read or admin")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintln(errOut, "exactly one token name is expected")
+		return 2
+	}
+	if auth.Scope(*scope) != auth.ScopeRead && auth.Scope(*scope) != auth.ScopeAdmin {
+		_, _ = fmt.Fprintf(errOut, "'%s' is not a valid scope\n", *scope)
+		return 2
+	}
+
+	token, hash, err := auth.GenerateToken()
+	if err != nil {
+		_, _ = fmt.Fprintf(errOut, "failed to generate token: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(out, "token: %s\n", token)
+	_, _ = fmt.Fprintln(out, "the token is not stored anywhere, add its hash to the auth.tokens of the web plugin config:")
+	_, _ = fmt.Fprintf(out, "  - name: %s\n    scope: %s\n    hash: %s\n", flags.Arg(0), *scope, hash)
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"github.com/anthonyraymond/joal-cli/internal/old/plugins/web/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+	"time"
+)
+
+// generateToken runs `joal token generate` and returns the token printed along with the config snippet parsed
+func generateToken(t *testing.T, args ...string) (string, *auth.TokenConfig) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+
+	code := runTokenCommand(append([]string{"generate"}, args...), out, errOut)
+
+	require.Equal(t, 0, code, errOut.String())
+	lines := strings.SplitN(out.String(), "\n", 3)
+	require.Len(t, lines, 3)
+	token := strings.TrimPrefix(lines[0], "token: ")
+	var tokens []*auth.TokenConfig
+	require.NoError(t, yaml.Unmarshal([]byte(lines[2]), &tokens), lines[2])
+	require.Len(t, tokens, 1)
+	return token, tokens[0]
+}
+
+func TestRunTokenCommand_ShouldGenerateATokenMatchingItsConfig(t *testing.T) {
+	token, conf := generateToken(t, "grafana")
+
+	assert.Equal(t, "grafana", conf.Name)
+	assert.NotContains(t, conf.Hash, token, "only the hash of the token goes to the config")
+	authenticator, err := auth.NewAuthenticator([]*auth.TokenConfig{conf}, time.Hour)
+	require.NoError(t, err)
+	identity, err := authenticator.VerifyToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, &auth.Identity{Name: "grafana", Scope: auth.ScopeRead}, identity)
+}
+
+func TestRunTokenCommand_ShouldDefaultToTheReadScope(t *testing.T) {
+	_, conf := generateToken(t, "dashboard")
+	assert.Equal(t, auth.ScopeRead, conf.Scope)
+
+	_, conf = generateToken(t, "-scope", "read", "dashboard")
+	assert.Equal(t, auth.ScopeRead, conf.Scope)
+}
+
+func TestRunTokenCommand_ShouldGenerateAdminTokens(t *testing.T) {
+	token, conf := generateToken(t, "-scope", "admin", "automation")
+
+	assert.Equal(t, auth.ScopeAdmin, conf.Scope)
+	authenticator, err := auth.NewAuthenticator([]*auth.TokenConfig{conf}, time.Hour)
+	require.NoError(t, err)
+	identity, err := authenticator.VerifyToken(token)
+	require.NoError(t, err)
+	assert.True(t, identity.Scope.Allows(auth.ScopeAdmin))
+}
+
+func TestRunTokenCommand_ShouldGenerateDistinctTokens(t *testing.T) {
+	first, firstConf := generateToken(t, "a")
+	second, secondConf := generateToken(t, "a")
+
+	assert.NotEqual(t, first, second)
+	assert.NotEqual(t, firstConf.Hash, secondConf.Hash)
+}
+
+func TestRunTokenCommand_ShouldRejectInvalidArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no-subcommand", args: []string{}},
+		{name: "unknown-subcommand", args: []string{"list"}},
+		{name: "no-name", args: []string{"generate"}},
+		{name: "two-names", args: []string{"generate", "a", "b"}},
+		{name: "invalid-scope", args: []string{"generate", "-scope", "write", "a"}},
+		{name: "unknown-flag", args: []string{"generate", "-ttl", "1h", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+			assert.Equal(t, 2, runTokenCommand(tt.args, out, errOut))
+			assert.Empty(t, out.String())
+			assert.NotEmpty(t, errOut.String())
+		})
+	}
+}